@@ -0,0 +1,18 @@
+package tunnel
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Agent returns an AuthMethod offering the keys held by the running ssh agent: the one SSH_AUTH_SOCK points at
+// or, on windows without it, the OpenSSH agent service and failing that Pageant
+func Agent() (ssh.AuthMethod, error) {
+	conn, err := dialAgent()
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the ssh agent: %v", err)
+	}
+	return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), nil
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+)
+
+func dialAgent() (io.ReadWriter, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("SSH_AUTH_SOCK is not set")
+	}
+	return net.Dial("unix", sock)
+}
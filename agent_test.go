@@ -0,0 +1,63 @@
+//go:build !windows
+// +build !windows
+
+package tunnel
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgent(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "id")
+	if err := testServer.WriteClientKey(keyFile, ""); err != nil {
+		t.Fatal(err)
+	}
+	pem, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.ParseRawPrivateKey(pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, err := Agent(); err == nil {
+		t.Fatal("expected an error without an agent")
+	}
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	auth, err := Agent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun, err := Dial(&Spec{Host: testServer.Addr, User: testServer.User, Auth: []ssh.AuthMethod{auth}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun.Close()
+}
@@ -0,0 +1,29 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// openSSHAgentPipe is where the Windows OpenSSH agent service listens
+const openSSHAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+func dialAgent() (io.ReadWriter, error) {
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if strings.HasPrefix(sock, `\\.\pipe\`) {
+			return os.OpenFile(sock, os.O_RDWR, 0)
+		}
+		return net.Dial("unix", sock)
+	}
+	pipe, pipeErr := os.OpenFile(openSSHAgentPipe, os.O_RDWR, 0)
+	if pipeErr == nil {
+		return pipe, nil
+	}
+	if pageantRunning() {
+		return &pageantConn{}, nil
+	}
+	return nil, fmt.Errorf("neither the OpenSSH agent (%v) nor Pageant is running", pipeErr)
+}
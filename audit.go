@@ -0,0 +1,143 @@
+package tunnel
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AuditRecord is the evidence of how an ssh connection was established
+type AuditRecord struct {
+	Time               time.Time `json:"time"`
+	Host               string    `json:"host"`
+	RemoteAddr         string    `json:"remoteAddr"`
+	User               string    `json:"user"`
+	SessionID          string    `json:"sessionId"`
+	ClientVersion      string    `json:"clientVersion"`
+	ServerVersion      string    `json:"serverVersion"`
+	HostKeyType        string    `json:"hostKeyType"`
+	HostKeyFingerprint string    `json:"hostKeyFingerprint"`
+	KeyExchange        string    `json:"keyExchange"`
+	CipherClientServer string    `json:"cipherClientServer"`
+	CipherServerClient string    `json:"cipherServerClient"`
+	MACClientServer    string    `json:"macClientServer"`
+	MACServerClient    string    `json:"macServerClient"`
+	Compression        string    `json:"compression"`
+	// AuthMethod is the RFC 4252 name of the method the connection was authenticated with, empty if it isn't one
+	// of password, publickey or keyboard-interactive
+	AuthMethod string `json:"authMethod"`
+}
+
+// AuditSink receives an AuditRecord for every established connection
+type AuditSink interface {
+	Record(AuditRecord)
+}
+
+type jsonAuditSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (s *jsonAuditSink) Record(r AuditRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enc.Encode(r)
+}
+
+// JSONAuditSink returns a sink writing each record as a line of JSON to w
+func JSONAuditSink(w io.Writer) AuditSink {
+	return &jsonAuditSink{enc: json.NewEncoder(w)}
+}
+
+type loggerAuditSink struct {
+	logger Logger
+}
+
+func (s *loggerAuditSink) Record(r AuditRecord) {
+	logAt(s.logger, LevelInfo, "audit: %s@%s (%s) session %s: host key %s %s; kex %s; ciphers %s/%s; macs %s/%s; compression %s; auth %s; versions %q/%q",
+		r.User, r.Host, r.RemoteAddr, r.SessionID, r.HostKeyType, r.HostKeyFingerprint, r.KeyExchange,
+		r.CipherClientServer, r.CipherServerClient, r.MACClientServer, r.MACServerClient, r.Compression,
+		r.AuthMethod, r.ClientVersion, r.ServerVersion)
+}
+
+// LoggerAuditSink returns a sink writing each record to logger
+func LoggerAuditSink(logger Logger) AuditSink {
+	return &loggerAuditSink{logger: logger}
+}
+
+func newAuditRecord(spec *Spec, client *ssh.Client, conn *kexObservingConn, hostKey ssh.PublicKey, authMethod string) AuditRecord {
+	clientVersion, serverVersion, clientKex, serverKex := conn.observed()
+	r := AuditRecord{
+		Time:          time.Now(),
+		Host:          spec.Host,
+		RemoteAddr:    client.RemoteAddr().String(),
+		User:          spec.User,
+		SessionID:     hex.EncodeToString(client.SessionID()),
+		ClientVersion: clientVersion,
+		ServerVersion: serverVersion,
+		AuthMethod:    authMethod,
+	}
+	if hostKey != nil {
+		r.HostKeyType = hostKey.Type()
+		r.HostKeyFingerprint = ssh.FingerprintSHA256(hostKey)
+	}
+	if clientKex != nil && serverKex != nil {
+		r.KeyExchange = negotiate(clientKex.KexAlgos, serverKex.KexAlgos)
+		r.CipherClientServer = negotiate(clientKex.CiphersClientServer, serverKex.CiphersClientServer)
+		r.CipherServerClient = negotiate(clientKex.CiphersServerClient, serverKex.CiphersServerClient)
+		r.MACClientServer = negotiateMAC(r.CipherClientServer, clientKex.MACsClientServer, serverKex.MACsClientServer)
+		r.MACServerClient = negotiateMAC(r.CipherServerClient, clientKex.MACsServerClient, serverKex.MACsServerClient)
+		r.Compression = negotiate(clientKex.CompressionClientServer, serverKex.CompressionClientServer)
+	}
+	return r
+}
+
+var (
+	passwordCallbackType   = reflect.TypeOf(func() (string, error) { return "", nil })
+	publicKeysCallbackType = reflect.TypeOf(func() ([]ssh.Signer, error) { return nil, nil })
+)
+
+// recordingAuth wraps the callbacks of methods so that each sets used to its RFC 4252 name when run. The client
+// tries methods in turn, so once the handshake succeeds used names the one that authenticated it. Methods whose
+// callback can't be reached, such as gssapi-with-mic, are left as they are.
+func recordingAuth(methods []ssh.AuthMethod, used *string) []ssh.AuthMethod {
+	wrapped := make([]ssh.AuthMethod, 0, len(methods))
+	for _, m := range methods {
+		wrapped = append(wrapped, recordingAuthMethod(m, used))
+	}
+	return wrapped
+}
+
+func recordingAuthMethod(m ssh.AuthMethod, used *string) ssh.AuthMethod {
+	if challenge, ok := m.(ssh.KeyboardInteractiveChallenge); ok {
+		return ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			*used = "keyboard-interactive"
+			return challenge(name, instruction, questions, echos)
+		})
+	}
+	// ssh.Password and ssh.PublicKeys return unexported function types, told apart by their signatures
+	v := reflect.ValueOf(m)
+	switch {
+	case v.Kind() != reflect.Func:
+		return m
+	case v.Type().ConvertibleTo(passwordCallbackType):
+		password := v.Convert(passwordCallbackType).Interface().(func() (string, error))
+		return ssh.PasswordCallback(func() (string, error) {
+			*used = "password"
+			return password()
+		})
+	case v.Type().ConvertibleTo(publicKeysCallbackType):
+		signers := v.Convert(publicKeysCallbackType).Interface().(func() ([]ssh.Signer, error))
+		return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			*used = "publickey"
+			return signers()
+		})
+	default:
+		return m
+	}
+}
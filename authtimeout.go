@@ -0,0 +1,72 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// AuthTimeoutError is returned when the server doesn't respond to a handshake message, typically an auth
+// attempt, within Spec.AuthTimeout
+type AuthTimeoutError struct {
+	Host    string
+	Timeout time.Duration
+}
+
+func (e *AuthTimeoutError) Error() string {
+	return fmt.Sprintf("%s didn't respond within %v during authentication", e.Host, e.Timeout)
+}
+
+// handshakeDeadlineConn limits how long the server may take to respond while the handshake is in progress: a
+// deadline is set whenever a message is sent and cleared once the server responds, so time spent prompting the
+// user doesn't count
+type handshakeDeadlineConn struct {
+	net.Conn
+	timeout  time.Duration
+	mu       sync.Mutex
+	finished bool
+	timedOut bool
+}
+
+func limitHandshake(conn net.Conn, timeout time.Duration) *handshakeDeadlineConn {
+	return &handshakeDeadlineConn{Conn: conn, timeout: timeout}
+}
+
+func (c *handshakeDeadlineConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	if !c.finished {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	}
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *handshakeDeadlineConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished {
+		return n, err
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		c.timedOut = true
+	} else if n > 0 {
+		c.Conn.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+// finish stops limiting the connection once the handshake is over
+func (c *handshakeDeadlineConn) finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.finished = true
+	c.Conn.SetReadDeadline(time.Time{})
+}
+
+func (c *handshakeDeadlineConn) hasTimedOut() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.timedOut
+}
@@ -0,0 +1,81 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// how long a local connection is held while the server refuses to open channels before it is given up on
+const channelOpenPatience = time.Second * 30
+
+const (
+	minChannelOpenBackoff = time.Millisecond * 100
+	maxChannelOpenBackoff = time.Second * 5
+)
+
+// isChannelOpenThrottled reports whether err is the server refusing to open more channels, as opposed to the
+// destination being unreachable
+func isChannelOpenThrottled(err error) bool {
+	var openErr *ssh.OpenChannelError
+	if !errors.As(err, &openErr) {
+		return false
+	}
+	return openErr.Reason == ssh.Prohibited || openErr.Reason == ssh.ResourceShortage
+}
+
+// dialWithBackoff dials the forward's destination, retrying with exponential backoff while the server refuses
+// to open channels so that connections queue up rather than fail
+func dialWithBackoff(ctx context.Context, forwarder Forwarder, state *forwardState, logger Logger) (net.Conn, error) {
+	backoff := minChannelOpenBackoff
+	giveUp := time.Now().Add(channelOpenPatience)
+	dial := state.dial
+	if forwarder.socks != nil {
+		dial = func() (net.Conn, error) {
+			return state.dialTo(forwarder.destination)
+		}
+	}
+	for {
+		conn, err := dial()
+		if err == nil {
+			state.setThrottled(false, forwarder, logger)
+			return conn, nil
+		}
+		if !isChannelOpenThrottled(err) || time.Now().Add(backoff).After(giveUp) {
+			return nil, err
+		}
+		state.setThrottled(true, forwarder, logger)
+		retry := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			retry.Stop()
+			return nil, err
+		case <-retry.C:
+		}
+		backoff *= 2
+		if backoff > maxChannelOpenBackoff {
+			backoff = maxChannelOpenBackoff
+		}
+	}
+}
+
+// setThrottled records whether the server is refusing channels for the forward, logging changes
+func (s *forwardState) setThrottled(throttled bool, forwarder Forwarder, logger Logger) {
+	if throttled {
+		if atomic.CompareAndSwapInt32(&s.throttled, 0, 1) {
+			logAt(logger, LevelWarn, "%s: server is refusing channels to %s, queueing connections", forwarder.local(), forwarder.destination)
+		}
+		return
+	}
+	if atomic.CompareAndSwapInt32(&s.throttled, 1, 0) {
+		logAt(logger, LevelInfo, "%s: server is accepting channels to %s again", forwarder.local(), forwarder.destination)
+	}
+}
+
+func (s *forwardState) isThrottled() bool {
+	return atomic.LoadInt32(&s.throttled) == 1
+}
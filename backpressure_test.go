@@ -0,0 +1,61 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDialWithBackoff(t *testing.T) {
+	f := Forward(0, "db:5432")
+	t.Run("retries while throttled", func(t *testing.T) {
+		state := newForwardState()
+		attempts := 0
+		state.dial = func() (net.Conn, error) {
+			attempts++
+			if attempts < 3 {
+				if !state.isThrottled() && attempts > 1 {
+					t.Error("expected the forward to be marked throttled")
+				}
+				return nil, &ssh.OpenChannelError{Reason: ssh.ResourceShortage, Message: "too many channels"}
+			}
+			c, _ := net.Pipe()
+			return c, nil
+		}
+		conn, err := dialWithBackoff(context.Background(), f, state, EmptyLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if attempts != 3 || state.isThrottled() {
+			t.Fatalf("expected recovery after 3 attempts, got %d attempts, throttled %v", attempts, state.isThrottled())
+		}
+	})
+	t.Run("other failures are not retried", func(t *testing.T) {
+		state := newForwardState()
+		attempts := 0
+		state.dial = func() (net.Conn, error) {
+			attempts++
+			return nil, &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "connection refused"}
+		}
+		if _, err := dialWithBackoff(context.Background(), f, state, EmptyLogger()); err == nil || attempts != 1 {
+			t.Fatalf("expected a single failed attempt, got %d: %v", attempts, err)
+		}
+	})
+	t.Run("gives up when cancelled", func(t *testing.T) {
+		state := newForwardState()
+		state.dial = func() (net.Conn, error) {
+			return nil, &ssh.OpenChannelError{Reason: ssh.Prohibited, Message: "administratively prohibited"}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := dialWithBackoff(ctx, f, state, EmptyLogger())
+		var openErr *ssh.OpenChannelError
+		if !errors.As(err, &openErr) {
+			t.Fatalf("expected the refusal to be returned, got %v", err)
+		}
+	})
+}
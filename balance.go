@@ -0,0 +1,141 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// unreachableFor is how long a destination a dial failed to is left out of a forward without health checks
+const unreachableFor = time.Second * 30
+
+// BalanceStrategy is how a forward with replicas picks the destination of each connection
+type BalanceStrategy int
+
+const (
+	// RoundRobin spreads connections across the destinations in turn
+	RoundRobin BalanceStrategy = iota
+	// Failover sends connections to the first destination that's up, in the order given
+	Failover
+)
+
+// WithStrategy sets how a forward with replicas picks a destination; RoundRobin by default
+func (f Forwarder) WithStrategy(strategy BalanceStrategy) Forwarder {
+	f.strategy = strategy
+	return f
+}
+
+// WithHealthCheck dials the destinations of a forward with replicas through the connection every interval,
+// leaving out those that can't be reached until they can again. Without it a destination is left out for 30
+// seconds after a dial to it fails. Destinations that are down are still tried when none are up.
+func (f Forwarder) WithHealthCheck(interval time.Duration) Forwarder {
+	f.healthCheck = interval
+	return f
+}
+
+// balancer picks among the destinations of a forward, skipping those that are down
+type balancer struct {
+	destinations []string
+	strategy     BalanceStrategy
+	// next is the round robin position; accessed atomically
+	next uint32
+
+	mu sync.Mutex
+	// downUntil is when each destination is next tried, zero while it's up
+	downUntil []time.Time
+}
+
+func newBalancer(forwarder Forwarder) *balancer {
+	return &balancer{
+		destinations: forwarder.destinations,
+		strategy:     forwarder.strategy,
+		downUntil:    make([]time.Time, len(forwarder.destinations)),
+	}
+}
+
+// order returns the indices of destinations to try in turn: those up, by the strategy, then those down
+func (b *balancer) order() []int {
+	start := 0
+	if b.strategy == RoundRobin {
+		start = int(atomic.AddUint32(&b.next, 1)-1) % len(b.destinations)
+	}
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	up := make([]int, 0, len(b.destinations))
+	down := []int{}
+	for n := 0; n < len(b.destinations); n++ {
+		i := (start + n) % len(b.destinations)
+		if now.Before(b.downUntil[i]) {
+			down = append(down, i)
+		} else {
+			up = append(up, i)
+		}
+	}
+	return append(up, down...)
+}
+
+// setDown marks destination i down until the given time, or up when it's zero, logging changes
+func (b *balancer) setDown(i int, until time.Time, err error, forwarder Forwarder, logger Logger) {
+	b.mu.Lock()
+	wasDown := time.Now().Before(b.downUntil[i])
+	b.downUntil[i] = until
+	b.mu.Unlock()
+	switch {
+	case !until.IsZero() && !wasDown:
+		logAt(logger, LevelWarn, "%s: leaving out %s as it can't be reached: %v", forwarder.local(), b.destinations[i], err)
+	case until.IsZero() && wasDown:
+		logAt(logger, LevelInfo, "%s: %s can be reached again", forwarder.local(), b.destinations[i])
+	}
+}
+
+// dial connects to a destination, trying the next one when it can't be reached. A server refusing channels
+// isn't down to the destination so is returned as is.
+func (b *balancer) dial(dial func(addr string) (net.Conn, error), forwarder Forwarder, logger Logger) (net.Conn, error) {
+	var lastErr error
+	for _, i := range b.order() {
+		conn, err := dial(b.destinations[i])
+		if err == nil {
+			return conn, nil
+		}
+		if isChannelOpenThrottled(err) {
+			return nil, err
+		}
+		lastErr = err
+		down := unreachableFor
+		if forwarder.healthCheck > 0 {
+			down = forwarder.healthCheck * 2
+		}
+		b.setDown(i, time.Now().Add(down), err, forwarder, logger)
+	}
+	return nil, lastErr
+}
+
+// checkHealth dials every destination each interval until ctx is done, marking them up or down
+func (b *balancer) checkHealth(ctx context.Context, dial func(addr string) (net.Conn, error), forwarder Forwarder, logger Logger) {
+	ticker := time.NewTicker(forwarder.healthCheck)
+	defer ticker.Stop()
+	for {
+		for i, destination := range b.destinations {
+			if ctx.Err() != nil {
+				return
+			}
+			conn, err := dial(destination)
+			if err != nil {
+				if !isChannelOpenThrottled(err) {
+					b.setDown(i, time.Now().Add(forwarder.healthCheck*2), err, forwarder, logger)
+				}
+				continue
+			}
+			conn.Close()
+			b.setDown(i, time.Time{}, nil, forwarder, logger)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
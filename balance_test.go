@@ -0,0 +1,86 @@
+package tunnel
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// nameServer answers every connection with name, listening on addr when set
+func nameServer(t *testing.T, name, addr string) net.Listener {
+	if addr == "" {
+		addr = "localhost:0"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(name))
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+func TestBalancing(t *testing.T) {
+	a := nameServer(t, "a", "")
+	b := nameServer(t, "b", "")
+	down := nameServer(t, "down", "")
+	downAddr := down.Addr().String()
+	down.Close()
+
+	tun, err := Execute(&Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: testServer.Auth(),
+		Forward: []Forwarder{
+			Forward(0, a.Addr().String(), b.Addr().String()).WithName("round robin"),
+			Forward(0, downAddr, b.Addr().String()).WithName("failover").WithStrategy(Failover).WithHealthCheck(time.Millisecond * 50),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	through := func(name string) string {
+		addr, _ := tun.LocalAddr(name)
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+		answer, _ := ioutil.ReadAll(conn)
+		return string(answer)
+	}
+
+	answers := ""
+	for i := 0; i < 4; i++ {
+		answers += through("round robin")
+	}
+	if answers != "abab" {
+		t.Fatalf("expected connections to alternate, got %s", answers)
+	}
+
+	for i := 0; i < 2; i++ {
+		if answer := through("failover"); answer != "b" {
+			t.Fatalf("expected to fail over to b, got %q", answer)
+		}
+	}
+	nameServer(t, "first", downAddr)
+	deadline := time.Now().Add(time.Second * 5)
+	for through("failover") != "first" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected connections back on the first destination once its health check passed")
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+}
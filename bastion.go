@@ -0,0 +1,43 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var lookupSRV = net.LookupSRV
+
+// isSRVName reports whether host names an SRV record such as _ssh._tcp.bastions.example.com rather than an address
+func isSRVName(host string) bool {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return false
+	}
+	return strings.HasPrefix(host, "_")
+}
+
+// bastionAddresses returns the addresses to try, in order, to reach host. An SRV name resolves to its targets
+// ordered by priority and, within a priority, randomly by weight (RFC 2782).
+func bastionAddresses(host string) ([]string, error) {
+	if !isSRVName(host) {
+		return []string{host}, nil
+	}
+	_, records, err := lookupSRV("", "", host)
+	if err != nil {
+		return nil, &UnreachableError{Host: host, Err: fmt.Errorf("unable to look up bastions: %v", err)}
+	}
+	addrs := make([]string, 0, len(records))
+	for _, r := range records {
+		// a target of "." means the service is decidedly not available (RFC 2782)
+		if r.Target == "." {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))))
+	}
+	if len(addrs) == 0 {
+		return nil, &UnreachableError{Host: host, Err: errors.New("no bastions available")}
+	}
+	return addrs, nil
+}
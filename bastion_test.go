@@ -0,0 +1,36 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestBastionAddresses(t *testing.T) {
+	defer func(l func(string, string, string) (string, []*net.SRV, error)) { lookupSRV = l }(lookupSRV)
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_ssh._tcp.bastions.example.com" {
+			return "", nil, errors.New("no such host")
+		}
+		return name, []*net.SRV{
+			{Target: "eu.example.com.", Port: 22, Priority: 1},
+			{Target: "us.example.com.", Port: 2222, Priority: 2},
+		}, nil
+	}
+
+	addrs, err := bastionAddresses("bastion.example.com:22")
+	if err != nil || !reflect.DeepEqual(addrs, []string{"bastion.example.com:22"}) {
+		t.Fatalf("plain address should be used as is, got %v %v", addrs, err)
+	}
+	addrs, err = bastionAddresses("_ssh._tcp.bastions.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"eu.example.com:22", "us.example.com:2222"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("expected %v, got %v", want, addrs)
+	}
+	if _, err := bastionAddresses("_ssh._tcp.nowhere.example.com"); err == nil {
+		t.Fatal("expected a failed lookup to be an error")
+	}
+}
@@ -0,0 +1,248 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// CaptureFormat is how a forward's captured traffic is written
+type CaptureFormat int
+
+const (
+	// HexDump writes every chunk of traffic as a hex dump headed by when it was sent, its connection and direction
+	HexDump CaptureFormat = iota
+	// Pcap writes a pcap file, as read by wireshark and tcpdump, with every connection a tcp stream between the
+	// client and the forward's listener. Connections that aren't over IPv4, such as unix sockets, are given
+	// addresses in 127.0.0.0/8.
+	Pcap
+)
+
+// pcapMaxPayload keeps the synthetic IPv4 packets of a pcap capture within their 16 bit length
+const pcapMaxPayload = 65000
+
+// Capture has the traffic tunneled by a forward written to File, to debug protocol issues through the tunnel
+// without a capture on the remote host
+type Capture struct {
+	// File is appended to by every connection through the forward
+	File   string
+	Format CaptureFormat
+	// MaxBytes, when set, stops capturing once File has grown this large
+	MaxBytes int64
+	// Redact are patterns, such as of passwords or tokens, whose matches are replaced by as many * before being
+	// captured. They're matched within each chunk read from the connection, so one split across chunks isn't.
+	Redact []*regexp.Regexp
+}
+
+// WithCapture writes the traffic of every connection through the forward to a file as described by capture
+func (f Forwarder) WithCapture(capture Capture) Forwarder {
+	f.capture = &capture
+	return f
+}
+
+// capturer writes the captured traffic of a forward's connections, shared between them
+type capturer struct {
+	capture Capture
+	logger  Logger
+
+	mu          sync.Mutex
+	file        *os.File
+	written     int64
+	connections int
+	full        bool
+}
+
+// openCapture opens the capture file of a forward, starting a pcap file that's empty with its header
+func openCapture(capture Capture, logger Logger) (*capturer, error) {
+	file, err := os.OpenFile(capture.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open capture file %s: %v", capture.File, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	c := &capturer{capture: capture, logger: logger, file: file, written: info.Size()}
+	if capture.Format == Pcap && info.Size() == 0 {
+		header := make([]byte, 24)
+		binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+		binary.LittleEndian.PutUint16(header[4:], 2)
+		binary.LittleEndian.PutUint16(header[6:], 4)
+		binary.LittleEndian.PutUint32(header[16:], 65535)
+		// LINKTYPE_RAW: packets start with their IP header
+		binary.LittleEndian.PutUint32(header[20:], 101)
+		if err := c.write(header); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *capturer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// write appends b to the file unless it's full; c.mu is held
+func (c *capturer) write(b []byte) error {
+	if c.file == nil || c.full {
+		return nil
+	}
+	if c.capture.MaxBytes > 0 && c.written+int64(len(b)) > c.capture.MaxBytes {
+		c.full = true
+		logAt(c.logger, LevelWarn, "capture to %s stopped at its limit of %d bytes", c.capture.File, c.capture.MaxBytes)
+		return nil
+	}
+	n, err := c.file.Write(b)
+	c.written += int64(n)
+	return err
+}
+
+// redact replaces the matches of the capture's patterns in b by as many *, keeping the length so that pcap
+// sequence numbers still add up
+func (c *capturer) redact(b []byte) []byte {
+	if len(c.capture.Redact) == 0 {
+		return b
+	}
+	b = append([]byte(nil), b...)
+	for _, pattern := range c.capture.Redact {
+		for _, match := range pattern.FindAllIndex(b, -1) {
+			for i := match[0]; i < match[1]; i++ {
+				b[i] = '*'
+			}
+		}
+	}
+	return b
+}
+
+// captureConnection is one connection through the forward, seen as a tcp stream between client and server
+type captureConnection struct {
+	c      *capturer
+	id     int
+	client *net.TCPAddr
+	server *net.TCPAddr
+	// seq are the next sequence numbers from the client and from the server
+	seq [2]uint32
+	// destination is where the forward's connection goes, for hex dumps
+	destination string
+}
+
+func (c *capturer) connection(conn net.Conn, destination string) *captureConnection {
+	c.mu.Lock()
+	c.connections++
+	id := c.connections
+	c.mu.Unlock()
+	client, server := captureAddr(conn.RemoteAddr(), id, 1), captureAddr(conn.LocalAddr(), id, 2)
+	return &captureConnection{c: c, id: id, client: client, server: server, destination: destination}
+}
+
+// captureAddr is addr when it's an IPv4 tcp address, otherwise a made up one distinct for every connection
+func captureAddr(addr net.Addr, id int, host byte) *net.TCPAddr {
+	if tcp, ok := addr.(*net.TCPAddr); ok && tcp.IP.To4() != nil {
+		return &net.TCPAddr{IP: tcp.IP.To4(), Port: tcp.Port}
+	}
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, host).To4(), Port: 1024 + id%64000}
+}
+
+// writer captures what's written to w, in direction toServer or back to the client
+func (cc *captureConnection) writer(w io.Writer, toServer bool) io.Writer {
+	return &captureWriter{w: w, cc: cc, toServer: toServer}
+}
+
+type captureWriter struct {
+	w        io.Writer
+	cc       *captureConnection
+	toServer bool
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	if n > 0 {
+		cw.cc.record(b[:n], cw.toServer)
+	}
+	return n, err
+}
+
+func (cc *captureConnection) record(b []byte, toServer bool) {
+	c := cc.c
+	b = c.redact(b)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	if c.capture.Format == Pcap {
+		for len(b) > 0 && err == nil {
+			chunk := b
+			if len(chunk) > pcapMaxPayload {
+				chunk = chunk[:pcapMaxPayload]
+			}
+			err = c.write(cc.packet(now, chunk, toServer))
+			b = b[len(chunk):]
+		}
+	} else {
+		from, to := cc.client.String(), cc.destination
+		if !toServer {
+			from, to = to, from
+		}
+		var dump bytes.Buffer
+		fmt.Fprintf(&dump, "%s conn %d %s -> %s %d bytes\n", now.UTC().Format(time.RFC3339Nano), cc.id, from, to, len(b))
+		dump.WriteString(hex.Dump(b))
+		dump.WriteString("\n")
+		err = c.write(dump.Bytes())
+	}
+	if err != nil {
+		logAt(c.logger, LevelWarn, "unable to write capture to %s: %v", c.capture.File, err)
+		c.full = true
+	}
+}
+
+// packet is a pcap record of payload as an IPv4 tcp segment of the connection's stream, acknowledging all the
+// other side has sent; checksums are left out
+func (cc *captureConnection) packet(at time.Time, payload []byte, toServer bool) []byte {
+	src, dst, side := cc.server, cc.client, 1
+	if toServer {
+		src, dst, side = cc.client, cc.server, 0
+	}
+	length := 40 + len(payload)
+	record := make([]byte, 16+length)
+	binary.LittleEndian.PutUint32(record[0:], uint32(at.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(at.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(length))
+	binary.LittleEndian.PutUint32(record[12:], uint32(length))
+
+	ip := record[16:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(length))
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:16], src.IP)
+	copy(ip[16:20], dst.IP)
+
+	tcp := ip[20:]
+	binary.BigEndian.PutUint16(tcp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], cc.seq[side])
+	binary.BigEndian.PutUint32(tcp[8:], cc.seq[1-side])
+	tcp[12] = 5 << 4
+	// PSH, ACK
+	tcp[13] = 0x18
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	copy(tcp[20:], payload)
+	cc.seq[side] += uint32(len(payload))
+	return record
+}
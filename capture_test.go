@@ -0,0 +1,86 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCaptureHexDump(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "capture.txt")
+	c, err := openCapture(Capture{File: file, Redact: []*regexp.Regexp{regexp.MustCompile(`secret=\w+`)}}, EmptyLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	captured := c.connection(server, "db:5432")
+	var out bytes.Buffer
+	captured.writer(&out, true).Write([]byte("login secret=hunter2"))
+	captured.writer(&out, false).Write([]byte("ok"))
+	c.Close()
+
+	if out.String() != "login secret=hunter2ok" {
+		t.Fatalf("expected the traffic to be passed on as is, got %q", out.String())
+	}
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump := string(contents)
+	if strings.Contains(dump, "hunter2") || !strings.Contains(dump, "|login **********|") {
+		t.Fatalf("expected the secret to be redacted:\n%s", dump)
+	}
+	if !strings.Contains(dump, "conn 1 ") || !strings.Contains(dump, "-> db:5432 20 bytes") || !strings.Contains(dump, "db:5432 -> ") {
+		t.Fatalf("expected both directions to be headed:\n%s", dump)
+	}
+}
+
+func TestCapturePcap(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "capture.pcap")
+	c, err := openCapture(Capture{File: file, Format: Pcap, MaxBytes: 24 + 2*(16+40+5)}, EmptyLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	captured := c.connection(server, "db:5432")
+	captured.writer(&bytes.Buffer{}, true).Write([]byte("hello"))
+	captured.writer(&bytes.Buffer{}, false).Write([]byte("world"))
+	// over the limit
+	captured.writer(&bytes.Buffer{}, true).Write([]byte("again"))
+	c.Close()
+
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 24+2*(16+40+5) || binary.LittleEndian.Uint32(contents) != 0xa1b2c3d4 {
+		t.Fatalf("expected a pcap header and two packets, got %d bytes", len(contents))
+	}
+	first, second := contents[24:24+61], contents[24+61:]
+	if string(first[16+40:]) != "hello" || string(second[16+40:]) != "world" {
+		t.Fatalf("unexpected payloads %q %q", first[16+40:], second[16+40:])
+	}
+	// the reply acknowledges the 5 bytes sent
+	if seq, ack := binary.BigEndian.Uint32(first[16+24:]), binary.BigEndian.Uint32(second[16+28:]); seq != 0 || ack != 5 {
+		t.Fatalf("unexpected sequence numbers %d %d", seq, ack)
+	}
+
+	// appending to the file doesn't repeat the header
+	c, err = openCapture(Capture{File: file, Format: Pcap}, EmptyLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if info, _ := os.Stat(file); info.Size() != int64(len(contents)) {
+		t.Fatalf("expected the file to be left as is, got %d bytes", info.Size())
+	}
+}
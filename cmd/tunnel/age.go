@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// Passphrase encrypted files in the age format (age-encryption.org/v1), as written by age -p, so sealed secrets
+// files can be made and read with age as well as with tunnel seal
+
+const (
+	ageDefaultLogN = 18
+	ageMaxLogN     = 22
+)
+
+// ageEncrypt encrypts plaintext with passphrase, armored so it can be committed as text. logN is scrypt's work
+// factor.
+func ageEncrypt(plaintext []byte, passphrase string, logN int) ([]byte, error) {
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	recipient.SetWorkFactor(logN)
+	sealed := &bytes.Buffer{}
+	armored := armor.NewWriter(sealed)
+	w, err := age.Encrypt(armored, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := armored.Close(); err != nil {
+		return nil, err
+	}
+	return sealed.Bytes(), nil
+}
+
+// ageDecrypt decrypts a file encrypted with a passphrase, armored or not
+func ageDecrypt(file []byte, passphrase string) ([]byte, error) {
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	identity.SetMaxWorkFactor(ageMaxLogN)
+	var r io.Reader = bytes.NewReader(file)
+	if trimmed := bytes.TrimSpace(file); bytes.HasPrefix(trimmed, []byte(armor.Header)) {
+		r = armor.NewReader(bytes.NewReader(trimmed))
+	}
+	d, err := age.Decrypt(r, identity)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, errors.New("incorrect passphrase")
+		}
+		return nil, err
+	}
+	return io.ReadAll(d)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// benchDials is how many times the tunnel's target is dialed to measure how long connecting to it takes
+	benchDials = 5
+	// benchRoundTrips is how many small messages are echoed to measure the round trip
+	benchRoundTrips = 20
+	// benchChunk is how much is written at a time to measure throughput
+	benchChunk = 32 * 1024
+	// benchDrainTimeout is how long what's been written may take to be echoed once writing stops
+	benchDrainTimeout = time.Second * 30
+)
+
+func benchCommand(opts *config) *cli.Command {
+	var duration time.Duration
+	var echo bool
+	return &cli.Command{
+		Name:      "bench",
+		Usage:     "measure the latency and throughput of a tunnel through its ssh connection",
+		UsageText: "tunnel bench [options] <config file> <tunnel name>",
+		Flags: []cli.Flag{
+			&cli.DurationFlag{
+				Name:        "duration",
+				Usage:       "how long to measure throughput for",
+				Value:       time.Second * 5,
+				Destination: &duration,
+			},
+			&cli.BoolFlag{
+				Name:        "echo",
+				Usage:       "the tunnel's target echoes what it's sent, so measure through it rather than cat run on the host",
+				Destination: &echo,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			if ctx.NArg() != 2 {
+				return errors.New("config file and tunnel name are required")
+			}
+			opts.configFile = ctx.Args().First()
+			tunnelConf, err := loadConfig(opts)
+			if err != nil {
+				return err
+			}
+			name := ctx.Args().Get(1)
+			id, target, ok := tunnelTarget(tunnelConf.SshConfigs, name)
+			if !ok {
+				return fmt.Errorf("no tunnel named %s", name)
+			}
+			t, closeHop, err := dialHop(ctx.Context, tunnelConf, id, opts)
+			if err != nil {
+				return err
+			}
+			defer closeHop()
+			return bench(t, id, target, echo, duration)
+		},
+	}
+}
+
+// bench prints how long dialing target through t takes, then the round trip and throughput of echoing through
+// target, or a cat session on the host
+func bench(t *tunnel.Tunnel, host, target string, echo bool, duration time.Duration) error {
+	dials := make([]time.Duration, benchDials)
+	for i := range dials {
+		start := time.Now()
+		conn, err := tunnel.DialWithTimeout(t.Client(), "tcp", target, time.Second*10)
+		if err != nil {
+			return fmt.Errorf("unable to connect to %s: %v", target, err)
+		}
+		dials[i] = time.Since(start)
+		conn.Close()
+	}
+	fmt.Printf("connecting to %s through %s: %s\n", target, host, formatDurations(dials))
+
+	var rw io.ReadWriter
+	through := "cat on " + host
+	if echo {
+		conn, err := tunnel.DialWithTimeout(t.Client(), "tcp", target, time.Second*10)
+		if err != nil {
+			return fmt.Errorf("unable to connect to %s: %v", target, err)
+		}
+		defer conn.Close()
+		rw, through = conn, target
+	} else {
+		session, err := t.NewSession()
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			return err
+		}
+		stdout, err := session.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := session.Start("cat"); err != nil {
+			return fmt.Errorf("unable to run cat on %s: %v", host, err)
+		}
+		rw = struct {
+			io.Reader
+			io.Writer
+		}{stdout, stdin}
+	}
+	roundTrips, err := measureRoundTrips(rw, benchRoundTrips)
+	if err != nil {
+		return fmt.Errorf("echoing through %s: %v", through, err)
+	}
+	fmt.Printf("round trip through %s: %s\n", through, formatDurations(roundTrips))
+	echoed, elapsed, err := measureThroughput(rw, duration)
+	if err != nil {
+		return fmt.Errorf("echoing through %s: %v", through, err)
+	}
+	fmt.Printf("throughput through %s: %s/s, %s echoed in %v\n", through,
+		formatBytes(float64(echoed)/elapsed.Seconds()), formatBytes(float64(echoed)), elapsed.Round(time.Millisecond))
+	return nil
+}
+
+// measureRoundTrips times echoing a small message through rw n times
+func measureRoundTrips(rw io.ReadWriter, n int) ([]time.Duration, error) {
+	message := make([]byte, 64)
+	reply := make([]byte, len(message))
+	durations := make([]time.Duration, n)
+	for i := range durations {
+		start := time.Now()
+		if _, err := rw.Write(message); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(rw, reply); err != nil {
+			return nil, err
+		}
+		durations[i] = time.Since(start)
+	}
+	return durations, nil
+}
+
+// measureThroughput writes to rw for duration while reading back what it echoes, returning how much was echoed
+// and how long that took. What's read is left to a reader that only stops once rw is closed.
+func measureThroughput(rw io.ReadWriter, duration time.Duration) (int64, time.Duration, error) {
+	var echoed int64
+	readErr := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		buf := make([]byte, benchChunk)
+		for {
+			n, err := rw.Read(buf)
+			atomic.AddInt64(&echoed, int64(n))
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+	var written int64
+	chunk := make([]byte, benchChunk)
+	for time.Since(start) < duration {
+		n, err := rw.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			return atomic.LoadInt64(&echoed), time.Since(start), err
+		}
+	}
+	poll := time.NewTicker(time.Millisecond)
+	defer poll.Stop()
+	giveUp := time.NewTimer(benchDrainTimeout)
+	defer giveUp.Stop()
+	for atomic.LoadInt64(&echoed) < written {
+		select {
+		case err := <-readErr:
+			return atomic.LoadInt64(&echoed), time.Since(start), err
+		case <-giveUp.C:
+			return atomic.LoadInt64(&echoed), time.Since(start), fmt.Errorf("only %d of %d bytes echoed", atomic.LoadInt64(&echoed), written)
+		case <-poll.C:
+		}
+	}
+	return written, time.Since(start), nil
+}
+
+// formatDurations summarises durations as their minimum, average and maximum
+func formatDurations(durations []time.Duration) string {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	average := total / time.Duration(len(sorted))
+	round := func(d time.Duration) time.Duration { return d.Round(time.Microsecond) }
+	return fmt.Sprintf("min %v, avg %v, max %v", round(sorted[0]), round(average), round(sorted[len(sorted)-1]))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arunsworld/go-tunnel/tunneltest"
+)
+
+func TestBench(t *testing.T) {
+	server := tunneltest.StartServer(t)
+	server.HandleDestination("echo.internal:7", tunneltest.Echo)
+	tunnelConf := tunnelConfig{SshConfigs: []sshConfig{{
+		Destination: server.Addr,
+		User:        server.User,
+		Auth:        []auth{{PwdAuth: pwdAuth{PasswordSecret: "pwd", password: staticSecret(server.Password)}}},
+		Tunnels:     []portForward{{Name: "echo", Port: 2000, Target: "echo.internal:7"}},
+	}}}
+	id, target, ok := tunnelTarget(tunnelConf.SshConfigs, "echo")
+	if !ok {
+		t.Fatal("expected the tunnel to be found")
+	}
+	opts := &config{knownHostsFile: filepath.Join(t.TempDir(), "known_hosts"), logger: stderrLogger{}}
+	tun, closeHop, err := dialHop(context.Background(), tunnelConf, id, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeHop()
+	for _, echo := range []bool{true, false} {
+		if err := bench(tun, id, target, echo, time.Millisecond*100); err != nil {
+			t.Fatalf("echo %v: %v", echo, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+// captureConfig writes the traffic of a tunnel to File, as a hex dump or pcap by Format, stopping once it's
+// MaxBytes large. Matches of the Redact regular expressions are replaced by * first.
+type captureConfig struct {
+	File     string
+	Format   string
+	MaxBytes int64
+	Redact   []string
+}
+
+func (c *captureConfig) toCapture() (tunnel.Capture, error) {
+	capture := tunnel.Capture{File: c.File, MaxBytes: c.MaxBytes}
+	if c.File == "" {
+		return capture, fmt.Errorf("capture has no file")
+	}
+	switch strings.ToLower(c.Format) {
+	case "", "hex":
+		capture.Format = tunnel.HexDump
+	case "pcap":
+		capture.Format = tunnel.Pcap
+	default:
+		return capture, fmt.Errorf("unknown capture format %s, expected hex or pcap", c.Format)
+	}
+	for _, r := range c.Redact {
+		pattern, err := regexp.Compile(r)
+		if err != nil {
+			return capture, fmt.Errorf("capture redact %s: %v", r, err)
+		}
+		capture.Redact = append(capture.Redact, pattern)
+	}
+	return capture, nil
+}
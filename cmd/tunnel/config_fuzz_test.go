@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func FuzzParseConfig(f *testing.F) {
+	sample, err := os.ReadFile("sample.yml")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(sample)
+	f.Add([]byte("version: 1\nsshconfigs:\n- destination: host:22\n  tunnels:\n  - port: 2000\n    target: a:80\n"))
+	f.Add([]byte("secrets:\n- name: pwd\n  env: PWD_ENV\nsshconfigs:\n- destination: host:22\n  auth:\n  - pwdauth:\n      passwordsecret: pwd\n"))
+	f.Add([]byte("sshconfigs:\n- destination: host:22\n  vpn:\n    address: 10.0.0.1/24\n    routes: [not a cidr]\n"))
+	f.Add([]byte("sshconfigs:\n- destination: host:22\n  suspendafter: forever\n"))
+
+	f.Fuzz(func(t *testing.T, contents []byte) {
+		tunnelConf, err := parseConfig(contents)
+		if err != nil {
+			return
+		}
+		// secrets would be prompted for; give every named one a value instead
+		vault := secretsVault{}
+		for _, s := range tunnelConf.Secrets {
+			vault[s.Name] = staticSecret("secret")
+		}
+		if err := validateConfig(&tunnelConf, vault); err != nil {
+			return
+		}
+		opts := &config{knownHostsFile: filepath.Join(t.TempDir(), "known_hosts")}
+		for _, c := range tunnelConf.SshConfigs {
+			specFor(c, opts)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+func cpCommand(opts *config) *cli.Command {
+	return &cli.Command{
+		Name:      "cp",
+		Usage:     "copy a file to or from a host through the configured hops",
+		UsageText: "tunnel cp <config file> <local path> <hop>:<remote path>\n   tunnel cp <config file> <hop>:<remote path> <local path>",
+		Action: func(ctx *cli.Context) error {
+			if ctx.NArg() != 3 {
+				return errors.New("config file, source and destination are required")
+			}
+			opts.configFile = ctx.Args().First()
+			tunnelConf, err := loadConfig(opts)
+			if err != nil {
+				return err
+			}
+			src, dst := ctx.Args().Get(1), ctx.Args().Get(2)
+			ids := hopIDs(tunnelConf.SshConfigs)
+			srcHop, srcPath := splitHopPath(src, ids)
+			dstHop, dstPath := splitHopPath(dst, ids)
+			switch {
+			case srcHop == "" && dstHop == "":
+				return errors.New("one of source or destination must be <hop>:<path>")
+			case srcHop != "" && dstHop != "":
+				return errors.New("copying between two remote hosts is not supported")
+			}
+			hop := srcHop + dstHop
+			t, closeHop, err := dialHop(ctx.Context, tunnelConf, hop, opts)
+			if err != nil {
+				return err
+			}
+			defer closeHop()
+			if dstHop != "" {
+				return t.CopyFile(srcPath, dstPath)
+			}
+			return t.CopyFileFrom(srcPath, dstPath)
+		},
+	}
+}
+
+// splitHopPath splits <hop>:<path> for any known hop id; a plain local path returns an empty hop
+func splitHopPath(arg string, ids []string) (string, string) {
+	for _, id := range ids {
+		if strings.HasPrefix(arg, id+":") {
+			return id, strings.TrimPrefix(arg, id+":")
+		}
+	}
+	return "", arg
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// controlRequest is a change asked for over the control socket, carried out by run's loop
+type controlRequest struct {
+	action string
+	// name is the entry to remove
+	name string
+	// contents is the config whose entries to add
+	contents []byte
+	reply    chan error
+}
+
+// daemonEntries are the entries the daemon runs: those of the config file, less the ones removed since it was
+// last read, and those added
+type daemonEntries struct {
+	file    []sshConfig
+	added   []sshConfig
+	removed map[string]bool
+}
+
+func (d *daemonEntries) all() []sshConfig {
+	all := []sshConfig{}
+	for _, c := range d.file {
+		if !d.removed[c.id()] {
+			all = append(all, c)
+		}
+	}
+	return append(all, d.added...)
+}
+
+// add adds the entries of contents, refusing names already running
+func (d *daemonEntries) add(opts *config, contents []byte) error {
+	tunnelConf, err := parseConfig(contents)
+	if err != nil {
+		return fmt.Errorf("unable to parse config: %v", err)
+	}
+	if len(tunnelConf.SshConfigs) == 0 {
+		return errors.New("no entries to add")
+	}
+	if err := prepareConfig(opts, &tunnelConf, opts.secrets); err != nil {
+		return err
+	}
+	running := map[string]bool{}
+	for _, c := range d.all() {
+		running[c.id()] = true
+	}
+	for _, c := range tunnelConf.SshConfigs {
+		if running[c.id()] {
+			return fmt.Errorf("%s is already running", c.id())
+		}
+		running[c.id()] = true
+	}
+	d.added = append(d.added, tunnelConf.SshConfigs...)
+	return nil
+}
+
+// remove stops the entry named name; one from the config file comes back when it's reloaded
+func (d *daemonEntries) remove(name string) error {
+	for i, c := range d.added {
+		if c.id() == name {
+			d.added = append(d.added[:i], d.added[i+1:]...)
+			return nil
+		}
+	}
+	for _, c := range d.file {
+		if c.id() == name && !d.removed[name] {
+			if d.removed == nil {
+				d.removed = map[string]bool{}
+			}
+			d.removed[name] = true
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not running", name)
+}
+
+func defaultControlSocket() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "tunnel.sock"
+	}
+	return filepath.Join(dir, "go-tunnel", "control.sock")
+}
+
+func daemonCommand(opts *config) *cli.Command {
+	var foreground bool
+	var logFile string
+	return &cli.Command{
+		Name:  "daemon",
+		Usage: "run the tunnels of a config in the background, managed with the status, add, remove, reload, upgrade and stop commands",
+		UsageText: "tunnel daemon [--foreground] [--log-file <file>] <config file>\n" +
+			"   secrets can't be prompted for in the background so have to come from the environment",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:        "foreground",
+				Usage:       "run in the foreground instead, as under a service manager",
+				Destination: &foreground,
+			},
+			&cli.StringFlag{
+				Name:        "log-file",
+				Usage:       "file to log to in the background (default daemon.log next to the control socket)",
+				Destination: &logFile,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			if ctx.NArg() != 1 {
+				return errors.New("config file not provided")
+			}
+			if foreground {
+				opts.configFile = ctx.Args().First()
+				// the name is only needed by services sharing a process, which this isn't
+				isService, err := runAsWindowsService("go-tunnel", func(ctx context.Context) error {
+					return runDaemon(ctx, opts)
+				})
+				if isService {
+					return err
+				}
+				return runDaemon(ctx.Context, opts)
+			}
+			if logFile == "" {
+				logFile = filepath.Join(filepath.Dir(opts.controlSocket), "daemon.log")
+			}
+			return startDaemon(opts.controlSocket, logFile)
+		},
+	}
+}
+
+// startDaemon runs this command again in the foreground, detached with its output going to logFile, and waits
+// for it to serve the control socket
+func startDaemon(socket, logFile string) error {
+	if _, err := controlCall(socket, http.MethodGet, "/status", nil); err == nil {
+		return fmt.Errorf("a daemon is already listening on %s", socket)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(logFile), 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open log file %s: %v", logFile, err)
+	}
+	defer out.Close()
+	args := []string{}
+	for i, arg := range os.Args[1:] {
+		args = append(args, arg)
+		if arg == "daemon" {
+			args = append(append(args, "--foreground"), os.Args[i+2:]...)
+			break
+		}
+	}
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout, cmd.Stderr = out, out
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to start daemon: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	deadline := time.After(time.Minute)
+	for {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("daemon exited (%v), see %s", err, logFile)
+		case <-deadline:
+			return fmt.Errorf("daemon didn't start listening on %s, see %s", socket, logFile)
+		case <-time.After(time.Millisecond * 100):
+		}
+		if _, err := controlCall(socket, http.MethodGet, "/status", nil); err == nil {
+			fmt.Printf("daemon running as pid %d, logging to %s\n", cmd.Process.Pid, logFile)
+			return nil
+		}
+	}
+}
+
+// runDaemon runs the config with the control socket served
+func runDaemon(ctx context.Context, opts *config) error {
+	listener, err := listenControlSocket(opts)
+	if err != nil {
+		return err
+	}
+	// tunnels are bound for an upgraded daemon to take them over
+	opts.reusePort = true
+	opts.upgrade = func() error {
+		return upgradeDaemon(listener)
+	}
+	opts.control = make(chan controlRequest)
+	// created ahead of run for status to be served from the start
+	opts.registry = newTunnelRegistry(nil)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	server := &http.Server{Handler: controlHandler(ctx, opts)}
+	go server.Serve(listener)
+	defer server.Close()
+	return run(ctx, opts)
+}
+
+// listenControlSocket listens on the control socket, or takes it over from the daemon upgraded to this one
+func listenControlSocket(opts *config) (net.Listener, error) {
+	if os.Getenv(upgradeEnv) != "" {
+		listener, started, err := inheritControlSocket(opts)
+		opts.started = started
+		return listener, err
+	}
+	socket := opts.controlSocket
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %s", socket)
+	}
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, err
+	}
+	// left behind by a daemon that didn't exit cleanly
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %v", socket, err)
+	}
+	os.Chmod(socket, 0600)
+	return listener, nil
+}
+
+// controlHandler serves the control API, handing changes to run's loop
+func controlHandler(ctx context.Context, opts *config) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newStatusReport(opts.registry.snapshot(), time.Now()))
+	})
+	change := func(action string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "POST required", http.StatusMethodNotAllowed)
+				return
+			}
+			contents, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req := controlRequest{action: action, name: r.URL.Query().Get("name"), contents: contents, reply: make(chan error, 1)}
+			select {
+			case opts.control <- req:
+			case <-ctx.Done():
+				http.Error(w, "daemon is stopping", http.StatusServiceUnavailable)
+				return
+			}
+			if err := <-req.reply; err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		}
+	}
+	for _, action := range []string{"add", "remove", "reload", "stop", "upgrade"} {
+		mux.HandleFunc("/"+action, change(action))
+	}
+	return mux
+}
+
+// controlCall makes a request of the daemon listening on socket, returning the response body
+func controlCall(socket, method, path string, body []byte) ([]byte, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+		Timeout: time.Minute,
+	}
+	defer client.CloseIdleConnections()
+	req, err := http.NewRequest(method, "http://daemon"+path, strings.NewReader(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the daemon on %s: %v", socket, err)
+	}
+	defer resp.Body.Close()
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(strings.TrimSpace(string(contents)))
+	}
+	return contents, nil
+}
+
+// controlCommands are the commands managing a running daemon
+func controlCommands(opts *config) []*cli.Command {
+	simple := func(name, usage, path string) *cli.Command {
+		return &cli.Command{
+			Name:  name,
+			Usage: usage,
+			Action: func(ctx *cli.Context) error {
+				_, err := controlCall(opts.controlSocket, http.MethodPost, path, nil)
+				return err
+			},
+		}
+	}
+	return []*cli.Command{
+		{
+			Name:  "status",
+			Usage: "show the state of the daemon's tunnels",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the state, uptime, reconnects and counters of every tunnel and forward as JSON",
+				},
+			},
+			Action: func(ctx *cli.Context) error {
+				contents, err := controlCall(opts.controlSocket, http.MethodGet, "/status", nil)
+				if err != nil {
+					return err
+				}
+				if ctx.Bool("json") {
+					return printStatusJSON(os.Stdout, contents)
+				}
+				return printStatus(os.Stdout, contents)
+			},
+		},
+		{
+			Name:      "add",
+			Usage:     "start the entries of a config file in the daemon",
+			UsageText: "tunnel add <config file>",
+			Action: func(ctx *cli.Context) error {
+				if ctx.NArg() != 1 {
+					return errors.New("config file not provided")
+				}
+				// includes and the environment are resolved here, as they're known to this process
+				tunnelConf, err := loadConfigFile(ctx.Args().First(), opts.configFormat, nil)
+				if err != nil {
+					return err
+				}
+				contents, err := yaml.Marshal(tunnelConf)
+				if err != nil {
+					return err
+				}
+				_, err = controlCall(opts.controlSocket, http.MethodPost, "/add", contents)
+				return err
+			},
+		},
+		{
+			Name:      "remove",
+			Usage:     "stop an entry of the daemon; one from its config file is back when it's reloaded",
+			UsageText: "tunnel remove <name>",
+			Action: func(ctx *cli.Context) error {
+				if ctx.NArg() != 1 {
+					return errors.New("name not provided")
+				}
+				_, err := controlCall(opts.controlSocket, http.MethodPost, "/remove?name="+url.QueryEscape(ctx.Args().First()), nil)
+				return err
+			},
+		},
+		simple("reload", "have the daemon reload its config file, restarting only the entries that changed", "/reload"),
+		simple("stop", "stop the daemon and its tunnels", "/stop"),
+		{
+			Name:  "upgrade",
+			Usage: "have the daemon start its executable again, as after replacing it, and hand over its tunnels without closing their ports",
+			UsageText: "tunnel upgrade\n" +
+				"   connections in progress are left to the old daemon to finish for up to their entry's draintimeout;\n" +
+				"   a daemon run by a service manager is restarted by it instead",
+			Action: func(ctx *cli.Context) error {
+				_, err := controlCall(opts.controlSocket, http.MethodPost, "/upgrade", nil)
+				return err
+			},
+		},
+	}
+}
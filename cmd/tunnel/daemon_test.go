@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arunsworld/go-tunnel/tunneltest"
+)
+
+func TestDaemon(t *testing.T) {
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	t.Setenv("TEST_DAEMON_PWD", server.Password)
+	entry := func(name string, port int) string {
+		return fmt.Sprintf(`secrets:
+- name: pwd
+  env: TEST_DAEMON_PWD
+sshconfigs:
+- name: %s
+  destination: %s
+  user: %s
+  auth:
+  - pwdauth:
+      passwordsecret: pwd
+  tunnels:
+  - name: web
+    port: %d
+    target: localhost:80
+`, name, server.Addr, server.User, port)
+	}
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(configFile, []byte(entry("first", 1253)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "control.sock")
+	opts := &config{configFile: configFile, controlSocket: socket, knownHostsFile: filepath.Join(dir, "known_hosts")}
+	done := make(chan error, 1)
+	go func() {
+		done <- runDaemon(context.Background(), opts)
+	}()
+
+	status := func(expected string) {
+		t.Helper()
+		var out bytes.Buffer
+		for start := time.Now(); time.Since(start) < time.Second*5; time.Sleep(time.Millisecond * 50) {
+			contents, err := controlCall(socket, http.MethodGet, "/status", nil)
+			if err != nil {
+				continue
+			}
+			out.Reset()
+			printStatus(&out, contents)
+			lines := []string{}
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n")[1:] {
+				lines = append(lines, strings.Join(strings.Fields(line), " "))
+			}
+			if strings.Join(lines, ", ") == expected {
+				return
+			}
+		}
+		t.Fatalf("expected status %q, got\n%s", expected, out.String())
+	}
+	status("first connected")
+
+	if _, err := controlCall(socket, http.MethodPost, "/add", []byte(entry("second", 1254))); err != nil {
+		t.Fatal(err)
+	}
+	status("first connected, second connected")
+	if _, err := controlCall(socket, http.MethodPost, "/add", []byte(entry("second", 1255))); err == nil {
+		t.Fatal("expected adding a running entry again to be refused")
+	}
+
+	if _, err := controlCall(socket, http.MethodPost, "/remove?name=first", nil); err != nil {
+		t.Fatal(err)
+	}
+	status("second connected")
+	if _, err := controlCall(socket, http.MethodPost, "/remove?name=first", nil); err == nil {
+		t.Fatal("expected removing an entry that isn't running to fail")
+	}
+
+	// reloading brings back the config file's entries
+	if _, err := controlCall(socket, http.MethodPost, "/reload", nil); err != nil {
+		t.Fatal(err)
+	}
+	status("first connected, second connected")
+
+	// an upgraded daemon can listen on the tunnels' ports before this one stops
+	successor, err := listenTCP("localhost:1253", true)
+	if err != nil {
+		t.Fatalf("expected the port to be shared with an upgraded daemon: %v", err)
+	}
+	successor.Close()
+
+	if _, err := controlCall(socket, http.MethodPost, "/stop", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("daemon didn't stop")
+	}
+	if _, err := controlCall(socket, http.MethodGet, "/status", nil); err == nil {
+		t.Fatal("expected the control socket to be gone")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// environment patches the config entries identified by name, or destination when unnamed
+type environment map[string]sshOverride
+
+type sshOverride struct {
+	Destination    string
+	User           string
+	Tunnels        map[string]forwardOverride
+	ReverseTunnels map[string]forwardOverride
+	ThroughSSH     environment
+}
+
+// forwardOverride patches the tunnel with the same name
+type forwardOverride struct {
+	Port   int
+	Target string
+}
+
+// applyEnvironment patches the config with the overrides of the named environment; overrides that don't match
+// anything are an error so that typos don't go unnoticed
+func (tc *tunnelConfig) applyEnvironment(name string) error {
+	env, ok := tc.Environments[name]
+	if !ok {
+		known := make([]string, 0, len(tc.Environments))
+		for k := range tc.Environments {
+			known = append(known, k)
+		}
+		sort.Strings(known)
+		return fmt.Errorf("no environment %s in config (have: %s)", name, strings.Join(known, ", "))
+	}
+	if err := env.apply(tc.SshConfigs); err != nil {
+		return fmt.Errorf("environment %s: %v", name, err)
+	}
+	return nil
+}
+
+func (env environment) apply(confs []sshConfig) error {
+	for id, o := range env {
+		i := indexOfConfig(confs, id)
+		if i < 0 {
+			return fmt.Errorf("no config for %s", id)
+		}
+		if err := o.apply(&confs[i]); err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func indexOfConfig(confs []sshConfig, id string) int {
+	for i, c := range confs {
+		if c.Name == id || c.Destination == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o sshOverride) apply(c *sshConfig) error {
+	if o.Destination != "" {
+		c.Destination = o.Destination
+	}
+	if o.User != "" {
+		c.User = o.User
+	}
+	if err := applyForwardOverrides(c.Tunnels, o.Tunnels); err != nil {
+		return err
+	}
+	if err := applyForwardOverrides(c.ReverseTunnels, o.ReverseTunnels); err != nil {
+		return err
+	}
+	return o.ThroughSSH.apply(c.ThroughSSH)
+}
+
+func applyForwardOverrides(forwards []portForward, overrides map[string]forwardOverride) error {
+	for name, o := range overrides {
+		found := false
+		for i := range forwards {
+			if forwards[i].Name != name {
+				continue
+			}
+			if o.Port != 0 {
+				forwards[i].Port = o.Port
+			}
+			if o.Target != "" {
+				forwards[i].Target = o.Target
+			}
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("no tunnel named %s", name)
+		}
+	}
+	return nil
+}
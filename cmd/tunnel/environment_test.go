@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+const environmentsConfig = `
+sshconfigs:
+- name: bastion
+  destination: bastion.dev:22
+  user: dev
+  tunnels:
+  - name: db
+    port: 5432
+    target: db.dev:5432
+  throughssh:
+  - destination: inner.dev:22
+    tunnels:
+    - name: cache
+      port: 6379
+      target: cache.dev:6379
+environments:
+  prod:
+    bastion:
+      destination: bastion.prod:22
+      user: deploy
+      tunnels:
+        db:
+          port: 15432
+          target: db.prod:5432
+      throughssh:
+        inner.dev:22:
+          destination: inner.prod:22
+  typo:
+    bastoin:
+      user: deploy
+`
+
+func TestApplyEnvironment(t *testing.T) {
+	t.Run("patches base config", func(t *testing.T) {
+		conf, err := parseConfig([]byte(environmentsConfig))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conf.applyEnvironment("prod"); err != nil {
+			t.Fatal(err)
+		}
+		bastion := conf.SshConfigs[0]
+		if bastion.Destination != "bastion.prod:22" || bastion.User != "deploy" {
+			t.Fatalf("bastion not patched: %+v", bastion)
+		}
+		if db := bastion.Tunnels[0]; db.Port != 15432 || db.Target != "db.prod:5432" {
+			t.Fatalf("tunnel not patched: %+v", db)
+		}
+		inner := bastion.ThroughSSH[0]
+		if inner.Destination != "inner.prod:22" || inner.Tunnels[0].Target != "cache.dev:6379" {
+			t.Fatalf("hop not patched as expected: %+v", inner)
+		}
+	})
+	t.Run("unknown environment", func(t *testing.T) {
+		conf, _ := parseConfig([]byte(environmentsConfig))
+		if err := conf.applyEnvironment("staging"); err == nil {
+			t.Fatal("expected an error for an unknown environment")
+		}
+	})
+	t.Run("override matching nothing", func(t *testing.T) {
+		conf, _ := parseConfig([]byte(environmentsConfig))
+		if err := conf.applyEnvironment("typo"); err == nil {
+			t.Fatal("expected an error for an override matching no config")
+		}
+	})
+}
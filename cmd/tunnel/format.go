@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// readConfig reads a config file of any format as yaml, with environment variables interpolated
+func readConfig(file, format string) ([]byte, error) {
+	format, err := configFormat(file, format)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open config file %s: %v", file, err)
+	}
+	contents, err = interpolateEnv(contents)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %v", file, err)
+	}
+	contents, err = configToYAML(contents, format)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse config file %s as %s: %v", file, format, err)
+	}
+	return contents, nil
+}
+
+// configFormat is the format of a config file: format when given, otherwise json or toml by the file's extension
+// and yaml for any other
+func configFormat(file, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "":
+	case "yml", "yaml":
+		return "yaml", nil
+	case "json", "toml":
+		return strings.ToLower(format), nil
+	default:
+		return "", fmt.Errorf("unknown config format %s, expected yaml, json or toml", format)
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return "json", nil
+	case ".toml":
+		return "toml", nil
+	}
+	return "yaml", nil
+}
+
+// configToYAML converts a json or toml config to yaml so that every format is parsed by the one schema, with the
+// same keys
+func configToYAML(contents []byte, format string) ([]byte, error) {
+	var conf interface{}
+	switch format {
+	case "yaml":
+		return contents, nil
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.UseNumber()
+		if err := decoder.Decode(&conf); err != nil {
+			return nil, err
+		}
+	case "toml":
+		table := map[string]interface{}{}
+		if _, err := toml.Decode(string(contents), &table); err != nil {
+			return nil, err
+		}
+		conf = table
+	default:
+		return nil, fmt.Errorf("unknown config format %s", format)
+	}
+	return yaml.Marshal(withIntegers(conf))
+}
+
+// withIntegers turns json numbers into integers where they are so that large ones, such as quotas, stay exact
+func withIntegers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = withIntegers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = withIntegers(e)
+		}
+	case []map[string]interface{}:
+		for _, e := range v {
+			withIntegers(e)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigFormats(t *testing.T) {
+	yamlConfig := `
+version: 1
+sshconfigs:
+- name: bastion
+  destination: bastion:22
+  idletimeout: 30m
+  tunnels:
+  - name: db
+    port: 5432
+    target: db:5432
+    maxbytes: 10737418240
+`
+	jsonConfig := `{
+	"version": 1,
+	"sshconfigs": [{
+		"name": "bastion",
+		"destination": "bastion:22",
+		"idletimeout": "30m",
+		"tunnels": [{"name": "db", "port": 5432, "target": "db:5432", "maxbytes": 10737418240}]
+	}]
+}`
+	tomlConfig := `
+version = 1
+
+[[sshconfigs]]
+name = "bastion"
+destination = "bastion:22"
+idletimeout = "30m"
+
+  [[sshconfigs.tunnels]]
+  name = "db"
+  port = 5432
+  target = "db:5432"
+  maxbytes = 10737418240
+`
+	expected, err := parseConfig([]byte(yamlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected.SshConfigs[0].IdleTimeout != 30*time.Minute || expected.SshConfigs[0].Tunnels[0].MaxBytes != 10737418240 {
+		t.Fatalf("unexpected config %+v", expected)
+	}
+	for format, contents := range map[string]string{"json": jsonConfig, "toml": tomlConfig} {
+		converted, err := configToYAML([]byte(contents), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		conf, err := parseConfig(converted)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !reflect.DeepEqual(conf, expected) {
+			t.Fatalf("%s: expected %+v, got %+v", format, expected, conf)
+		}
+	}
+
+	if _, err := parseConfig(mustConvert(t, `{"sshconfigs": [{"destination": "bastion:22", "tunels": []}]}`, "json")); err == nil {
+		t.Fatal("expected unknown keys in json to be rejected")
+	}
+}
+
+func TestConfigFormat(t *testing.T) {
+	cases := []struct{ file, format, expected string }{
+		{"tunnels.yml", "", "yaml"},
+		{"tunnels.json", "", "json"},
+		{"tunnels.TOML", "", "toml"},
+		{"tunnels.conf", "", "yaml"},
+		{"tunnels.conf", "json", "json"},
+	}
+	for _, c := range cases {
+		if format, err := configFormat(c.file, c.format); err != nil || format != c.expected {
+			t.Fatalf("%s with %q: expected %s, got %s (%v)", c.file, c.format, c.expected, format, err)
+		}
+	}
+	if _, err := configFormat("tunnels.yml", "ini"); err == nil {
+		t.Fatal("expected an unknown format to be rejected")
+	}
+}
+
+func mustConvert(t *testing.T, contents, format string) []byte {
+	t.Helper()
+	converted, err := configToYAML([]byte(contents), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return converted
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// onError records errors connecting for conf's entry and notifies the webhook of them
+func (c *config) onError(conf sshConfig) func(error) {
+	notify := c.notifyError(conf.Destination)
+	return func(err error) {
+		c.registry.recordError(conf.id(), err)
+		notify(err)
+	}
+}
+
+type healthReport struct {
+	Status  string         `json:"status"`
+	Tunnels []tunnelHealth `json:"tunnels"`
+}
+
+type tunnelHealth struct {
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// healthHandler reports the state of every entry. Liveness fails once an entry has failed, which it won't
+// recover from without a restart, while readiness needs every entry connected.
+func healthHandler(m *tunnelRegistry, ready bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := healthReport{Status: "ok", Tunnels: []tunnelHealth{}}
+		for _, s := range m.snapshot() {
+			h := tunnelHealth{Name: s.id, State: s.state}
+			if s.lastErr != nil {
+				h.LastError = s.lastErr.Error()
+			}
+			report.Tunnels = append(report.Tunnels, h)
+			if s.state == stateFailed || (ready && s.state != stateConnected) {
+				report.Status = "unavailable"
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// serveHealth serves /healthz and /readyz for the registry on addr until ctx is done
+func serveHealth(ctx context.Context, addr string, m *tunnelRegistry, reusePort bool) error {
+	listener, err := listenTCP(addr, reusePort)
+	if err != nil {
+		return fmt.Errorf("unable to serve health on %s: %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", healthHandler(m, false))
+	mux.Handle("/readyz", healthHandler(m, true))
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go server.Serve(listener)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/arunsworld/go-tunnel/tunneltest"
+)
+
+func TestHealth(t *testing.T) {
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	m := newTunnelRegistry([]sshConfig{
+		{Name: "bastion", Destination: server.Addr, ThroughSSH: []sshConfig{{Destination: "inner:22"}}},
+	})
+	tun, err := tunnel.Execute(&tunnel.Spec{Host: server.Addr, User: server.User, Auth: server.Auth()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	m.track("bastion", tun)
+
+	check := func(path string, ready bool, expectedCode int, expected map[string]string) healthReport {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		healthHandler(m, ready).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != expectedCode {
+			t.Fatalf("%s: expected %d, got %d: %s", path, expectedCode, rec.Code, rec.Body)
+		}
+		report := healthReport{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range report.Tunnels {
+			if expected[h.Name] != h.State {
+				t.Fatalf("%s: expected %s to be %s, got %s", path, h.Name, expected[h.Name], h.State)
+			}
+		}
+		return report
+	}
+
+	states := map[string]string{"bastion": stateConnected, "inner:22": stateConnecting}
+	check("/healthz", false, http.StatusOK, states)
+	check("/readyz", true, http.StatusServiceUnavailable, states)
+
+	m.recordError("inner:22", errors.New("connection refused"))
+	m.fail("inner:22", nil)
+	states["inner:22"] = stateFailed
+	report := check("/healthz", false, http.StatusServiceUnavailable, states)
+	if report.Tunnels[1].LastError != "connection refused" {
+		t.Fatalf("expected the last error to be reported, got %+v", report.Tunnels[1])
+	}
+
+	m.track("inner:22", tun)
+	states["inner:22"] = stateConnected
+	check("/readyz", true, http.StatusOK, states)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+// forwardHooks runs the onup and ondown commands of an entry's tunnels as they start and stop listening
+type forwardHooks struct {
+	entry string
+	// forwards with hooks by the name their events carry
+	forwards map[string]portForward
+	// up are the listeners up, by name and address
+	up map[[2]string]tunnel.Event
+}
+
+func newForwardHooks(conf sshConfig) *forwardHooks {
+	h := &forwardHooks{entry: conf.id(), forwards: map[string]portForward{}, up: map[[2]string]tunnel.Event{}}
+	for _, pf := range append(conf.localForwards(), conf.reverseForwards()...) {
+		if pf.Ignore || (pf.OnUp == "" && pf.OnDown == "") {
+			continue
+		}
+		name := pf.Name
+		if name == "" {
+			name = pf.Target
+		}
+		h.forwards[name] = pf
+	}
+	if len(h.forwards) == 0 {
+		return nil
+	}
+	return h
+}
+
+// watch runs hooks for a tunnel's events until they stop, then runs ondown for the listeners still up
+func (h *forwardHooks) watch(events <-chan tunnel.Event) {
+	for e := range events {
+		pf, ok := h.forwards[e.Name]
+		if !ok {
+			continue
+		}
+		key := [2]string{e.Name, e.Local}
+		switch e.Type {
+		case tunnel.ListenerStarted:
+			h.up[key] = e
+			h.run(pf, pf.OnUp, "up", e)
+		case tunnel.ListenerStopped:
+			if _, up := h.up[key]; up {
+				delete(h.up, key)
+				h.run(pf, pf.OnDown, "down", e)
+			}
+		}
+	}
+	for _, e := range h.up {
+		pf := h.forwards[e.Name]
+		h.run(pf, pf.OnDown, "down", e)
+	}
+}
+
+// run runs command with the shell in the background, telling it about the forward through the environment
+func (h *forwardHooks) run(pf portForward, command, event string, e tunnel.Event) {
+	if command == "" {
+		return
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	port := ""
+	if _, p, err := net.SplitHostPort(e.Local); err == nil {
+		port = p
+	}
+	cmd.Env = append(os.Environ(),
+		"TUNNEL_EVENT="+event,
+		"TUNNEL_ENTRY="+h.entry,
+		"TUNNEL_NAME="+pf.Name,
+		"TUNNEL_LOCAL="+e.Local,
+		"TUNNEL_PORT="+port,
+		"TUNNEL_TARGET="+pf.Target,
+	)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	go func() {
+		if err := cmd.Run(); err != nil {
+			log.Printf("on%s hook of tunnel %s failed: %v", event, pf.Name, err)
+		}
+	}()
+}
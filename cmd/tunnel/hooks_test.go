@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+func TestForwardHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run with /bin/sh")
+	}
+	dir := t.TempDir()
+	up, down := filepath.Join(dir, "up"), filepath.Join(dir, "down")
+	conf := sshConfig{Destination: "bastion:22", Tunnels: []portForward{
+		{Name: "db", Port: 5432, Target: "db:5432",
+			OnUp:   `echo "$TUNNEL_EVENT $TUNNEL_NAME $TUNNEL_PORT $TUNNEL_TARGET" > ` + up,
+			OnDown: `echo "$TUNNEL_EVENT $TUNNEL_LOCAL" > ` + down},
+		{Name: "web", Port: 8080, Target: "web:80"},
+	}}
+	hooks := newForwardHooks(conf)
+	if hooks == nil {
+		t.Fatal("expected hooks for db")
+	}
+	events := make(chan tunnel.Event, 3)
+	events <- tunnel.Event{Type: tunnel.ListenerStarted, Name: "web", Local: "127.0.0.1:8080"}
+	events <- tunnel.Event{Type: tunnel.ListenerStarted, Name: "db", Local: "127.0.0.1:5432"}
+	close(events)
+	hooks.watch(events)
+	waitForFile(t, up, "up db 5432 db:5432\n")
+	// db was still up as the events stopped
+	waitForFile(t, down, "down 127.0.0.1:5432\n")
+
+	if newForwardHooks(sshConfig{Tunnels: []portForward{{Name: "web", Port: 8080, Target: "web:80"}}}) != nil {
+		t.Fatal("expected no hooks without onup or ondown")
+	}
+	if err := (portForward{Ports: "9000-9001", Target: "node", OnUp: "true"}).validate(); err == nil {
+		t.Fatal("expected hooks on unnamed ports to be rejected")
+	}
+}
+
+func waitForFile(t *testing.T, path, expected string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		content, err := os.ReadFile(path)
+		if err == nil && string(content) == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s to hold %q, got %q (%v)", path, expected, strings.TrimSpace(string(content)), err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+// id identifies a config entry by name, falling back to its destination
+func (sc *sshConfig) id() string {
+	if sc.Name != "" {
+		return sc.Name
+	}
+	return sc.Destination
+}
+
+// hopPath returns the chain of config entries leading to the hop identified by id
+func hopPath(confs []sshConfig, id string) ([]sshConfig, bool) {
+	for _, c := range confs {
+		if c.Name == id || c.Destination == id {
+			return []sshConfig{c}, true
+		}
+		if path, ok := hopPath(c.ThroughSSH, id); ok {
+			return append([]sshConfig{c}, path...), true
+		}
+	}
+	return nil, false
+}
+
+// hopIDs returns the ids of all config entries, longest first
+func hopIDs(confs []sshConfig) []string {
+	ids := []string{}
+	var collect func([]sshConfig)
+	collect = func(confs []sshConfig) {
+		for _, c := range confs {
+			ids = append(ids, c.id())
+			if c.Name != "" {
+				ids = append(ids, c.Destination)
+			}
+			collect(c.ThroughSSH)
+		}
+	}
+	collect(confs)
+	sort.Slice(ids, func(i, j int) bool { return len(ids[i]) > len(ids[j]) })
+	return ids
+}
+
+// dialHop connects to the hop identified by id: every entry before it in the chain is brought up with its
+// tunnels exactly as when running the config, then the hop itself is dialed. The returned func tears it all down.
+func dialHop(ctx context.Context, tunnelConf tunnelConfig, id string, opts *config) (*tunnel.Tunnel, func(), error) {
+	path, ok := hopPath(tunnelConf.SshConfigs, id)
+	if !ok {
+		return nil, nil, fmt.Errorf("no config for %s", id)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	running := []chan error{}
+	teardown := func() {
+		cancel()
+		for _, done := range running {
+			<-done
+		}
+	}
+	for _, c := range path[:len(path)-1] {
+		spec, err := specFor(c, opts)
+		if err != nil {
+			teardown()
+			return nil, nil, err
+		}
+		ok := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- tunnel.ExecuteAndBlock(ctx, spec, ok)
+		}()
+		select {
+		case <-ok:
+			running = append(running, done)
+		case err := <-done:
+			teardown()
+			return nil, nil, fmt.Errorf("error connecting to %s: %v", c.Destination, err)
+		}
+	}
+	last := path[len(path)-1]
+	spec, err := specFor(last, opts)
+	if err != nil {
+		teardown()
+		return nil, nil, err
+	}
+	t, err := tunnel.Dial(spec)
+	if err != nil {
+		teardown()
+		return nil, nil, fmt.Errorf("error connecting to %s: %v", last.Destination, err)
+	}
+	return t, func() {
+		t.Close()
+		teardown()
+	}, nil
+}
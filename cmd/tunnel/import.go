@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+func importCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "import",
+		Usage: "convert ssh or autossh command lines, or an OpenSSH client config, into a config",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "cmdline",
+				Usage:     "convert a single command line",
+				UsageText: `tunnel import cmdline "ssh -L 8080:db:5432 -J jump user@host"`,
+				Action: func(ctx *cli.Context) error {
+					if ctx.NArg() == 0 {
+						return errors.New("command line is required")
+					}
+					imported, err := importCommandLine(strings.Join(ctx.Args().Slice(), " "))
+					if err != nil {
+						return err
+					}
+					return writeImported(os.Stdout, []importedCommand{imported})
+				},
+			},
+			{
+				Name:      "sshconfig",
+				Usage:     "convert every host with LocalForward or RemoteForward in an OpenSSH client config",
+				UsageText: "tunnel import sshconfig [ssh config file (default: ~/.ssh/config)]",
+				Action: func(ctx *cli.Context) error {
+					file := ctx.Args().First()
+					openSSH, err := tunnel.LoadSSHConfig(file)
+					if err != nil {
+						return err
+					}
+					if file == "" {
+						file = "~/.ssh/config"
+					}
+					imported := importSSHConfig(openSSH, file)
+					if len(imported) == 0 {
+						return fmt.Errorf("no hosts with forwards found in %s", file)
+					}
+					return writeImported(os.Stdout, imported)
+				},
+			},
+			{
+				Name:      "history",
+				Usage:     "convert every ssh command line forwarding ports in a shell history file",
+				UsageText: "tunnel import history [history file (default: ~/.bash_history)]",
+				Action: func(ctx *cli.Context) error {
+					file := ctx.Args().First()
+					if file == "" {
+						home, err := os.UserHomeDir()
+						if err != nil {
+							return err
+						}
+						file = filepath.Join(home, ".bash_history")
+					}
+					f, err := os.Open(file)
+					if err != nil {
+						return fmt.Errorf("unable to open history file %s: %v", file, err)
+					}
+					defer f.Close()
+					imported, err := importHistory(f)
+					if err != nil {
+						return fmt.Errorf("unable to read history file %s: %v", file, err)
+					}
+					if len(imported) == 0 {
+						return fmt.Errorf("no ssh command lines forwarding ports found in %s", file)
+					}
+					return writeImported(os.Stdout, imported)
+				},
+			},
+		},
+	}
+}
+
+// the imported types mirror sshConfig and portForward, leaving out what ssh command lines can't express
+
+type importedConfig struct {
+	Version    int           `yaml:"version"`
+	SshConfigs []importedSSH `yaml:"sshconfigs"`
+}
+
+type importedSSH struct {
+	Name           string            `yaml:"name,omitempty"`
+	Destination    string            `yaml:"destination"`
+	User           string            `yaml:"user,omitempty"`
+	Auth           []importedAuth    `yaml:"auth,omitempty"`
+	Tunnels        []importedForward `yaml:"tunnels,omitempty"`
+	ReverseTunnels []importedForward `yaml:"reversetunnels,omitempty"`
+	ThroughSSH     []importedSSH     `yaml:"throughssh,omitempty"`
+}
+
+type importedAuth struct {
+	KeyAuth importedKeyAuth `yaml:"keyauth"`
+}
+
+type importedKeyAuth struct {
+	FileLocation string `yaml:"filelocation"`
+}
+
+type importedForward struct {
+	Name   string `yaml:"name"`
+	Port   int    `yaml:"port,omitempty"`
+	Socket string `yaml:"socket,omitempty"`
+	Target string `yaml:"target"`
+}
+
+type importedCommand struct {
+	commandLine string
+	config      importedSSH
+	// warnings are about parts of the command line that couldn't be carried over
+	warnings []string
+}
+
+func writeImported(w io.Writer, imported []importedCommand) error {
+	conf := importedConfig{Version: configVersion}
+	for _, i := range imported {
+		fmt.Fprintf(w, "# imported from: %s\n", i.commandLine)
+		for _, warning := range i.warnings {
+			fmt.Fprintf(w, "#   warning: %s\n", warning)
+		}
+		conf.SshConfigs = append(conf.SshConfigs, i.config)
+	}
+	out, err := yaml.Marshal(conf)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// importSSHConfig converts every host alias of an OpenSSH client config with forwards, named after the alias,
+// with its jump hosts looked up in the config too
+func importSSHConfig(openSSH *tunnel.SSHConfig, file string) []importedCommand {
+	imported := []importedCommand{}
+	for _, alias := range openSSH.Hosts() {
+		host := openSSH.Lookup(alias)
+		if len(host.LocalForwards) == 0 && len(host.RemoteForwards) == 0 {
+			continue
+		}
+		i := importedCommand{commandLine: fmt.Sprintf("Host %s in %s", alias, file)}
+		jumps := make([]string, 0, len(host.ProxyJump))
+		for _, jump := range host.ProxyJump {
+			hop := sshHop(jump, "", "")
+			if name, port, _ := net.SplitHostPort(hop.Destination); port == "22" && !strings.Contains(jump, ":") {
+				// an alias of its own, as ssh would look it up
+				j := openSSH.Lookup(name)
+				if hop.User == "" {
+					hop.User = j.User
+				}
+				hop.Destination = j.Addr()
+			}
+			if hop.User != "" {
+				jumps = append(jumps, hop.User+"@"+hop.Destination)
+			} else {
+				jumps = append(jumps, hop.Destination)
+			}
+		}
+		target := importedSSH{Name: alias, Destination: host.Addr(), User: host.User}
+		i.convert(target, jumps, host.IdentityFiles, host.LocalForwards, host.RemoteForwards)
+		imported = append(imported, i)
+	}
+	return imported
+}
+
+// zsh extended history prefixes commands with ": <start>:<elapsed>;"
+var zshHistoryPrefix = regexp.MustCompile(`^: \d+:\d+;`)
+
+// importHistory imports the distinct ssh command lines that forward ports
+func importHistory(r io.Reader) ([]importedCommand, error) {
+	seen := map[string]bool{}
+	imported := []importedCommand{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(zshHistoryPrefix.ReplaceAllString(scanner.Text(), ""))
+		if seen[line] || !isSSHCommand(line) {
+			continue
+		}
+		seen[line] = true
+		i, err := importCommandLine(line)
+		if err != nil || (len(i.config.Tunnels) == 0 && len(i.config.ReverseTunnels) == 0 && len(i.config.ThroughSSH) == 0) {
+			continue
+		}
+		imported = append(imported, i)
+	}
+	return imported, scanner.Err()
+}
+
+func isSSHCommand(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	switch filepath.Base(fields[0]) {
+	case "ssh", "autossh":
+		return true
+	}
+	return false
+}
+
+// ssh options taking an argument (see ssh(1))
+const sshFlagsWithArgument = "BbcDEeFIiJLlmOoPpQRSWw"
+
+// importCommandLine converts an ssh or autossh command line into a config entry
+func importCommandLine(commandLine string) (importedCommand, error) {
+	imported := importedCommand{commandLine: commandLine}
+	args, err := splitCommandLine(commandLine)
+	if err != nil {
+		return imported, err
+	}
+	if len(args) == 0 || !isSSHCommand(args[0]) {
+		return imported, errors.New("not an ssh or autossh command line")
+	}
+	autossh := filepath.Base(args[0]) == "autossh"
+
+	var destination, user, port string
+	var jumps []string
+	var identities []string
+	var locals, remotes []string
+	warn := func(format string, a ...interface{}) {
+		imported.warnings = append(imported.warnings, fmt.Sprintf(format, a...))
+	}
+
+	rest := args[1:]
+	for len(rest) > 0 {
+		arg := rest[0]
+		rest = rest[1:]
+		if destination != "" {
+			warn("remote command %q is not supported", strings.Join(append([]string{arg}, rest...), " "))
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			destination = arg
+			continue
+		}
+		// flags without arguments may be combined (-NfL 8080:db:80)
+		flags := arg[1:]
+		for i := 0; i < len(flags); i++ {
+			flag := flags[i]
+			if autossh && flag == 'M' {
+				if i == len(flags)-1 && len(rest) > 0 {
+					rest = rest[1:]
+				}
+				break
+			}
+			if !strings.ContainsRune(sshFlagsWithArgument, rune(flag)) {
+				continue
+			}
+			value := flags[i+1:]
+			if value == "" {
+				if len(rest) == 0 {
+					return imported, fmt.Errorf("option -%c requires an argument", flag)
+				}
+				value, rest = rest[0], rest[1:]
+			}
+			switch flag {
+			case 'L':
+				locals = append(locals, value)
+			case 'R':
+				remotes = append(remotes, value)
+			case 'D':
+				warn("dynamic forward -D %s is not supported", value)
+			case 'J':
+				jumps = append(jumps, strings.Split(value, ",")...)
+			case 'i':
+				identities = append(identities, value)
+			case 'l':
+				user = value
+			case 'p':
+				port = value
+			case 'o':
+				key, val := splitOption(value)
+				switch strings.ToLower(key) {
+				case "user":
+					user = val
+				case "port":
+					port = val
+				case "identityfile":
+					identities = append(identities, val)
+				case "proxyjump":
+					jumps = append(jumps, strings.Split(val, ",")...)
+				case "localforward":
+					locals = append(locals, strings.Replace(val, " ", ":", 1))
+				case "remoteforward":
+					remotes = append(remotes, strings.Replace(val, " ", ":", 1))
+				default:
+					warn("option %s is not supported", value)
+				}
+			default:
+				warn("option -%c %s is not supported", flag, value)
+			}
+			break
+		}
+	}
+	if destination == "" {
+		return imported, errors.New("no destination host")
+	}
+	imported.convert(sshHop(destination, user, port), jumps, identities, locals, remotes)
+	return imported, nil
+}
+
+// convert sets the config entry connecting to target, through jumps, with the identity files and forwards given
+// as ssh takes them
+func (imported *importedCommand) convert(target importedSSH, jumps, identities, locals, remotes []string) {
+	warn := func(format string, a ...interface{}) {
+		imported.warnings = append(imported.warnings, fmt.Sprintf(format, a...))
+	}
+	for _, identity := range identities {
+		target.Auth = append(target.Auth, importedAuth{KeyAuth: importedKeyAuth{FileLocation: identity}})
+	}
+	usedPorts := map[int]bool{}
+	for _, l := range locals {
+		f, err := parseForwardSpec(l)
+		if err != nil {
+			warn("local forward %s: %v", l, err)
+			continue
+		}
+		usedPorts[f.Port] = true
+		target.Tunnels = append(target.Tunnels, f)
+	}
+	for _, r := range remotes {
+		f, err := parseForwardSpec(r)
+		if err != nil || f.Socket != "" {
+			warn("remote forward %s is not supported", r)
+			continue
+		}
+		target.ReverseTunnels = append(target.ReverseTunnels, f)
+	}
+	if len(target.Auth) == 0 {
+		warn("no identity file given; add auth to the config")
+	}
+
+	// each jump forwards a local port to the ssh port of the next hop, which is then reached through it
+	nextPort := 2222
+	for i := len(jumps) - 1; i >= 0; i-- {
+		for usedPorts[nextPort] {
+			nextPort++
+		}
+		usedPorts[nextPort] = true
+		jump := sshHop(jumps[i], "", "")
+		jump.Auth = target.Auth
+		jump.Tunnels = []importedForward{{
+			Name:   "ssh to " + target.Destination,
+			Port:   nextPort,
+			Target: target.Destination,
+		}}
+		target.Destination = net.JoinHostPort("localhost", strconv.Itoa(nextPort))
+		jump.ThroughSSH = []importedSSH{target}
+		target = jump
+	}
+	imported.config = target
+}
+
+// sshHop parses [ssh://][user@]host[:port], with user and port given separately taking precedence
+func sshHop(destination, user, port string) importedSSH {
+	destination = strings.TrimPrefix(destination, "ssh://")
+	if i := strings.LastIndex(destination, "@"); i >= 0 {
+		if user == "" {
+			user = destination[:i]
+		}
+		destination = destination[i+1:]
+	}
+	host := destination
+	if h, p, err := net.SplitHostPort(destination); err == nil {
+		host = h
+		if port == "" {
+			port = p
+		}
+	}
+	if port == "" {
+		port = "22"
+	}
+	return importedSSH{Destination: net.JoinHostPort(host, port), User: user}
+}
+
+// splitOption splits -o Key=Value or -o "Key Value"
+func splitOption(option string) (string, string) {
+	if i := strings.IndexAny(option, "= "); i >= 0 {
+		return option[:i], strings.TrimSpace(option[i+1:])
+	}
+	return option, ""
+}
+
+// parseForwardSpec parses [bind_address:]port:host:hostport or local_socket:host:hostport; the bind address is
+// dropped as forwards always listen on localhost
+func parseForwardSpec(spec string) (importedForward, error) {
+	parts := splitForwardFields(spec)
+	if len(parts) == 4 {
+		parts = parts[1:]
+	}
+	if len(parts) != 3 {
+		return importedForward{}, errors.New("expected [bind_address:]port:host:hostport")
+	}
+	f := importedForward{Target: net.JoinHostPort(parts[1], parts[2])}
+	if strings.HasPrefix(parts[0], "/") {
+		f.Socket = parts[0]
+		f.Name = parts[0] + " to " + f.Target
+		return f, nil
+	}
+	port, err := strconv.Atoi(parts[0])
+	if err != nil || port <= 0 || port > 65535 {
+		return importedForward{}, fmt.Errorf("invalid port %s", parts[0])
+	}
+	f.Port = port
+	f.Name = parts[0] + " to " + f.Target
+	return f, nil
+}
+
+// splitForwardFields splits on colons outside of [] so IPv6 addresses can be given in brackets
+func splitForwardFields(spec string) []string {
+	fields := []string{}
+	current := strings.Builder{}
+	inBrackets := false
+	for _, r := range spec {
+		switch {
+		case r == '[':
+			inBrackets = true
+		case r == ']':
+			inBrackets = false
+		case r == ':' && !inBrackets:
+			fields = append(fields, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(fields, current.String())
+}
+
+// splitCommandLine splits a command line into arguments the way a POSIX shell would for simple quoting
+func splitCommandLine(s string) ([]string, error) {
+	args := []string{}
+	current := strings.Builder{}
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in command line")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+func TestImportCommandLine(t *testing.T) {
+	t.Run("jump host", func(t *testing.T) {
+		imported, err := importCommandLine(`ssh -NL 8080:db:5432 -i ~/.ssh/id_ed25519 -J jump user@host`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jump := imported.config
+		if jump.Destination != "jump:22" || jump.Tunnels[0].Port != 2222 || jump.Tunnels[0].Target != "host:22" {
+			t.Fatalf("unexpected jump hop: %+v", jump)
+		}
+		target := jump.ThroughSSH[0]
+		if target.Destination != "localhost:2222" || target.User != "user" {
+			t.Fatalf("unexpected target hop: %+v", target)
+		}
+		if f := target.Tunnels[0]; f.Port != 8080 || f.Target != "db:5432" {
+			t.Fatalf("unexpected forward: %+v", f)
+		}
+		if target.Auth[0].KeyAuth.FileLocation != "~/.ssh/id_ed25519" {
+			t.Fatalf("identity not carried over: %+v", target.Auth)
+		}
+	})
+	t.Run("autossh", func(t *testing.T) {
+		imported, err := importCommandLine(`autossh -M 0 -f -p 2200 -o "ServerAliveInterval 30" -R 9000:localhost:80 -D 1080 host`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if imported.config.Destination != "host:2200" || imported.config.ReverseTunnels[0].Port != 9000 {
+			t.Fatalf("unexpected config: %+v", imported.config)
+		}
+		if len(imported.warnings) != 3 {
+			t.Fatalf("expected warnings for the option, -D and missing identity, got %v", imported.warnings)
+		}
+	})
+	t.Run("not ssh", func(t *testing.T) {
+		if _, err := importCommandLine("scp a b"); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+	t.Run("output parses as config", func(t *testing.T) {
+		imported, err := importCommandLine(`ssh -L 127.0.0.1:8080:db:5432 -L /tmp/db.sock:db:5432 -i key -J a,b c`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := &bytes.Buffer{}
+		if err := writeImported(out, []importedCommand{imported}); err != nil {
+			t.Fatal(err)
+		}
+		conf, err := parseConfig(out.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := validateConfig(&conf, nil); err != nil {
+			t.Fatalf("%v\n%s", err, out)
+		}
+		if _, ok := hopPath(conf.SshConfigs, "localhost:2223"); !ok {
+			t.Fatalf("expected a hop through both jumps:\n%s", out)
+		}
+	})
+}
+
+func TestImportHistory(t *testing.T) {
+	history := strings.Join([]string{
+		": 1700000000:0;ssh -L 8080:db:5432 -i key host",
+		"ls",
+		"ssh host",
+		"ssh -L 8080:db:5432 -i key host",
+		"ssh -L 9090:web:80 -i key other",
+	}, "\n")
+	imported, err := importHistory(strings.NewReader(history))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 2 {
+		t.Fatalf("expected 2 distinct forwarding command lines, got %d", len(imported))
+	}
+}
+
+func TestImportSSHConfig(t *testing.T) {
+	openSSH, err := tunnel.ParseSSHConfig(strings.NewReader(`
+Host bastion
+    HostName bastion.example.com
+    Port 2222
+    User ops
+
+Host db
+    HostName db.internal
+    User app
+    IdentityFile /keys/app
+    ProxyJump bastion
+    LocalForward 5432 localhost:5432
+    RemoteForward 9000 localhost:80
+
+Host plain
+    HostName plain.example.com
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := importSSHConfig(openSSH, "config")
+	if len(imported) != 1 {
+		t.Fatalf("expected only the host with forwards, got %d", len(imported))
+	}
+	jump := imported[0].config
+	if jump.Destination != "bastion.example.com:2222" || jump.User != "ops" {
+		t.Fatalf("expected the jump host to be looked up, got %+v", jump)
+	}
+	target := jump.ThroughSSH[0]
+	if target.Name != "db" || target.User != "app" || target.Tunnels[0].Port != 5432 || target.ReverseTunnels[0].Port != 9000 {
+		t.Fatalf("unexpected target hop: %+v", target)
+	}
+
+	out := &bytes.Buffer{}
+	if err := writeImported(out, imported); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := parseConfig(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateConfig(&conf, nil); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if !strings.Contains(out.String(), "# imported from: Host db in config") {
+		t.Fatalf("expected the host to be noted:\n%s", out)
+	}
+}
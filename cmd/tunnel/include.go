@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// envReference matches ${NAME} in a config file, and $${NAME} written to keep it as is
+var envReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// interpolateEnv replaces every ${NAME} in contents with the environment variable; unset ones are an error so that
+// typos don't go unnoticed
+func interpolateEnv(contents []byte) ([]byte, error) {
+	missing := []string{}
+	interpolated := envReference.ReplaceAllFunc(contents, func(ref []byte) []byte {
+		if ref[1] == '$' {
+			return ref[1:]
+		}
+		name := string(ref[2 : len(ref)-1])
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+			return ref
+		}
+		return []byte(value)
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("environment variables %s not set", strings.Join(missing, ", "))
+	}
+	return interpolated, nil
+}
+
+// loadConfigFile parses a config file layered over the files it includes, which are relative to it. including
+// are the files including it, to refuse include cycles.
+func loadConfigFile(file, format string, including []string) (tunnelConfig, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return tunnelConfig{}, err
+	}
+	for _, f := range including {
+		if f == abs {
+			return tunnelConfig{}, fmt.Errorf("config file %s includes itself", file)
+		}
+	}
+	contents, err := readConfig(file, format)
+	if err != nil {
+		return tunnelConfig{}, err
+	}
+	top, err := parseConfig(contents)
+	if err != nil {
+		return top, fmt.Errorf("unable to parse config file %s: %v", file, err)
+	}
+	conf := tunnelConfig{}
+	for _, include := range top.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(file), include)
+		}
+		included, err := loadConfigFile(include, "", append(including, abs))
+		if err != nil {
+			return conf, err
+		}
+		conf = layerConfig(conf, included)
+	}
+	return layerConfig(conf, top), nil
+}
+
+// layerConfig layers top over base: secrets and environments of the same name are replaced, entries of the same
+// name are layered, other entries added, and names replaced when top has them
+func layerConfig(base, top tunnelConfig) tunnelConfig {
+	layered := tunnelConfig{Version: top.Version, Environments: map[string]environment{}}
+	redefined := map[string]bool{}
+	for _, s := range top.Secrets {
+		redefined[s.Name] = true
+	}
+	for _, s := range base.Secrets {
+		if !redefined[s.Name] {
+			layered.Secrets = append(layered.Secrets, s)
+		}
+	}
+	layered.Secrets = append(layered.Secrets, top.Secrets...)
+
+	layered.SshConfigs = append([]sshConfig{}, base.SshConfigs...)
+	for _, c := range top.SshConfigs {
+		matched := false
+		for i, b := range layered.SshConfigs {
+			if b.id() == c.id() {
+				layered.SshConfigs[i] = layerEntry(b, c)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			layered.SshConfigs = append(layered.SshConfigs, c)
+		}
+	}
+
+	for name, env := range base.Environments {
+		layered.Environments[name] = env
+	}
+	for name, env := range top.Environments {
+		layered.Environments[name] = env
+	}
+	if len(layered.Environments) == 0 {
+		layered.Environments = nil
+	}
+	layered.Names = base.Names
+	if top.Names != nil {
+		layered.Names = top.Names
+	}
+	return layered
+}
+
+// layerEntry layers top over base: lists such as tunnels and auth are added to, other settings replaced when set
+func layerEntry(base, top sshConfig) sshConfig {
+	b, t := reflect.ValueOf(&base).Elem(), reflect.ValueOf(top)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		switch {
+		case field.Kind() == reflect.Slice:
+			b.Field(i).Set(reflect.AppendSlice(b.Field(i), field))
+		case !field.IsZero():
+			b.Field(i).Set(field)
+		}
+	}
+	return base
+}
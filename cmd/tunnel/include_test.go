@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestInterpolateEnv(t *testing.T) {
+	t.Setenv("TUNNEL_TEST_USER", "deploy")
+	out, err := interpolateEnv([]byte("user: ${TUNNEL_TEST_USER}\nonup: echo $${TUNNEL_NAME} $TUNNEL_PORT\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "user: deploy\nonup: echo ${TUNNEL_NAME} $TUNNEL_PORT\n" {
+		t.Fatalf("unexpected interpolation %q", out)
+	}
+	if _, err := interpolateEnv([]byte("user: ${TUNNEL_TEST_UNSET}")); err == nil || !strings.Contains(err.Error(), "TUNNEL_TEST_UNSET") {
+		t.Fatalf("expected the unset variable to be reported, got %v", err)
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("base.yml", `
+secrets:
+- name: pwd
+  env: TEAM_PWD
+sshconfigs:
+- name: bastion
+  destination: bastion.corp:22
+  user: team
+  tunnels:
+  - name: db
+    port: 5432
+    target: db:5432
+`)
+	write("other.json", `{"sshconfigs": [{"destination": "other.corp:22"}]}`)
+	t.Setenv("TUNNEL_TEST_USER", "me")
+	personal := write("personal.yml", `
+include: [base.yml, other.json]
+secrets:
+- name: pwd
+  env: MY_PWD
+sshconfigs:
+- name: bastion
+  user: ${TUNNEL_TEST_USER}
+  tunnels:
+  - name: web
+    port: 8080
+    target: web:80
+`)
+	conf, err := loadConfigFile(personal, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.SshConfigs) != 2 || len(conf.Secrets) != 1 || conf.Secrets[0].Env != "MY_PWD" {
+		t.Fatalf("unexpected config %+v", conf)
+	}
+	bastion := conf.SshConfigs[0]
+	if bastion.Destination != "bastion.corp:22" || bastion.User != "me" || len(bastion.Tunnels) != 2 {
+		t.Fatalf("unexpected layered entry %+v", bastion)
+	}
+	if conf.SshConfigs[1].Destination != "other.corp:22" {
+		t.Fatalf("unexpected included entry %+v", conf.SshConfigs[1])
+	}
+
+	// as sent to the daemon
+	out, err := yaml.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(out); err != nil {
+		t.Fatalf("layered config doesn't parse again: %v", err)
+	}
+
+	write("a.yml", "include: [b.yml]\n")
+	write("b.yml", "include: [a.yml]\n")
+	if _, err := loadConfigFile(filepath.Join(dir, "a.yml"), "", nil); err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Fatalf("expected the include cycle to be refused, got %v", err)
+	}
+}
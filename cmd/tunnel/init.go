@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+func initCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "init",
+		Usage:     "write a config by answering a few questions",
+		UsageText: "tunnel init [config file (default: tunnel.yml)]",
+		Action: func(ctx *cli.Context) error {
+			file := ctx.Args().First()
+			if file == "" {
+				file = "tunnel.yml"
+			}
+			if _, err := os.Stat(file); err == nil {
+				return fmt.Errorf("%s already exists", file)
+			}
+			contents, err := initWizard(os.Stdin, os.Stdout)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(file, []byte(contents), 0600); err != nil {
+				return err
+			}
+			fmt.Printf("wrote %s; run it with: tunnel %s\n", file, file)
+			return nil
+		},
+	}
+}
+
+// wizard asks its questions on out, reading the answers from in
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask asks question, returning the answer or def when it's left blank
+func (w wizard) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	answer, err := w.in.ReadString('\n')
+	if err != nil && answer == "" {
+		if err == io.EOF {
+			return "", errors.New("ran out of answers")
+		}
+		return "", err
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// require asks question until it's answered and check, when set, accepts the answer
+func (w wizard) require(question, def string, check func(string) error) (string, error) {
+	for {
+		answer, err := w.ask(question, def)
+		if err != nil {
+			return "", err
+		}
+		if answer == "" {
+			fmt.Fprintln(w.out, "  an answer is required")
+			continue
+		}
+		if check != nil {
+			if err := check(answer); err != nil {
+				fmt.Fprintf(w.out, "  %v\n", err)
+				continue
+			}
+		}
+		return answer, nil
+	}
+}
+
+// initWizard asks for a bastion, how to log in to it and the tunnels through it, returning the config with
+// comments explaining it
+func initWizard(in io.Reader, out io.Writer) (string, error) {
+	w := wizard{in: bufio.NewReader(in), out: out}
+	name, err := w.require("Name for the bastion", "bastion", nil)
+	if err != nil {
+		return "", err
+	}
+	destination, err := w.require("Bastion host, as host or host:port", "", nil)
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := net.SplitHostPort(destination); err != nil {
+		destination = net.JoinHostPort(destination, "22")
+	}
+	user, err := w.require("User to log in as", os.Getenv("USER"), nil)
+	if err != nil {
+		return "", err
+	}
+	authType, err := w.require("Log in with an ssh agent, a key file or a password (agent, key, password)", "agent", func(answer string) error {
+		switch answer {
+		case "agent", "key", "password":
+			return nil
+		}
+		return errors.New("expected agent, key or password")
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var secrets, auth strings.Builder
+	switch authType {
+	case "agent":
+		auth.WriteString("    # the keys of the running ssh agent\n    - agent: true\n")
+	case "key":
+		home, _ := os.UserHomeDir()
+		key, err := w.require("Private key file", filepath.Join(home, ".ssh", "id_ed25519"), nil)
+		if err != nil {
+			return "", err
+		}
+		// key files are read as they're given
+		if strings.HasPrefix(key, "~/") {
+			key = filepath.Join(home, key[2:])
+		}
+		env, err := w.ask("Environment variable holding the key's passphrase, blank when it has none", "")
+		if err != nil {
+			return "", err
+		}
+		auth.WriteString("    # the private key to log in with\n    - keyauth:\n")
+		fmt.Fprintf(&auth, "        filelocation: %s\n", yamlScalar(key))
+		if env != "" {
+			fmt.Fprintf(&secrets, "  # the key's passphrase, read from $%s\n  - name: key passphrase\n    env: %s\n", env, yamlScalar(env))
+			auth.WriteString("        passwordsecret: key passphrase\n")
+		}
+	case "password":
+		env, err := w.require("Environment variable holding the password", "TUNNEL_PASSWORD", nil)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&secrets, "  # the password, read from $%s\n  - name: password\n    env: %s\n", env, yamlScalar(env))
+		auth.WriteString("    - pwdauth:\n        passwordsecret: password\n")
+	}
+
+	var tunnels strings.Builder
+	fmt.Fprintln(out, "Tunnels listen on a local port and forward to a host:port reached from the bastion.")
+	for {
+		port, err := w.ask("Local port to listen on, blank when done", "")
+		if err != nil {
+			return "", err
+		}
+		if port == "" {
+			break
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			fmt.Fprintln(out, "  expected a port number")
+			continue
+		}
+		target, err := w.require("Host:port to forward it to", "", func(answer string) error {
+			_, _, err := net.SplitHostPort(answer)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		tunnelName, err := w.require("Name for the tunnel", target, nil)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&tunnels, "    # localhost:%s goes to %s through %s\n", port, target, name)
+		fmt.Fprintf(&tunnels, "    - name: %s\n      port: %s\n      target: %s\n", yamlScalar(tunnelName), port, yamlScalar(target))
+	}
+
+	var conf strings.Builder
+	conf.WriteString("# written by tunnel init; see sample.yml for every setting\n")
+	fmt.Fprintf(&conf, "version: %d\n", configVersion)
+	if secrets.Len() > 0 {
+		conf.WriteString("secrets:\n")
+		conf.WriteString(secrets.String())
+	}
+	conf.WriteString("sshconfigs:\n")
+	fmt.Fprintf(&conf, "  - name: %s\n    destination: %s\n    user: %s\n", yamlScalar(name), yamlScalar(destination), yamlScalar(user))
+	conf.WriteString("    auth:\n")
+	conf.WriteString(auth.String())
+	if tunnels.Len() > 0 {
+		conf.WriteString("    tunnels:\n")
+		conf.WriteString(tunnels.String())
+	}
+	return conf.String(), nil
+}
+
+// yamlScalar writes s as a yaml scalar, quoted where it has to be
+func yamlScalar(s string) string {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return strconv.Quote(s)
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestInitWizard(t *testing.T) {
+	answers := strings.Join([]string{
+		"",             // name: bastion
+		"bastion.corp", // host, given the default port
+		"deploy",       // user
+		"ssh",          // not an auth type, asked again
+		"password",     // auth
+		"",             // password env: TUNNEL_PASSWORD
+		"5432",         // port
+		"db",           // not host:port, asked again
+		"db.corp:5432", // target
+		"team db",      // name
+		"8080",         // port
+		"web.corp:80",  // target
+		"",             // name: the target
+		"",             // done
+	}, "\n") + "\n"
+	contents, err := initWizard(strings.NewReader(answers), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := parseConfig([]byte(contents))
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, contents)
+	}
+	vault := secretsVault{}
+	for _, s := range conf.Secrets {
+		vault[s.Name] = staticSecret("secret")
+	}
+	if err := validateConfig(&conf, vault); err != nil {
+		t.Fatalf("%v in\n%s", err, contents)
+	}
+	entry := conf.SshConfigs[0]
+	if entry.Name != "bastion" || entry.Destination != "bastion.corp:22" || entry.User != "deploy" {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	if conf.Secrets[0].Env != "TUNNEL_PASSWORD" || entry.Auth[0].PwdAuth.PasswordSecret != "password" {
+		t.Fatalf("unexpected auth in\n%s", contents)
+	}
+	if len(entry.Tunnels) != 2 || entry.Tunnels[0].Name != "team db" || entry.Tunnels[1].Name != "web.corp:80" || entry.Tunnels[1].Port != 8080 {
+		t.Fatalf("unexpected tunnels %+v", entry.Tunnels)
+	}
+	if !strings.Contains(contents, "# localhost:5432 goes to db.corp:5432 through bastion") {
+		t.Fatalf("expected tunnels to be explained in\n%s", contents)
+	}
+
+	if _, err := initWizard(strings.NewReader("bastion\n"), io.Discard); err == nil {
+		t.Fatal("expected running out of answers to fail")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh"
+)
+
+func keygenCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "keygen",
+		Usage:     "generate a key pair to enroll with a bastion, written without a passphrase",
+		UsageText: "tunnel keygen [options] <private key file>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "type", Usage: "ed25519 or ecdsa", Value: "ed25519"},
+			&cli.StringFlag{Name: "comment", Usage: "comment recorded with the public key (default user@host)"},
+		},
+		Action: func(ctx *cli.Context) error {
+			if ctx.NArg() != 1 {
+				return fmt.Errorf("expected the file to write the private key to")
+			}
+			keyType, err := tunnel.ParseKeyType(ctx.String("type"))
+			if err != nil {
+				return err
+			}
+			comment := ctx.String("comment")
+			if !ctx.IsSet("comment") {
+				comment = defaultKeyComment()
+			}
+			file := ctx.Args().First()
+			pair, err := writeKeyPair(file, keyType, comment)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("wrote %s and %s.pub\n", file, file)
+			fmt.Printf("fingerprint: %s\n", ssh.FingerprintSHA256(pair.Signer.PublicKey()))
+			fmt.Printf("add this line to ~/.ssh/authorized_keys on the server:\n%s", pair.PublicKey)
+			return nil
+		},
+	}
+}
+
+// writeKeyPair generates a key pair, writing its private key to file and public key to file.pub. Neither may
+// exist already.
+func writeKeyPair(file string, keyType tunnel.KeyType, comment string) (*tunnel.KeyPair, error) {
+	for _, f := range []string{file, file + ".pub"} {
+		if _, err := os.Stat(f); err == nil {
+			return nil, fmt.Errorf("%s already exists", f)
+		}
+	}
+	pair, err := tunnel.GenerateKeyPair(keyType, comment)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file, pair.PrivateKey, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file+".pub", pair.PublicKey, 0644); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+func defaultKeyComment() string {
+	host, _ := os.Hostname()
+	u, err := user.Current()
+	if err != nil {
+		return host
+	}
+	return u.Username + "@" + host
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+func TestWriteKeyPair(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "id_bastion")
+	if _, err := writeKeyPair(file, tunnel.Ed25519Key, "deploy@laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tunnel.PrivateKeyFile(file, ""); err != nil {
+		t.Fatalf("expected the private key to be usable: %v", err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the private key to be readable by its owner only: %v %v", info.Mode(), err)
+	}
+	if _, err := os.Stat(file + ".pub"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeKeyPair(file, tunnel.Ed25519Key, ""); err == nil {
+		t.Fatal("expected an existing key not to be overwritten")
+	}
+}
@@ -3,16 +3,54 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
+	tunnel "github.com/arunsworld/go-tunnel"
 	"github.com/urfave/cli/v2"
 )
 
 type config struct {
-	configFile string
+	configFile            string
+	configFormat          string
+	env                   string
+	knownHostsFile        string
+	acceptChangedHostKeys bool
+	passwordPrompts       int
+	webhook               string
+	auditLog              string
+	audit                 tunnel.AuditSink
+	metricsAddr           string
+	healthAddr            string
+	tui                   bool
+	// registry tracks the tunnels of every entry when metrics or health are served
+	registry      *tunnelRegistry
+	logLevel      tunnel.Level
+	sshConfigFile string
+	// openSSH is the OpenSSH client config destination aliases are looked up in
+	openSSH *tunnel.SSHConfig
+	// logger overrides logging to stdout
+	logger tunnel.Logger
+	// manager shares connections between entries to the same host as the same user
+	manager *tunnel.Manager
+	// controlSocket is where the daemon takes commands
+	controlSocket string
+	// control, when set, receives the daemon's commands
+	control chan controlRequest
+	// secrets resolved so far, reused when reloading the config
+	secrets secretsVault
+	// reusePort binds tunnels and the addresses served for a daemon taking over from this one to share them
+	reusePort bool
+	// started, when set, is called once the config's entries are started
+	started func()
+	// upgrade, when set, hands the daemon's tunnels over to its executable started again
+	upgrade func() error
+	// handedOff is set once a daemon has taken over, for this one to leave it the hosts file entries
+	handedOff bool
 }
 
 func main() {
@@ -24,6 +62,31 @@ func main() {
 		Usage:     "tunnel ports through an ssh connection",
 		UsageText: "tunnel [options] <config file>",
 		Flags:     flags,
+		Before: func(ctx *cli.Context) error {
+			level, err := tunnel.ParseLevel(ctx.String("log-level"))
+			if err != nil {
+				return err
+			}
+			conf.logLevel = level
+			return conf.openAuditLog()
+		},
+		Commands: append([]*cli.Command{
+			vpnHelperCommand(),
+			shareCommand(conf),
+			shareFrontCommand(),
+			cpCommand(conf),
+			sftpCommand(conf),
+			sshCommand(conf),
+			testServerCommand(),
+			keygenCommand(),
+			importCommand(),
+			initCommand(),
+			stdioCommand(conf),
+			benchCommand(conf),
+			daemonCommand(conf),
+			serviceCommand(conf),
+			sealCommand(),
+		}, controlCommands(conf)...),
 		Action: func(ctx *cli.Context) error {
 			if ctx.NArg() != 1 {
 				return errors.New("confg file not provided")
@@ -43,5 +106,105 @@ func main() {
 
 func flagsAndConfig() ([]cli.Flag, *config) {
 	conf := config{}
-	return []cli.Flag{}, &conf
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "format",
+			Usage:       "format of the config file: yaml, json or toml (default from its extension, else yaml)",
+			Destination: &conf.configFormat,
+		},
+		&cli.StringFlag{
+			Name:        "env",
+			Usage:       "environment whose overrides to apply to the config",
+			Destination: &conf.env,
+		},
+		&cli.StringFlag{
+			Name:        "known-hosts",
+			Usage:       "known hosts file recording the host keys seen",
+			Value:       defaultKnownHostsFile(),
+			Destination: &conf.knownHostsFile,
+		},
+		&cli.BoolFlag{
+			Name:        "accept-changed-host-keys",
+			Usage:       "replace recorded host keys that have changed instead of refusing to connect",
+			Destination: &conf.acceptChangedHostKeys,
+		},
+		&cli.IntFlag{
+			Name:        "password-prompts",
+			Usage:       "times a password secret entered at the prompt is asked for when the server rejects it",
+			Value:       3,
+			Destination: &conf.passwordPrompts,
+		},
+		&cli.StringFlag{
+			Name:        "ssh-config",
+			Usage:       "OpenSSH client config to look up destinations without a port in (default ~/.ssh/config)",
+			Destination: &conf.sshConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "audit-log",
+			Usage:       "file to append a JSON audit record of every connection established to (- for stdout)",
+			Destination: &conf.auditLog,
+		},
+		&cli.StringFlag{
+			Name:  "log-level",
+			Usage: "least severe messages to log: debug, info, warn or error",
+			Value: "debug",
+		},
+		&cli.StringFlag{
+			Name:        "metrics-addr",
+			Usage:       "address to serve prometheus metrics on at /metrics, such as localhost:9100",
+			Destination: &conf.metricsAddr,
+		},
+		&cli.StringFlag{
+			Name:        "health-addr",
+			Usage:       "address to serve /healthz and /readyz with the state of every tunnel on, such as localhost:8081",
+			Destination: &conf.healthAddr,
+		},
+		&cli.BoolFlag{
+			Name:        "tui",
+			Usage:       "show a live dashboard of the tunnels instead of logging",
+			Destination: &conf.tui,
+		},
+		&cli.StringFlag{
+			Name:        "control-socket",
+			Usage:       "socket the daemon takes commands on",
+			Value:       defaultControlSocket(),
+			Destination: &conf.controlSocket,
+		},
+		&cli.StringFlag{
+			Name:        "webhook",
+			Usage:       "url to POST connection errors to",
+			Destination: &conf.webhook,
+		},
+	}, &conf
+}
+
+func defaultKnownHostsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "known_hosts"
+	}
+	return filepath.Join(dir, "go-tunnel", "known_hosts")
+}
+
+func (c *config) log() tunnel.Logger {
+	if c.logger != nil {
+		return c.logger
+	}
+	return tunnel.StdOutLogger()
+}
+
+func (c *config) openAuditLog() error {
+	switch c.auditLog {
+	case "":
+		return nil
+	case "-":
+		c.audit = tunnel.JSONAuditSink(os.Stdout)
+		return nil
+	}
+	f, err := os.OpenFile(c.auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open audit log %s: %v", c.auditLog, err)
+	}
+	c.audit = tunnel.JSONAuditSink(f)
+	return nil
 }
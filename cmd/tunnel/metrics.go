@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+// tunnelRegistry tracks the tunnels of every config entry to serve their stats in the prometheus text format and
+// their health
+type tunnelRegistry struct {
+	mu      sync.Mutex
+	entries map[string]*trackedEntry
+}
+
+type trackedEntry struct {
+	// unit is the key of the top level entry this one is run with
+	unit    string
+	tunnel  *tunnel.Tunnel
+	lastErr error
+	// failed is set once the entry gave up, which it doesn't recover from until it's started again
+	failed bool
+}
+
+// newTunnelRegistry registers every entry of confs, reported as down until connected
+func newTunnelRegistry(confs []sshConfig) *tunnelRegistry {
+	m := &tunnelRegistry{entries: map[string]*trackedEntry{}}
+	var register func(string, []sshConfig)
+	register = func(unit string, confs []sshConfig) {
+		for _, c := range confs {
+			m.entries[c.id()] = &trackedEntry{unit: unit}
+			register(unit, c.ThroughSSH)
+		}
+	}
+	for key, c := range entryKeys(confs) {
+		register(key, []sshConfig{c})
+	}
+	return m
+}
+
+// expect makes the entries of confs the ones reported, keeping the state of those already tracked; a nil
+// registry does nothing
+func (m *tunnelRegistry) expect(confs []sshConfig) {
+	if m == nil {
+		return
+	}
+	expected := newTunnelRegistry(confs)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, e := range expected.entries {
+		if tracked, ok := m.entries[id]; ok {
+			tracked.unit = e.unit
+			expected.entries[id] = tracked
+		}
+	}
+	m.entries = expected.entries
+}
+
+// track records the tunnel connected for the entry identified by id; a nil registry tracks nothing
+func (m *tunnelRegistry) track(id string, t *tunnel.Tunnel) {
+	m.update(id, func(e *trackedEntry) {
+		e.tunnel = t
+		e.failed = false
+	})
+}
+
+// recordError records the latest error connecting the entry identified by id
+func (m *tunnelRegistry) recordError(id string, err error) {
+	m.update(id, func(e *trackedEntry) { e.lastErr = err })
+}
+
+// fail records that the entry identified by id gave up with err
+func (m *tunnelRegistry) fail(id string, err error) {
+	m.update(id, func(e *trackedEntry) {
+		e.failed = true
+		if err != nil {
+			e.lastErr = err
+		}
+	})
+}
+
+// setForwardEnabled enables or disables the forward with the given name of the entry identified by id, reporting
+// whether the entry is connected with such a forward
+func (m *tunnelRegistry) setForwardEnabled(id, forward string, enabled bool) bool {
+	m.mu.Lock()
+	var t *tunnel.Tunnel
+	if e, ok := m.entries[id]; ok {
+		t = e.tunnel
+	}
+	m.mu.Unlock()
+	if t == nil {
+		return false
+	}
+	return t.SetForwardEnabled(forward, enabled)
+}
+
+func (m *tunnelRegistry) update(id string, f func(*trackedEntry)) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[id]
+	if !ok {
+		e = &trackedEntry{}
+		m.entries[id] = e
+	}
+	f(e)
+}
+
+type tunnelSnapshot struct {
+	id      string
+	unit    string
+	stats   tunnel.Stats
+	state   string
+	lastErr error
+}
+
+// states of an entry
+const (
+	stateConnecting   = "connecting"
+	stateConnected    = "connected"
+	stateReconnecting = "reconnecting"
+	stateFailed       = "failed"
+)
+
+func (m *tunnelRegistry) snapshot() []tunnelSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshots := make([]tunnelSnapshot, 0, len(m.entries))
+	for id, e := range m.entries {
+		s := tunnelSnapshot{id: id, unit: e.unit, state: stateConnecting, lastErr: e.lastErr}
+		if e.tunnel != nil {
+			s.stats = e.tunnel.Stats()
+			s.state = stateReconnecting
+			if s.stats.Connected {
+				s.state = stateConnected
+			}
+			if err := e.tunnel.Err(); err != nil {
+				s.lastErr = err
+				s.state = stateFailed
+			}
+		}
+		if e.failed {
+			s.state = stateFailed
+		}
+		snapshots = append(snapshots, s)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].id < snapshots[j].id })
+	return snapshots
+}
+
+func (m *tunnelRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+func (m *tunnelRegistry) write(w io.Writer) {
+	snapshots := m.snapshot()
+	family := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	family("tunnel_up", "gauge", "Whether the ssh connection is established.")
+	for _, s := range snapshots {
+		up := 0
+		if s.stats.Connected {
+			up = 1
+		}
+		fmt.Fprintf(w, "tunnel_up{tunnel=%s} %d\n", labelValue(s.id), up)
+	}
+	family("tunnel_reconnect_attempts_total", "counter", "Attempts made to re-establish a dropped ssh connection.")
+	for _, s := range snapshots {
+		fmt.Fprintf(w, "tunnel_reconnect_attempts_total{tunnel=%s} %d\n", labelValue(s.id), s.stats.ReconnectAttempts)
+	}
+
+	forwardMetrics := []struct {
+		name, kind, help string
+		value            func(tunnel.ForwardStats) int64
+	}{
+		{"tunnel_active_connections", "gauge", "Connections currently tunneled.",
+			func(f tunnel.ForwardStats) int64 { return f.ActiveConnections }},
+		{"tunnel_connections_total", "counter", "Connections tunneled.",
+			func(f tunnel.ForwardStats) int64 { return f.TotalConnections }},
+		{"tunnel_received_bytes_total", "counter", "Bytes received from the destination.",
+			func(f tunnel.ForwardStats) int64 { return f.BytesIn }},
+		{"tunnel_sent_bytes_total", "counter", "Bytes sent to the destination.",
+			func(f tunnel.ForwardStats) int64 { return f.BytesOut }},
+		{"tunnel_dial_failures_total", "counter", "Connections that couldn't reach the destination.",
+			func(f tunnel.ForwardStats) int64 { return f.DialErrors }},
+	}
+	// every port of a ports entry shares its name, as unnamed forwards to one destination do, so where they listen
+	// tells their series apart
+	for _, metric := range forwardMetrics {
+		family(metric.name, metric.kind, metric.help)
+		for _, s := range snapshots {
+			for _, f := range s.stats.Forward {
+				fmt.Fprintf(w, "%s{tunnel=%s,forward=%s,local=%s,direction=\"local\"} %d\n", metric.name, labelValue(s.id), labelValue(f.Name), labelValue(f.Local), metric.value(f))
+			}
+			for _, f := range s.stats.Reverse {
+				fmt.Fprintf(w, "%s{tunnel=%s,forward=%s,remote=%s,direction=\"reverse\"} %d\n", metric.name, labelValue(s.id), labelValue(f.Name), labelValue(f.Local), metric.value(f))
+			}
+		}
+	}
+}
+
+// labelValue quotes a label value escaping as the text format requires
+func labelValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+// serveMetrics serves the registry on addr until ctx is done
+func serveMetrics(ctx context.Context, addr string, m *tunnelRegistry, reusePort bool) error {
+	listener, err := listenTCP(addr, reusePort)
+	if err != nil {
+		return fmt.Errorf("unable to serve metrics on %s: %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go server.Serve(listener)
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/arunsworld/go-tunnel/tunneltest"
+)
+
+func TestMetrics(t *testing.T) {
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	confs := []sshConfig{
+		{Name: "bastion", Destination: server.Addr, ThroughSSH: []sshConfig{{Destination: "inner:22"}}},
+	}
+	m := newTunnelRegistry(confs)
+	tun, err := tunnel.Execute(&tunnel.Spec{
+		Host:    server.Addr,
+		User:    server.User,
+		Auth:    server.Auth(),
+		Forward: []tunnel.Forwarder{tunnel.Forward(0, "db:5432").WithName(`the "db"`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	m.track("bastion", tun)
+
+	out := &bytes.Buffer{}
+	m.write(out)
+	for _, expected := range []string{
+		"# TYPE tunnel_up gauge",
+		`tunnel_up{tunnel="bastion"} 1`,
+		`tunnel_up{tunnel="inner:22"} 0`,
+		`tunnel_connections_total{tunnel="bastion",forward="the \"db\"",local="port `,
+		`tunnel_reconnect_attempts_total{tunnel="bastion"} 0`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected %s in:\n%s", expected, out)
+		}
+	}
+}
+
+func TestMetricsForPortRange(t *testing.T) {
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conf := sshConfig{
+		Name:        "bastion",
+		Destination: server.Addr,
+		User:        server.User,
+		Auth:        []auth{{PwdAuth: pwdAuth{PasswordSecret: "pwd", password: staticSecret(server.Password)}}},
+		Tunnels:     []portForward{{Name: "range", Ports: "1264-1265", Target: "db"}},
+	}
+	opts := &config{knownHostsFile: filepath.Join(t.TempDir(), "known_hosts")}
+	spec, err := specFor(conf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun, err := tunnel.Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	m := newTunnelRegistry([]sshConfig{conf})
+	m.track("bastion", tun)
+
+	out := &bytes.Buffer{}
+	m.write(out)
+	series := map[string]bool{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		labels := line[:strings.LastIndex(line, " ")]
+		if series[labels] {
+			t.Fatalf("duplicate series %s in:\n%s", labels, out)
+		}
+		series[labels] = true
+	}
+	for _, port := range []string{"1264", "1265"} {
+		expected := `tunnel_connections_total{tunnel="bastion",forward="range",local="port ` + port + `",direction="local"}`
+		if !series[expected] {
+			t.Fatalf("expected %s in:\n%s", expected, out)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+const (
+	hostsBegin = "# begin go-tunnel: managed by tunnel, changes are lost"
+	hostsEnd   = "# end go-tunnel"
+)
+
+// namesConfig has the hostnames of tunnels resolve to where they listen, so that services are reached by name:
+// over DNS served on DNS, such as 127.0.0.1:5353 for the system's resolver of a domain like tunnel.local to be
+// pointed at, and in the hosts file with HostsFile, which needs permission to write it
+type namesConfig struct {
+	DNS       string
+	HostsFile bool
+}
+
+// tunnelNames keeps the hostnames of the running tunnels resolving; a nil *tunnelNames does nothing
+type tunnelNames struct {
+	server    *tunnel.NameServer
+	hostsFile string
+}
+
+func startNames(ctx context.Context, conf *namesConfig, logger tunnel.Logger, reusePort bool) (*tunnelNames, error) {
+	if conf == nil || (conf.DNS == "" && !conf.HostsFile) {
+		return nil, nil
+	}
+	n := &tunnelNames{}
+	if conf.DNS != "" {
+		conn, err := listenUDP(conf.DNS, reusePort)
+		if err != nil {
+			return nil, fmt.Errorf("unable to serve names on %s: %v", conf.DNS, err)
+		}
+		n.server = &tunnel.NameServer{}
+		go n.server.Serve(ctx, conn, logger)
+	}
+	if conf.HostsFile {
+		n.hostsFile = hostsFilePath()
+	}
+	return n, nil
+}
+
+// apply has the hostnames of the tunnels of entries resolve, and no others
+func (n *tunnelNames) apply(entries []sshConfig) {
+	if n == nil {
+		return
+	}
+	names := hostnames(entries)
+	if n.server != nil {
+		n.server.SetNames(names)
+	}
+	if n.hostsFile != "" {
+		if err := updateHostsFile(n.hostsFile, names); err != nil {
+			log.Printf("unable to update %s: %v", n.hostsFile, err)
+		}
+	}
+}
+
+// close removes the entries of the hosts file
+func (n *tunnelNames) close() {
+	if n == nil || n.hostsFile == "" {
+		return
+	}
+	if err := updateHostsFile(n.hostsFile, nil); err != nil {
+		log.Printf("unable to update %s: %v", n.hostsFile, err)
+	}
+}
+
+func hostsFilePath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("SystemRoot"), "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
+}
+
+// hostnames are the hostnames of the tunnels of entries with the address they listen on, loopback for those
+// listening on localhost or every address
+func hostnames(entries []sshConfig) map[string]net.IP {
+	names := map[string]net.IP{}
+	var add func(sc sshConfig)
+	add = func(sc sshConfig) {
+		for _, pf := range sc.localForwards() {
+			if pf.Hostname == "" || pf.Ignore {
+				continue
+			}
+			bindAddress := pf.BindAddress
+			if bindAddress == "" {
+				bindAddress = sc.BindAddress
+			}
+			ip := net.ParseIP(bindAddress)
+			if ip == nil || ip.IsUnspecified() {
+				ip = net.IPv4(127, 0, 0, 1)
+			}
+			name := strings.ToLower(pf.Hostname)
+			if existing, taken := names[name]; taken && !existing.Equal(ip) {
+				log.Printf("hostname %s of %s already resolves to %s, ignoring %s", pf.Hostname, sc.id(), existing, ip)
+				continue
+			}
+			names[name] = ip
+		}
+		for _, through := range sc.ThroughSSH {
+			add(through)
+		}
+	}
+	for _, sc := range entries {
+		add(sc)
+	}
+	return names
+}
+
+// updateHostsFile replaces the entries tunnel manages in the hosts file by names
+func updateHostsFile(file string, names map[string]net.IP) error {
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	updated := withHostsEntries(string(contents), names)
+	if updated == string(contents) {
+		return nil
+	}
+	return os.WriteFile(file, []byte(updated), 0644)
+}
+
+// withHostsEntries replaces the block of entries tunnel manages in the contents of a hosts file by names, dropping
+// it when there are none
+func withHostsEntries(contents string, names map[string]net.IP) string {
+	var kept strings.Builder
+	managed := false
+	for _, line := range strings.SplitAfter(contents, "\n") {
+		switch strings.TrimSpace(line) {
+		case hostsBegin:
+			managed = true
+			continue
+		case hostsEnd:
+			if managed {
+				managed = false
+				continue
+			}
+		}
+		if !managed {
+			kept.WriteString(line)
+		}
+	}
+	updated := kept.String()
+	if len(names) == 0 {
+		return updated
+	}
+	if updated != "" && !strings.HasSuffix(updated, "\n") {
+		updated += "\n"
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	var block strings.Builder
+	block.WriteString(hostsBegin + "\n")
+	for _, name := range sorted {
+		fmt.Fprintf(&block, "%s\t%s\n", names[name], name)
+	}
+	block.WriteString(hostsEnd + "\n")
+	return updated + block.String()
+}
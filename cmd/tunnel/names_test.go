@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestHostnames(t *testing.T) {
+	entries := []sshConfig{
+		{Name: "a", BindAddress: "0.0.0.0", Tunnels: []portForward{
+			{Name: "grafana", Port: 3000, Hostname: "Grafana.tunnel.local"},
+			{Name: "api", Port: 80, BindAddress: "127.0.0.2", Hostname: "api.tunnel.local"},
+			{Name: "ignored", Port: 81, Hostname: "ignored.tunnel.local", Ignore: true},
+		}},
+		{Name: "b", ThroughSSH: []sshConfig{{Tunnels: []portForward{
+			{Name: "db", Port: 5432, BindAddress: "127.0.0.3", Hostname: "db.tunnel.local"},
+			{Name: "clash", Port: 80, BindAddress: "127.0.0.4", Hostname: "api.tunnel.local"},
+		}}}},
+	}
+	names := hostnames(entries)
+	expected := map[string]string{"grafana.tunnel.local": "127.0.0.1", "api.tunnel.local": "127.0.0.2", "db.tunnel.local": "127.0.0.3"}
+	if len(names) != len(expected) {
+		t.Fatalf("unexpected names %v", names)
+	}
+	for name, ip := range expected {
+		if !names[name].Equal(net.ParseIP(ip)) {
+			t.Fatalf("expected %s to resolve to %s, got %v", name, ip, names[name])
+		}
+	}
+}
+
+func TestWithHostsEntries(t *testing.T) {
+	hosts := "127.0.0.1\tlocalhost\n::1\tlocalhost"
+	names := map[string]net.IP{"b.tunnel.local": net.ParseIP("127.0.0.3"), "a.tunnel.local": net.ParseIP("127.0.0.2")}
+	updated := withHostsEntries(hosts, names)
+	expected := hosts + "\n" + hostsBegin + "\n127.0.0.2\ta.tunnel.local\n127.0.0.3\tb.tunnel.local\n" + hostsEnd + "\n"
+	if updated != expected {
+		t.Fatalf("unexpected hosts file:\n%s", updated)
+	}
+	// the block is replaced rather than added to, and lines after it kept
+	updated = withHostsEntries(updated+"10.0.0.1\tadded.later\n", map[string]net.IP{"c.tunnel.local": net.ParseIP("127.0.0.4")})
+	if strings.Count(updated, hostsBegin) != 1 || strings.Contains(updated, "a.tunnel.local") || !strings.Contains(updated, "127.0.0.4\tc.tunnel.local") || !strings.Contains(updated, "added.later") {
+		t.Fatalf("unexpected hosts file:\n%s", updated)
+	}
+	if cleared := withHostsEntries(updated, nil); cleared != hosts+"\n10.0.0.1\tadded.later\n" {
+		t.Fatalf("expected the block to be removed:\n%s", cleared)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+// parsePorts parses a comma separated list of ports and ranges such as 9000-9010,9020
+func parsePorts(s string) ([]int, error) {
+	ports := []int{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		first, last := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			first, last = part[:i], part[i+1:]
+		}
+		from, err := parsePort(first)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parsePort(last)
+		if err != nil {
+			return nil, err
+		}
+		if to < from {
+			return nil, fmt.Errorf("invalid port range %s", part)
+		}
+		for p := from; p <= to; p++ {
+			ports = append(ports, p)
+		}
+	}
+	return ports, nil
+}
+
+func parsePort(s string) (int, error) {
+	p, err := strconv.Atoi(s)
+	if err != nil || p <= 0 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %s", s)
+	}
+	return p, nil
+}
+
+// portRange resolves Ports against Target, which is either a host whose ports match the local ones or, for a
+// single range, host:port or host:first-last giving the destination ports the range maps to
+func (pf portForward) portRange() (ports []int, host string, destinationPort int, err error) {
+	ports, err = parsePorts(pf.Ports)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	host, destination, err := net.SplitHostPort(pf.Target)
+	if err != nil {
+		return ports, pf.Target, ports[0], nil
+	}
+	for i, p := range ports {
+		if p != ports[0]+i {
+			return nil, "", 0, fmt.Errorf("target %s must be a host when ports %s aren't a single range", pf.Target, pf.Ports)
+		}
+	}
+	destinationPorts, err := parsePorts(destination)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	if len(destinationPorts) > 1 && len(destinationPorts) != len(ports) {
+		return nil, "", 0, fmt.Errorf("target %s has %d ports, expected %d", pf.Target, len(destinationPorts), len(ports))
+	}
+	return ports, host, destinationPorts[0], nil
+}
+
+// validate checks a forward can be turned into a forwarder
+func (pf portForward) validate() error {
+	if pf.Reverse && (len(pf.AllowedUIDs) > 0 || len(pf.AllowedGIDs) > 0) {
+		return fmt.Errorf("tunnel %s: allowed peers can only be checked on local sockets", pf.Name)
+	}
+	if _, err := pf.strategy(); err != nil {
+		return fmt.Errorf("tunnel %s: %v", pf.Name, err)
+	}
+	conflict, err := pf.portConflict()
+	if err != nil {
+		return fmt.Errorf("tunnel %s: %v", pf.Name, err)
+	}
+	if conflict != tunnel.FailOnConflict && (pf.Reverse || pf.Socket != "") {
+		return fmt.Errorf("tunnel %s: portconflict only applies to local ports", pf.Name)
+	}
+	if pf.Capture != nil {
+		if _, err := pf.Capture.toCapture(); err != nil {
+			return fmt.Errorf("tunnel %s: %v", pf.Name, err)
+		}
+	}
+	if pf.TLS != nil {
+		if _, err := pf.TLS.toTLS(pf.BindAddress); err != nil {
+			return fmt.Errorf("tunnel %s: %v", pf.Name, err)
+		}
+	}
+	if pf.TargetTLS != nil {
+		if _, err := pf.TargetTLS.toTLS(); err != nil {
+			return fmt.Errorf("tunnel %s: %v", pf.Name, err)
+		}
+	}
+	if pf.Hostname != "" && (pf.Reverse || pf.Socket != "") {
+		return fmt.Errorf("tunnel %s: hostname only applies to local ports", pf.Name)
+	}
+	if pf.Dynamic && (pf.Reverse || pf.Socket != "" || pf.Ports != "" || len(pf.Replicas) > 0) {
+		return fmt.Errorf("tunnel %s: dynamic only applies to a single local port without replicas", pf.Name)
+	}
+	if !pf.Dynamic && (pf.SocksUser != "" || len(pf.AllowDestinations) > 0 || len(pf.DenyDestinations) > 0) {
+		return fmt.Errorf("tunnel %s: socksuser, allowdestinations and denydestinations need dynamic", pf.Name)
+	}
+	if pf.SocksUser != "" && pf.SocksPasswordSecret == "" {
+		return fmt.Errorf("tunnel %s: socksuser needs a sockspasswordsecret", pf.Name)
+	}
+	if _, err := parseCIDRs(pf.AllowedSources); err != nil {
+		return fmt.Errorf("tunnel %s: allowedsources: %v", pf.Name, err)
+	}
+	if pf.Ports == "" {
+		return nil
+	}
+	if len(pf.Replicas) > 0 {
+		return fmt.Errorf("tunnel %s: ports can't have replicas", pf.Name)
+	}
+	if pf.Name == "" && (pf.OnUp != "" || pf.OnDown != "") {
+		return fmt.Errorf("tunnel on ports %s: onup and ondown need a name", pf.Ports)
+	}
+	if pf.Port != 0 || pf.Socket != "" {
+		return fmt.Errorf("tunnel %s: ports can't be combined with port or socket", pf.Name)
+	}
+	if _, _, _, err := pf.portRange(); err != nil {
+		return fmt.Errorf("tunnel %s: %v", pf.Name, err)
+	}
+	return nil
+}
+
+// forwardCount is how many forwards the library brings up for pf, one for every port of Ports
+func (pf portForward) forwardCount() int {
+	if pf.Ports == "" {
+		return 1
+	}
+	ports, _ := parsePorts(pf.Ports)
+	return len(ports)
+}
+
+// multiPortForwarder returns the forwarder for Ports; pf must have been validated
+func (pf portForward) multiPortForwarder() tunnel.Forwarder {
+	ports, host, destinationPort, _ := pf.portRange()
+	if destinationPort == ports[0] {
+		return tunnel.ForwardPorts(ports, host)
+	}
+	return tunnel.ForwardRange(ports[0], ports[len(ports)-1], host, destinationPort)
+}
+
+// parseCIDRs parses networks such as 10.0.0.0/8
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks[i] = network
+	}
+	return networks, nil
+}
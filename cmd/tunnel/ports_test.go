@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	ports, err := parsePorts("9000-9002, 9010")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ports, []int{9000, 9001, 9002, 9010}) {
+		t.Fatalf("unexpected ports %v", ports)
+	}
+	for _, bad := range []string{"", "9002-9000", "0", "70000", "a-b", "9000,"} {
+		if _, err := parsePorts(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestPortRange(t *testing.T) {
+	cases := []struct {
+		ports, target   string
+		host            string
+		destinationPort int
+		invalid         bool
+	}{
+		{ports: "9000-9010", target: "node", host: "node", destinationPort: 9000},
+		{ports: "9000,9005", target: "node", host: "node", destinationPort: 9000},
+		{ports: "9000-9010", target: "node:9000-9010", host: "node", destinationPort: 9000},
+		{ports: "19000-19010", target: "node:9000", host: "node", destinationPort: 9000},
+		{ports: "9000-9010", target: "node:9000-9005", invalid: true},
+		{ports: "9000,9005", target: "node:9000", invalid: true},
+	}
+	for _, c := range cases {
+		pf := portForward{Name: "test", Ports: c.ports, Target: c.target}
+		if c.invalid {
+			if err := pf.validate(); err == nil {
+				t.Fatalf("expected %s -> %s to be rejected", c.ports, c.target)
+			}
+			continue
+		}
+		if err := pf.validate(); err != nil {
+			t.Fatal(err)
+		}
+		_, host, destinationPort, _ := pf.portRange()
+		if host != c.host || destinationPort != c.destinationPort {
+			t.Fatalf("%s -> %s: expected %s:%d, got %s:%d", c.ports, c.target, c.host, c.destinationPort, host, destinationPort)
+		}
+	}
+	if err := (portForward{Ports: "9000", Port: 8000, Target: "node"}).validate(); err == nil {
+		t.Fatal("expected ports combined with port to be rejected")
+	}
+}
+
+func TestValidatePortConflict(t *testing.T) {
+	for _, conflict := range []string{"", "fail", "next-free", "Kill-Stale"} {
+		if err := (portForward{Name: "test", Port: 8000, Target: "node:80", PortConflict: conflict}).validate(); err != nil {
+			t.Fatalf("%s: %v", conflict, err)
+		}
+	}
+	for _, pf := range []portForward{
+		{Name: "unknown", Port: 8000, Target: "node:80", PortConflict: "retry"},
+		{Name: "reverse", Port: 8000, Target: "node:80", PortConflict: "next-free", Reverse: true},
+		{Name: "socket", Socket: "/tmp/s", Target: "node:80", PortConflict: "next-free"},
+	} {
+		if err := pf.validate(); err == nil {
+			t.Fatalf("expected tunnel %s to be rejected", pf.Name)
+		}
+	}
+}
+
+func TestValidateDynamic(t *testing.T) {
+	valid := portForward{Name: "test", Port: 1080, Dynamic: true, SocksUser: "team", SocksPasswordSecret: "pwd", AllowDestinations: []string{"10.0.0.0/8"}}
+	if err := valid.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, pf := range []portForward{
+		{Name: "reverse", Port: 1080, Dynamic: true, Reverse: true},
+		{Name: "replicas", Port: 1080, Dynamic: true, Replicas: []string{"node:80"}},
+		{Name: "not dynamic", Port: 8000, Target: "node:80", DenyDestinations: []string{"10.0.0.1"}},
+		{Name: "no password", Port: 1080, Dynamic: true, SocksUser: "team"},
+	} {
+		if err := pf.validate(); err == nil {
+			t.Fatalf("expected tunnel %s to be rejected", pf.Name)
+		}
+	}
+}
+
+func TestValidateAllowedSources(t *testing.T) {
+	if err := (portForward{Name: "test", Port: 8000, Target: "node:80", AllowedSources: []string{"10.0.0.0/8", "::1/128"}}).validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (portForward{Name: "test", Port: 8000, Target: "node:80", AllowedSources: []string{"10.0.0.1"}}).validate(); err == nil {
+		t.Fatal("expected an address without a prefix length to be rejected")
+	}
+}
+
+func TestValidateCapture(t *testing.T) {
+	valid := portForward{Name: "test", Port: 8000, Target: "node:80", Capture: &captureConfig{File: "capture.pcap", Format: "pcap", Redact: []string{`token=\w+`}}}
+	if err := valid.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []captureConfig{
+		{Format: "hex"},
+		{File: "capture", Format: "tcpdump"},
+		{File: "capture", Redact: []string{"("}},
+	} {
+		c := c
+		if err := (portForward{Name: "test", Port: 8000, Target: "node:80", Capture: &c}).validate(); err == nil {
+			t.Fatalf("expected capture %+v to be rejected", c)
+		}
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	selfSigned := portForward{Name: "test", Port: 8443, Target: "node:80", TLS: &listenerTLSConfig{}, TargetTLS: &targetTLSConfig{ServerName: "node"}}
+	if err := selfSigned.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, pf := range []portForward{
+		{Name: "no key", Port: 8443, Target: "node:80", TLS: &listenerTLSConfig{CertFile: "cert.pem"}},
+		{Name: "missing ca", Port: 8443, Target: "node:443", TargetTLS: &targetTLSConfig{CAFile: "missing.pem"}},
+	} {
+		if err := pf.validate(); err == nil {
+			t.Fatalf("expected tunnel %s to be rejected", pf.Name)
+		}
+	}
+}
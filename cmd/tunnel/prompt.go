@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"golang.org/x/term"
+)
+
+// promptMu keeps entries connecting at the same time from prompting over each other
+var promptMu sync.Mutex
+
+// terminalPrompt asks the server's keyboard-interactive questions, such as for a one time code, on the terminal.
+// As with ssh, the controlling terminal is used where there is one so prompting works while stdin and stdout
+// carry a connection.
+func terminalPrompt(destination string) tunnel.KeyboardInteractivePrompt {
+	return func(name, instruction, question string, echo bool) (string, error) {
+		promptMu.Lock()
+		defer promptMu.Unlock()
+		in, out, done, err := openTerminal()
+		if err != nil {
+			return "", fmt.Errorf("unable to prompt for %s: %v", destination, err)
+		}
+		defer done()
+		for _, line := range []string{name, instruction} {
+			if line != "" {
+				fmt.Fprintln(out, line)
+			}
+		}
+		fmt.Fprintf(out, "(%s) %s", destination, question)
+		if !echo {
+			answer, err := term.ReadPassword(int(in.Fd()))
+			fmt.Fprintln(out)
+			return string(answer), err
+		}
+		answer, err := bufio.NewReader(in).ReadString('\n')
+		return strings.TrimRight(answer, "\r\n"), err
+	}
+}
+
+// openTerminal opens the controlling terminal, falling back to stdin and stdout when they're a terminal; done
+// is called once finished with it
+func openTerminal() (in *os.File, out io.Writer, done func(), err error) {
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		return tty, tty, func() { tty.Close() }, nil
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return os.Stdin, os.Stdout, func() {}, nil
+	}
+	return nil, nil, nil, fmt.Errorf("no terminal to prompt on")
+}
+
+// promptedSecret is a secret entered at the prompt, which can be asked for again if it turns out to be mistyped
+type promptedSecret struct {
+	name string
+	mu   sync.Mutex
+	v    string
+}
+
+func (s *promptedSecret) value() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.v, nil
+}
+
+// prompt asks for the secret's value on in with question
+func (s *promptedSecret) prompt(in *os.File, out io.Writer, question string) error {
+	fmt.Fprint(out, question)
+	answer, err := term.ReadPassword(int(in.Fd()))
+	if err != nil {
+		return fmt.Errorf("error reading secret from prompt for %s: %v", s.name, err)
+	}
+	if len(answer) == 0 {
+		return fmt.Errorf("error - no value provided for secret %s", s.name)
+	}
+	fmt.Fprintln(out)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.v = string(answer)
+	return nil
+}
+
+// repromptPasswords asks again for the password secrets of conf's entry that were entered at the prompt, once
+// the server has rejected them; false when there are none or they can't be asked for
+func repromptPasswords(conf sshConfig, opts *config) bool {
+	if opts.tui {
+		return false
+	}
+	secrets := []*promptedSecret{}
+	seen := map[*promptedSecret]bool{}
+	for _, a := range conf.Auth {
+		if s, ok := a.PwdAuth.password.(*promptedSecret); ok && !seen[s] {
+			secrets = append(secrets, s)
+			seen[s] = true
+		}
+	}
+	if len(secrets) == 0 {
+		return false
+	}
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	in, out, done, err := openTerminal()
+	if err != nil {
+		return false
+	}
+	defer done()
+	for _, s := range secrets {
+		if err := s.prompt(in, out, fmt.Sprintf("(%s) authentication failed, enter value for secret %s again: ", conf.Destination, s.name)); err != nil {
+			log.Printf("%v", err)
+			return false
+		}
+	}
+	return true
+}
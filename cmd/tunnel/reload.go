@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+)
+
+// supervisor runs every top level config entry, along with the entries reached through it, as one unit. On reload
+// only the units whose config changed are restarted so connections through the others are left alone.
+type supervisor struct {
+	ctx     context.Context
+	opts    *config
+	running map[string]*runningEntry
+	// desired are the entries last applied, by key
+	desired map[string]sshConfig
+	// finished receives entries as they stop
+	finished chan *runningEntry
+}
+
+type runningEntry struct {
+	key    string
+	conf   sshConfig
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newSupervisor(ctx context.Context, opts *config) *supervisor {
+	return &supervisor{
+		ctx:      ctx,
+		opts:     opts,
+		running:  map[string]*runningEntry{},
+		finished: make(chan *runningEntry),
+	}
+}
+
+// entryKeys keys entries by id, numbering those sharing one
+func entryKeys(confs []sshConfig) map[string]sshConfig {
+	keyed := make(map[string]sshConfig, len(confs))
+	for _, c := range confs {
+		key := c.id()
+		for n := 2; ; n++ {
+			if _, taken := keyed[key]; !taken {
+				break
+			}
+			key = fmt.Sprintf("%s#%d", c.id(), n)
+		}
+		keyed[key] = c
+	}
+	return keyed
+}
+
+// apply brings the running entries in line with confs, stopping removed and changed entries before starting new
+// and changed ones so their ports are free
+func (s *supervisor) apply(confs []sshConfig) {
+	desired := entryKeys(confs)
+	s.desired = desired
+	for key, e := range s.running {
+		if c, ok := desired[key]; ok && reflect.DeepEqual(c, e.conf) {
+			continue
+		}
+		log.Printf("stopping %s", key)
+		e.cancel()
+		<-e.done
+		delete(s.running, key)
+	}
+	for key, c := range desired {
+		if _, ok := s.running[key]; ok {
+			continue
+		}
+		s.start(key, c)
+	}
+}
+
+func (s *supervisor) start(key string, conf sshConfig) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	e := &runningEntry{key: key, conf: conf, cancel: cancel, done: make(chan struct{})}
+	s.running[key] = e
+	go func() {
+		if err := handleConnectionTo(ctx, conf, s.opts); err != nil {
+			log.Printf("error connecting to %s: %v", conf.Destination, err)
+		}
+		cancel()
+		close(e.done)
+		select {
+		case s.finished <- e:
+		case <-s.ctx.Done():
+		}
+	}()
+}
+
+// restart stops the entry with the given key, if it's running, and starts it again
+func (s *supervisor) restart(key string) {
+	c, ok := s.desired[key]
+	if !ok {
+		return
+	}
+	if e, ok := s.running[key]; ok {
+		log.Printf("restarting %s", key)
+		e.cancel()
+		<-e.done
+	}
+	s.start(key, c)
+}
+
+// stopped forgets e if it's still the running entry for its key, reporting whether nothing is left running
+func (s *supervisor) stopped(e *runningEntry) bool {
+	if s.running[e.key] == e {
+		delete(s.running, e.key)
+	}
+	return len(s.running) == 0
+}
+
+// wait stops waiting once all entries are done
+func (s *supervisor) wait() {
+	for _, e := range s.running {
+		<-e.done
+	}
+}
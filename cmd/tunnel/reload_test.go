@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arunsworld/go-tunnel/tunneltest"
+)
+
+func TestSupervisorApply(t *testing.T) {
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	entry := func(name string, port int) sshConfig {
+		return sshConfig{
+			Name:        name,
+			Destination: server.Addr,
+			User:        server.User,
+			Auth:        []auth{{PwdAuth: pwdAuth{PasswordSecret: "pwd", password: staticSecret(server.Password)}}},
+			Tunnels:     []portForward{{Name: "web", Port: port, Target: "localhost:80"}},
+		}
+	}
+	opts := &config{knownHostsFile: filepath.Join(t.TempDir(), "known_hosts")}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newSupervisor(ctx, opts)
+
+	s.apply([]sshConfig{entry("a", 0), entry("b", 0)})
+	a, b := s.running["a"], s.running["b"]
+	if a == nil || b == nil {
+		t.Fatalf("expected both entries to be running, got %v", s.running)
+	}
+
+	s.apply([]sshConfig{entry("a", 0), entry("b", 1), entry("c", 0)})
+	if s.running["a"] != a {
+		t.Fatal("unchanged entry was restarted")
+	}
+	if s.running["b"] == b {
+		t.Fatal("changed entry was not restarted")
+	}
+	select {
+	case <-b.done:
+	default:
+		t.Fatal("changed entry was not stopped")
+	}
+	if s.running["c"] == nil {
+		t.Fatal("new entry not started")
+	}
+
+	s.apply([]sshConfig{entry("a", 0)})
+	if len(s.running) != 1 {
+		t.Fatalf("expected removed entries to be stopped, got %v", s.running)
+	}
+
+	s.restart("a")
+	select {
+	case <-a.done:
+	default:
+		t.Fatal("restarted entry was not stopped")
+	}
+	if s.running["a"] == a || s.running["a"] == nil {
+		t.Fatal("restarted entry was not started again")
+	}
+	s.restart("b")
+	if s.running["b"] != nil {
+		t.Fatal("removed entry was restarted")
+	}
+
+	cancel()
+	waited := make(chan struct{})
+	go func() {
+		s.wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(time.Second * 5):
+		t.Fatal("entries not stopped on cancellation")
+	}
+}
+
+func TestEntryKeys(t *testing.T) {
+	keys := entryKeys([]sshConfig{{Destination: "host:22"}, {Destination: "host:22"}, {Name: "named", Destination: "host:22"}})
+	for _, key := range []string{"host:22", "host:22#2", "named"} {
+		if _, ok := keys[key]; !ok {
+			t.Fatalf("expected key %s in %v", key, keys)
+		}
+	}
+}
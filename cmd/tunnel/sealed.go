@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v2"
+)
+
+// sealedPassphraseEnv holds the passphrase of sealed secrets files, for when there's no one to prompt
+const sealedPassphraseEnv = "TUNNEL_SECRETS_PASSPHRASE"
+
+// sealedFiles are the secrets files unlocked so far, all with the one passphrase, asked for once
+type sealedFiles struct {
+	passphrase string
+	files      map[string]map[string]string
+}
+
+// secret returns the value of key in the sealed file, unlocking it the first time
+func (s *sealedFiles) secret(file, key string) (string, error) {
+	values, ok := s.files[file]
+	if !ok {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("unable to read secrets file: %v", err)
+		}
+		if s.passphrase == "" {
+			if s.passphrase, err = sealedPassphrase(); err != nil {
+				return "", err
+			}
+		}
+		plaintext, err := ageDecrypt(contents, s.passphrase)
+		if err != nil {
+			return "", fmt.Errorf("unable to unlock secrets file %s: %v", file, err)
+		}
+		if err := yaml.Unmarshal(plaintext, &values); err != nil {
+			return "", fmt.Errorf("unable to parse secrets file %s: %v", file, err)
+		}
+		if s.files == nil {
+			s.files = map[string]map[string]string{}
+		}
+		s.files[file] = values
+	}
+	v, ok := values[key]
+	if !ok || v == "" {
+		return "", fmt.Errorf("secrets file %s has no %s", file, key)
+	}
+	return v, nil
+}
+
+func sealedPassphrase() (string, error) {
+	if passphrase := os.Getenv(sealedPassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	fmt.Print("Enter passphrase for secrets files: ")
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println("")
+	if err != nil {
+		return "", fmt.Errorf("error reading passphrase from prompt: %v", err)
+	}
+	if len(passphrase) == 0 {
+		return "", errors.New("error - no passphrase provided for secrets files")
+	}
+	return string(passphrase), nil
+}
+
+func sealCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "seal",
+		Usage: "encrypt a yaml file of name: value secrets with a passphrase, for secrets to reference with file",
+		UsageText: "tunnel seal <secrets file> <sealed file>\n" +
+			"   the sealed file is in age's format and can also be made with age -p -a",
+		Action: func(ctx *cli.Context) error {
+			if ctx.NArg() != 2 {
+				return errors.New("secrets file and sealed file are required")
+			}
+			plaintext, err := os.ReadFile(ctx.Args().First())
+			if err != nil {
+				return err
+			}
+			values := map[string]string{}
+			if err := yaml.Unmarshal(plaintext, &values); err != nil {
+				return fmt.Errorf("secrets file should be yaml of name: value: %v", err)
+			}
+			passphrase, err := sealedPassphrase()
+			if err != nil {
+				return err
+			}
+			if os.Getenv(sealedPassphraseEnv) == "" {
+				fmt.Print("Confirm passphrase: ")
+				confirmed, err := term.ReadPassword(int(os.Stdin.Fd()))
+				fmt.Println("")
+				if err != nil {
+					return fmt.Errorf("error reading passphrase from prompt: %v", err)
+				}
+				if string(confirmed) != passphrase {
+					return errors.New("passphrases don't match")
+				}
+			}
+			sealed, err := ageEncrypt(plaintext, passphrase, ageDefaultLogN)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(ctx.Args().Get(1), sealed, 0600)
+		},
+	}
+}
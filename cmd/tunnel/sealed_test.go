@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age/armor"
+)
+
+func TestAgeRoundTrip(t *testing.T) {
+	// the payload is encrypted in chunks of 64KiB
+	chunk := 64 * 1024
+	for _, size := range []int{0, 10, chunk, chunk + 1, 2*chunk + 100} {
+		plaintext := bytes.Repeat([]byte("s"), size)
+		sealed, err := ageEncrypt(plaintext, "correct horse", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opened, err := ageDecrypt(sealed, "correct horse")
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Fatalf("%d bytes: got %d bytes back", size, len(opened))
+		}
+		if _, err := ageDecrypt(sealed, "wrong"); err == nil || !strings.Contains(err.Error(), "incorrect passphrase") {
+			t.Fatalf("expected a wrong passphrase to be refused, got %v", err)
+		}
+		unarmored, err := io.ReadAll(armor.NewReader(bytes.NewReader(sealed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ageDecrypt(unarmored, "correct horse"); err != nil {
+			t.Fatalf("expected the binary format to be read too: %v", err)
+		}
+		tampered := append([]byte{}, unarmored...)
+		tampered[len(tampered)-1] ^= 1
+		if _, err := ageDecrypt(tampered, "correct horse"); err == nil {
+			t.Fatal("expected a tampered payload to be refused")
+		}
+		if _, err := ageDecrypt(unarmored[:len(unarmored)-17], "correct horse"); err == nil {
+			t.Fatal("expected a truncated payload to be refused")
+		}
+	}
+}
+
+func TestSealedSecrets(t *testing.T) {
+	sealed, err := ageEncrypt([]byte("bastion: hunter2\ndb: swordfish\n"), "team passphrase", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "secrets.age")
+	if err := os.WriteFile(file, sealed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(sealedPassphraseEnv, "team passphrase")
+	vault, err := newSecretsVault([]secret{
+		{Name: "bastion", File: file},
+		{Name: "database", File: file, Key: "db"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"bastion": "hunter2", "database": "swordfish"} {
+		if v, _ := vault[name].value(); v != expected {
+			t.Errorf("expected %s to be %q, got %q", name, expected, v)
+		}
+	}
+
+	if _, err := newSecretsVault([]secret{{Name: "missing", File: file}}, nil); err == nil {
+		t.Fatal("expected an error for a secret the file doesn't have")
+	}
+	t.Setenv(sealedPassphraseEnv, "wrong passphrase")
+	if _, err := newSecretsVault([]secret{{Name: "bastion", File: file}}, nil); err == nil {
+		t.Fatal("expected an error for the wrong passphrase")
+	}
+}
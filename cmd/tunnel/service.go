@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/urfave/cli/v2"
+)
+
+// service is the daemon registered with the system's service manager
+type service struct {
+	name string
+	// args run the daemon in the foreground, as service managers expect, starting with the executable
+	args []string
+	// system is set for a service of the whole system, installed as root, rather than one of the user
+	system bool
+	home   string
+}
+
+// serviceManager is the service manager of the system: systemd, launchd or the windows service control manager
+type serviceManager interface {
+	install(s service) error
+	uninstall(name string) error
+	start(name string) error
+	stop(name string) error
+}
+
+func serviceCommand(opts *config) *cli.Command {
+	var name string
+	nameFlag := &cli.StringFlag{
+		Name:        "name",
+		Usage:       "name of the service, to install more than one",
+		Value:       "go-tunnel",
+		Destination: &name,
+	}
+	action := func(act func(serviceManager, string) error) cli.ActionFunc {
+		return func(ctx *cli.Context) error {
+			manager, err := systemServiceManager()
+			if err != nil {
+				return err
+			}
+			return act(manager, name)
+		}
+	}
+	return &cli.Command{
+		Name:  "service",
+		Usage: "run the daemon as a systemd, launchd or windows service so that tunnels survive reboots",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "install",
+				Usage: "register the daemon running a config to start at boot, or login when not installed as root",
+				UsageText: "tunnel service install [--name <name>] <config file>\n" +
+					"   secrets can't be prompted for by a service so have to come from the environment",
+				Flags: []cli.Flag{nameFlag},
+				Action: func(ctx *cli.Context) error {
+					if ctx.NArg() != 1 {
+						return errors.New("config file not provided")
+					}
+					s, err := newService(name, ctx.Args().First(), opts)
+					if err != nil {
+						return err
+					}
+					manager, err := systemServiceManager()
+					if err != nil {
+						return err
+					}
+					if err := manager.install(s); err != nil {
+						return err
+					}
+					fmt.Printf("installed service %s; start it with: tunnel service start --name %s\n", name, name)
+					return nil
+				},
+			},
+			{
+				Name:   "uninstall",
+				Usage:  "stop the service and remove it",
+				Flags:  []cli.Flag{nameFlag},
+				Action: action(serviceManager.uninstall),
+			},
+			{
+				Name:   "start",
+				Usage:  "start the service",
+				Flags:  []cli.Flag{nameFlag},
+				Action: action(serviceManager.start),
+			},
+			{
+				Name:   "stop",
+				Usage:  "stop the service",
+				Flags:  []cli.Flag{nameFlag},
+				Action: action(serviceManager.stop),
+			},
+		},
+	}
+}
+
+// newService is the daemon of configFile, taking commands on the control socket of this user so that the status
+// and other commands reach it
+func newService(name, configFile string, opts *config) (service, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return service{}, err
+	}
+	configFile, err = filepath.Abs(configFile)
+	if err != nil {
+		return service{}, err
+	}
+	if _, err := os.Stat(configFile); err != nil {
+		return service{}, err
+	}
+	home, _ := os.UserHomeDir()
+	return service{
+		name:   name,
+		args:   []string{executable, "--control-socket", opts.controlSocket, "daemon", "--foreground", configFile},
+		system: os.Geteuid() == 0,
+		home:   home,
+	}, nil
+}
+
+func systemServiceManager() (serviceManager, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return systemd{}, nil
+	case "darwin":
+		return launchd{}, nil
+	case "windows":
+		return windowsServices{}, nil
+	}
+	return nil, fmt.Errorf("services aren't supported on %s", runtime.GOOS)
+}
+
+// runManager runs a service manager's command, with its output in the error when it fails
+func runManager(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+type systemd struct{}
+
+var systemdUnitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=go-tunnel {{.Name}}
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+ExecStart={{.ExecStart}}
+{{- if .System}}
+Environment=HOME={{.Home}}
+{{- end}}
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy={{if .System}}multi-user.target{{else}}default.target{{end}}
+`))
+
+// systemdUnit is the unit running the service; a service of the system is given the home directory of the root
+// user that installed it, where its known hosts and control socket are
+func systemdUnit(s service) string {
+	quoted := make([]string, len(s.args))
+	for i, arg := range s.args {
+		quoted[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%").Replace(arg) + `"`
+	}
+	var unit strings.Builder
+	systemdUnitTemplate.Execute(&unit, map[string]interface{}{
+		"Name":      s.name,
+		"ExecStart": strings.Join(quoted, " "),
+		"System":    s.system,
+		"Home":      s.home,
+	})
+	return unit.String()
+}
+
+func (systemd) unitFile(name string, system bool) (string, error) {
+	if system {
+		return filepath.Join("/etc/systemd/system", name+".service"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", name+".service"), nil
+}
+
+// systemctl runs systemctl for the system's services as root, and the user's otherwise
+func (systemd) systemctl(args ...string) error {
+	if os.Geteuid() != 0 {
+		args = append([]string{"--user"}, args...)
+	}
+	return runManager("systemctl", args...)
+}
+
+func (m systemd) install(s service) error {
+	file, err := m.unitFile(s.name, s.system)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, []byte(systemdUnit(s)), 0644); err != nil {
+		return err
+	}
+	if err := m.systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return m.systemctl("enable", s.name)
+}
+
+func (m systemd) uninstall(name string) error {
+	file, err := m.unitFile(name, os.Geteuid() == 0)
+	if err != nil {
+		return err
+	}
+	if err := m.systemctl("disable", "--now", name); err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	return m.systemctl("daemon-reload")
+}
+
+func (m systemd) start(name string) error {
+	return m.systemctl("start", name)
+}
+
+func (m systemd) stop(name string) error {
+	return m.systemctl("stop", name)
+}
+
+type launchd struct{}
+
+var launchdPlistTemplate = template.Must(template.New("plist").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{.Name}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args}}
+		<string>{{.}}</string>
+{{- end}}
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardOutPath</key>
+	<string>{{.Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{.Log}}</string>
+</dict>
+</plist>
+`))
+
+// launchdPlist is the job running the service, restarted should it fail and logging to logFile
+func launchdPlist(s service, logFile string) string {
+	var plist strings.Builder
+	// text/template doesn't escape, and arguments can have characters special to xml
+	args := make([]string, len(s.args))
+	for i, arg := range s.args {
+		args[i] = template.HTMLEscapeString(arg)
+	}
+	launchdPlistTemplate.Execute(&plist, map[string]interface{}{
+		"Name": template.HTMLEscapeString(s.name),
+		"Args": args,
+		"Log":  template.HTMLEscapeString(logFile),
+	})
+	return plist.String()
+}
+
+// paths are the plist of a job, a daemon of the system as root and an agent of the user otherwise, and its log
+func (launchd) paths(name string) (string, string, error) {
+	if os.Geteuid() == 0 {
+		return filepath.Join("/Library/LaunchDaemons", name+".plist"), filepath.Join("/Library/Logs", name+".log"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", name+".plist"), filepath.Join(home, "Library", "Logs", name+".log"), nil
+}
+
+func (m launchd) install(s service) error {
+	file, logFile, err := m.paths(s.name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, []byte(launchdPlist(s, logFile)), 0644); err != nil {
+		return err
+	}
+	return runManager("launchctl", "load", "-w", file)
+}
+
+func (m launchd) uninstall(name string) error {
+	file, _, err := m.paths(name)
+	if err != nil {
+		return err
+	}
+	if err := runManager("launchctl", "unload", "-w", file); err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
+
+func (launchd) start(name string) error {
+	return runManager("launchctl", "start", name)
+}
+
+func (launchd) stop(name string) error {
+	return runManager("launchctl", "stop", name)
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// windowsServices is only available on windows
+type windowsServices struct{}
+
+var errNoWindowsServices = errors.New("windows services are only available on windows")
+
+func (windowsServices) install(s service) error {
+	return errNoWindowsServices
+}
+
+func (windowsServices) uninstall(name string) error {
+	return errNoWindowsServices
+}
+
+func (windowsServices) start(name string) error {
+	return errNoWindowsServices
+}
+
+func (windowsServices) stop(name string) error {
+	return errNoWindowsServices
+}
+
+// runAsWindowsService is false as the daemon only runs as a windows service on windows
+func runAsWindowsService(name string, run func(ctx context.Context) error) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSystemdUnit(t *testing.T) {
+	s := service{
+		name: "go-tunnel",
+		args: []string{"/usr/local/bin/tunnel", "daemon", "--foreground", `/etc/my "tunnels"/100%.yml`},
+	}
+	unit := systemdUnit(s)
+	for _, want := range []string{
+		`ExecStart="/usr/local/bin/tunnel" "daemon" "--foreground" "/etc/my \"tunnels\"/100%%.yml"`,
+		"Restart=on-failure",
+		"WantedBy=default.target",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("expected %q in:\n%s", want, unit)
+		}
+	}
+	if strings.Contains(unit, "HOME=") {
+		t.Errorf("a user's unit has its home already:\n%s", unit)
+	}
+
+	s.system, s.home = true, "/root"
+	unit = systemdUnit(s)
+	for _, want := range []string{"Environment=HOME=/root", "WantedBy=multi-user.target"} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("expected %q in:\n%s", want, unit)
+		}
+	}
+}
+
+func TestLaunchdPlist(t *testing.T) {
+	s := service{
+		name: "go-tunnel",
+		args: []string{"/usr/local/bin/tunnel", "daemon", "--foreground", "/Users/me/a&b.yml"},
+	}
+	plist := launchdPlist(s, "/Users/me/Library/Logs/go-tunnel.log")
+	for _, want := range []string{
+		"<key>Label</key>\n\t<string>go-tunnel</string>",
+		"<array>\n\t\t<string>/usr/local/bin/tunnel</string>\n\t\t<string>daemon</string>\n\t\t<string>--foreground</string>\n\t\t<string>/Users/me/a&amp;b.yml</string>\n\t</array>",
+		"<string>/Users/me/Library/Logs/go-tunnel.log</string>",
+	} {
+		if !strings.Contains(plist, want) {
+			t.Errorf("expected %q in:\n%s", want, plist)
+		}
+	}
+}
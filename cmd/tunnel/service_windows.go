@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// windowsServices is the windows service control manager
+type windowsServices struct{}
+
+func (windowsServices) open(name string) (*mgr.Mgr, *mgr.Service, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to the service manager: %v", err)
+	}
+	s, err := m.OpenService(name)
+	if err != nil {
+		m.Disconnect()
+		return nil, nil, fmt.Errorf("unable to open service %s: %v", name, err)
+	}
+	return m, s, nil
+}
+
+func (windowsServices) install(s service) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect to the service manager: %v", err)
+	}
+	defer m.Disconnect()
+	created, err := m.CreateService(s.name, s.args[0], mgr.Config{
+		DisplayName: "go-tunnel " + s.name,
+		Description: "go-tunnel daemon running " + s.args[len(s.args)-1],
+		StartType:   mgr.StartAutomatic,
+	}, s.args[1:]...)
+	if err != nil {
+		return fmt.Errorf("unable to create service %s: %v", s.name, err)
+	}
+	defer created.Close()
+	return created.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 5 * time.Second}}, 0)
+}
+
+func (w windowsServices) uninstall(name string) error {
+	if err := w.stop(name); err != nil && !strings.Contains(err.Error(), "not running") {
+		return err
+	}
+	m, s, err := w.open(name)
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	defer s.Close()
+	return s.Delete()
+}
+
+func (w windowsServices) start(name string) error {
+	m, s, err := w.open(name)
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	defer s.Close()
+	return s.Start()
+}
+
+func (w windowsServices) stop(name string) error {
+	m, s, err := w.open(name)
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	defer s.Close()
+	status, err := s.Query()
+	if err != nil {
+		return err
+	}
+	if status.State == svc.Stopped {
+		return fmt.Errorf("service %s is not running", name)
+	}
+	_, err = s.Control(svc.Stop)
+	return err
+}
+
+// serviceHandler runs the daemon, cancelling it when the service is stopped
+type serviceHandler struct {
+	run func(ctx context.Context) error
+	err error
+}
+
+func (h *serviceHandler) Execute(_ []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- h.run(ctx)
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case h.err = <-done:
+			changes <- svc.Status{State: svc.StopPending}
+			if h.err != nil {
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				cancel()
+				h.err = <-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// runAsWindowsService runs the daemon under the service control manager when it was started by it, returning
+// false otherwise
+func runAsWindowsService(name string, run func(ctx context.Context) error) (bool, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false, err
+	}
+	h := &serviceHandler{run: run}
+	if err := svc.Run(name, h); err != nil {
+		return true, err
+	}
+	return true, h.err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+)
+
+func sftpCommand(opts *config) *cli.Command {
+	return &cli.Command{
+		Name:  "sftp",
+		Usage: "transfer files to or from a host through the configured hops with sftp",
+		UsageText: "tunnel sftp <config file> <host or tunnel name> get <remote path>... [<local path>]\n" +
+			"   tunnel sftp <config file> <host or tunnel name> put <local path>... [<remote path>]",
+		Description: "With a single path the file is transferred to the current directory, or the remote home " +
+			"directory, under its own name. With more the last is where they go, which must be a directory when " +
+			"there are several.",
+		Action: func(ctx *cli.Context) error {
+			if ctx.NArg() < 4 {
+				return errors.New("config file, host or tunnel name, get or put and paths are required")
+			}
+			args := ctx.Args().Slice()
+			direction, paths := args[2], args[3:]
+			if direction != "get" && direction != "put" {
+				return fmt.Errorf("unknown transfer %s, expected get or put", direction)
+			}
+			opts.configFile = args[0]
+			tunnelConf, err := loadConfig(opts)
+			if err != nil {
+				return err
+			}
+			hop, err := shellHop(tunnelConf.SshConfigs, args[1])
+			if err != nil {
+				return err
+			}
+			t, closeHop, err := dialHop(ctx.Context, tunnelConf, hop, opts)
+			if err != nil {
+				return err
+			}
+			defer closeHop()
+			client, err := t.SFTP()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			sources, destination := paths, ""
+			if len(paths) > 1 {
+				sources, destination = paths[:len(paths)-1], paths[len(paths)-1]
+			}
+			if direction == "get" {
+				isDir := destination == ""
+				if info, err := os.Stat(destination); err == nil && info.IsDir() {
+					isDir = true
+				}
+				pairs, err := sftpTransfers(sources, destination, isDir, path.Base, filepath.Join)
+				if err != nil {
+					return err
+				}
+				for _, p := range pairs {
+					if err := t.Download(p[0], p[1]); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			isDir := destination == ""
+			if info, err := client.Stat(destination); err == nil && info.IsDir() {
+				isDir = true
+			}
+			pairs, err := sftpTransfers(sources, destination, isDir, filepath.Base, path.Join)
+			if err != nil {
+				return err
+			}
+			for _, p := range pairs {
+				if err := t.Upload(p[0], p[1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// sftpTransfers pairs every source with where it's transferred to: destination itself, or the source's base name
+// within it when it's a directory
+func sftpTransfers(sources []string, destination string, isDir bool, base func(string) string, join func(...string) string) ([][2]string, error) {
+	if len(sources) > 1 && !isDir {
+		return nil, fmt.Errorf("%s must be a directory to transfer several files to", destination)
+	}
+	pairs := make([][2]string, 0, len(sources))
+	for _, source := range sources {
+		to := destination
+		if isDir {
+			to = join(destination, base(source))
+		}
+		pairs = append(pairs, [2]string{source, to})
+	}
+	return pairs, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestSFTPTransfers(t *testing.T) {
+	pairs, err := sftpTransfers([]string{"/var/log/app.log"}, "", true, path.Base, path.Join)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pairs, [][2]string{{"/var/log/app.log", "app.log"}}) {
+		t.Fatalf("unexpected transfers %v", pairs)
+	}
+	pairs, err = sftpTransfers([]string{"a.txt", "dir/b.txt"}, "/tmp", true, path.Base, path.Join)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pairs, [][2]string{{"a.txt", "/tmp/a.txt"}, {"dir/b.txt", "/tmp/b.txt"}}) {
+		t.Fatalf("unexpected transfers %v", pairs)
+	}
+	pairs, err = sftpTransfers([]string{"a.txt"}, "renamed.txt", false, path.Base, path.Join)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pairs, [][2]string{{"a.txt", "renamed.txt"}}) {
+		t.Fatalf("unexpected transfers %v", pairs)
+	}
+	if _, err := sftpTransfers([]string{"a.txt", "b.txt"}, "file.txt", false, path.Base, path.Join); err == nil {
+		t.Fatal("expected several files to a file to be rejected")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/arunsworld/nursery"
+	"github.com/urfave/cli/v2"
+)
+
+func shareCommand(opts *config) *cli.Command {
+	share := tunnel.ShareSpec{}
+	via := ""
+	return &cli.Command{
+		Name:      "share",
+		Usage:     "share a local service publicly through the ssh host",
+		UsageText: "tunnel share [options] <config file> <local address>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "via", Usage: "destination of the config entry to share through (default: the first)", Destination: &via},
+			&cli.IntFlag{Name: "remote-port", Usage: "port to open on the remote host (default: chosen by the remote host)", Destination: &share.RemotePort},
+			&cli.StringFlag{Name: "public-host", Usage: "name under which the remote host is publicly reachable", Destination: &share.PublicHost},
+			&cli.StringFlag{Name: "vhost", Usage: "host name to register with the share front", Destination: &share.VHost},
+			&cli.StringFlag{Name: "front", Usage: "registration address of the share front, as seen from the remote host", Destination: &share.Front},
+			&cli.StringFlag{Name: "scheme", Usage: "scheme of the shareable url", Value: "http", Destination: &share.Scheme},
+		},
+		Action: func(ctx *cli.Context) error {
+			if ctx.NArg() != 2 {
+				return errors.New("config file and local address are required")
+			}
+			share.LocalAddr = ctx.Args().Get(1)
+			opts.configFile = ctx.Args().First()
+			tunnelConf, err := loadConfig(opts)
+			if err != nil {
+				return err
+			}
+			conf, err := topLevelConfig(tunnelConf, via)
+			if err != nil {
+				return err
+			}
+			spec, err := specFor(conf, opts)
+			if err != nil {
+				return err
+			}
+			url := make(chan string, 1)
+			return nursery.RunConcurrently(
+				func(_ context.Context, errCh chan error) {
+					if err := tunnel.ShareAndBlock(ctx.Context, spec, &share, url); err != nil {
+						errCh <- err
+					}
+					close(url)
+				},
+				func(context.Context, chan error) {
+					if u, ok := <-url; ok {
+						fmt.Printf("Sharing %s at %s\n", share.LocalAddr, u)
+					}
+				},
+			)
+		},
+	}
+}
+
+func shareFrontCommand() *cli.Command {
+	public, admin := "", ""
+	return &cli.Command{
+		Name:      "share-front",
+		Usage:     "run on the remote host: route public http traffic to shares by host name",
+		UsageText: "tunnel share-front [options]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "listen", Usage: "public address to serve", Value: ":80", Destination: &public},
+			&cli.StringFlag{Name: "admin", Usage: "local address accepting share registrations", Value: "localhost:8022", Destination: &admin},
+		},
+		Action: func(ctx *cli.Context) error {
+			return tunnel.NewShareFront(tunnel.StdOutLogger()).ServeAndBlock(ctx.Context, public, admin)
+		},
+	}
+}
+
+// topLevelConfig returns the config entry for destination, or the first one if destination is empty
+func topLevelConfig(tunnelConf tunnelConfig, destination string) (sshConfig, error) {
+	for _, c := range tunnelConf.SshConfigs {
+		if destination == "" || c.Destination == destination {
+			return c, nil
+		}
+	}
+	if destination == "" {
+		return sshConfig{}, errors.New("config has no ssh configs")
+	}
+	return sshConfig{}, fmt.Errorf("no config for destination %s", destination)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+func sshCommand(opts *config) *cli.Command {
+	return &cli.Command{
+		Name:      "ssh",
+		Usage:     "open an interactive shell on a host through the configured hops",
+		UsageText: "tunnel ssh <config file> <host or tunnel name>",
+		Action: func(ctx *cli.Context) error {
+			if ctx.NArg() != 2 {
+				return errors.New("config file and host or tunnel name are required")
+			}
+			opts.configFile = ctx.Args().First()
+			tunnelConf, err := loadConfig(opts)
+			if err != nil {
+				return err
+			}
+			hop, err := shellHop(tunnelConf.SshConfigs, ctx.Args().Get(1))
+			if err != nil {
+				return err
+			}
+			t, closeHop, err := dialHop(ctx.Context, tunnelConf, hop, opts)
+			if err != nil {
+				return err
+			}
+			defer closeHop()
+			return interactiveShell(t)
+		},
+	}
+}
+
+// shellHop resolves name to a hop id: either a hop itself or a named tunnel that a hop connects through
+func shellHop(confs []sshConfig, name string) (string, error) {
+	if _, ok := hopPath(confs, name); ok {
+		return name, nil
+	}
+	if id, ok := hopThroughTunnel(confs, name); ok {
+		return id, nil
+	}
+	return "", fmt.Errorf("no host or tunnel named %s", name)
+}
+
+func hopThroughTunnel(confs []sshConfig, name string) (string, bool) {
+	for _, c := range confs {
+		for _, f := range c.localForwards() {
+			if f.Name != name {
+				continue
+			}
+			for _, child := range c.ThroughSSH {
+				if _, port, err := net.SplitHostPort(child.Destination); err == nil && port == strconv.Itoa(f.Port) {
+					return child.id(), true
+				}
+			}
+		}
+		if id, ok := hopThroughTunnel(c.ThroughSSH, name); ok {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func interactiveShell(t *tunnel.Tunnel) error {
+	session, err := t.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("unable to put terminal into raw mode: %v", err)
+		}
+		defer term.Restore(fd, state)
+		width, height, err := term.GetSize(fd)
+		if err != nil {
+			width, height = 80, 24
+		}
+		termType := os.Getenv("TERM")
+		if termType == "" {
+			termType = "xterm-256color"
+		}
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+		if err := session.RequestPty(termType, height, width, modes); err != nil {
+			return fmt.Errorf("unable to request pty: %v", err)
+		}
+		stop := watchTerminalSize(fd, session)
+		defer stop()
+	}
+	if err := session.Shell(); err != nil {
+		return fmt.Errorf("unable to start shell: %v", err)
+	}
+	if err := session.Wait(); err != nil {
+		if _, ok := err.(*ssh.ExitError); !ok {
+			return err
+		}
+	}
+	return nil
+}
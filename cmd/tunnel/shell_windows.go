@@ -0,0 +1,10 @@
+package main
+
+import (
+	"golang.org/x/crypto/ssh"
+)
+
+// watchTerminalSize is a no-op on windows which has no SIGWINCH
+func watchTerminalSize(fd int, session *ssh.Session) func() {
+	return func() {}
+}
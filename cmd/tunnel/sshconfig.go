@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+// resolveSSHAliases resolves every destination without a port, including fallbacks and hops through ssh, as a
+// host alias of the OpenSSH config
+func resolveSSHAliases(confs []sshConfig, openSSH *tunnel.SSHConfig) {
+	for i := range confs {
+		confs[i].resolveSSHAlias(openSSH)
+		resolveSSHAliases(confs[i].ThroughSSH, openSSH)
+	}
+}
+
+// resolveSSHAlias fills in the destination, user, identity files and jump hosts of an alias; anything the
+// entry sets itself is kept
+func (sc *sshConfig) resolveSSHAlias(openSSH *tunnel.SSHConfig) {
+	for i, fallback := range sc.FallbackDestinations {
+		if !hasPort(fallback) {
+			sc.FallbackDestinations[i] = openSSH.Lookup(fallback).Addr()
+		}
+	}
+	if sc.Destination == "" || hasPort(sc.Destination) {
+		return
+	}
+	alias := sc.Destination
+	host := openSSH.Lookup(alias)
+	if sc.Name == "" {
+		sc.Name = alias
+	}
+	sc.Destination = host.Addr()
+	if sc.User == "" {
+		sc.User = host.User
+	}
+	if len(sc.Auth) == 0 {
+		for _, file := range host.IdentityFiles {
+			sc.Auth = append(sc.Auth, auth{KeyAuth: keyAuth{FileLocation: file}})
+		}
+	}
+	if sc.ProxyJump == "" {
+		sc.ProxyJump = strings.Join(host.ProxyJump, ",")
+	}
+}
+
+func hasPort(destination string) bool {
+	_, _, err := net.SplitHostPort(destination)
+	return err == nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+func TestResolveSSHAliases(t *testing.T) {
+	openSSH, err := tunnel.ParseSSHConfig(strings.NewReader(`
+Host bastion
+    HostName bastion.example.com
+    User ops
+    IdentityFile /keys/ops
+    ProxyJump jump:2200
+
+Host bastion-dr
+    HostName dr.example.com
+    Port 2222
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	confs := []sshConfig{
+		{
+			Destination:          "bastion",
+			FallbackDestinations: []string{"bastion-dr", "other:22"},
+			ThroughSSH:           []sshConfig{{Destination: "localhost:2222", User: "app"}},
+		},
+		{Name: "kept", Destination: "bastion", User: "me", Auth: []auth{{PwdAuth: pwdAuth{PasswordSecret: "pwd"}}}},
+	}
+	resolveSSHAliases(confs, openSSH)
+
+	resolved := confs[0]
+	if resolved.Name != "bastion" || resolved.Destination != "bastion.example.com:22" || resolved.User != "ops" {
+		t.Fatalf("unexpected resolution: %+v", resolved)
+	}
+	if len(resolved.Auth) != 1 || resolved.Auth[0].KeyAuth.FileLocation != "/keys/ops" || resolved.ProxyJump != "jump:2200" {
+		t.Fatalf("expected the identity file and jump host, got %+v", resolved)
+	}
+	if resolved.FallbackDestinations[0] != "dr.example.com:2222" || resolved.FallbackDestinations[1] != "other:22" {
+		t.Fatalf("unexpected fallbacks: %v", resolved.FallbackDestinations)
+	}
+	if resolved.ThroughSSH[0].Destination != "localhost:2222" {
+		t.Fatalf("expected destinations with a port to be left alone, got %s", resolved.ThroughSSH[0].Destination)
+	}
+
+	kept := confs[1]
+	if kept.Name != "kept" || kept.User != "me" || len(kept.Auth) != 1 || kept.Auth[0].KeyAuth.FileLocation != "" {
+		t.Fatalf("expected settings of the entry to be kept, got %+v", kept)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+// statusReport is what the daemon reports of its entries on /status
+type statusReport struct {
+	Tunnels []tunnelStatus `json:"tunnels"`
+}
+
+type tunnelStatus struct {
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	LastError string `json:"lastError,omitempty"`
+	// ConnectedSince is when the connection was last established; UptimeSeconds is how long ago while it's up
+	ConnectedSince    *time.Time      `json:"connectedSince,omitempty"`
+	UptimeSeconds     int64           `json:"uptimeSeconds"`
+	ReconnectAttempts int64           `json:"reconnectAttempts"`
+	ActiveConnections int64           `json:"activeConnections"`
+	BytesIn           int64           `json:"bytesIn"`
+	BytesOut          int64           `json:"bytesOut"`
+	Forwards          []forwardStatus `json:"forwards"`
+}
+
+type forwardStatus struct {
+	Name string `json:"name"`
+	// Direction is local for forwards listening here and reverse for those listening on the remote host
+	Direction         string `json:"direction"`
+	Local             string `json:"local"`
+	Destination       string `json:"destination"`
+	ActiveConnections int64  `json:"activeConnections"`
+	TotalConnections  int64  `json:"totalConnections"`
+	BytesIn           int64  `json:"bytesIn"`
+	BytesOut          int64  `json:"bytesOut"`
+	DialErrors        int64  `json:"dialErrors"`
+	Disabled          bool   `json:"disabled,omitempty"`
+	// BindError is why the forward isn't listening, with failurepolicy best-effort
+	BindError string `json:"bindError,omitempty"`
+}
+
+func newStatusReport(snapshots []tunnelSnapshot, now time.Time) statusReport {
+	report := statusReport{Tunnels: []tunnelStatus{}}
+	for _, s := range snapshots {
+		total := s.stats.Total()
+		status := tunnelStatus{
+			Name:              s.id,
+			State:             s.state,
+			ReconnectAttempts: s.stats.ReconnectAttempts,
+			ActiveConnections: total.ActiveConnections,
+			BytesIn:           total.BytesIn,
+			BytesOut:          total.BytesOut,
+			Forwards:          []forwardStatus{},
+		}
+		if s.lastErr != nil {
+			status.LastError = s.lastErr.Error()
+		}
+		if since := s.stats.ConnectedSince; !since.IsZero() {
+			status.ConnectedSince = &since
+			if s.stats.Connected {
+				status.UptimeSeconds = int64(now.Sub(since) / time.Second)
+			}
+		}
+		for _, f := range s.stats.Forward {
+			status.Forwards = append(status.Forwards, newForwardStatus(f, "local"))
+		}
+		for _, f := range s.stats.Reverse {
+			status.Forwards = append(status.Forwards, newForwardStatus(f, "reverse"))
+		}
+		report.Tunnels = append(report.Tunnels, status)
+	}
+	return report
+}
+
+func newForwardStatus(f tunnel.ForwardStats, direction string) forwardStatus {
+	status := forwardStatus{
+		Name:              f.Name,
+		Direction:         direction,
+		Local:             f.Local,
+		Destination:       f.Destination,
+		ActiveConnections: f.ActiveConnections,
+		TotalConnections:  f.TotalConnections,
+		BytesIn:           f.BytesIn,
+		BytesOut:          f.BytesOut,
+		DialErrors:        f.DialErrors,
+		Disabled:          f.Disabled,
+	}
+	if f.BindError != nil {
+		status.BindError = f.BindError.Error()
+	}
+	return status
+}
+
+func printStatus(w io.Writer, contents []byte) error {
+	report := statusReport{}
+	if err := json.Unmarshal(contents, &report); err != nil {
+		return fmt.Errorf("unexpected status from daemon: %v", err)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tLAST ERROR")
+	for _, t := range report.Tunnels {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Name, t.State, t.LastError)
+	}
+	return tw.Flush()
+}
+
+// printStatusJSON prints the daemon's status indented, for scripts
+func printStatusJSON(w io.Writer, contents []byte) error {
+	var out bytes.Buffer
+	if err := json.Indent(&out, contents, "", "  "); err != nil {
+		return fmt.Errorf("unexpected status from daemon: %v", err)
+	}
+	_, err := out.WriteTo(w)
+	return err
+}
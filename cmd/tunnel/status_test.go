@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+func TestStatusReport(t *testing.T) {
+	now := time.Now()
+	snapshots := []tunnelSnapshot{
+		{id: "bastion", state: stateConnected, stats: tunnel.Stats{
+			Connected:         true,
+			ConnectedSince:    now.Add(-90 * time.Second),
+			ReconnectAttempts: 2,
+			Forward: []tunnel.ForwardStats{
+				{Name: "db", Local: "localhost:5432", ActiveConnections: 1, BytesIn: 100, BytesOut: 10},
+				{Name: "web", Local: "port 8080", BindError: errors.New("address already in use")},
+			},
+			Reverse: []tunnel.ForwardStats{{Name: "hook", ActiveConnections: 2, BytesIn: 5}},
+		}},
+		{id: "other", state: stateConnecting, lastErr: errors.New("connection refused")},
+	}
+	contents, err := json.Marshal(newStatusReport(snapshots, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := statusReport{}
+	if err := json.Unmarshal(contents, &report); err != nil {
+		t.Fatal(err)
+	}
+	up := report.Tunnels[0]
+	if up.UptimeSeconds != 90 || up.ReconnectAttempts != 2 || up.ActiveConnections != 3 || up.BytesIn != 105 || up.BytesOut != 10 {
+		t.Fatalf("unexpected status %+v", up)
+	}
+	if len(up.Forwards) != 3 || up.Forwards[0].Direction != "local" || up.Forwards[2].Direction != "reverse" {
+		t.Fatalf("unexpected forwards %+v", up.Forwards)
+	}
+	if up.Forwards[0].BindError != "" || up.Forwards[1].BindError != "address already in use" {
+		t.Fatalf("expected the bind error of the forward that isn't listening, got %+v", up.Forwards)
+	}
+	down := report.Tunnels[1]
+	if down.ConnectedSince != nil || down.UptimeSeconds != 0 || down.LastError != "connection refused" {
+		t.Fatalf("unexpected status %+v", down)
+	}
+
+	var table bytes.Buffer
+	if err := printStatus(&table, contents); err != nil {
+		t.Fatal(err)
+	}
+	var indented bytes.Buffer
+	if err := printStatusJSON(&indented, contents); err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(indented.Bytes()) || !bytes.Contains(indented.Bytes(), []byte(`"uptimeSeconds": 90`)) {
+		t.Fatalf("unexpected json\n%s", indented.String())
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/urfave/cli/v2"
+)
+
+func stdioCommand(opts *config) *cli.Command {
+	var hop string
+	return &cli.Command{
+		Name:  "stdio",
+		Usage: "connect stdin and stdout to a target through the configured hops, for use as an ssh ProxyCommand",
+		UsageText: "tunnel stdio [--hop <host>] <config file> <tunnel name or host:port>\n" +
+			"   ssh -o ProxyCommand='tunnel stdio config.yml %h:%p' host",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "hop",
+				Usage:       "host to connect to host:port targets from (default: the first in the config)",
+				Destination: &hop,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			if ctx.NArg() != 2 {
+				return errors.New("config file and target are required")
+			}
+			// stdout carries the connection, so logs go to stderr and are kept down to problems unless asked for
+			opts.logger = stderrLogger{}
+			if !ctx.IsSet("log-level") {
+				opts.logLevel = tunnel.LevelWarn
+			}
+			opts.configFile = ctx.Args().First()
+			tunnelConf, err := loadConfig(opts)
+			if err != nil {
+				return err
+			}
+			id, target, err := stdioTarget(tunnelConf.SshConfigs, hop, ctx.Args().Get(1))
+			if err != nil {
+				return err
+			}
+			t, closeHop, err := dialHop(ctx.Context, tunnelConf, id, opts)
+			if err != nil {
+				return err
+			}
+			defer closeHop()
+			conn, err := tunnel.DialWithTimeout(t.Client(), "tcp", target, time.Second*10)
+			if err != nil {
+				return fmt.Errorf("unable to connect to %s: %v", target, err)
+			}
+			defer conn.Close()
+			return pipeStdio(conn, os.Stdin, os.Stdout)
+		},
+	}
+}
+
+// stdioTarget resolves target to the hop to connect from and the address to connect to: a tunnel name connects
+// to the tunnel's target from the host it belongs to, anything else is a host:port connected to from hop or
+// else the first host in the config
+func stdioTarget(confs []sshConfig, hop, target string) (string, string, error) {
+	if id, address, ok := tunnelTarget(confs, target); ok {
+		return id, address, nil
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return "", "", fmt.Errorf("%s is neither a tunnel name nor a host:port", target)
+	}
+	if hop == "" {
+		if len(confs) == 0 {
+			return "", "", errors.New("no hosts in config")
+		}
+		return confs[0].id(), target, nil
+	}
+	if _, ok := hopPath(confs, hop); !ok {
+		return "", "", fmt.Errorf("no config for %s", hop)
+	}
+	return hop, target, nil
+}
+
+func tunnelTarget(confs []sshConfig, name string) (string, string, bool) {
+	for _, c := range confs {
+		for _, f := range c.localForwards() {
+			if f.Name == name {
+				return c.id(), f.Target, true
+			}
+		}
+		if id, address, ok := tunnelTarget(c.ThroughSSH, name); ok {
+			return id, address, true
+		}
+	}
+	return "", "", false
+}
+
+// pipeStdio copies between conn and stdin/stdout until conn is closed, closing conn's write side when stdin ends
+func pipeStdio(conn net.Conn, stdin io.Reader, stdout io.Writer) error {
+	go func() {
+		io.Copy(conn, stdin)
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+	_, err := io.Copy(stdout, conn)
+	return err
+}
+
+// stderrLogger logs through the log package, which writes to stderr
+type stderrLogger struct{}
+
+func (stderrLogger) Log(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/arunsworld/go-tunnel/tunneltest"
+)
+
+func TestStdioTarget(t *testing.T) {
+	confs := []sshConfig{
+		{Name: "bastion", Destination: "bastion:22", Tunnels: []portForward{{Name: "db", Port: 2000, Target: "db:5432"}}},
+		{Destination: "other:22", ThroughSSH: []sshConfig{
+			{Name: "box", Destination: "localhost:2222", Tunnels: []portForward{{Name: "web", Port: 2001, Target: "web:80"}}},
+		}},
+	}
+	tests := []struct {
+		hop, target   string
+		id, address   string
+		expectFailure bool
+	}{
+		{target: "db", id: "bastion", address: "db:5432"},
+		{target: "web", id: "box", address: "web:80"},
+		{target: "git.internal:22", id: "bastion", address: "git.internal:22"},
+		{hop: "box", target: "git.internal:22", id: "box", address: "git.internal:22"},
+		{hop: "unknown", target: "git.internal:22", expectFailure: true},
+		{target: "unknown", expectFailure: true},
+	}
+	for _, test := range tests {
+		id, address, err := stdioTarget(confs, test.hop, test.target)
+		if test.expectFailure {
+			if err == nil {
+				t.Errorf("%s from %s: expected failure", test.target, test.hop)
+			}
+			continue
+		}
+		if err != nil || id != test.id || address != test.address {
+			t.Errorf("%s from %s: expected %s %s, got %s %s %v", test.target, test.hop, test.id, test.address, id, address, err)
+		}
+	}
+}
+
+func TestStdio(t *testing.T) {
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	echo, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	tunnelConf := tunnelConfig{SshConfigs: []sshConfig{{
+		Destination: server.Addr,
+		User:        server.User,
+		Auth:        []auth{{PwdAuth: pwdAuth{PasswordSecret: "pwd", password: staticSecret(server.Password)}}},
+	}}}
+	opts := &config{knownHostsFile: filepath.Join(t.TempDir(), "known_hosts"), logger: stderrLogger{}}
+	tun, closeHop, err := dialHop(context.Background(), tunnelConf, server.Addr, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeHop()
+	conn, err := tun.Client().Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// stdin stays open until the echo is back as the test server closes on half close
+	stdin, writeStdin := io.Pipe()
+	defer writeStdin.Close()
+	readStdout, stdout := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- pipeStdio(conn, stdin, stdout)
+		stdout.Close()
+	}()
+	message := "hello through stdio"
+	go io.WriteString(writeStdin, message)
+	echoed := make([]byte, len(message))
+	if _, err := io.ReadFull(readStdout, echoed); err != nil {
+		t.Fatal(err)
+	}
+	if string(echoed) != message {
+		t.Fatalf("expected the input echoed back, got %q", echoed)
+	}
+	conn.Close()
+	go io.Copy(ioutil.Discard, readStdout)
+	<-done
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/arunsworld/go-tunnel/tunneltest"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh"
+)
+
+func testServerCommand() *cli.Command {
+	port := 0
+	return &cli.Command{
+		Name:      "test-server",
+		Usage:     "run a throwaway ssh server with generated credentials to try out configs against",
+		UsageText: "tunnel test-server [options]",
+		Flags: []cli.Flag{
+			&cli.IntFlag{Name: "port", Usage: "port to listen on", Value: 2229, Destination: &port},
+		},
+		Action: func(ctx *cli.Context) error {
+			server, err := tunneltest.Listen(net.JoinHostPort("localhost", strconv.Itoa(port)))
+			if err != nil {
+				return fmt.Errorf("unable to start test server: %v", err)
+			}
+			defer server.Close()
+			dir, err := os.MkdirTemp("", "tunnel-test-server")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+			keyFile := filepath.Join(dir, "id_ecdsa")
+			if err := server.WriteClientKey(keyFile, ""); err != nil {
+				return fmt.Errorf("unable to write client key: %v", err)
+			}
+
+			fmt.Printf("test server listening on %s\n", server.Addr)
+			fmt.Printf("\tuser:        %s\n", server.User)
+			fmt.Printf("\tpassword:    %s\n", server.Password)
+			fmt.Printf("\tprivate key: %s\n", keyFile)
+			fmt.Printf("\thost key:    %s\n", ssh.FingerprintSHA256(server.HostKey))
+			fmt.Println("local and remote forwarding are allowed; press Ctrl-C to stop")
+			<-ctx.Context.Done()
+			return nil
+		},
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+// listenerTLSConfig serves a tunnel over tls with the certificate of CertFile and KeyFile or, without them, one
+// signed by itself for localhost and the tunnel's bind address
+type listenerTLSConfig struct {
+	CertFile string
+	KeyFile  string
+}
+
+func (c *listenerTLSConfig) toTLS(bindAddress string) (*tls.Config, error) {
+	if c.CertFile == "" && c.KeyFile == "" {
+		hosts := []string{"localhost", "127.0.0.1", "::1"}
+		if bindAddress != "" {
+			hosts = append(hosts, bindAddress)
+		}
+		return tunnel.SelfSignedTLSConfig(hosts...)
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("tls needs both certfile and keyfile, or neither for a self-signed certificate")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load tls certificate: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// targetTLSConfig speaks tls to a tunnel's target, verifying its certificate against the certificates of CAFile,
+// or the system's without it, for ServerName, or the target's host without it. InsecureSkipVerify accepts any.
+type targetTLSConfig struct {
+	ServerName         string
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+func (c *targetTLSConfig) toTLS() (*tls.Config, error) {
+	config := &tls.Config{ServerName: c.ServerName, InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read ca file: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca file %s", c.CAFile)
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	totpStep   = 30 * time.Second
+	totpDigits = 6
+)
+
+type totpConfig struct {
+	// SecretEnv is the environment variable holding the base32 seed, as shown by authenticator apps on setup
+	SecretEnv string
+}
+
+// totpSecret generates RFC 6238 codes: HMAC-SHA1 over 30 second steps, 6 digits, as authenticator apps do by
+// default
+type totpSecret struct {
+	key []byte
+	now func() time.Time
+}
+
+func newTOTPSecret(name string, c totpConfig) (*totpSecret, error) {
+	if c.SecretEnv == "" {
+		return nil, fmt.Errorf("totp secret %s has no secretenv", name)
+	}
+	seed := os.Getenv(c.SecretEnv)
+	if seed == "" {
+		return nil, fmt.Errorf("totp secret %s: %s is not set", name, c.SecretEnv)
+	}
+	key, err := decodeTOTPSeed(seed)
+	if err != nil {
+		return nil, fmt.Errorf("totp secret %s: invalid base32 seed: %v", name, err)
+	}
+	return &totpSecret{key: key, now: time.Now}, nil
+}
+
+// decodeTOTPSeed decodes base32 the way it's usually written out: in any case, with or without padding and
+// grouped by spaces
+func decodeTOTPSeed(seed string) ([]byte, error) {
+	seed = strings.ToUpper(strings.Join(strings.Fields(seed), ""))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(seed, "="))
+}
+
+func (s *totpSecret) value() (string, error) {
+	return totpCode(s.key, s.now()), nil
+}
+
+func totpCode(key []byte, t time.Time) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/int64(totpStep/time.Second)))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, code%mod)
+}
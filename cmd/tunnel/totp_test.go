@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/arunsworld/go-tunnel/tunneltest"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B's SHA1 vectors, cut to 6 digits
+	key := []byte("12345678901234567890")
+	for unix, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		if code := totpCode(key, time.Unix(unix, 0)); code != expected {
+			t.Errorf("at %d expected %s, got %s", unix, expected, code)
+		}
+	}
+
+	seed, err := decodeTOTPSeed("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(seed) != string(key) {
+		t.Fatalf("unexpected seed %q", seed)
+	}
+}
+
+func TestTOTPSecret(t *testing.T) {
+	t.Setenv("TOTP_SEED", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	vault, err := newSecretsVault([]secret{{Name: "otp", TOTP: &totpConfig{SecretEnv: "TOTP_SEED"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := vault["otp"].(*totpSecret)
+	otp.now = func() time.Time { return time.Unix(59, 0) }
+
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.OTP = "287082"
+
+	a := auth{KeyboardInteractive: true, OTPSecret: "otp"}
+	if err := a.validateAndUpdate(vault); err != nil {
+		t.Fatal(err)
+	}
+	method, err := sshAuthFromAuth(a, server.Addr, &config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun, err := tunnel.Dial(&tunnel.Spec{Host: server.Addr, User: server.User, Auth: []ssh.AuthMethod{method}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun.Close()
+
+	if _, err := newSecretsVault([]secret{{Name: "otp", TOTP: &totpConfig{SecretEnv: "UNSET_TOTP_SEED"}}}, nil); err == nil {
+		t.Fatal("expected an error for an unset seed")
+	}
+}
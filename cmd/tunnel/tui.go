@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"golang.org/x/term"
+)
+
+// eventLog keeps the latest log lines for the dashboard to show in place of the log. Messages from the library
+// below warn are dropped as they'd drown everything else out.
+type eventLog struct {
+	mu    sync.Mutex
+	lines []string
+	max   int
+}
+
+func newEventLog(max int) *eventLog {
+	return &eventLog{max: max}
+}
+
+// Write takes the output of the log package
+func (l *eventLog) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		l.add(line)
+	}
+	return len(b), nil
+}
+
+func (l *eventLog) Log(format string, v ...interface{}) {
+	l.add(time.Now().Format("2006/01/02 15:04:05 ") + strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (l *eventLog) LogLevel(level tunnel.Level, format string, v ...interface{}) {
+	if level >= tunnel.LevelWarn {
+		l.Log(format, v...)
+	}
+}
+
+func (l *eventLog) add(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, line)
+	if len(l.lines) > l.max {
+		l.lines = l.lines[len(l.lines)-l.max:]
+	}
+}
+
+// latest returns up to n of the most recent lines, oldest first
+func (l *eventLog) latest(n int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n > len(l.lines) {
+		n = len(l.lines)
+	}
+	return append([]string(nil), l.lines[len(l.lines)-n:]...)
+}
+
+type key int
+
+const (
+	keyUp key = iota
+	keyDown
+	keyToggle
+	keyRestart
+	keyQuit
+)
+
+// parseKeys turns terminal input into the keys the dashboard responds to, ignoring the rest
+func parseKeys(input []byte) []key {
+	keys := []key{}
+	for i := 0; i < len(input); i++ {
+		switch input[i] {
+		case 0x1b:
+			if i+2 < len(input) && input[i+1] == '[' {
+				switch input[i+2] {
+				case 'A':
+					keys = append(keys, keyUp)
+				case 'B':
+					keys = append(keys, keyDown)
+				}
+				i += 2
+			}
+		case 'k':
+			keys = append(keys, keyUp)
+		case 'j':
+			keys = append(keys, keyDown)
+		case ' ', 'e':
+			keys = append(keys, keyToggle)
+		case 'r':
+			keys = append(keys, keyRestart)
+		case 'q', 3:
+			keys = append(keys, keyQuit)
+		}
+	}
+	return keys
+}
+
+// dashboardRow is a host, or one of its forwards when forward is set
+type dashboardRow struct {
+	id       string
+	unit     string
+	forward  string
+	disabled bool
+	columns  [7]string
+}
+
+// dashboard is the --tui view: a table of hosts and their forwards with a selection to act on, and the latest
+// events below
+type dashboard struct {
+	registry *tunnelRegistry
+	events   *eventLog
+	// restart receives the key of the top level entry to restart
+	restart chan<- string
+
+	rows     []dashboardRow
+	selected int
+	// previous byte counts by forward and when they were taken, to work throughput out from
+	previous   map[string][2]int64
+	previousAt time.Time
+}
+
+func newDashboard(registry *tunnelRegistry, events *eventLog, restart chan<- string) *dashboard {
+	return &dashboard{registry: registry, events: events, restart: restart, previous: map[string][2]int64{}}
+}
+
+// refresh rebuilds the rows from the registry
+func (d *dashboard) refresh(now time.Time) {
+	elapsed := now.Sub(d.previousAt).Seconds()
+	counts := map[string][2]int64{}
+	rows := []dashboardRow{}
+	for _, s := range d.registry.snapshot() {
+		state := s.state
+		if s.stats.ReconnectAttempts > 0 {
+			state = fmt.Sprintf("%s (%d reconnects)", state, s.stats.ReconnectAttempts)
+		}
+		lastErr := ""
+		if s.lastErr != nil {
+			lastErr = s.lastErr.Error()
+		}
+		rows = append(rows, dashboardRow{id: s.id, unit: s.unit, columns: [7]string{s.id, state, lastErr}})
+		add := func(direction string, forwards []tunnel.ForwardStats) {
+			for _, f := range forwards {
+				countKey := s.id + "\x00" + direction + "\x00" + f.Name
+				counts[countKey] = [2]int64{f.BytesIn, f.BytesOut}
+				in, out := "-", "-"
+				if previous, ok := d.previous[countKey]; ok && elapsed > 0 {
+					in = formatBytes(float64(f.BytesIn-previous[0])/elapsed) + "/s"
+					out = formatBytes(float64(f.BytesOut-previous[1])/elapsed) + "/s"
+				}
+				name := "  " + f.Name
+				if direction == "reverse" {
+					name += " (reverse)"
+				}
+				if f.Disabled {
+					name += " [disabled]"
+				}
+				rows = append(rows, dashboardRow{
+					id:       s.id,
+					unit:     s.unit,
+					forward:  f.Name,
+					disabled: f.Disabled,
+					columns: [7]string{name, f.Local, f.Destination, fmt.Sprint(f.ActiveConnections),
+						fmt.Sprint(f.TotalConnections), in, out},
+				})
+			}
+		}
+		add("local", s.stats.Forward)
+		add("reverse", s.stats.Reverse)
+	}
+	d.rows, d.previous, d.previousAt = rows, counts, now
+	if d.selected >= len(d.rows) {
+		d.selected = len(d.rows) - 1
+	}
+	if d.selected < 0 {
+		d.selected = 0
+	}
+}
+
+// handle acts on k, reporting false when it's time to quit
+func (d *dashboard) handle(k key) bool {
+	switch k {
+	case keyUp:
+		if d.selected > 0 {
+			d.selected--
+		}
+	case keyDown:
+		if d.selected < len(d.rows)-1 {
+			d.selected++
+		}
+	case keyToggle:
+		if d.selected < len(d.rows) && d.rows[d.selected].forward != "" {
+			row := d.rows[d.selected]
+			d.registry.setForwardEnabled(row.id, row.forward, row.disabled)
+			d.refresh(time.Now())
+		}
+	case keyRestart:
+		if d.selected < len(d.rows) {
+			// a restart already pending will do
+			select {
+			case d.restart <- d.rows[d.selected].unit:
+			default:
+			}
+		}
+	case keyQuit:
+		return false
+	}
+	return true
+}
+
+var dashboardHeader = [7]string{"HOST / FORWARD", "STATE / LOCAL", "DESTINATION", "ACTIVE", "TOTAL", "IN", "OUT"}
+
+// draw writes the whole screen, fitting it to width and height
+func (d *dashboard) draw(w io.Writer, width, height int) {
+	widths := [7]int{len(dashboardHeader[0]), len(dashboardHeader[1]), len(dashboardHeader[2]), 6, 6, 10, 10}
+	for _, row := range d.rows {
+		for i := 0; i < 2; i++ {
+			if len(row.columns[i]) > widths[i] {
+				widths[i] = len(row.columns[i])
+			}
+		}
+		if row.forward != "" && len(row.columns[2]) > widths[2] {
+			widths[2] = len(row.columns[2])
+		}
+	}
+	format := func(columns [7]string, host bool) string {
+		if host {
+			// a host's last error takes the rest of the line
+			return fmt.Sprintf("%-*s  %-*s  %s", widths[0], columns[0], widths[1], columns[1], columns[2])
+		}
+		return fmt.Sprintf("%-*s  %-*s  %-*s  %*s  %*s  %*s  %*s", widths[0], columns[0], widths[1], columns[1],
+			widths[2], columns[2], widths[3], columns[3], widths[4], columns[4], widths[5], columns[5], widths[6], columns[6])
+	}
+	fit := func(line string) string {
+		if width > 0 && len(line) > width {
+			return line[:width]
+		}
+		return line
+	}
+
+	var b strings.Builder
+	// redrawn in place, clearing each line and whatever's below, to not flicker
+	b.WriteString("\x1b[H")
+	line := func(s string) {
+		b.WriteString(fit(s) + "\x1b[K\r\n")
+	}
+	line("go-tunnel   up/down select   space enable/disable forward   r reconnect   q quit")
+	line("")
+	line(format(dashboardHeader, false))
+	for i, row := range d.rows {
+		text := fit(format(row.columns, row.forward == ""))
+		if i == d.selected {
+			text = "\x1b[7m" + text + "\x1b[0m"
+		}
+		b.WriteString(text + "\x1b[K\r\n")
+	}
+	line("")
+	line("Recent events")
+	// what's left of the screen below the table goes to events
+	room := height - len(d.rows) - 6
+	if height <= 0 || room < 3 {
+		room = 3
+	}
+	for _, event := range d.events.latest(room) {
+		line(event)
+	}
+	b.WriteString("\x1b[J")
+	io.WriteString(w, b.String())
+}
+
+func formatBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f%s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", n, units[i])
+}
+
+// startDashboard takes over the terminal with the dashboard until the returned func is called; quitting it calls
+// quit. Logs go to the dashboard meanwhile.
+func startDashboard(quit context.CancelFunc, registry *tunnelRegistry, opts *config, restart chan<- string) (func(), error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil, errors.New("--tui needs a terminal")
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to put terminal into raw mode: %v", err)
+	}
+	events := newEventLog(200)
+	log.SetOutput(events)
+	opts.logger = events
+	// alternate screen without a cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+
+	keys := make(chan []byte)
+	go func() {
+		buffer := make([]byte, 64)
+		for {
+			n, err := os.Stdin.Read(buffer)
+			if err != nil {
+				return
+			}
+			keys <- append([]byte(nil), buffer[:n]...)
+		}
+	}()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	d := newDashboard(registry, events, restart)
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			d.refresh(time.Now())
+			width, height, err := term.GetSize(int(os.Stdout.Fd()))
+			if err != nil {
+				width, height = 0, 0
+			}
+			d.draw(os.Stdout, width, height)
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			case input := <-keys:
+				for _, k := range parseKeys(input) {
+					if !d.handle(k) {
+						quit()
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		term.Restore(fd, state)
+		log.SetOutput(os.Stderr)
+	}, nil
+}
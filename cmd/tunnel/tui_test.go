@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/arunsworld/go-tunnel/tunneltest"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys := parseKeys([]byte("\x1b[A\x1b[Bjk e\x1b[Cxrq\x03"))
+	expected := []key{keyUp, keyDown, keyDown, keyUp, keyToggle, keyToggle, keyRestart, keyQuit, keyQuit}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected %v, got %v", expected, keys)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	registry := newTunnelRegistry([]sshConfig{
+		{Name: "bastion", Destination: server.Addr, ThroughSSH: []sshConfig{{Destination: "inner:22"}}},
+	})
+	tun, err := tunnel.Execute(&tunnel.Spec{
+		Host:    server.Addr,
+		User:    server.User,
+		Auth:    server.Auth(),
+		Forward: []tunnel.Forwarder{tunnel.Forward(0, "db:5432").WithName("db")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	registry.track("bastion", tun)
+
+	events := newEventLog(2)
+	logger := log.New(events, "", 0)
+	logger.Print("first")
+	events.LogLevel(tunnel.LevelDebug, "dropped")
+	logger.Print("second")
+	logger.Print("third")
+
+	restarts := make(chan string, 1)
+	d := newDashboard(registry, events, restarts)
+	d.refresh(time.Now())
+	out := &bytes.Buffer{}
+	d.draw(out, 0, 0)
+	for _, expected := range []string{"bastion", "connected", "  db", "db:5432", "inner:22", "connecting", "second", "third"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected %q in:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out.String(), "first") || strings.Contains(out.String(), "dropped") {
+		t.Fatalf("expected only the latest events in:\n%s", out)
+	}
+
+	// rows are bastion, its db forward, then inner:22
+	d.handle(keyDown)
+	d.handle(keyToggle)
+	if !tun.Stats().Forward[0].Disabled || !strings.Contains(d.rows[1].columns[0], "[disabled]") {
+		t.Fatal("expected the selected forward to be disabled")
+	}
+	d.handle(keyToggle)
+	if tun.Stats().Forward[0].Disabled {
+		t.Fatal("expected the selected forward to be enabled again")
+	}
+
+	d.handle(keyDown)
+	d.handle(keyDown)
+	d.handle(keyRestart)
+	if unit := <-restarts; unit != "bastion" {
+		t.Fatalf("expected the unit inner:22 runs in to be restarted, got %s", unit)
+	}
+	if d.handle(keyQuit) {
+		t.Fatal("expected quit to end the dashboard")
+	}
+}
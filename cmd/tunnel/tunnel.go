@@ -2,26 +2,48 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	tunnel "github.com/arunsworld/go-tunnel"
 	"github.com/arunsworld/nursery"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/term"
 	"gopkg.in/yaml.v2"
 )
 
+// configVersion is the version of the config schema understood; configs without a version are taken to be it
+const configVersion = 1
+
 type tunnelConfig struct {
-	Secrets    []secret
-	SshConfigs []sshConfig `json:"sshconfigs"`
+	Version int
+	// Include are config files, relative to this one, that it's layered over: entries with the same name are
+	// merged, their tunnels added to
+	Include      []string
+	Secrets      []secret
+	SshConfigs   []sshConfig `json:"sshconfigs"`
+	Environments map[string]environment
+	// Names, when set, has the hostnames of tunnels resolve to where they listen
+	Names *namesConfig
 }
 
 type secret struct {
 	Name string
 	Env  string
+	// TOTP, when set, makes the secret a time based one time code generated from a base32 seed
+	TOTP *totpConfig
+	// Vault, when set, fetches the secret from HashiCorp Vault
+	Vault *vaultConfig
+	// File, when set, is a sealed secrets file, made with tunnel seal or age -p, to take the secret from. Its
+	// entry is Key, defaulting to Name. All sealed files share the one passphrase, asked for once.
+	File string
+	Key  string
 }
 
 type secretsVault map[string]vaultSecret
@@ -29,17 +51,33 @@ type secretsVault map[string]vaultSecret
 func (s secretsVault) secretFor(k string) (vaultSecret, error) {
 	v, ok := s[k]
 	if !ok {
-		return "", fmt.Errorf("secret %s not setup", k)
+		return nil, fmt.Errorf("secret %s not setup", k)
 	}
 	return v, nil
 }
 
-type vaultSecret string
+// vaultSecret gives a secret's value each time it's used
+type vaultSecret interface {
+	value() (string, error)
+}
+
+// staticSecret is a secret whose value doesn't change
+type staticSecret string
+
+func (s staticSecret) value() (string, error) {
+	return string(s), nil
+}
 
-func newSecretsVault(rawSecrets []secret) (secretsVault, error) {
+// newSecretsVault resolves rawSecrets, reusing the values of those already in known rather than prompting again
+func newSecretsVault(rawSecrets []secret, known secretsVault) (secretsVault, error) {
 	result := make(secretsVault)
+	sealed := &sealedFiles{}
 	for _, s := range rawSecrets {
-		sv, err := newSecretVault(s)
+		if v, ok := known[s.Name]; ok && s.Env == "" && s.TOTP == nil && s.Vault == nil {
+			result[s.Name] = v
+			continue
+		}
+		sv, err := newSecretVault(s, sealed)
 		if err != nil {
 			return nil, err
 		}
@@ -48,35 +86,87 @@ func newSecretsVault(rawSecrets []secret) (secretsVault, error) {
 	return result, nil
 }
 
-func newSecretVault(s secret) (vaultSecret, error) {
+func newSecretVault(s secret, sealed *sealedFiles) (vaultSecret, error) {
 	if s.Name == "" {
-		return "", fmt.Errorf("secret specified without a name")
+		return nil, fmt.Errorf("secret specified without a name")
+	}
+	if s.TOTP != nil {
+		return newTOTPSecret(s.Name, *s.TOTP)
+	}
+	if s.Vault != nil {
+		return newVaultSecret(s.Name, *s.Vault)
+	}
+	if s.File != "" {
+		key := s.Key
+		if key == "" {
+			key = s.Name
+		}
+		v, err := sealed.secret(s.File, key)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %v", s.Name, err)
+		}
+		return staticSecret(v), nil
 	}
 	if s.Env != "" {
 		v := os.Getenv(s.Env)
 		if v != "" {
-			return vaultSecret(v), nil
+			return staticSecret(v), nil
 		}
 	}
-	fmt.Printf("Enter value for secret %s: ", s.Name)
-	bytePassword, err := term.ReadPassword(int(syscall.Stdin))
-	if err != nil {
-		return "", fmt.Errorf("error reading secret from prompt for %s: %v", s.Name, err)
+	prompted := &promptedSecret{name: s.Name}
+	if err := prompted.prompt(os.Stdin, os.Stdout, fmt.Sprintf("Enter value for secret %s: ", s.Name)); err != nil {
+		return nil, err
 	}
-	if bytePassword == nil || string(bytePassword) == "" {
-		return "", fmt.Errorf("error - no value provided for secret %s", s.Name)
-	}
-	fmt.Println("")
-	return vaultSecret(string(bytePassword)), nil
+	return prompted, nil
 }
 
 type sshConfig struct {
-	Destination    string
-	User           string
-	Auth           []auth
-	Tunnels        []portForward
-	ReverseTunnels []portForward
-	ThroughSSH     []sshConfig
+	Name                 string
+	Destination          string
+	FallbackDestinations []string
+	User                 string
+	Auth                 []auth
+	Tunnels              []portForward
+	ReverseTunnels       []portForward
+	ThroughSSH           []sshConfig
+	VPN                  *vpnConfig
+	DNS                  *dnsConfig
+	SuspendAfter         time.Duration
+	AuthTimeout          time.Duration
+	ConnectTimeout       time.Duration
+	// Reconnect, when set, retries connecting with its backoff, both at first and once the connection is lost
+	Reconnect *reconnectConfig
+	// IdleTimeout, when set, closes connections through tunnels without an idletimeout of their own once they've
+	// had no traffic for this long
+	IdleTimeout time.Duration
+	// MonitorInterval, when set, is how often the targets of tunnels are checked; unreachable ones are reported
+	// to the webhook
+	MonitorInterval time.Duration
+	// BindAddress is where tunnels listen unless they set their own bindaddress; defaults to localhost
+	BindAddress string
+	// ProxyURL is an http, https or socks5 proxy to reach Destination through, such as socks5://proxy:1080
+	ProxyURL string
+	// ProxyCommand is run to reach Destination instead, as with ssh's ProxyCommand
+	ProxyCommand string
+	// ProxyJump are comma separated [user@]host[:port] jump hosts to reach Destination through, as with ssh's
+	// ProxyJump; hosts are looked up in the OpenSSH config
+	ProxyJump string
+	// PreCommands are run on Destination in turn once connected, before tunnels are brought up, such as to start
+	// a service; the connection is given up on if one fails. PostCommands are run once tunnels are down, before
+	// disconnecting.
+	PreCommands  []string
+	PostCommands []string
+	// FailurePolicy is what happens when some tunnels can't listen: all-or-nothing, the default, gives up on the
+	// connection, and best-effort carries on with those that can, logging the others and reporting them in status
+	FailurePolicy string
+	// DrainTimeout, when set, lets connections through tunnels finish for up to this long on shutdown, or when the
+	// entry is stopped or restarted, with no new ones accepted meanwhile
+	DrainTimeout time.Duration
+	// Ciphers, MACs and KeyExchanges, when set, are the algorithms offered to Destination in order of preference
+	// in place of the defaults, such as aes128-cbc for an old appliance or only chacha20-poly1305@openssh.com
+	Ciphers      []string
+	MACs         []string
+	KeyExchanges []string
 }
 
 func (sc *sshConfig) validateAndUpdateAuth(vault secretsVault) error {
@@ -86,19 +176,249 @@ func (sc *sshConfig) validateAndUpdateAuth(vault secretsVault) error {
 		}
 		sc.Auth[i] = a
 	}
+	for i, f := range sc.Tunnels {
+		if f.SocksPasswordSecret == "" {
+			continue
+		}
+		v, err := vault.secretFor(f.SocksPasswordSecret)
+		if err != nil {
+			return err
+		}
+		if sc.Tunnels[i].socksPassword, err = v.value(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 type portForward struct {
-	Name   string
-	Port   int
-	Target string
-	Ignore bool
+	Name               string
+	Port               int
+	Target             string
+	BindAddress        string
+	Ignore             bool
+	MaxConnectionBytes int64
+	MaxBytes           int64
+	DisableOnQuota     bool
+	Prewarm            int
+	DualStack          bool
+	// Ports, when set, listens on every port of a list such as 9000-9010,9020 instead of Port
+	Ports string
+	// Socket, when set, listens on a unix socket instead of Port
+	Socket      string
+	AllowedUIDs []int
+	AllowedGIDs []int
+	// Reverse, when set, makes a tunnel listed under tunnels a reverse one, as if listed under reversetunnels:
+	// it listens on the remote host, bindaddress included, and forwards to Target from here. Remote bind
+	// addresses other than localhost need GatewayPorts enabled on the server. On port 0 the server picks the
+	// port, which is logged once the tunnel is established.
+	Reverse bool
+	// IdleTimeout, when set, closes connections with no traffic either way for this long
+	IdleTimeout time.Duration
+	// RateLimit, when set, limits the tunnel's connections to this many bytes a second between them, allowing
+	// bursts of RateBurst bytes
+	RateLimit int64
+	RateBurst int64
+	// MaxConnections, when set, limits the tunnel to this many connections at a time; above it new connections
+	// wait to be accepted, or are closed straight away with RejectOverLimit
+	MaxConnections  int
+	RejectOverLimit bool
+	// Replicas, when set, are more destinations like Target to spread connections across by Strategy: roundrobin,
+	// the default, or failover. HealthCheck, when set, is how often they're all checked through the connection.
+	Replicas    []string
+	Strategy    string
+	HealthCheck time.Duration
+	// OnUp and OnDown, when set, are shell commands run here each time the tunnel starts listening and stops,
+	// told about it by TUNNEL_EVENT (up or down), TUNNEL_NAME, TUNNEL_LOCAL, TUNNEL_PORT, TUNNEL_TARGET and TUNNEL_ENTRY
+	OnUp   string
+	OnDown string
+	// DialTimeout, when set, is how long reaching Target through the connection may take, and DialRetries how many
+	// times it's retried when it can't be reached
+	DialTimeout time.Duration
+	DialRetries int
+	// PortConflict is what to do when Port is in use: fail, the default, next-free to listen on the next free port
+	// instead, or kill-stale to stop a previous instance of tunnel still holding it (linux only)
+	PortConflict string
+	// Capture, when set, writes the tunnel's traffic to a file to debug protocols through it
+	Capture *captureConfig
+	// TLS, when set, serves the tunnel over tls so that tools speaking it reach a plain Target, and TargetTLS
+	// speaks tls to Target so that plain tools reach one only accepting it
+	TLS       *listenerTLSConfig
+	TargetTLS *targetTLSConfig
+	// Hostname, such as grafana.tunnel.local, resolves to where the tunnel listens when names are configured. For
+	// it to be reached by name alone give the tunnel a loopback bindaddress of its own, such as 127.0.0.2, and the
+	// port of its target.
+	Hostname string
+	// Dynamic, when set, serves SOCKS5 on Port instead of forwarding to Target, as with ssh -D. Clients must log in
+	// as SocksUser with the password of SocksPasswordSecret when set, and may only connect to destinations matching
+	// AllowDestinations, when set, and not DenyDestinations: addresses, CIDRs, host names or *.domain patterns.
+	// Host names are resolved locally to check them against denied addresses and CIDRs, and refused if they don't
+	// resolve.
+	Dynamic             bool
+	SocksUser           string
+	SocksPasswordSecret string
+	AllowDestinations   []string
+	DenyDestinations    []string
+	// AllowedSources, when set, are the CIDRs such as 192.168.1.0/24 connections to the tunnel may come from,
+	// others being refused, as when it listens on a bindaddress other hosts can reach
+	AllowedSources []string
+	// internal
+	socksPassword string
+}
+
+func (pf portForward) forwarder() tunnel.Forwarder {
+	f := tunnel.Forward(pf.Port, pf.Target, pf.Replicas...)
+	if pf.Ports != "" {
+		f = pf.multiPortForwarder()
+	}
+	if pf.Dynamic {
+		f = tunnel.ForwardDynamic(pf.Port).
+			WithAllowedDestinations(pf.AllowDestinations...).
+			WithDeniedDestinations(pf.DenyDestinations...)
+		if pf.SocksUser != "" {
+			f = f.WithSOCKSAuth(pf.SocksUser, pf.socksPassword)
+		}
+	}
+	if pf.Socket != "" {
+		f = tunnel.ForwardUnix(pf.Socket, pf.Target, pf.Replicas...)
+		if len(pf.AllowedUIDs) > 0 || len(pf.AllowedGIDs) > 0 {
+			f = f.WithAllowedPeers(pf.AllowedUIDs, pf.AllowedGIDs)
+		}
+	}
+	if pf.MaxConnectionBytes > 0 {
+		f = f.WithConnectionQuota(pf.MaxConnectionBytes)
+	}
+	if pf.MaxBytes > 0 {
+		f = f.WithForwardQuota(pf.MaxBytes, pf.DisableOnQuota)
+	}
+	if pf.Prewarm > 0 {
+		f = f.WithPrewarm(pf.Prewarm)
+	}
+	if pf.DualStack {
+		f = f.WithDualStack()
+	}
+	if pf.IdleTimeout > 0 {
+		f = f.WithIdleTimeout(pf.IdleTimeout)
+	}
+	if pf.RateLimit > 0 {
+		f = f.WithRateLimit(pf.RateLimit, pf.RateBurst)
+	}
+	if pf.MaxConnections > 0 {
+		f = f.WithMaxConnections(pf.MaxConnections, pf.RejectOverLimit)
+	}
+	if strategy, _ := pf.strategy(); strategy != tunnel.RoundRobin {
+		f = f.WithStrategy(strategy)
+	}
+	if pf.HealthCheck > 0 {
+		f = f.WithHealthCheck(pf.HealthCheck)
+	}
+	if pf.DialTimeout > 0 {
+		f = f.WithDialTimeout(pf.DialTimeout)
+	}
+	if pf.DialRetries > 0 {
+		f = f.WithDialRetries(pf.DialRetries)
+	}
+	if pf.Capture != nil {
+		capture, _ := pf.Capture.toCapture()
+		f = f.WithCapture(capture)
+	}
+	if pf.TLS != nil {
+		config, _ := pf.TLS.toTLS(pf.BindAddress)
+		f = f.WithListenerTLS(config)
+	}
+	if pf.TargetTLS != nil {
+		config, _ := pf.TargetTLS.toTLS()
+		f = f.WithDestinationTLS(config)
+	}
+	if conflict, _ := pf.portConflict(); conflict != tunnel.FailOnConflict {
+		f = f.WithPortConflict(conflict)
+	}
+	if pf.BindAddress != "" {
+		f = f.WithBindAddress(pf.BindAddress)
+	}
+	if len(pf.AllowedSources) > 0 {
+		networks, _ := parseCIDRs(pf.AllowedSources)
+		f = f.WithAllowedSources(networks...)
+	}
+	if pf.Name != "" {
+		f = f.WithName(pf.Name)
+	}
+	return f
+}
+
+// local describes where the forward listens
+func (pf portForward) local() string {
+	if pf.Socket != "" {
+		return "socket " + pf.Socket
+	}
+	if pf.Ports != "" {
+		return "ports " + pf.Ports
+	}
+	return "port " + strconv.Itoa(pf.Port)
+}
+
+// targets describes where the forward goes
+func (pf portForward) targets() string {
+	if pf.Dynamic {
+		return "the destinations asked for over SOCKS"
+	}
+	return strings.Join(append([]string{pf.Target}, pf.Replicas...), ", ")
+}
+
+func (pf portForward) strategy() (tunnel.BalanceStrategy, error) {
+	switch strings.ToLower(pf.Strategy) {
+	case "", "roundrobin":
+		return tunnel.RoundRobin, nil
+	case "failover":
+		return tunnel.Failover, nil
+	}
+	return tunnel.RoundRobin, fmt.Errorf("unknown strategy %s, expected roundrobin or failover", pf.Strategy)
+}
+
+func (pf portForward) portConflict() (tunnel.PortConflict, error) {
+	switch strings.ToLower(pf.PortConflict) {
+	case "", "fail":
+		return tunnel.FailOnConflict, nil
+	case "next-free":
+		return tunnel.NextFreePort, nil
+	case "kill-stale":
+		return tunnel.KillStale, nil
+	}
+	return tunnel.FailOnConflict, fmt.Errorf("unknown portconflict %s, expected fail, next-free or kill-stale", pf.PortConflict)
+}
+
+type reconnectConfig struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+type dnsConfig struct {
+	Listen   string
+	Domains  []string
+	Resolver string
+	Fallback string
 }
 
 type auth struct {
 	KeyAuth keyAuth
 	PwdAuth pwdAuth
+	// GSSAPI logs in with Kerberos
+	GSSAPI bool
+	// Agent offers the keys of the running ssh agent, Pageant or the OpenSSH agent service on windows
+	Agent bool
+	// KeyFiles are private keys, and KeyDir a directory of them such as ~/.ssh, offered in turn as ssh does with
+	// several IdentityFile. Keys that can't be read, or decrypted with KeyPasswordSecret when set, are skipped.
+	KeyFiles          []string
+	KeyDir            string
+	KeyPasswordSecret string
+	// KeyboardInteractive answers the server's challenges, such as for a one time code, on the terminal or with
+	// the secret named by OTPSecret when set
+	KeyboardInteractive bool
+	OTPSecret           string
+	// internal
+	otp         vaultSecret
+	keyPassword vaultSecret
 }
 
 func (a *auth) validateAndUpdate(vault secretsVault) error {
@@ -108,14 +428,32 @@ func (a *auth) validateAndUpdate(vault secretsVault) error {
 	if err := a.PwdAuth.validateAndUpdate(vault); err != nil {
 		return err
 	}
+	if a.OTPSecret != "" {
+		v, err := vault.secretFor(a.OTPSecret)
+		if err != nil {
+			return err
+		}
+		a.otp = v
+	}
+	if a.KeyPasswordSecret != "" {
+		v, err := vault.secretFor(a.KeyPasswordSecret)
+		if err != nil {
+			return err
+		}
+		a.keyPassword = v
+	}
 	return nil
 }
 
 type keyAuth struct {
 	FileLocation   string
 	PasswordSecret string
+	// SignWith, when set, is a vault secret with a role to have the key signed by, offering it with the
+	// certificate
+	SignWith string
 	// internal
 	password vaultSecret
+	ca       certificateSigner
 }
 
 func (a *keyAuth) validateAndUpdate(vault secretsVault) error {
@@ -126,13 +464,28 @@ func (a *keyAuth) validateAndUpdate(vault secretsVault) error {
 		}
 		a.password = v
 	}
+	if a.SignWith != "" {
+		v, err := vault.secretFor(a.SignWith)
+		if err != nil {
+			return err
+		}
+		ca, ok := v.(certificateSigner)
+		if !ok {
+			return fmt.Errorf("secret %s can't sign keys, it needs a vault role", a.SignWith)
+		}
+		a.ca = ca
+	}
 	return nil
 }
 
 type pwdAuth struct {
 	PasswordSecret string
+	// OTPSecret, when set, is a secret such as a totp code appended to the password, as bastions wanting both in
+	// the one password prompt expect
+	OTPSecret string
 	// internal
 	password vaultSecret
+	otp      vaultSecret
 }
 
 func (a *pwdAuth) validateAndUpdate(vault secretsVault) error {
@@ -143,9 +496,26 @@ func (a *pwdAuth) validateAndUpdate(vault secretsVault) error {
 		}
 		a.password = v
 	}
+	if a.OTPSecret != "" {
+		v, err := vault.secretFor(a.OTPSecret)
+		if err != nil {
+			return err
+		}
+		a.otp = v
+	}
 	return nil
 }
 
+// passwordWithOTP is the password to send, taken again on every attempt so that one time codes are current
+func (a pwdAuth) passwordWithOTP() (string, error) {
+	password, err := a.password.value()
+	if err != nil || a.otp == nil {
+		return password, err
+	}
+	otp, err := a.otp.value()
+	return password + otp, err
+}
+
 func (sc *sshConfig) validateAndUpdate(vault secretsVault) error {
 	if sc.Destination == "" {
 		return fmt.Errorf("config has empty destination")
@@ -153,6 +523,9 @@ func (sc *sshConfig) validateAndUpdate(vault secretsVault) error {
 	if err := sc.validateAndUpdateAuth(vault); err != nil {
 		return err
 	}
+	if err := sc.validateForwards(); err != nil {
+		return err
+	}
 	for i, pf := range sc.ThroughSSH {
 		if pf.Destination == "" {
 			return fmt.Errorf("ThroughSSH config has empty destination")
@@ -160,70 +533,353 @@ func (sc *sshConfig) validateAndUpdate(vault secretsVault) error {
 		if err := pf.validateAndUpdateAuth(vault); err != nil {
 			return err
 		}
+		if err := pf.validateForwards(); err != nil {
+			return err
+		}
 		sc.ThroughSSH[i] = pf
 	}
 	return nil
 }
 
-func (sc *sshConfig) logSuccessful() {
-	log.Printf("Connection to %s successfully established...", sc.Destination)
+func (sc *sshConfig) validateForwards() error {
+	if _, err := sc.failurePolicy(); err != nil {
+		return fmt.Errorf("%s: %v", sc.id(), err)
+	}
+	for _, f := range append(sc.localForwards(), sc.reverseForwards()...) {
+		if err := f.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sc *sshConfig) failurePolicy() (tunnel.FailurePolicy, error) {
+	switch strings.ToLower(sc.FailurePolicy) {
+	case "", "all-or-nothing":
+		return tunnel.AllOrNothing, nil
+	case "best-effort":
+		return tunnel.BestEffort, nil
+	}
+	return tunnel.AllOrNothing, fmt.Errorf("unknown failurepolicy %s, expected all-or-nothing or best-effort", sc.FailurePolicy)
+}
+
+// localForwards are the tunnels listening here
+func (sc *sshConfig) localForwards() []portForward {
+	local := []portForward{}
 	for _, f := range sc.Tunnels {
+		if !f.Reverse {
+			local = append(local, f)
+		}
+	}
+	return local
+}
+
+// reverseForwards are the tunnels listening on the remote host: reversetunnels and tunnels marked reverse
+func (sc *sshConfig) reverseForwards() []portForward {
+	reverse := []portForward{}
+	for _, f := range sc.Tunnels {
+		if f.Reverse {
+			reverse = append(reverse, f)
+		}
+	}
+	for _, f := range sc.ReverseTunnels {
+		f.Reverse = true
+		reverse = append(reverse, f)
+	}
+	return reverse
+}
+
+// logSuccessful lists the tunnels of t that are live; those that aren't are logged by OnForward and OnReverse
+func (sc *sshConfig) logSuccessful(t *tunnel.Tunnel) {
+	log.Printf("Connection to %s successfully established...", sc.Destination)
+	// the library reports every port of a ports entry on its own
+	forward := t.ForwardStatus()
+	i := 0
+	for _, f := range sc.localForwards() {
 		if f.Ignore {
 			continue
 		}
-		log.Printf("\testablished tunnel %s: forwarded port %d to %s", f.Name, f.Port, f.Target)
+		from, to := statusRange(i, f.forwardCount(), len(forward))
+		for _, s := range forward[from:to] {
+			if !s.Bound {
+				continue
+			}
+			if f.Ports != "" {
+				log.Printf("\testablished tunnel %s: forwarded %s to %s", f.Name, s.Local, s.Destination)
+			} else {
+				log.Printf("\testablished tunnel %s: forwarded %s to %s", f.Name, f.local(), f.targets())
+			}
+		}
+		i += f.forwardCount()
 	}
-	for _, f := range sc.ReverseTunnels {
+	reverse := t.ReverseStatus()
+	i = 0
+	for _, f := range sc.reverseForwards() {
 		if f.Ignore {
 			continue
 		}
-		log.Printf("\testablished tunnel %s: forwarded remote port %d to %s", f.Name, f.Port, f.Target)
+		from, to := statusRange(i, f.forwardCount(), len(reverse))
+		for _, s := range reverse[from:to] {
+			if !s.Bound {
+				continue
+			}
+			// reported by the library, with the port the server picked for port 0
+			if f.Ports != "" {
+				log.Printf("\testablished tunnel %s: forwarded remote %s to %s", f.Name, s.Remote, s.Destination)
+			} else {
+				log.Printf("\testablished tunnel %s: forwarded remote %s to %s", f.Name, s.Remote, f.targets())
+			}
+		}
+		i += f.forwardCount()
 	}
 }
 
+// statusRange bounds the n statuses of a config entry from i on by how many statuses there are
+func statusRange(i, n, count int) (int, int) {
+	if i > count {
+		i = count
+	}
+	if i+n > count {
+		return i, count
+	}
+	return i, i + n
+}
+
 func run(ctx context.Context, conf *config) error {
+	tunnelConf, err := loadConfig(conf)
+	if err != nil {
+		return err
+	}
+	// the daemon keeps going without any entries, for more to be added
+	daemon := conf.control != nil
+	if len(tunnelConf.SshConfigs) == 0 && !daemon {
+		return fmt.Errorf("no successfull connections, terminating")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conf.manager = tunnel.NewManager()
+	if conf.registry == nil && (conf.metricsAddr != "" || conf.healthAddr != "" || conf.tui) {
+		conf.registry = newTunnelRegistry(nil)
+	}
+	conf.registry.expect(tunnelConf.SshConfigs)
+	restarts := make(chan string, 1)
+	if conf.tui {
+		stop, err := startDashboard(cancel, conf.registry, conf, restarts)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+	if conf.metricsAddr != "" {
+		if err := serveMetrics(ctx, conf.metricsAddr, conf.registry, conf.reusePort); err != nil {
+			return err
+		}
+	}
+	if conf.healthAddr != "" {
+		if err := serveHealth(ctx, conf.healthAddr, conf.registry, conf.reusePort); err != nil {
+			return err
+		}
+	}
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	names, err := startNames(ctx, tunnelConf.Names, conf.log(), conf.reusePort)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if !conf.handedOff {
+			names.close()
+		}
+	}()
+
+	entries := &daemonEntries{file: tunnelConf.SshConfigs}
+	s := newSupervisor(ctx, conf)
+	s.apply(entries.all())
+	names.apply(entries.all())
+	if conf.started != nil {
+		conf.started()
+	}
+	update := func() {
+		conf.registry.expect(entries.all())
+		s.apply(entries.all())
+		names.apply(entries.all())
+	}
+	reloadConfig := func() error {
+		log.Printf("reloading %s", conf.configFile)
+		tunnelConf, err := loadConfig(conf)
+		if err != nil {
+			return err
+		}
+		entries.file, entries.removed = tunnelConf.SshConfigs, nil
+		update()
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			s.wait()
+			return nil
+		case e := <-s.finished:
+			if s.stopped(e) && !conf.tui && !daemon {
+				return nil
+			}
+		case key := <-restarts:
+			s.restart(key)
+		case <-reload:
+			if err := reloadConfig(); err != nil {
+				log.Printf("keeping the running config as the reloaded one is invalid: %v", err)
+				continue
+			}
+			if len(s.running) == 0 && !daemon {
+				return nil
+			}
+		case req := <-conf.control:
+			req.reply <- handleControl(req, entries, conf, update, reloadConfig, cancel)
+		}
+	}
+}
+
+// handleControl carries out a daemon command
+func handleControl(req controlRequest, entries *daemonEntries, conf *config, update func(), reloadConfig func() error, stop func()) error {
+	switch req.action {
+	case "add":
+		if err := entries.add(conf, req.contents); err != nil {
+			return err
+		}
+	case "remove":
+		if err := entries.remove(req.name); err != nil {
+			return err
+		}
+	case "reload":
+		return reloadConfig()
+	case "stop":
+		log.Printf("stopping as asked to")
+		stop()
+		return nil
+	case "upgrade":
+		if conf.upgrade == nil {
+			return fmt.Errorf("only the daemon can be upgraded")
+		}
+		if err := conf.upgrade(); err != nil {
+			return err
+		}
+		conf.handedOff = true
+		log.Printf("stopping as an upgraded daemon took over")
+		stop()
+		return nil
+	default:
+		return fmt.Errorf("unknown command %s", req.action)
+	}
+	update()
+	return nil
+}
+
+// loadConfig reads, parses and validates the config file, resolving all secrets
+func loadConfig(conf *config) (tunnelConfig, error) {
 	if conf.configFile == "" {
-		return fmt.Errorf("cannot proceed without config file")
+		return tunnelConfig{}, fmt.Errorf("cannot proceed without config file")
 	}
-	contents, err := os.ReadFile(conf.configFile)
+	tunnelConf, err := loadConfigFile(conf.configFile, conf.configFormat, nil)
 	if err != nil {
-		return fmt.Errorf("unable to open config file %s: %v", conf.configFile, err)
+		return tunnelConf, err
 	}
-	tunnelConf := tunnelConfig{}
-	if err := yaml.Unmarshal(contents, &tunnelConf); err != nil {
-		return fmt.Errorf("unable to parse config file %s: %v", conf.configFile, err)
+	return tunnelConf, prepareConfig(conf, &tunnelConf, nil)
+}
+
+// prepareConfig applies the environment to tunnelConf and validates it, with secrets resolved from known unless
+// defined again
+func prepareConfig(conf *config, tunnelConf *tunnelConfig, known secretsVault) error {
+	if conf.env != "" {
+		if err := tunnelConf.applyEnvironment(conf.env); err != nil {
+			return err
+		}
 	}
-	vault, err := newSecretsVault(tunnelConf.Secrets)
+	openSSH, err := tunnel.LoadSSHConfig(conf.sshConfigFile)
 	if err != nil {
 		return err
 	}
-	jobs := []nursery.ConcurrentJob{}
+	conf.openSSH = openSSH
+	resolveSSHAliases(tunnelConf.SshConfigs, openSSH)
+	vault, err := newSecretsVault(tunnelConf.Secrets, conf.secrets)
+	if err != nil {
+		return err
+	}
+	for name, v := range known {
+		if _, ok := vault[name]; !ok {
+			vault[name] = v
+		}
+	}
+	conf.secrets = vault
+	if err := validateConfig(tunnelConf, vault); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseConfig parses a config strictly so that misspelt keys are reported with their line rather than ignored
+func parseConfig(contents []byte) (tunnelConfig, error) {
+	tunnelConf := tunnelConfig{}
+	if err := yaml.UnmarshalStrict(contents, &tunnelConf); err != nil {
+		return tunnelConf, err
+	}
+	switch {
+	case tunnelConf.Version == 0:
+		tunnelConf.Version = configVersion
+	case tunnelConf.Version < 0 || tunnelConf.Version > configVersion:
+		return tunnelConf, fmt.Errorf("config version %d isn't supported, expected %d", tunnelConf.Version, configVersion)
+	}
+	return tunnelConf, nil
+}
+
+func validateConfig(tunnelConf *tunnelConfig, vault secretsVault) error {
 	for i, c := range tunnelConf.SshConfigs {
 		if err := c.validateAndUpdate(vault); err != nil {
 			return fmt.Errorf("invalid config #%d: %v", i, err)
 		}
-		jobs = append(jobs, jobForConfig(ctx, c))
-	}
-	if len(jobs) == 0 {
-		return fmt.Errorf("no successfull connections, terminating")
+		tunnelConf.SshConfigs[i] = c
 	}
-	return nursery.RunConcurrently(jobs...)
+	return nil
 }
 
-func jobForConfig(ctx context.Context, conf sshConfig) nursery.ConcurrentJob {
+func jobForConfig(ctx context.Context, conf sshConfig, opts *config) nursery.ConcurrentJob {
 	return func(_ context.Context, _ chan error) {
-		if err := handleConnectionTo(ctx, conf); err != nil {
+		if err := handleConnectionTo(ctx, conf, opts); err != nil {
 			log.Printf("error connecting to %s: %v", conf.Destination, err)
 		}
 	}
 }
 
-func sshAuthFromAuth(auth auth) (ssh.AuthMethod, error) {
+func sshAuthFromAuth(auth auth, destination string, opts *config) (ssh.AuthMethod, error) {
 	switch {
+	case auth.GSSAPI:
+		return tunnel.GSSAPIAuth(destination)
+	case auth.Agent:
+		return tunnel.Agent()
+	case auth.KeyboardInteractive && auth.otp != nil:
+		return tunnel.KeyboardInteractive(func(_, _, _ string, _ bool) (string, error) {
+			return auth.otp.value()
+		}), nil
+	case auth.KeyboardInteractive:
+		if opts.tui {
+			return nil, fmt.Errorf("keyboard-interactive auth can't prompt while --tui has the terminal")
+		}
+		return tunnel.KeyboardInteractive(terminalPrompt(destination)), nil
 	case auth.KeyAuth.FileLocation != "":
 		pwd := ""
 		if auth.KeyAuth.PasswordSecret != "" {
-			pwd = string(auth.KeyAuth.password)
+			var err error
+			if pwd, err = auth.KeyAuth.password.value(); err != nil {
+				return nil, err
+			}
+		}
+		if auth.KeyAuth.ca != nil {
+			key, err := signedKeyAuth(auth.KeyAuth.FileLocation, pwd, auth.KeyAuth.ca)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create private key auth: %v", err)
+			}
+			return key, nil
 		}
 		key, err := tunnel.PrivateKeyFile(auth.KeyAuth.FileLocation, pwd)
 		if err != nil {
@@ -231,58 +887,222 @@ func sshAuthFromAuth(auth auth) (ssh.AuthMethod, error) {
 		}
 		return key, nil
 	case auth.PwdAuth.PasswordSecret != "":
-		return ssh.Password(string(auth.PwdAuth.password)), nil
+		return ssh.PasswordCallback(auth.PwdAuth.passwordWithOTP), nil
+	case len(auth.KeyFiles) > 0 || auth.KeyDir != "":
+		passphrase := ""
+		if auth.keyPassword != nil {
+			var err error
+			if passphrase, err = auth.keyPassword.value(); err != nil {
+				return nil, err
+			}
+		}
+		paths := auth.KeyFiles
+		if auth.KeyDir != "" {
+			paths = append(append([]string{}, paths...), auth.KeyDir)
+		}
+		return tunnel.IdentityKeys(paths, passphrase, opts.log())
 	default:
 		return nil, fmt.Errorf("invalid auth details")
 	}
 }
 
-func handleConnectionTo(ctx context.Context, conf sshConfig) error {
-	spec := &tunnel.Spec{
-		Host:   conf.Destination,
-		User:   conf.User,
-		Logger: tunnel.StdOutLogger(),
+func handleConnectionTo(ctx context.Context, conf sshConfig, opts *config) (err error) {
+	defer func() {
+		if err != nil && ctx.Err() == nil {
+			opts.registry.fail(conf.id(), err)
+		}
+	}()
+	spec, err := specFor(conf, opts)
+	if err != nil {
+		return err
 	}
-	for _, auth := range conf.Auth {
-		sshAuth, err := sshAuthFromAuth(auth)
-		if err != nil {
+	t, err := executeSpec(ctx, conf, spec, opts)
+	for prompts := 1; errors.Is(err, tunnel.ErrAuthFailed) && prompts < opts.passwordPrompts; prompts++ {
+		log.Printf("%s: %v", conf.Destination, err)
+		if !repromptPasswords(conf, opts) {
+			break
+		}
+		if spec, err = specFor(conf, opts); err != nil {
 			return err
 		}
-		spec.Auth = append(spec.Auth, sshAuth)
+		t, err = executeSpec(ctx, conf, spec, opts)
 	}
-	for _, f := range conf.Tunnels {
-		if f.Ignore {
-			continue
-		}
-		spec.Forward = append(spec.Forward, tunnel.Forward(f.Port, f.Target))
+	if err != nil || t == nil {
+		return err
 	}
-	for _, f := range conf.ReverseTunnels {
-		if f.Ignore {
-			continue
-		}
-		spec.Reverse = append(spec.Reverse, tunnel.Forward(f.Port, f.Target))
+	conf.logSuccessful(t)
+	opts.registry.track(conf.id(), t)
+	if hooks := newForwardHooks(conf); hooks != nil {
+		go hooks.watch(t.Events())
 	}
-	ok := make(chan struct{})
-	finished := make(chan struct{})
 	return nursery.RunConcurrently(
 		func(_ context.Context, errCh chan error) {
-			if err := tunnel.ExecuteAndBlock(ctx, spec, ok); err != nil {
+			select {
+			case <-ctx.Done():
+			case <-t.Done():
+			}
+			t.Close()
+			if err := t.Err(); err != nil {
 				errCh <- err
 			}
-			close(finished)
 		},
 		func(context.Context, chan error) {
-			select {
-			case <-ok:
-				conf.logSuccessful()
-			case <-finished:
-				return
-			}
 			jobs := []nursery.ConcurrentJob{}
 			for _, c := range conf.ThroughSSH {
-				jobs = append(jobs, jobForConfig(ctx, c))
+				jobs = append(jobs, jobForConfig(ctx, c, opts))
 			}
 			nursery.RunConcurrently(jobs...)
 		},
 	)
 }
+
+// executeSpec establishes the entry's tunnel, retrying until ctx is done when it reconnects; the tunnel is nil
+// when ctx is done first
+func executeSpec(ctx context.Context, conf sshConfig, spec *tunnel.Spec, opts *config) (*tunnel.Tunnel, error) {
+	if conf.Reconnect == nil {
+		if opts.manager != nil {
+			return opts.manager.ExecuteContext(ctx, spec)
+		}
+		return tunnel.ExecuteContext(ctx, spec)
+	}
+	policy := tunnel.RetryPolicy{
+		MaxRetries:     conf.Reconnect.MaxRetries,
+		InitialBackoff: conf.Reconnect.InitialBackoff,
+		MaxBackoff:     conf.Reconnect.MaxBackoff,
+	}
+	var retrying *tunnel.RetryingTunnel
+	if opts.manager != nil {
+		retrying = opts.manager.ExecuteWithRetry(ctx, spec, policy)
+	} else {
+		retrying = tunnel.ExecuteWithRetry(ctx, spec, policy)
+	}
+	<-retrying.Done()
+	if ctx.Err() != nil {
+		return nil, nil
+	}
+	return retrying.Tunnel(), retrying.Err()
+}
+
+// specFor builds the library spec for connecting to conf's destination
+func specFor(conf sshConfig, opts *config) (*tunnel.Spec, error) {
+	spec := &tunnel.Spec{
+		Host:            conf.Destination,
+		FallbackHosts:   conf.FallbackDestinations,
+		User:            conf.User,
+		Logger:          opts.log(),
+		LogLevel:        opts.logLevel,
+		HostKeyCallback: tunnel.TrustOnFirstUse(opts.knownHostsFile, opts.acceptChangedHostKeys),
+		OnError:         opts.onError(conf),
+		Audit:           opts.audit,
+		SuspendAfter:    conf.SuspendAfter,
+		IdleTimeout:     conf.IdleTimeout,
+		AuthTimeout:     conf.AuthTimeout,
+		ConnectTimeout:  conf.ConnectTimeout,
+		BindAddress:     conf.BindAddress,
+		MonitorInterval: conf.MonitorInterval,
+		ProxyURL:        conf.ProxyURL,
+		ProxyCommand:    conf.ProxyCommand,
+		Ciphers:         conf.Ciphers,
+		MACs:            conf.MACs,
+		KeyExchanges:    conf.KeyExchanges,
+	}
+	for _, auth := range conf.Auth {
+		sshAuth, err := sshAuthFromAuth(auth, conf.Destination, opts)
+		if err != nil {
+			return nil, err
+		}
+		spec.Auth = append(spec.Auth, sshAuth)
+	}
+	if len(spec.Auth) == 0 {
+		// as ssh does, fall back to the agent's keys, if there's one running, and the default identities
+		if agent, err := tunnel.Agent(); err == nil {
+			spec.Auth = append(spec.Auth, agent)
+		}
+		auth, err := tunnel.IdentityFiles(nil)
+		if err != nil {
+			return nil, err
+		}
+		spec.Auth = append(spec.Auth, auth...)
+	}
+	if conf.ProxyJump != "" {
+		via, err := opts.openSSH.Via(strings.Split(conf.ProxyJump, ","), spec.Auth)
+		if err != nil {
+			return nil, err
+		}
+		for hop := via; hop != nil; hop = hop.Via {
+			hop.HostKeyCallback = spec.HostKeyCallback
+		}
+		spec.Via = via
+	}
+	for _, f := range conf.localForwards() {
+		if f.Ignore {
+			continue
+		}
+		spec.Forward = append(spec.Forward, f.forwarder())
+	}
+	for _, f := range conf.reverseForwards() {
+		if f.Ignore {
+			continue
+		}
+		spec.Reverse = append(spec.Reverse, f.forwarder())
+	}
+	spec.FailurePolicy, _ = conf.failurePolicy()
+	spec.ReusePort = opts.reusePort
+	if conf.DrainTimeout > 0 {
+		spec.DrainTimeout = conf.DrainTimeout
+		spec.OnDrain = func(cutOff int) {
+			if cutOff > 0 {
+				log.Printf("closed %d connections through %s still open after draining for %v", cutOff, conf.Destination, conf.DrainTimeout)
+			}
+		}
+	}
+	if spec.FailurePolicy == tunnel.BestEffort {
+		spec.OnForward = func(statuses []tunnel.ForwardStatus) {
+			for _, s := range statuses {
+				if !s.Bound {
+					log.Printf("unable to open local %s for %s, carrying on without it: %v", s.Local, conf.Destination, s.Err)
+				}
+			}
+		}
+	}
+	if len(spec.Reverse) > 0 {
+		spec.OnReverse = func(statuses []tunnel.ReverseStatus) {
+			for _, s := range statuses {
+				if !s.Bound {
+					log.Printf("unable to open remote %s on %s: %v", s.Remote, conf.Destination, s.Err)
+				}
+			}
+		}
+	}
+	if conf.MonitorInterval > 0 {
+		notify := opts.notifyError(conf.Destination)
+		spec.OnDestinationDown = func(s tunnel.DestinationStatus) {
+			notify(fmt.Errorf("target %s of tunnel %s is unreachable: %v", s.Destination, s.Name, s.Err))
+		}
+	}
+	if conf.VPN != nil {
+		spec.VPN = conf.VPN.toVPN()
+	}
+	if len(conf.PreCommands) > 0 {
+		spec.OnConnect = tunnel.RemoteCommands(conf.PreCommands...)
+	}
+	if len(conf.PostCommands) > 0 {
+		spec.OnDisconnect = tunnel.RemoteCommands(conf.PostCommands...)
+	}
+	if conf.Reconnect != nil {
+		spec.Reconnect = &tunnel.Reconnect{
+			MaxRetries:     conf.Reconnect.MaxRetries,
+			InitialBackoff: conf.Reconnect.InitialBackoff,
+			MaxBackoff:     conf.Reconnect.MaxBackoff,
+		}
+	}
+	if conf.DNS != nil {
+		spec.DNS = &tunnel.DNSForward{
+			Listen:   conf.DNS.Listen,
+			Domains:  conf.DNS.Domains,
+			Resolver: conf.DNS.Resolver,
+			Fallback: conf.DNS.Fallback,
+		}
+	}
+	return spec, nil
+}
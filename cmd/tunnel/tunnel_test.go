@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/arunsworld/go-tunnel/tunneltest"
+)
+
+func TestReverseTunnels(t *testing.T) {
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	echo, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	conf := sshConfig{
+		Destination: server.Addr,
+		User:        server.User,
+		Auth:        []auth{{PwdAuth: pwdAuth{PasswordSecret: "pwd", password: staticSecret(server.Password)}}},
+		Tunnels: []portForward{
+			{Name: "local", Port: 1250, Target: "db:5432"},
+			{Name: "flagged", Port: 1251, Target: echo.Addr().String(), BindAddress: "127.0.0.1", Reverse: true},
+		},
+		ReverseTunnels: []portForward{{Name: "listed", Port: 1252, Target: echo.Addr().String()}},
+	}
+	if err := conf.validateForwards(); err != nil {
+		t.Fatal(err)
+	}
+	opts := &config{knownHostsFile: filepath.Join(t.TempDir(), "known_hosts")}
+	spec, err := specFor(conf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.Forward) != 1 || len(spec.Reverse) != 2 {
+		t.Fatalf("expected 1 local and 2 reverse forwards, got %d and %d", len(spec.Forward), len(spec.Reverse))
+	}
+	tun, err := tunnel.Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	for _, s := range tun.ReverseStatus() {
+		if !s.Bound {
+			t.Fatalf("expected reverse forward on %s to be bound: %v", s.Remote, s.Err)
+		}
+	}
+	// the test server listens for reverse forwards on this host
+	for _, addr := range []string{"127.0.0.1:1251", "localhost:1252"} {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+		io.WriteString(conn, "ping")
+		reply := make([]byte, 4)
+		if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+			t.Fatalf("expected %s to echo, got %q %v", addr, reply, err)
+		}
+		conn.Close()
+	}
+
+	conf.ReverseTunnels[0].AllowedUIDs = []int{1000}
+	if err := conf.validateForwards(); err == nil {
+		t.Fatal("expected allowed peers on a reverse tunnel to be refused")
+	}
+}
+
+func TestParseConfigStrictly(t *testing.T) {
+	sample, err := os.ReadFile("sample.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := parseConfig(sample)
+	if err != nil {
+		t.Fatalf("sample config: %v", err)
+	}
+	if conf.Version != configVersion || len(conf.SshConfigs) == 0 {
+		t.Fatalf("unexpected sample config %+v", conf)
+	}
+
+	_, err = parseConfig([]byte("sshconfigs:\n- destination: host:22\n  tunels:\n  - port: 2000\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3: field tunels not found") {
+		t.Fatalf("expected the misspelt key to be reported with its line, got %v", err)
+	}
+	if conf, err := parseConfig([]byte("sshconfigs:\n- destination: host:22\n")); err != nil || conf.Version != configVersion {
+		t.Fatalf("expected a config without a version to be the current one, got %d: %v", conf.Version, err)
+	}
+	if _, err := parseConfig([]byte("version: 2\nsshconfigs:\n- destination: host:22\n")); err == nil {
+		t.Fatal("expected a newer config version to be rejected")
+	}
+}
+
+func TestAuthFailure(t *testing.T) {
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conf := sshConfig{
+		Destination: server.Addr,
+		User:        server.User,
+		Auth:        []auth{{PwdAuth: pwdAuth{PasswordSecret: "pwd", password: staticSecret("typo")}}},
+	}
+	opts := &config{knownHostsFile: filepath.Join(t.TempDir(), "known_hosts"), passwordPrompts: 3}
+	// a secret that wasn't entered at the prompt isn't asked for again
+	if repromptPasswords(conf, opts) {
+		t.Fatal("expected only prompted secrets to be asked for again")
+	}
+	err = handleConnectionTo(context.Background(), conf, opts)
+	if !errors.Is(err, tunnel.ErrAuthFailed) {
+		t.Fatalf("expected the auth failure to be returned, got %v", err)
+	}
+
+	conf.Auth[0].PwdAuth.password = &promptedSecret{name: "pwd", v: "typo"}
+	opts.tui = true
+	if repromptPasswords(conf, opts) {
+		t.Fatal("expected no prompting while the dashboard has the terminal")
+	}
+}
+
+func TestLogSuccessfulAfterPortRange(t *testing.T) {
+	server, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	taken, err := net.Listen("tcp", "localhost:1263")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	conf := sshConfig{
+		Destination:   server.Addr,
+		User:          server.User,
+		Auth:          []auth{{PwdAuth: pwdAuth{PasswordSecret: "pwd", password: staticSecret(server.Password)}}},
+		FailurePolicy: "best-effort",
+		Tunnels: []portForward{
+			{Name: "range", Ports: "1261-1262", Target: "db"},
+			{Name: "taken", Port: 1263, Target: "db:5432"},
+		},
+	}
+	if err := conf.validateForwards(); err != nil {
+		t.Fatal(err)
+	}
+	opts := &config{knownHostsFile: filepath.Join(t.TempDir(), "known_hosts")}
+	spec, err := specFor(conf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun, err := tunnel.Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+
+	var logged strings.Builder
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	conf.logSuccessful(tun)
+	for _, port := range []string{"1261", "1262"} {
+		if !strings.Contains(logged.String(), "established tunnel range: forwarded port "+port+" to db:"+port) {
+			t.Fatalf("expected port %s of the range to be logged, got %q", port, logged.String())
+		}
+	}
+	if strings.Contains(logged.String(), "tunnel taken") {
+		t.Fatalf("expected the forward that couldn't listen not to be logged as established, got %q", logged.String())
+	}
+}
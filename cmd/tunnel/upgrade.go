@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+)
+
+// upgradeEnv tells a daemon it's started by the one it takes over from, with the control socket inherited as
+// its first extra file and a pipe to report it's running its config on as its second
+const upgradeEnv = "GO_TUNNEL_UPGRADE"
+
+const (
+	// upgradeReady is what an upgraded daemon reports once its tunnels are up
+	upgradeReady = "ready"
+	// upgradeTimeout is how long an upgraded daemon is given to bring its tunnels up before taking over anyway
+	upgradeTimeout = time.Minute
+)
+
+// listenTCP listens on addr, with the port shared with a daemon taking over from this one when reusePort is set
+func listenTCP(addr string, reusePort bool) (net.Listener, error) {
+	if !reusePort {
+		return net.Listen("tcp", addr)
+	}
+	lc := tunnel.ReusingPortListenConfig()
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// listenUDP is listenTCP for udp
+func listenUDP(addr string, reusePort bool) (net.PacketConn, error) {
+	if !reusePort {
+		return net.ListenPacket("udp", addr)
+	}
+	lc := tunnel.ReusingPortListenConfig()
+	return lc.ListenPacket(context.Background(), "udp", addr)
+}
+
+// upgradeDaemon starts the daemon's executable again, by now possibly a newer version, handing it the control
+// socket, and returns once it's running the config. Its tunnels listen on the same ports as this daemon's, which
+// is then to stop, draining its connections.
+func upgradeDaemon(control net.Listener) error {
+	if runtime.GOOS == "windows" {
+		return errors.New("upgrading the daemon in place isn't supported on windows")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	controlFile, err := control.(*net.UnixListener).File()
+	if err != nil {
+		return err
+	}
+	defer controlFile.Close()
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), upgradeEnv+"=1")
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{controlFile, readyWriter}
+	detach(cmd)
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return fmt.Errorf("unable to start %s: %v", executable, err)
+	}
+	go cmd.Wait()
+	log.Printf("started %s as pid %d to take over", executable, cmd.Process.Pid)
+	ready.SetReadDeadline(time.Now().Add(upgradeTimeout * 2))
+	status := make([]byte, len(upgradeReady))
+	if _, err := io.ReadFull(ready, status); err != nil || string(status) != upgradeReady {
+		cmd.Process.Kill()
+		return fmt.Errorf("upgraded daemon didn't take over, see its log: %v", err)
+	}
+	// the socket is the upgraded daemon's now
+	control.(*net.UnixListener).SetUnlinkOnClose(false)
+	return nil
+}
+
+// inheritControlSocket takes over the control socket from the daemon that started this one, returning it with
+// what to call once the config's entries are started for that daemon to stop
+func inheritControlSocket(opts *config) (net.Listener, func(), error) {
+	os.Unsetenv(upgradeEnv)
+	listener, err := net.FileListener(os.NewFile(3, "control socket"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to take over the control socket: %v", err)
+	}
+	// removed on exit like one this daemon listened on
+	listener.(*net.UnixListener).SetUnlinkOnClose(true)
+	ready := os.NewFile(4, "upgrade")
+	started := func() {
+		go func() {
+			defer ready.Close()
+			deadline := time.Now().Add(upgradeTimeout)
+			for !settled(opts.registry) && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond * 100)
+			}
+			log.Printf("taking over from the previous daemon")
+			io.WriteString(ready, upgradeReady)
+		}()
+	}
+	return listener, started, nil
+}
+
+// settled is whether every entry is connected, or has failed for good
+func settled(m *tunnelRegistry) bool {
+	for _, s := range m.snapshot() {
+		if s.state != stateConnected && s.state != stateFailed {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// certRenewBefore is how long before it expires a signed certificate is replaced
+const certRenewBefore = time.Minute
+
+// vaultConfig fetches a secret from HashiCorp Vault: a password from a kv secret, or with Role, certificates
+// signed by the ssh secrets engine for keyauths to sign their keys with
+type vaultConfig struct {
+	// Address is Vault's, such as https://vault.corp:8200; defaults to VAULT_ADDR
+	Address string
+	// Path is the kv secret's, such as secret/data/bastion, or with Role where the ssh secrets engine is mounted,
+	// such as ssh-client-signer
+	Path string
+	// Field is the kv secret's field holding the password; defaults to password
+	Field string
+	// Role, when set, is the ssh secrets engine role keys are signed with
+	Role string
+	// TokenEnv is the environment variable holding the Vault token; defaults to VAULT_TOKEN, falling back to the
+	// ~/.vault-token written by vault login
+	TokenEnv string
+}
+
+// certificateSigner signs public keys into certificates for them to be accepted by servers trusting its CA
+type certificateSigner interface {
+	signKey(key ssh.PublicKey) (*ssh.Certificate, error)
+}
+
+// vaultClient makes requests of Vault's HTTP API, reading the token again each time so a new vault login is
+// picked up
+type vaultClient struct {
+	address  string
+	tokenEnv string
+	http     *http.Client
+}
+
+func newVaultClient(name string, c vaultConfig) (*vaultClient, error) {
+	address := c.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" {
+		return nil, fmt.Errorf("vault secret %s has no address and VAULT_ADDR is not set", name)
+	}
+	if c.Path == "" {
+		return nil, fmt.Errorf("vault secret %s has no path", name)
+	}
+	tokenEnv := c.TokenEnv
+	if tokenEnv == "" {
+		tokenEnv = "VAULT_TOKEN"
+	}
+	return &vaultClient{
+		address:  strings.TrimRight(address, "/"),
+		tokenEnv: tokenEnv,
+		http:     &http.Client{Timeout: time.Second * 30},
+	}, nil
+}
+
+func (v *vaultClient) token() (string, error) {
+	if token := os.Getenv(v.tokenEnv); token != "" {
+		return token, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("%s is not set and there's no home directory for a .vault-token", v.tokenEnv)
+	}
+	token, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+	if err != nil {
+		return "", fmt.Errorf("%s is not set and there's no .vault-token, log in to vault first", v.tokenEnv)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// do makes a request of path, under /v1, decoding the response's data into data
+func (v *vaultClient) do(method, path string, body interface{}, data interface{}) error {
+	token, err := v.token()
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	if body != nil {
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, v.address+"/v1/"+strings.Trim(path, "/"), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := v.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach vault: %v", err)
+	}
+	defer resp.Body.Close()
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read vault's response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		failure := struct{ Errors []string }{}
+		json.Unmarshal(contents, &failure)
+		if len(failure.Errors) == 0 {
+			return fmt.Errorf("vault responded %s to %s", resp.Status, path)
+		}
+		return fmt.Errorf("vault responded %s to %s: %s", resp.Status, path, strings.Join(failure.Errors, "; "))
+	}
+	response := struct{ Data json.RawMessage }{}
+	if err := json.Unmarshal(contents, &response); err != nil {
+		return fmt.Errorf("unexpected response from vault to %s: %v", path, err)
+	}
+	if err := json.Unmarshal(response.Data, data); err != nil {
+		return fmt.Errorf("unexpected response from vault to %s: %v", path, err)
+	}
+	return nil
+}
+
+func newVaultSecret(name string, c vaultConfig) (vaultSecret, error) {
+	client, err := newVaultClient(name, c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Role != "" {
+		return &vaultSSHSigner{name: name, client: client, path: c.Path, role: c.Role}, nil
+	}
+	field := c.Field
+	if field == "" {
+		field = "password"
+	}
+	return &vaultKVSecret{name: name, client: client, path: c.Path, field: field}, nil
+}
+
+// vaultKVSecret is a password read from a kv secret every time it's used, so rotated passwords are picked up
+type vaultKVSecret struct {
+	name   string
+	client *vaultClient
+	path   string
+	field  string
+}
+
+func (s *vaultKVSecret) value() (string, error) {
+	data := map[string]interface{}{}
+	if err := s.client.do(http.MethodGet, s.path, nil, &data); err != nil {
+		return "", fmt.Errorf("unable to read secret %s: %v", s.name, err)
+	}
+	// version 2 of the kv engine nests the secret's fields, alongside its metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nested
+		}
+	}
+	v, ok := data[s.field].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s: %s has no %s field", s.name, s.path, s.field)
+	}
+	return v, nil
+}
+
+// vaultSSHSigner signs keys with a role of the ssh secrets engine
+type vaultSSHSigner struct {
+	name   string
+	client *vaultClient
+	path   string
+	role   string
+}
+
+func (s *vaultSSHSigner) value() (string, error) {
+	return "", fmt.Errorf("secret %s signs keys with vault role %s and has no value", s.name, s.role)
+}
+
+func (s *vaultSSHSigner) signKey(key ssh.PublicKey) (*ssh.Certificate, error) {
+	data := struct {
+		SignedKey string `json:"signed_key"`
+	}{}
+	body := map[string]string{"public_key": string(ssh.MarshalAuthorizedKey(key))}
+	if err := s.client.do(http.MethodPost, s.path+"/sign/"+s.role, body, &data); err != nil {
+		return nil, fmt.Errorf("unable to sign key with secret %s: %v", s.name, err)
+	}
+	signed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(data.SignedKey))
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: vault returned an invalid certificate: %v", s.name, err)
+	}
+	cert, ok := signed.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("secret %s: vault returned a key rather than a certificate", s.name)
+	}
+	return cert, nil
+}
+
+// signedKey offers a key with a certificate from ca, getting a new one when the current one is about to expire
+type signedKey struct {
+	key ssh.Signer
+	ca  certificateSigner
+	now func() time.Time
+
+	mu     sync.Mutex
+	cert   *ssh.Certificate
+	signer ssh.Signer
+}
+
+func (k *signedKey) signers() ([]ssh.Signer, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.signer != nil && (k.cert.ValidBefore == ssh.CertTimeInfinity ||
+		k.now().Add(certRenewBefore).Before(time.Unix(int64(k.cert.ValidBefore), 0))) {
+		return []ssh.Signer{k.signer}, nil
+	}
+	cert, err := k.ca.signKey(k.key.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewCertSigner(cert, k.key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to use signed certificate: %v", err)
+	}
+	k.cert, k.signer = cert, signer
+	return []ssh.Signer{signer}, nil
+}
+
+// signedKeyAuth authenticates with the private key in file, offering it with certificates signed by ca
+func signedKeyAuth(file, passPhrase string, ca certificateSigner) (ssh.AuthMethod, error) {
+	buffer, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read private key: %v", err)
+	}
+	var key ssh.Signer
+	if passPhrase == "" {
+		key, err = ssh.ParsePrivateKey(buffer)
+	} else {
+		key, err = ssh.ParsePrivateKeyWithPassphrase(buffer, []byte(passPhrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse private key: %v", err)
+	}
+	signed := &signedKey{key: key, ca: ca, now: time.Now}
+	return ssh.PublicKeysCallback(signed.signers), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestVaultSecrets(t *testing.T) {
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := 0
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/bastion":
+			w.Write([]byte(`{"data":{"data":{"password":"v2 password"},"metadata":{"version":3}}}`))
+		case "/v1/kv/bastion":
+			w.Write([]byte(`{"data":{"pwd":"v1 password"}}`))
+		case "/v1/ssh-client-signer/sign/bastion":
+			req := struct {
+				PublicKey string `json:"public_key"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&req)
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			signed++
+			cert := &ssh.Certificate{
+				Key:             key,
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"user"},
+				ValidBefore:     uint64(time.Now().Add(time.Minute * 30).Unix()),
+			}
+			if err := cert.SignCert(rand.Reader, ca); err != nil {
+				t.Error(err)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"signed_key": string(ssh.MarshalAuthorizedKey(cert))}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer vault.Close()
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "token")
+
+	secrets, err := newSecretsVault([]secret{
+		{Name: "v2", Vault: &vaultConfig{Path: "secret/data/bastion"}},
+		{Name: "v1", Vault: &vaultConfig{Path: "kv/bastion", Field: "pwd"}},
+		{Name: "missing", Vault: &vaultConfig{Path: "kv/missing"}},
+		{Name: "denied", Vault: &vaultConfig{Path: "kv/bastion", TokenEnv: "OTHER_VAULT_TOKEN"}},
+		{Name: "ca", Vault: &vaultConfig{Path: "ssh-client-signer", Role: "bastion"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"v2": "v2 password", "v1": "v1 password"} {
+		if v, err := secrets[name].value(); err != nil || v != expected {
+			t.Errorf("expected %s to be %q, got %q (%v)", name, expected, v, err)
+		}
+	}
+	if _, err := secrets["missing"].value(); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+	t.Setenv("OTHER_VAULT_TOKEN", "wrong")
+	if _, err := secrets["denied"].value(); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected vault's error, got %v", err)
+	}
+
+	a := keyAuth{SignWith: "v1"}
+	if err := a.validateAndUpdate(secrets); err == nil {
+		t.Fatal("expected a secret without a role to be refused for signing")
+	}
+	a = keyAuth{SignWith: "ca"}
+	if err := a.validateAndUpdate(secrets); err != nil {
+		t.Fatal(err)
+	}
+	_, userKey, _ := ed25519.GenerateKey(rand.Reader)
+	key, err := ssh.NewSignerFromKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	k := &signedKey{key: key, ca: a.ca, now: func() time.Time { return now }}
+	for i := 0; i < 2; i++ {
+		signers, err := k.signers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, ok := signers[0].PublicKey().(*ssh.Certificate)
+		if !ok || string(cert.Key.Marshal()) != string(key.PublicKey().Marshal()) {
+			t.Fatalf("expected the key offered with its certificate, got %v", signers[0].PublicKey().Type())
+		}
+	}
+	if signed != 1 {
+		t.Fatalf("expected the certificate to be reused while valid, signed %d", signed)
+	}
+	now = now.Add(time.Minute * 29)
+	if _, err := k.signers(); err != nil {
+		t.Fatal(err)
+	}
+	if signed != 2 {
+		t.Fatalf("expected the certificate to be replaced before it expires, signed %d", signed)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/urfave/cli/v2"
+)
+
+type vpnConfig struct {
+	Interface     string
+	Address       string
+	Routes        []string
+	MTU           int
+	RemoteCommand string
+}
+
+func (v *vpnConfig) toVPN() *tunnel.VPN {
+	return &tunnel.VPN{
+		Interface:     v.Interface,
+		Address:       v.Address,
+		Routes:        v.Routes,
+		MTU:           v.MTU,
+		RemoteCommand: v.RemoteCommand,
+	}
+}
+
+// vpnHelperCommand is the peer helper run on the remote side of a vpn (via vpn.remotecommand);
+// it relays packets between a local tun interface and stdin/stdout
+func vpnHelperCommand() *cli.Command {
+	v := vpnConfig{}
+	routes := cli.StringSlice{}
+	return &cli.Command{
+		Name:      "vpn-helper",
+		Usage:     "remote peer of a vpn: relay packets between a tun interface and stdin/stdout",
+		UsageText: "tunnel vpn-helper [options]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "interface", Usage: "name of the tun interface", Destination: &v.Interface},
+			&cli.StringFlag{Name: "address", Usage: "CIDR to assign to the tun interface", Destination: &v.Address},
+			&cli.StringSliceFlag{Name: "route", Usage: "subnet to route via the tun interface", Destination: &routes},
+			&cli.IntFlag{Name: "mtu", Usage: "mtu of the tun interface", Destination: &v.MTU},
+		},
+		Action: func(ctx *cli.Context) error {
+			v.Routes = routes.Value()
+			return tunnel.ServeVPNPeer(ctx.Context, v.toVPN(), os.Stdin, os.Stdout)
+		},
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+type webhookEvent struct {
+	Destination string    `json:"destination"`
+	Error       string    `json:"error"`
+	Time        time.Time `json:"time"`
+}
+
+// notifyError returns the OnError hook for destination, posting errors to the configured webhook
+func (c *config) notifyError(destination string) func(error) {
+	return func(err error) {
+		if c.webhook == "" {
+			return
+		}
+		body, _ := json.Marshal(webhookEvent{
+			Destination: destination,
+			Error:       err.Error(),
+			Time:        time.Now(),
+		})
+		client := http.Client{Timeout: time.Second * 10}
+		resp, postErr := client.Post(c.webhook, "application/json", bytes.NewReader(body))
+		if postErr != nil {
+			log.Printf("unable to notify webhook of error connecting to %s: %v", destination, postErr)
+			return
+		}
+		resp.Body.Close()
+	}
+}
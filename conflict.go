@@ -0,0 +1,114 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// PortConflict is what a forward does when its local port is already in use
+type PortConflict int
+
+const (
+	// FailOnConflict gives up on the forwards, as by default
+	FailOnConflict PortConflict = iota
+	// NextFreePort listens on the next free port after the one in use instead
+	NextFreePort
+	// KillStale stops a previous instance of this program still listening on the port, such as one that didn't
+	// exit cleanly, and takes the port over. Only supported on linux.
+	KillStale
+)
+
+const (
+	// nextFreePortAttempts is how many ports after the one in use NextFreePort tries
+	nextFreePortAttempts = 100
+	// staleExitTimeout is how long a stale instance has to exit and release its port
+	staleExitTimeout = time.Second * 5
+)
+
+// WithPortConflict sets what the forward does when its local port is in use; FailOnConflict by default. Where it
+// ends up listening is reported by LocalAddr and Stats, and kept after reconnecting.
+func (f Forwarder) WithPortConflict(policy PortConflict) Forwarder {
+	f.portConflict = policy
+	return f
+}
+
+// isAddrInUse is set for the error of listening on a port that's in use, 10048 being WSAEADDRINUSE on windows
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE) || errors.Is(err, syscall.Errno(10048))
+}
+
+// listenResolvingConflict listens on the forward's port, resolving a conflict over it by its policy
+func listenResolvingConflict(n NetworkingDevice, f Forwarder, logger Logger) (net.Listener, error) {
+	listener, err := listenOnNetworkingDevice(n, f.bindAddress, f.port, EmptyLogger())
+	if err == nil || f.port == 0 || !isAddrInUse(err) {
+		if err != nil {
+			logAt(logger, LevelError, "Unable to bind to %s port: %d\n", f.bindAddress, f.port)
+		}
+		return listener, err
+	}
+	switch f.portConflict {
+	case NextFreePort:
+		for port := f.port + 1; port <= f.port+nextFreePortAttempts && port <= 65535; port++ {
+			if listener, err := listenOnNetworkingDevice(n, f.bindAddress, port, EmptyLogger()); err == nil {
+				logAt(logger, LevelWarn, "port %d is in use, %s listens on port %d instead", f.port, f.displayName(), port)
+				return listener, nil
+			}
+		}
+		err = fmt.Errorf("port %d and the %d after it are in use", f.port, nextFreePortAttempts)
+	case KillStale:
+		listener, err = replaceStaleListener(n, f, logger)
+		if err == nil {
+			return listener, nil
+		}
+	}
+	logAt(logger, LevelError, "Unable to bind to %s port: %d: %v\n", f.bindAddress, f.port, err)
+	return nil, err
+}
+
+// replaceStaleListener stops the previous instance of this program listening on the forward's port, and listens
+// on it once it's released
+func replaceStaleListener(n NetworkingDevice, f Forwarder, logger Logger) (net.Listener, error) {
+	pid, exe, err := listeningProcess(f.port)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find what's listening on port %d: %v", f.port, err)
+	}
+	if pid == os.Getpid() {
+		return nil, fmt.Errorf("port %d is in use by this process", f.port)
+	}
+	if !sameExecutable(exe) {
+		return nil, fmt.Errorf("port %d is in use by %s (pid %d), not a previous instance", f.port, exe, pid)
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	logAt(logger, LevelWarn, "port %d is held by a previous instance (pid %d), stopping it", f.port, pid)
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		return nil, fmt.Errorf("unable to stop pid %d: %v", pid, err)
+	}
+	deadline := time.Now().Add(staleExitTimeout)
+	for {
+		listener, err := listenOnNetworkingDevice(n, f.bindAddress, f.port, EmptyLogger())
+		if err == nil || !isAddrInUse(err) || time.Now().After(deadline) {
+			return listener, err
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// sameExecutable is set when exe is the executable of this process
+func sameExecutable(exe string) bool {
+	own, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	if resolved, err := filepath.EvalSymlinks(own); err == nil {
+		own = resolved
+	}
+	return exe == own
+}
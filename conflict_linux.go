@@ -0,0 +1,69 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// listeningProcess finds the process listening on a local tcp port, and its executable, through /proc
+func listeningProcess(port int) (int, string, error) {
+	inodes := map[string]bool{}
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		if err := listeningInodes(table, port, inodes); err != nil && !os.IsNotExist(err) {
+			return 0, "", err
+		}
+	}
+	if len(inodes) == 0 {
+		return 0, "", fmt.Errorf("no listener on port %d", port)
+	}
+	fds, err := filepath.Glob("/proc/[0-9]*/fd/*")
+	if err != nil {
+		return 0, "", err
+	}
+	for _, fd := range fds {
+		link, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		if !inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+			continue
+		}
+		dir := filepath.Dir(filepath.Dir(fd))
+		pid, _ := strconv.Atoi(filepath.Base(dir))
+		exe, err := os.Readlink(filepath.Join(dir, "exe"))
+		if err != nil {
+			return pid, "", fmt.Errorf("unable to read the executable of pid %d: %v", pid, err)
+		}
+		return pid, exe, nil
+	}
+	return 0, "", fmt.Errorf("the listener on port %d belongs to a process that can't be seen", port)
+}
+
+// listeningInodes adds the inodes of the sockets of table, such as /proc/net/tcp, listening on port
+func listeningInodes(table string, port int, inodes map[string]bool) error {
+	file, err := os.Open(table)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	// header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// local address is hex ip:port, state 0A is LISTEN
+		if len(fields) < 10 || fields[3] != "0A" {
+			continue
+		}
+		local := fields[1]
+		p, err := strconv.ParseInt(local[strings.LastIndex(local, ":")+1:], 16, 32)
+		if err == nil && int(p) == port {
+			inodes[fields[9]] = true
+		}
+	}
+	return scanner.Err()
+}
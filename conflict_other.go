@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package tunnel
+
+import "errors"
+
+func listeningProcess(port int) (int, string, error) {
+	return 0, "", errors.New("finding the process listening on a port is only supported on linux")
+}
@@ -0,0 +1,166 @@
+package tunnel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CopyFile copies the local file at localPath to remotePath on the remote host using the scp protocol,
+// so it works against hosts that don't offer the sftp subsystem
+func (t *Tunnel) CopyFile(localPath, remotePath string) error {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", localPath)
+	}
+
+	session, err := t.Client().NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start("scp -t " + shellQuote(remotePath)); err != nil {
+		return fmt.Errorf("unable to start scp on %s: %v", t.spec.Host, err)
+	}
+	acks := bufio.NewReader(stdout)
+	if err := readSCPAck(acks); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(stdin, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), filepath.Base(localPath)); err != nil {
+		return err
+	}
+	if err := readSCPAck(acks); err != nil {
+		return err
+	}
+	if _, err := io.Copy(stdin, src); err != nil {
+		return fmt.Errorf("unable to copy %s to %s:%s: %v", localPath, t.spec.Host, remotePath, err)
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return err
+	}
+	if err := readSCPAck(acks); err != nil {
+		return err
+	}
+	stdin.Close()
+	return session.Wait()
+}
+
+// CopyFileFrom copies remotePath on the remote host to the local file at localPath using the scp protocol
+func (t *Tunnel) CopyFileFrom(remotePath, localPath string) error {
+	session, err := t.Client().NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start("scp -f " + shellQuote(remotePath)); err != nil {
+		return fmt.Errorf("unable to start scp on %s: %v", t.spec.Host, err)
+	}
+	r := bufio.NewReader(stdout)
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return err
+	}
+	mode, size, err := readSCPFileHeader(r)
+	if err != nil {
+		return err
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(dst, r, size); err != nil {
+		dst.Close()
+		return fmt.Errorf("unable to copy %s:%s to %s: %v", t.spec.Host, remotePath, localPath, err)
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := readSCPAck(r); err != nil {
+		return err
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return err
+	}
+	stdin.Close()
+	return session.Wait()
+}
+
+// readSCPAck reads a single scp response: 0 for success, 1 (warning) or 2 (error) followed by a message line
+func readSCPAck(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("scp terminated unexpectedly: %v", err)
+	}
+	if code == 0 {
+		return nil
+	}
+	msg, _ := r.ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+}
+
+// readSCPFileHeader reads a "C<mode> <size> <name>" line, skipping a preceding time line if present
+func readSCPFileHeader(r *bufio.Reader) (os.FileMode, int64, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return 0, 0, fmt.Errorf("scp terminated unexpectedly: %v", err)
+		}
+		switch line[0] {
+		case 'T':
+			continue
+		case 1, 2:
+			return 0, 0, fmt.Errorf("scp: %s", strings.TrimSpace(line[1:]))
+		case 'C':
+		default:
+			return 0, 0, fmt.Errorf("scp: unexpected response %q", line)
+		}
+		fields := strings.SplitN(strings.TrimSpace(line[1:]), " ", 3)
+		if len(fields) != 3 {
+			return 0, 0, fmt.Errorf("scp: malformed file header %q", line)
+		}
+		mode, err := strconv.ParseUint(fields[0], 8, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("scp: malformed file mode %q", fields[0])
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size < 0 {
+			return 0, 0, errors.New("scp: malformed file size " + fields[1])
+		}
+		return os.FileMode(mode).Perm(), size, nil
+	}
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,39 @@
+package tunnel
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadSCPFileHeader(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("T1700000000 0 1700000000 0\nC0640 12 notes.txt\n"))
+	mode, size, err := readSCPFileHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != os.FileMode(0640) || size != 12 {
+		t.Fatalf("unexpected header: %v %d", mode, size)
+	}
+
+	r = bufio.NewReader(strings.NewReader("\x01scp: /etc/shadow: Permission denied\n"))
+	if _, _, err := readSCPFileHeader(r); err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Fatalf("expected the remote error to be surfaced, got %v", err)
+	}
+}
+
+func TestReadSCPAck(t *testing.T) {
+	if err := readSCPAck(bufio.NewReader(strings.NewReader("\x00"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := readSCPAck(bufio.NewReader(strings.NewReader("\x02scp: no space left\n"))); err == nil {
+		t.Fatal("Expected an error but didn't get it!")
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if q := shellQuote("/tmp/it's here"); q != `'/tmp/it'\''s here'` {
+		t.Fatalf("unexpected quoting: %s", q)
+	}
+}
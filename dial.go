@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// dialRetryPause is the wait before retrying a failed dial to a forward's destination
+const dialRetryPause = time.Millisecond * 200
+
+// Dialer is anything that opens connections, such as an *ssh.Client
+type Dialer interface {
+	Dial(network, address string) (net.Conn, error)
+}
+
+// DialWithTimeout dials address through d, giving up after timeout. ssh.Client.Dial can't be cancelled, so an
+// abandoned dial keeps running in the background and its connection is closed should it still succeed.
+func DialWithTimeout(d Dialer, network, address string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		return d.Dial(network, address)
+	}
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	// buffered so the dialing goroutine never blocks once we've given up
+	results := make(chan result, 1)
+	go func() {
+		conn, err := d.Dial(network, address)
+		results <- result{conn: conn, err: err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.conn, r.err
+	case <-timer.C:
+		go func() {
+			if r := <-results; r.err == nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("dial %s %s: timed out after %v", network, address, timeout)
+	}
+}
+
+// contextDialer dials through a function such as Spec.DialContext, cancelling the dial after timeout
+type contextDialer struct {
+	ctx     context.Context
+	dial    func(ctx context.Context, network, address string) (net.Conn, error)
+	timeout time.Duration
+}
+
+func (d contextDialer) Dial(network, address string) (net.Conn, error) {
+	ctx := d.ctx
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	return d.dial(ctx, network, address)
+}
+
+// WithDialTimeout sets how long dialing the forward's destination through the connection may take, rather than
+// Spec.ForwardTimeout
+func (f Forwarder) WithDialTimeout(timeout time.Duration) Forwarder {
+	f.dialTimeout = timeout
+	return f
+}
+
+// WithDialRetries has a failed dial to the forward's destination retried up to n times before the connection
+// is given up on, as for a backend that's slow to accept
+func (f Forwarder) WithDialRetries(n int) Forwarder {
+	f.dialRetries = n
+	return f
+}
+
+// dialRetrying returns dial retried up to retries times after failures other than the server refusing channels,
+// which dialWithBackoff waits out
+func dialRetrying(ctx context.Context, dial func(addr string) (net.Conn, error), retries int) func(addr string) (net.Conn, error) {
+	return func(addr string) (net.Conn, error) {
+		for attempt := 0; ; attempt++ {
+			conn, err := dial(addr)
+			if err == nil || attempt == retries || isChannelOpenThrottled(err) {
+				return conn, err
+			}
+			retry := time.NewTimer(dialRetryPause)
+			select {
+			case <-ctx.Done():
+				retry.Stop()
+				return nil, err
+			case <-retry.C:
+			}
+		}
+	}
+}
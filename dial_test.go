@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type slowDialer struct {
+	release chan struct{}
+	dialed  chan net.Conn
+}
+
+func (d *slowDialer) Dial(network, address string) (net.Conn, error) {
+	<-d.release
+	local, remote := net.Pipe()
+	d.dialed <- remote
+	return local, nil
+}
+
+func TestDialWithTimeout(t *testing.T) {
+	d := &slowDialer{release: make(chan struct{}), dialed: make(chan net.Conn, 1)}
+	start := time.Now()
+	if _, err := DialWithTimeout(d, "tcp", "hung:1", time.Millisecond*50); err == nil {
+		t.Fatal("expected the dial to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("timeout took %v", elapsed)
+	}
+
+	// the abandoned dial completing late must not leak its connection
+	close(d.release)
+	remote := <-d.dialed
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := remote.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("expected the late connection to be closed, got %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func TestDialRetrying(t *testing.T) {
+	attempts := 0
+	dial := dialRetrying(context.Background(), func(addr string) (net.Conn, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("connection refused")
+		}
+		local, _ := net.Pipe()
+		return local, nil
+	}, 2)
+	conn, err := dial("backend:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+
+	attempts = 0
+	if _, err := dialRetrying(context.Background(), func(addr string) (net.Conn, error) {
+		attempts++
+		return nil, errors.New("connection refused")
+	}, 1)("backend:80"); err == nil {
+		t.Fatal("expected the dial to fail once out of retries")
+	}
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+}
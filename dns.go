@@ -0,0 +1,294 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSForward defines selective forwarding of DNS queries: queries for Domains are resolved by
+// Resolver on the remote side of the ssh connection, anything else goes to Fallback
+type DNSForward struct {
+	// Listen is the local UDP address serving DNS; defaults to 127.0.0.1:53
+	Listen string
+	// Domains whose names are resolved remotely, e.g. *.e2open.com or zymesolutions.local
+	Domains []string
+	// Resolver is the DNS server reachable from the ssh host, e.g. 10.0.0.2:53
+	Resolver string
+	// Fallback is the local DNS server for all other queries; when empty they are refused
+	Fallback string
+}
+
+const (
+	defaultDNSListen = "127.0.0.1:53"
+	dnsHeaderLen     = 12
+	dnsRcodeRefused  = 5
+	dnsRcodeNXDomain = 3
+	dnsRcodeServFail = 2
+	dnsTypeA         = 1
+	dnsTypeAAAA      = 28
+	// maxDNSQueriesInFlight is how many queries are answered at once; those beyond get a server failure
+	maxDNSQueriesInFlight = 64
+	// dnsNameTTL is how long answers of a NameServer may be cached, short as names come and go with forwards
+	dnsNameTTL = 60
+)
+
+func serveDNS(ctx context.Context, dialer NetworkingDevice, d *DNSForward, timeout time.Duration, logger Logger) error {
+	if d.Resolver == "" {
+		return errors.New("dns forwarding requires a remote resolver")
+	}
+	listen := d.Listen
+	if listen == "" {
+		listen = defaultDNSListen
+	}
+	conn, err := net.ListenPacket("udp", listen)
+	if err != nil {
+		return fmt.Errorf("unable to listen for dns on %s: %v", listen, err)
+	}
+	domains := normalizeDomains(d.Domains)
+	logAt(logger, LevelInfo, "forwarding dns for %v on %s to %s", d.Domains, listen, d.Resolver)
+	return serveDNSQueries(ctx, conn, func(query []byte) ([]byte, error) {
+		return answerDNSQuery(dialer, d, domains, query, timeout)
+	}, logger)
+}
+
+// serveDNSQueries answers the queries received on conn with answer until ctx is done, closing conn
+func serveDNSQueries(ctx context.Context, conn net.PacketConn, answer func([]byte) ([]byte, error), logger Logger) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, 65535)
+	inFlight := make(chan struct{}, maxDNSQueriesInFlight)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+				return fmt.Errorf("unable to read dns query on %s: %v", conn.LocalAddr(), err)
+			}
+		}
+		query := append([]byte(nil), buf[:n]...)
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			// a flood of queries is turned away rather than piling up goroutines
+			if _, questionEnd, err := parseDNSQuestion(query); err == nil {
+				conn.WriteTo(errorDNSResponse(query, questionEnd, dnsRcodeServFail), addr)
+			}
+			continue
+		}
+		go func() {
+			defer func() { <-inFlight }()
+			resp, err := answer(query)
+			if err != nil {
+				logAt(logger, LevelWarn, "unable to answer dns query: %v", err)
+				return
+			}
+			conn.WriteTo(resp, addr)
+		}()
+	}
+}
+
+func answerDNSQuery(dialer NetworkingDevice, d *DNSForward, domains []string, query []byte, timeout time.Duration) ([]byte, error) {
+	name, questionEnd, err := parseDNSQuestion(query)
+	if err != nil {
+		return nil, err
+	}
+	if matchesDomain(name, domains) {
+		return exchangeDNSOverTCP(dialer, d.Resolver, query, timeout)
+	}
+	if d.Fallback == "" {
+		return errorDNSResponse(query, questionEnd, dnsRcodeRefused), nil
+	}
+	return exchangeDNSOverUDP(d.Fallback, query, timeout)
+}
+
+// exchangeDNSOverTCP sends query to resolver using DNS over TCP (RFC 1035 4.2.2) since ssh only carries streams
+func exchangeDNSOverTCP(dialer NetworkingDevice, resolver string, query []byte, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	conn, err := DialWithTimeout(dialer, "tcp", resolver, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach resolver %s: %v", resolver, err)
+	}
+	defer conn.Close()
+	// channels over ssh don't support deadlines
+	timer := time.AfterFunc(time.Until(deadline), func() { conn.Close() })
+	defer timer.Stop()
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, fmt.Errorf("unable to send query to %s: %v", resolver, err)
+	}
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("unable to read response from %s: %v", resolver, err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, fmt.Errorf("unable to read response from %s: %v", resolver, err)
+	}
+	return resp, nil
+}
+
+func exchangeDNSOverUDP(resolver string, query []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", resolver, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach resolver %s: %v", resolver, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("unable to send query to %s: %v", resolver, err)
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response from %s: %v", resolver, err)
+	}
+	return buf[:n], nil
+}
+
+// parseDNSQuestion returns the name of the first question in msg and the offset where the question ends
+func parseDNSQuestion(msg []byte) (string, int, error) {
+	if len(msg) < dnsHeaderLen {
+		return "", 0, errors.New("dns message too short")
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return "", 0, errors.New("dns message has no question")
+	}
+	labels := []string{}
+	i := dnsHeaderLen
+	for {
+		if i >= len(msg) {
+			return "", 0, errors.New("dns question name is truncated")
+		}
+		l := int(msg[i])
+		i++
+		if l == 0 {
+			break
+		}
+		if l&0xc0 != 0 {
+			return "", 0, errors.New("dns question name is compressed")
+		}
+		if i+l > len(msg) {
+			return "", 0, errors.New("dns question name is truncated")
+		}
+		labels = append(labels, string(msg[i:i+l]))
+		i += l
+	}
+	// qtype and qclass
+	i += 4
+	if i > len(msg) {
+		return "", 0, errors.New("dns question is truncated")
+	}
+	return strings.Join(labels, "."), i, nil
+}
+
+// errorDNSResponse answers query with rcode and no records
+func errorDNSResponse(query []byte, questionEnd int, rcode byte) []byte {
+	resp := append([]byte(nil), query[:questionEnd]...)
+	// QR set, opcode and RD preserved
+	resp[2] |= 0x80
+	resp[3] = resp[3]&0xf0 | rcode
+	// exactly one question, no answer, authority or additional records
+	binary.BigEndian.PutUint16(resp[4:6], 1)
+	binary.BigEndian.PutUint16(resp[6:8], 0)
+	binary.BigEndian.PutUint16(resp[8:10], 0)
+	binary.BigEndian.PutUint16(resp[10:12], 0)
+	return resp
+}
+
+func normalizeDomains(domains []string) []string {
+	result := make([]string, 0, len(domains))
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(d), "."), "*.")
+		if d != "" {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+func matchesDomain(name string, domains []string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, d := range domains {
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// NameServer answers DNS queries for the A and AAAA records of the names it's given, such as friendly names of
+// the addresses forwards listen on, and that the names don't exist for any other
+type NameServer struct {
+	mu    sync.Mutex
+	names map[string]net.IP
+}
+
+// SetNames replaces the names answered for, matched regardless of case
+func (s *NameServer) SetNames(names map[string]net.IP) {
+	normalized := make(map[string]net.IP, len(names))
+	for name, ip := range names {
+		normalized[strings.TrimSuffix(strings.ToLower(name), ".")] = ip
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names = normalized
+}
+
+// Serve answers the queries received on conn until ctx is done, closing conn
+func (s *NameServer) Serve(ctx context.Context, conn net.PacketConn, logger Logger) error {
+	return serveDNSQueries(ctx, conn, s.answer, logger)
+}
+
+func (s *NameServer) answer(query []byte) ([]byte, error) {
+	name, questionEnd, err := parseDNSQuestion(query)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	ip, ok := s.names[strings.TrimSuffix(strings.ToLower(name), ".")]
+	s.mu.Unlock()
+	resp := append([]byte(nil), query[:questionEnd]...)
+	// QR and AA set, opcode and RD preserved
+	resp[2] |= 0x84
+	resp[3] &= 0xf0
+	binary.BigEndian.PutUint16(resp[4:6], 1)
+	binary.BigEndian.PutUint16(resp[6:8], 0)
+	binary.BigEndian.PutUint16(resp[8:10], 0)
+	binary.BigEndian.PutUint16(resp[10:12], 0)
+	if !ok {
+		resp[3] |= dnsRcodeNXDomain
+		return resp, nil
+	}
+	qtype := binary.BigEndian.Uint16(query[questionEnd-4:])
+	rdata := ip.To4()
+	if qtype == dnsTypeAAAA && rdata == nil {
+		rdata = ip.To16()
+	} else if qtype != dnsTypeA || rdata == nil {
+		// the name exists without a record of the type asked for
+		return resp, nil
+	}
+	binary.BigEndian.PutUint16(resp[6:8], 1)
+	answer := make([]byte, 12+len(rdata))
+	// the name is the question's, at offset 12
+	binary.BigEndian.PutUint16(answer[0:], 0xc000|dnsHeaderLen)
+	binary.BigEndian.PutUint16(answer[2:], qtype)
+	// class IN
+	binary.BigEndian.PutUint16(answer[4:], 1)
+	binary.BigEndian.PutUint32(answer[6:], dnsNameTTL)
+	binary.BigEndian.PutUint16(answer[10:], uint16(len(rdata)))
+	copy(answer[12:], rdata)
+	return append(resp, answer...), nil
+}
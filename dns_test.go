@@ -0,0 +1,192 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func dnsQuery(name string) []byte {
+	msg := []byte{0xab, 0xcd, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 1}
+	for _, label := range splitLabels(name) {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, 1, 0, 1)
+	// EDNS OPT record in the additional section
+	return append(msg, 0, 0, 41, 0x10, 0, 0, 0, 0, 0, 0, 0)
+}
+
+func splitLabels(name string) []string {
+	labels := []string{}
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i == len(name) || name[i] == '.' {
+			labels = append(labels, name[start:i])
+			start = i + 1
+		}
+	}
+	return labels
+}
+
+func TestParseDNSQuestion(t *testing.T) {
+	query := dnsQuery("dev1249.dev.e2open.com")
+	name, end, err := parseDNSQuestion(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "dev1249.dev.e2open.com" {
+		t.Fatalf("unexpected name: %s", name)
+	}
+	if end != len(query)-11 {
+		t.Fatalf("unexpected end of question: %d", end)
+	}
+
+	if _, _, err := parseDNSQuestion(query[:20]); err == nil {
+		t.Fatal("Expected an error for a truncated query but didn't get it!")
+	}
+}
+
+func TestMatchesDomain(t *testing.T) {
+	domains := normalizeDomains([]string{"*.e2open.com", "zymesolutions.local."})
+	cases := map[string]bool{
+		"dev1249.dev.e2open.com":  true,
+		"E2OPEN.com.":             true,
+		"box.zymesolutions.local": true,
+		"note2open.com":           false,
+		"google.com":              false,
+	}
+	for name, expected := range cases {
+		if matchesDomain(name, domains) != expected {
+			t.Errorf("%s: expected match to be %v", name, expected)
+		}
+	}
+}
+
+func TestRefusedDNSResponse(t *testing.T) {
+	query := dnsQuery("google.com")
+	_, end, err := parseDNSQuestion(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := errorDNSResponse(query, end, dnsRcodeRefused)
+	if resp[0] != 0xab || resp[1] != 0xcd {
+		t.Fatal("response does not carry the query id")
+	}
+	if resp[2]&0x80 == 0 || resp[3]&0x0f != dnsRcodeRefused {
+		t.Fatalf("response is not a refusal: %x %x", resp[2], resp[3])
+	}
+	if binary.BigEndian.Uint16(resp[10:12]) != 0 || len(resp) != end {
+		t.Fatal("response should not carry additional records")
+	}
+}
+
+func TestDNSQueriesInFlight(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	release := make(chan struct{})
+	defer close(release)
+	go serveDNSQueries(ctx, conn, func(query []byte) ([]byte, error) {
+		<-release
+		return nil, errors.New("released")
+	}, EmptyLogger())
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(time.Second * 5))
+	for i := 0; i <= maxDNSQueriesInFlight; i++ {
+		query := dnsQuery("db.internal")
+		binary.BigEndian.PutUint16(query, uint16(i))
+		if _, err := client.Write(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp := make([]byte, 512)
+	n, err := client.Read(resp)
+	if err != nil {
+		t.Fatalf("expected the query beyond the limit to be answered: %v", err)
+	}
+	if id := binary.BigEndian.Uint16(resp); id != maxDNSQueriesInFlight || resp[3]&0x0f != dnsRcodeServFail || n < dnsHeaderLen {
+		t.Fatalf("expected a server failure for query %d, got %x", maxDNSQueriesInFlight, resp[:n])
+	}
+}
+
+func TestNameServer(t *testing.T) {
+	s := &NameServer{}
+	s.SetNames(map[string]net.IP{"Grafana.tunnel.local.": net.ParseIP("127.0.0.2")})
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, conn, EmptyLogger())
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(time.Second * 5))
+	query := dnsQuery("grafana.tunnel.local")
+	if _, err := client.Write(query); err != nil {
+		t.Fatal(err)
+	}
+	resp := make([]byte, 512)
+	n, err := client.Read(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = resp[:n]
+	if resp[3]&0x0f != 0 || binary.BigEndian.Uint16(resp[6:8]) != 1 {
+		t.Fatalf("expected an answer, got %x", resp)
+	}
+	if ip := net.IP(resp[len(resp)-4:]); !ip.Equal(net.ParseIP("127.0.0.2")) {
+		t.Fatalf("expected 127.0.0.2, got %s", ip)
+	}
+
+	// the name has no IPv6 address
+	aaaa := dnsQuery("grafana.tunnel.local")
+	_, end, _ := parseDNSQuestion(aaaa)
+	binary.BigEndian.PutUint16(aaaa[end-4:], dnsTypeAAAA)
+	resp, err = s.answer(aaaa)
+	if err != nil || resp[3]&0x0f != 0 || binary.BigEndian.Uint16(resp[6:8]) != 0 {
+		t.Fatalf("expected no answer for AAAA, got %x %v", resp, err)
+	}
+
+	resp, err = s.answer(dnsQuery("prometheus.tunnel.local"))
+	if err != nil || resp[3]&0x0f != dnsRcodeNXDomain {
+		t.Fatalf("expected an unknown name not to exist, got %x %v", resp, err)
+	}
+}
+
+// hangingDevice never completes a dial
+type hangingDevice struct{}
+
+func (hangingDevice) Listen(network, address string) (net.Listener, error) {
+	return nil, errors.New("not supported")
+}
+
+func (hangingDevice) Dial(n, addr string) (net.Conn, error) {
+	select {}
+}
+
+func TestExchangeDNSOverTCPTimeout(t *testing.T) {
+	start := time.Now()
+	if _, err := exchangeDNSOverTCP(hangingDevice{}, "10.0.0.53:53", dnsQuery("db.internal"), time.Millisecond*200); err == nil {
+		t.Fatal("expected an unreachable resolver to fail the query")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("took %v to give up on the resolver", elapsed)
+	}
+}
@@ -0,0 +1,65 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainConnections stops accepting connections and waits up to spec.DrainTimeout for those in progress to finish,
+// closing the ones still open then with closeConnections
+func drainConnections(spec *Spec, localListeners, remoteListeners []net.Listener, wg *sync.WaitGroup, closeConnections func()) {
+	for _, l := range append(append([]net.Listener{}, localListeners...), remoteListeners...) {
+		if l != nil {
+			l.Close()
+		}
+	}
+	logAt(spec.Logger, LevelInfo, "draining connections to %s for up to %v", spec.Host, spec.DrainTimeout)
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	cutOff := 0
+	select {
+	case <-drained:
+	case <-time.After(spec.DrainTimeout):
+		cutOff = activeConnections(spec)
+		logAt(spec.Logger, LevelWarn, "closing %d connections to %s still open after draining for %v", cutOff, spec.Host, spec.DrainTimeout)
+	}
+	closeConnections()
+	if spec.OnDrain != nil {
+		spec.OnDrain(cutOff)
+	}
+}
+
+// activeConnections counts the connections being tunneled by the forwards of spec
+func activeConnections(spec *Spec) int {
+	active := int64(0)
+	for _, f := range append(append([]Forwarder{}, spec.Forward...), spec.Reverse...) {
+		if f.counters != nil {
+			active += atomic.LoadInt64(&f.counters.active)
+		}
+	}
+	return int(active)
+}
+
+// detachedContext has the values of the context it wraps, such as the span of the trace the tunnel is part of, but
+// isn't done when it is, so that connections outlive it while they're drained
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
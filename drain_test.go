@@ -0,0 +1,88 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDrainConnections(t *testing.T) {
+	drainWith := func(timeout time.Duration) (*Tunnel, chan int) {
+		drained := make(chan int, 1)
+		tun, err := Execute(&Spec{
+			Host:         testServer.Addr,
+			User:         testServer.User,
+			Auth:         testServer.Auth(),
+			Forward:      []Forwarder{Forward(1248, echoServer(t))},
+			DrainTimeout: timeout,
+			OnDrain: func(cutOff int) {
+				drained <- cutOff
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tun, drained
+	}
+	echo := func(conn net.Conn) error {
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		_, err := io.ReadFull(conn, make([]byte, 4))
+		return err
+	}
+
+	t.Run("finishing connections", func(t *testing.T) {
+		tun, drained := drainWith(time.Second * 10)
+		conn, err := net.Dial("tcp", "localhost:1248")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := echo(conn); err != nil {
+			t.Fatal(err)
+		}
+		closed := make(chan struct{})
+		go func() {
+			tun.Close()
+			close(closed)
+		}()
+		time.Sleep(time.Millisecond * 200)
+		if err := echo(conn); err != nil {
+			t.Fatalf("expected the connection to keep working while draining: %v", err)
+		}
+		if c, err := net.DialTimeout("tcp", "localhost:1248", time.Millisecond*200); err == nil {
+			c.Close()
+			t.Fatal("expected new connections to be refused while draining")
+		}
+		conn.Close()
+		select {
+		case <-closed:
+		case <-time.After(time.Second * 5):
+			t.Fatal("expected the tunnel to close once its connections finished")
+		}
+		if cutOff := <-drained; cutOff != 0 {
+			t.Fatalf("expected no connections to be cut off, got %d", cutOff)
+		}
+	})
+
+	t.Run("cut off at the deadline", func(t *testing.T) {
+		tun, drained := drainWith(time.Millisecond * 200)
+		conn, err := net.Dial("tcp", "localhost:1248")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err := echo(conn); err != nil {
+			t.Fatal(err)
+		}
+		tun.Close()
+		if cutOff := <-drained; cutOff != 1 {
+			t.Fatalf("expected 1 connection to be cut off, got %d", cutOff)
+		}
+		if err := echo(conn); err == nil {
+			t.Fatal("expected the connection to have been closed")
+		}
+	})
+}
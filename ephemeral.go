@@ -0,0 +1,77 @@
+package tunnel
+
+import (
+	"net"
+)
+
+// WithName names the forward so that a Tunnel can report where it listens with LocalAddr, or RemoteAddr for a
+// reverse forward
+func (f Forwarder) WithName(name string) Forwarder {
+	f.name = name
+	return f
+}
+
+// displayName is the forward's name, or its destination when unnamed
+func (f Forwarder) displayName() string {
+	if f.name == "" {
+		return f.destination
+	}
+	return f.name
+}
+
+// pinBoundPorts records the ports picked for forwards listening on port 0, or moved off a port in use, so that
+// they listen on the same ports after reconnecting
+func pinBoundPorts(forwarders []Forwarder, listeners []net.Listener) {
+	for i, f := range forwarders {
+		if f.socket != "" || listeners[i] == nil {
+			continue
+		}
+		if addr, ok := listeners[i].Addr().(*net.TCPAddr); ok {
+			forwarders[i].port = addr.Port
+		}
+	}
+}
+
+// LocalAddr returns where the forward with the given name, or if unnamed with the given destination, listens.
+// This is how to find the port picked for a forward on port 0.
+func (t *Tunnel) LocalAddr(name string) (net.Addr, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	addr, ok := t.localAddrs[name]
+	return addr, ok
+}
+
+// RemoteAddr returns where the reverse forward with the given name, or if unnamed with the given destination,
+// listens on the server. This is how to find the port the server picked for a reverse forward on port 0.
+func (t *Tunnel) RemoteAddr(name string) (net.Addr, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	addr, ok := t.remoteAddrs[name]
+	return addr, ok
+}
+
+func (t *Tunnel) setLocalAddrs(forwarders []Forwarder, listeners []net.Listener) {
+	addrs := listenerAddrs(forwarders, listeners)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.localAddrs = addrs
+}
+
+func (t *Tunnel) setRemoteAddrs(forwarders []Forwarder, listeners []net.Listener) {
+	addrs := listenerAddrs(forwarders, listeners)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remoteAddrs = addrs
+}
+
+// listenerAddrs maps the display name of every forwarder listening to where it listens, the first one winning
+func listenerAddrs(forwarders []Forwarder, listeners []net.Listener) map[string]net.Addr {
+	addrs := make(map[string]net.Addr, len(forwarders))
+	for i, f := range forwarders {
+		name := f.displayName()
+		if _, taken := addrs[name]; !taken && listeners[i] != nil {
+			addrs[name] = listeners[i].Addr()
+		}
+	}
+	return addrs
+}
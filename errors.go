@@ -0,0 +1,78 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Causes of failing to establish a tunnel, to branch on with errors.Is, such as to prompt for the password again
+// on ErrAuthFailed or move on to the next bastion on ErrHostUnreachable. The errors returned carry the details
+// as an *AuthError, *UnreachableError, *BindError or *HostKeyChangedError for errors.As.
+var (
+	ErrAuthFailed      = errors.New("authentication failed")
+	ErrHostUnreachable = errors.New("host unreachable")
+	ErrLocalBindFailed = errors.New("local bind failed")
+	ErrHostKeyMismatch = errors.New("host key mismatch")
+)
+
+// AuthError is returned when the server rejects every auth method offered
+type AuthError struct {
+	Host string
+	Err  error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("unable to authenticate to %s: %v", e.Host, e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+func (e *AuthError) Is(target error) bool {
+	return target == ErrAuthFailed
+}
+
+// UnreachableError is returned when a connection to the host, or through the proxy or jump host to it, can't be
+// opened
+type UnreachableError struct {
+	Host string
+	Err  error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("unable to reach %s: %v", e.Host, e.Err)
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UnreachableError) Is(target error) bool {
+	return target == ErrHostUnreachable
+}
+
+// BindError is returned when a local forward can't listen
+type BindError struct {
+	// Port is the port the forward was to listen on, 0 for one on a unix socket or a port picked for it
+	Port int
+	// Local describes where the forward was to listen
+	Local string
+	Err   error
+}
+
+func (e *BindError) Error() string {
+	return fmt.Sprintf("unable to listen on local %s: %v", e.Local, e.Err)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BindError) Is(target error) bool {
+	return target == ErrLocalBindFailed
+}
+
+func (e *HostKeyChangedError) Is(target error) bool {
+	return target == ErrHostKeyMismatch
+}
@@ -0,0 +1,83 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConnectionErrors(t *testing.T) {
+	t.Run("auth failed", func(t *testing.T) {
+		_, err := ExecuteContext(context.Background(), &Spec{
+			Host: testServer.Addr,
+			User: testServer.User,
+			Auth: []ssh.AuthMethod{ssh.Password("the wrong password")},
+		})
+		var authErr *AuthError
+		if !errors.Is(err, ErrAuthFailed) || !errors.As(err, &authErr) || authErr.Host != testServer.Addr {
+			t.Fatalf("expected an auth failure for %s, got %v", testServer.Addr, err)
+		}
+		if errors.Is(err, ErrHostUnreachable) {
+			t.Fatal("expected an auth failure not to be taken for an unreachable host")
+		}
+	})
+	t.Run("host unreachable", func(t *testing.T) {
+		closed, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := closed.Addr().String()
+		closed.Close()
+		_, err = ExecuteContext(context.Background(), &Spec{Host: addr, User: testServer.User, Auth: testServer.Auth()})
+		var unreachable *UnreachableError
+		if !errors.Is(err, ErrHostUnreachable) || !errors.As(err, &unreachable) || unreachable.Host != addr {
+			t.Fatalf("expected %s to be unreachable, got %v", addr, err)
+		}
+	})
+	t.Run("bastion lookup failed", func(t *testing.T) {
+		defer func(l func(string, string, string) (string, []*net.SRV, error)) { lookupSRV = l }(lookupSRV)
+		lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+			return "", nil, errors.New("no such host")
+		}
+		host := "_ssh._tcp.bastions.example.com"
+		_, err := ExecuteContext(context.Background(), &Spec{Host: host, User: testServer.User, Auth: testServer.Auth()})
+		var unreachable *UnreachableError
+		if !errors.Is(err, ErrHostUnreachable) || !errors.As(err, &unreachable) || unreachable.Host != host {
+			t.Fatalf("expected %s to be unreachable, got %v", host, err)
+		}
+	})
+	t.Run("proxy failed", func(t *testing.T) {
+		for _, proxy := range []string{"ftp://proxy:21", "socks5://127.0.0.1:1"} {
+			_, err := ExecuteContext(context.Background(), &Spec{
+				Host:     testServer.Addr,
+				User:     testServer.User,
+				Auth:     testServer.Auth(),
+				ProxyURL: proxy,
+			})
+			if !errors.Is(err, ErrHostUnreachable) {
+				t.Fatalf("expected %s through %s to be unreachable, got %v", testServer.Addr, proxy, err)
+			}
+		}
+	})
+	t.Run("local bind failed", func(t *testing.T) {
+		taken, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer taken.Close()
+		port := taken.Addr().(*net.TCPAddr).Port
+		_, err = ExecuteContext(context.Background(), &Spec{
+			Host:    testServer.Addr,
+			User:    testServer.User,
+			Auth:    testServer.Auth(),
+			Forward: []Forwarder{Forward(port, "localhost:80")},
+		})
+		var bindErr *BindError
+		if !errors.Is(err, ErrLocalBindFailed) || !errors.As(err, &bindErr) || bindErr.Port != port {
+			t.Fatalf("expected binding port %d to fail, got %v", port, err)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package tunnel
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// eventBuffer is how many events are held for a reader that's behind before new ones are dropped
+const eventBuffer = 256
+
+// EventType is what happened on a forward
+type EventType int
+
+const (
+	// ListenerStarted is sent each time a forward starts listening, including after a reconnect
+	ListenerStarted EventType = iota
+	// ConnAccepted is sent for every connection accepted to be tunneled
+	ConnAccepted
+	// ConnClosed is sent once a tunneled connection has finished, with the bytes it transferred
+	ConnClosed
+	// DialFailed is sent when an accepted connection couldn't be tunneled as its destination couldn't be reached
+	DialFailed
+	// ListenerStopped is sent each time a forward stops listening, as when the connection is lost. Those sent as
+	// the tunnel stops may be missed as the channel is closed.
+	ListenerStopped
+)
+
+func (e EventType) String() string {
+	switch e {
+	case ListenerStarted:
+		return "ListenerStarted"
+	case ConnAccepted:
+		return "ConnAccepted"
+	case ConnClosed:
+		return "ConnClosed"
+	case DialFailed:
+		return "DialFailed"
+	case ListenerStopped:
+		return "ListenerStopped"
+	}
+	return "EventType(" + strconv.Itoa(int(e)) + ")"
+}
+
+// Event is something that happened on one of a tunnel's forwards
+type Event struct {
+	Type EventType
+	// Name is the forward's name, its destination unless set with WithName
+	Name string
+	Time time.Time
+	// Local is the address the forward listens on, on the server for a reverse forward
+	Local string
+	// Peer is the address the connection came from, unset for ListenerStarted and ListenerStopped
+	Peer string
+	// BytesIn were received from the destination and BytesOut sent to it, set for ConnClosed
+	BytesIn  int64
+	BytesOut int64
+	// Err is why the destination couldn't be dialed, set for DialFailed
+	Err error
+}
+
+// Events returns the events of the forwards of a tunnel started with Execute. Events are dropped rather than
+// hold up forwarding when the channel isn't kept up with; it's closed once the tunnel has stopped.
+func (t *Tunnel) Events() <-chan Event {
+	if t.events == nil {
+		return nil
+	}
+	return t.events.c
+}
+
+// eventSink sends events without blocking and stops once closed. A nil *eventSink sends nothing.
+type eventSink struct {
+	mu     sync.Mutex
+	c      chan Event
+	closed bool
+}
+
+func newEventSink() *eventSink {
+	return &eventSink{c: make(chan Event, eventBuffer)}
+}
+
+func (s *eventSink) emit(e Event) {
+	if s == nil {
+		return
+	}
+	e.Time = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.c <- e:
+	default:
+	}
+}
+
+func (s *eventSink) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.c)
+	}
+}
+
+// connectionEvent is an event of forwarder's about conn, accepted on its listener
+func connectionEvent(typ EventType, forwarder Forwarder, conn net.Conn) Event {
+	return Event{Type: typ, Name: forwarder.displayName(), Local: conn.LocalAddr().String(), Peer: conn.RemoteAddr().String()}
+}
@@ -0,0 +1,84 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	closed, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := closed.Addr().String()
+	closed.Close()
+
+	tun, err := Execute(&Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: testServer.Auth(),
+		Forward: []Forwarder{
+			Forward(0, echoServer(t)).WithName("echo"),
+			Forward(0, unreachable).WithName("down"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() Event {
+		select {
+		case e := <-tun.Events():
+			return e
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for an event")
+		}
+		return Event{}
+	}
+	started := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		e := next()
+		if e.Type != ListenerStarted {
+			t.Fatalf("expected the listeners to start first, got %v", e.Type)
+		}
+		started[e.Name] = true
+	}
+	if !started["echo"] || !started["down"] {
+		t.Fatalf("unexpected listeners started: %v", started)
+	}
+
+	addr, _ := tun.LocalAddr("echo")
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	conn.Read(make([]byte, 5))
+	conn.Close()
+	if e := next(); e.Type != ConnAccepted || e.Name != "echo" || e.Peer != conn.LocalAddr().String() {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if e := next(); e.Type != ConnClosed || e.Name != "echo" || e.BytesIn != 5 || e.BytesOut != 5 {
+		t.Fatalf("unexpected event %+v", e)
+	}
+
+	addr, _ = tun.LocalAddr("down")
+	conn, err = net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if e := next(); e.Type != ConnAccepted || e.Name != "down" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if e := next(); e.Type != DialFailed || e.Name != "down" || e.Err == nil {
+		t.Fatalf("unexpected event %+v", e)
+	}
+
+	tun.Close()
+	for e := range tun.Events() {
+		if e.Type != ListenerStopped {
+			t.Fatalf("unexpected event %+v after closing", e)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+)
+
+// FailurePolicy is what happens to a connection when some of its local forwards can't listen
+type FailurePolicy int
+
+const (
+	// AllOrNothing gives up on the connection unless every local forward listens, as by default
+	AllOrNothing FailurePolicy = iota
+	// BestEffort brings up the local forwards that can listen and reports the others with ForwardStatus, OnForward
+	// and Stats; the connection is only given up on when none can
+	BestEffort
+)
+
+// ForwardStatus is the state of a local forward's listener
+type ForwardStatus struct {
+	// Name is the forward's name, when it has one
+	Name string
+	// Local is where the forward listens, such as port 8080
+	Local       string
+	Destination string
+	Bound       bool
+	// Err is why the forward couldn't listen when not Bound
+	Err error
+}
+
+// listenForForwards binds the local forwards of spec by its failure policy, returning why each one that couldn't
+// listen didn't; its listener is nil
+func listenForForwards(n NetworkingDevice, spec *Spec) ([]net.Listener, []error, error) {
+	if spec.FailurePolicy != BestEffort {
+		listeners, err := listenForAll(n, spec.Forward, spec.Logger)
+		return listeners, make([]error, len(listeners)), err
+	}
+	listeners, errs := listenConcurrently(n, spec.Forward, spec.Logger)
+	if len(listeners) == 0 {
+		return listeners, errs, nil
+	}
+	for i, l := range listeners {
+		if l != nil {
+			continue
+		}
+		logAt(spec.Logger, LevelWarn, "local %s of %s could not be opened, carrying on without it: %v", spec.Forward[i].local(), spec.Host, errs[i])
+	}
+	for _, l := range listeners {
+		if l != nil {
+			return listeners, errs, nil
+		}
+	}
+	return nil, errs, fmt.Errorf("could not open any local forward... closing down: %w", errs[0])
+}
+
+func forwardStatuses(forwarders []Forwarder, bindErrs []error) []ForwardStatus {
+	statuses := make([]ForwardStatus, len(forwarders))
+	for i, f := range forwarders {
+		statuses[i] = ForwardStatus{
+			Name:        f.name,
+			Local:       f.local(),
+			Destination: f.destination,
+			Bound:       bindErrs[i] == nil,
+			Err:         bindErrs[i],
+		}
+	}
+	return statuses
+}
+
+func reportForward(spec *Spec, t *Tunnel, statuses []ForwardStatus) {
+	if t != nil {
+		t.setForwardStatus(statuses)
+	}
+	if spec.OnForward != nil {
+		spec.OnForward(statuses)
+	}
+}
+
+// ForwardStatus returns the status of the local forwards of a tunnel started with Execute as of the latest
+// connection
+func (t *Tunnel) ForwardStatus() []ForwardStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ForwardStatus(nil), t.forward...)
+}
+
+func (t *Tunnel) setForwardStatus(statuses []ForwardStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.forward = statuses
+}
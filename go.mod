@@ -1,12 +1,25 @@
 module github.com/arunsworld/go-tunnel
 
+go 1.17
+
 require (
+	filippo.io/age v1.0.0
+	github.com/BurntSushi/toml v1.2.1
 	github.com/arunsworld/nursery v0.6.0
 	github.com/gliderlabs/ssh v0.3.3
+	github.com/pkg/sftp v1.13.5
 	github.com/urfave/cli/v2 v2.25.3
 	golang.org/x/crypto v0.9.0
+	golang.org/x/sys v0.8.0
 	golang.org/x/term v0.8.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
-go 1.13
+require (
+	filippo.io/edwards25519 v1.0.0-rc.1 // indirect
+	github.com/anmitsu/go-shlex v0.0.0-20200514113438-38f4b401e2be // indirect
+	github.com/cpuguy83/go-md2man/v2 v2.0.2 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/russross/blackfriday/v2 v2.1.0 // indirect
+	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
+)
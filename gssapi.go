@@ -0,0 +1,47 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	gssapiMu       sync.Mutex
+	gssapiProvider func() (ssh.GSSAPIClient, error)
+)
+
+// RegisterGSSAPIProvider sets where GSSAPIAuth gets a GSS-API client holding the user's Kerberos credentials from,
+// such as one reading the credential cache with gokrb5 or using SSPI on windows. No Kerberos implementation is
+// built in.
+func RegisterGSSAPIProvider(provider func() (ssh.GSSAPIClient, error)) {
+	gssapiMu.Lock()
+	defer gssapiMu.Unlock()
+	gssapiProvider = provider
+}
+
+// GSSAPIAuth returns an AuthMethod logging in to host with Kerberos through a client from the registered
+// provider. host is the server's name as in its host/ service principal; a port is ignored.
+func GSSAPIAuth(host string) (ssh.AuthMethod, error) {
+	gssapiMu.Lock()
+	provider := gssapiProvider
+	gssapiMu.Unlock()
+	if provider == nil {
+		return nil, errors.New("gssapi auth needs a Kerberos client, none is registered")
+	}
+	client, err := provider()
+	if err != nil {
+		return nil, err
+	}
+	return GSSAPIClientAuth(client, host), nil
+}
+
+// GSSAPIClientAuth is GSSAPIAuth with client in place of the registered provider
+func GSSAPIClientAuth(client ssh.GSSAPIClient, host string) ssh.AuthMethod {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return ssh.GSSAPIWithMICAuthMethod(client, host)
+}
@@ -0,0 +1,115 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeGSSAPIClient stands in for Kerberos: its token names the target and its MIC is the field prefixed
+type fakeGSSAPIClient struct{}
+
+func (fakeGSSAPIClient) InitSecContext(target string, token []byte, isGSSDelegCreds bool) ([]byte, bool, error) {
+	return []byte("ticket for " + target), false, nil
+}
+
+func (fakeGSSAPIClient) GetMIC(micField []byte) ([]byte, error) {
+	return append([]byte("mic:"), micField...), nil
+}
+
+func (fakeGSSAPIClient) DeleteSecContext() error { return nil }
+
+type fakeGSSAPIServer struct {
+	target string
+}
+
+func (s fakeGSSAPIServer) AcceptSecContext(token []byte) ([]byte, string, bool, error) {
+	if string(token) != "ticket for host@"+s.target {
+		return nil, "", false, errors.New("unexpected ticket " + string(token))
+	}
+	return nil, "testuser@EXAMPLE.COM", false, nil
+}
+
+func (fakeGSSAPIServer) VerifyMIC(micField []byte, micToken []byte) error {
+	if !bytes.Equal(micToken, append([]byte("mic:"), micField...)) {
+		return errors.New("bad mic")
+	}
+	return nil
+}
+
+func (fakeGSSAPIServer) DeleteSecContext() error { return nil }
+
+// gssapiServer accepts connections authenticating with the fake GSS-API client only
+func gssapiServer(t *testing.T) string {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, _, _ := net.SplitHostPort(l.Addr().String())
+	config := &ssh.ServerConfig{
+		GSSAPIWithMICConfig: &ssh.GSSAPIWithMICConfig{
+			AllowLogin: func(conn ssh.ConnMetadata, srcName string) (*ssh.Permissions, error) {
+				return nil, nil
+			},
+			Server: fakeGSSAPIServer{target: host},
+		},
+	}
+	config.AddHostKey(signer)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					ch.Reject(ssh.Prohibited, "no channels")
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestGSSAPIAuth(t *testing.T) {
+	addr := gssapiServer(t)
+	if _, err := GSSAPIAuth(addr); err == nil {
+		t.Fatal("expected an error without a registered provider")
+	}
+
+	RegisterGSSAPIProvider(func() (ssh.GSSAPIClient, error) { return fakeGSSAPIClient{}, nil })
+	defer RegisterGSSAPIProvider(nil)
+	auth, err := GSSAPIAuth(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun, err := Dial(&Spec{
+		Host: addr,
+		User: "testuser",
+		Auth: []ssh.AuthMethod{auth},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun.Close()
+}
@@ -0,0 +1,112 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// connectionAttemptDelay is how long an attempt gets before the next address is tried as well (RFC 8305 5)
+const connectionAttemptDelay = time.Millisecond * 250
+
+// WithDualStack resolves the destination locally and races its IPv6 and IPv4 addresses through the bastion
+// (RFC 8305) instead of leaving resolution to the bastion, which tries addresses one after the other. Only use
+// it for names that resolve the same locally as they do behind the bastion.
+func (f Forwarder) WithDualStack() Forwarder {
+	f.dualStack = true
+	return f
+}
+
+// bastionDialer races the address families of the bastion's name; the standard dialer implements RFC 8305
+func bastionDialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{Timeout: timeout, FallbackDelay: connectionAttemptDelay}
+}
+
+// dialDualStack resolves destination locally and races its addresses over dial. If the name doesn't resolve
+// locally it is passed on for the other side to resolve.
+func dialDualStack(ctx context.Context, dial func(addr string) (net.Conn, error), destination string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(destination)
+	if err != nil || net.ParseIP(host) != nil {
+		return dial(destination)
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(ips) == 0 {
+		return dial(destination)
+	}
+	return raceDial(interleaveFamilies(ips, port), dial)
+}
+
+// interleaveFamilies orders addresses alternating between IPv6 and IPv4, IPv6 first (RFC 8305 4)
+func interleaveFamilies(ips []net.IPAddr, port string) []string {
+	var v6, v4 []string
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip.String(), port)
+		if ip.IP.To4() == nil {
+			v6 = append(v6, addr)
+		} else {
+			v4 = append(v4, addr)
+		}
+	}
+	addrs := make([]string, 0, len(ips))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			addrs = append(addrs, v6[i])
+		}
+		if i < len(v4) {
+			addrs = append(addrs, v4[i])
+		}
+	}
+	return addrs
+}
+
+// raceDial starts an attempt per address, each connectionAttemptDelay after the previous or as soon as the
+// previous fails, and returns the first connection established; the others are closed when they complete
+func raceDial(addrs []string, dial func(addr string) (net.Conn, error)) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	next, pending := 0, 0
+	var nextAttempt <-chan time.Time
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dial(addr)
+			results <- result{conn: conn, err: err}
+		}()
+		nextAttempt = nil
+		if next < len(addrs) {
+			nextAttempt = time.After(connectionAttemptDelay)
+		}
+	}
+	start()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if r := <-results; r.err == nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if pending == 0 && next < len(addrs) {
+				start()
+			}
+		case <-nextAttempt:
+			start()
+		}
+	}
+	return nil, firstErr
+}
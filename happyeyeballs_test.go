@@ -0,0 +1,61 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	ips := []net.IPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("10.0.0.2")},
+		{IP: net.ParseIP("::1")},
+	}
+	got := interleaveFamilies(ips, "22")
+	want := []string{"[::1]:22", "10.0.0.1:22", "10.0.0.2:22"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestRaceDial(t *testing.T) {
+	t.Run("unreachable family doesn't hang", func(t *testing.T) {
+		hang := make(chan struct{})
+		defer close(hang)
+		dial := func(addr string) (net.Conn, error) {
+			if addr == "[::1]:22" {
+				<-hang
+				return nil, errors.New("timed out")
+			}
+			c, _ := net.Pipe()
+			return c, nil
+		}
+		start := time.Now()
+		conn, err := raceDial([]string{"[::1]:22", "10.0.0.1:22"}, dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("took %v to fall back", elapsed)
+		}
+	})
+	t.Run("failure moves on immediately", func(t *testing.T) {
+		var dialed []string
+		dial := func(addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return nil, errors.New("refused " + addr)
+		}
+		start := time.Now()
+		_, err := raceDial([]string{"a:1", "b:1", "c:1"}, dial)
+		if err == nil || err.Error() != "refused a:1" {
+			t.Fatalf("expected the first error, got %v", err)
+		}
+		if len(dialed) != 3 || time.Since(start) > connectionAttemptDelay {
+			t.Fatalf("expected all addresses to be tried without waiting, got %v in %v", dialed, time.Since(start))
+		}
+	})
+}
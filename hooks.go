@@ -0,0 +1,52 @@
+package tunnel
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// RemoteCommands returns an OnConnect or OnDisconnect hook running commands on the remote host one after the
+// other, such as sudo systemctl start service, failing at the first to exit with an error
+func RemoteCommands(commands ...string) func(*ssh.Client) error {
+	return func(client *ssh.Client) error {
+		for _, command := range commands {
+			session, err := client.NewSession()
+			if err != nil {
+				return fmt.Errorf("unable to run %s: %v", command, err)
+			}
+			output, err := session.CombinedOutput(command)
+			session.Close()
+			if err != nil {
+				if out := strings.TrimSpace(string(output)); out != "" {
+					return fmt.Errorf("%s failed: %v: %s", command, err, out)
+				}
+				return fmt.Errorf("%s failed: %v", command, err)
+			}
+		}
+		return nil
+	}
+}
+
+// onConnect runs spec.OnConnect on a connection just established, closing it when the hook fails
+func onConnect(spec *Spec, client *ssh.Client) error {
+	if spec.OnConnect == nil {
+		return nil
+	}
+	if err := spec.OnConnect(client); err != nil {
+		client.Close()
+		return fmt.Errorf("on connecting to %s: %v", spec.Host, err)
+	}
+	return nil
+}
+
+// onDisconnect runs spec.OnDisconnect on a connection about to be closed
+func onDisconnect(spec *Spec, client *ssh.Client) {
+	if spec.OnDisconnect == nil {
+		return
+	}
+	if err := spec.OnDisconnect(client); err != nil {
+		logAt(spec.Logger, LevelWarn, "on disconnecting from %s: %v", spec.Host, err)
+	}
+}
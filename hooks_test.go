@@ -0,0 +1,42 @@
+package tunnel
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConnectHooks(t *testing.T) {
+	var calls []string
+	run := RemoteCommands("systemctl start service", "knock")
+	spec := &Spec{
+		Host:    testServer.Addr,
+		User:    testServer.User,
+		Auth:    testServer.Auth(),
+		Forward: []Forwarder{Forward(0, echoServer(t))},
+		OnConnect: func(client *ssh.Client) error {
+			calls = append(calls, "connect")
+			return run(client)
+		},
+		OnDisconnect: func(client *ssh.Client) error {
+			calls = append(calls, "disconnect")
+			return run(client)
+		},
+	}
+	tun, err := Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun.Close()
+	if strings.Join(calls, ",") != "connect,disconnect" {
+		t.Fatalf("unexpected hook calls %v", calls)
+	}
+
+	spec.OnConnect = func(*ssh.Client) error { return errors.New("service wouldn't start") }
+	spec.OnDisconnect = nil
+	if _, err := Execute(spec); err == nil || !strings.Contains(err.Error(), "service wouldn't start") {
+		t.Fatalf("expected the failing hook to stop the tunnel, got %v", err)
+	}
+}
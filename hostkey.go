@@ -0,0 +1,144 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyChangedError is returned when a host presents a key different from the one previously seen for it,
+// which is either a legitimately rotated key or someone intercepting the connection
+type HostKeyChangedError struct {
+	Host string
+	// Old holds the SHA256 fingerprints of the previously seen keys
+	Old []string
+	// New is the SHA256 fingerprint of the presented key
+	New string
+	// File is the known hosts file holding the old keys
+	File string
+}
+
+func (e *HostKeyChangedError) Error() string {
+	return fmt.Sprintf(`
+@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
+@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @
+@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
+IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY!
+Someone could be eavesdropping on you right now (man-in-the-middle attack)!
+It is also possible that the host key of %s has just been changed.
+Previously seen fingerprint(s): %s
+Presented fingerprint:          %s
+Known hosts file:               %s
+Refusing to connect. Accept the new key explicitly only once you've verified it.`,
+		e.Host, strings.Join(e.Old, ", "), e.New, e.File)
+}
+
+// TrustOnFirstUse returns a host key callback backed by an OpenSSH format known hosts file: keys of hosts not
+// seen before are recorded, keys of known hosts must match. A changed key fails with a *HostKeyChangedError
+// unless acceptChanged is set, in which case the old key is replaced.
+func TrustOnFirstUse(file string, acceptChanged bool) ssh.HostKeyCallback {
+	tofu := &knownHostsFile{file: file, acceptChanged: acceptChanged}
+	return tofu.check
+}
+
+type knownHostsFile struct {
+	mu            sync.Mutex
+	file          string
+	acceptChanged bool
+}
+
+func (k *knownHostsFile) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.ensureExists(); err != nil {
+		return err
+	}
+	callback, err := knownhosts.New(k.file)
+	if err != nil {
+		return fmt.Errorf("unable to read known hosts file %s: %v", k.file, err)
+	}
+	err = callback(hostname, remote, key)
+	keyErr, ok := err.(*knownhosts.KeyError)
+	if !ok {
+		return err
+	}
+	if len(keyErr.Want) == 0 {
+		return k.add(hostname, remote, key)
+	}
+	if !k.acceptChanged {
+		changed := &HostKeyChangedError{
+			Host: hostname,
+			New:  ssh.FingerprintSHA256(key),
+			File: k.file,
+		}
+		for _, w := range keyErr.Want {
+			changed.Old = append(changed.Old, ssh.FingerprintSHA256(w.Key))
+		}
+		return changed
+	}
+	if err := k.remove(keyErr.Want); err != nil {
+		return err
+	}
+	return k.add(hostname, remote, key)
+}
+
+func (k *knownHostsFile) ensureExists() error {
+	if _, err := os.Stat(k.file); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(k.file), 0700); err != nil {
+		return fmt.Errorf("unable to create known hosts file %s: %v", k.file, err)
+	}
+	f, err := os.OpenFile(k.file, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to create known hosts file %s: %v", k.file, err)
+	}
+	return f.Close()
+}
+
+func (k *knownHostsFile) add(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	addresses := []string{knownhosts.Normalize(hostname)}
+	if remote != nil && knownhosts.Normalize(remote.String()) != addresses[0] {
+		addresses = append(addresses, knownhosts.Normalize(remote.String()))
+	}
+	f, err := os.OpenFile(k.file, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to record host key in %s: %v", k.file, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(knownhosts.Line(addresses, key) + "\n"); err != nil {
+		return fmt.Errorf("unable to record host key in %s: %v", k.file, err)
+	}
+	return nil
+}
+
+// remove deletes the lines holding the given keys from the known hosts file
+func (k *knownHostsFile) remove(keys []knownhosts.KnownKey) error {
+	contents, err := ioutil.ReadFile(k.file)
+	if err != nil {
+		return err
+	}
+	stale := map[int]bool{}
+	for _, w := range keys {
+		if w.Filename != k.file {
+			return errors.New("host key is recorded in another file: " + w.Filename)
+		}
+		stale[w.Line] = true
+	}
+	lines := strings.SplitAfter(string(contents), "\n")
+	kept := make([]string, 0, len(lines))
+	for i, l := range lines {
+		if !stale[i+1] {
+			kept = append(kept, l)
+		}
+	}
+	return ioutil.WriteFile(k.file, []byte(strings.Join(kept, "")), 0600)
+}
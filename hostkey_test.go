@@ -0,0 +1,65 @@
+package tunnel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knownhosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "nested", "known_hosts")
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	original, rotated := newTestHostKey(t), newTestHostKey(t)
+
+	check := TrustOnFirstUse(file, false)
+	if err := check("bastion:22", remote, original); err != nil {
+		t.Fatalf("first use should be trusted: %v", err)
+	}
+	if err := check("bastion:22", remote, original); err != nil {
+		t.Fatalf("known key should be accepted: %v", err)
+	}
+
+	err = check("bastion:22", remote, rotated)
+	changed, ok := err.(*HostKeyChangedError)
+	if !ok || !errors.Is(err, ErrHostKeyMismatch) {
+		t.Fatalf("expected a HostKeyChangedError, got %v", err)
+	}
+	if changed.New != ssh.FingerprintSHA256(rotated) || len(changed.Old) != 1 || changed.Old[0] != ssh.FingerprintSHA256(original) {
+		t.Fatalf("unexpected fingerprints: %+v", changed)
+	}
+
+	override := TrustOnFirstUse(file, true)
+	if err := override("bastion:22", remote, rotated); err != nil {
+		t.Fatalf("changed key should be accepted with override: %v", err)
+	}
+	if err := check("bastion:22", remote, rotated); err != nil {
+		t.Fatalf("replaced key should now be known: %v", err)
+	}
+	if err := check("bastion:22", remote, original); err == nil {
+		t.Fatal("old key should no longer be accepted")
+	}
+}
@@ -0,0 +1,111 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// IdentityKeys returns an AuthMethod offering the private keys in paths in turn, as ssh does with several
+// IdentityFile; a path that's a directory, such as ~/.ssh, offers the private keys in it by name. Keys that can't
+// be read, or decrypted with passphrase, are skipped with a warning and the one the server accepts is logged.
+func IdentityKeys(paths []string, passphrase string, logger Logger) (ssh.AuthMethod, error) {
+	if logger == nil {
+		logger = EmptyLogger()
+	}
+	var signers []ssh.Signer
+	for _, path := range paths {
+		path = expandHome(path)
+		info, err := os.Stat(path)
+		if err != nil {
+			logAt(logger, LevelWarn, "skipping identity %s: %v", path, err)
+			continue
+		}
+		if !info.IsDir() {
+			if signer := loadIdentity(path, passphrase, logger, true); signer != nil {
+				signers = append(signers, signer)
+			}
+			continue
+		}
+		// sorted by name
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			logAt(logger, LevelWarn, "skipping identities in %s: %v", path, err)
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasSuffix(entry.Name(), ".pub") {
+				continue
+			}
+			if signer := loadIdentity(filepath.Join(path, entry.Name()), passphrase, logger, false); signer != nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no usable private keys in %s", strings.Join(paths, ", "))
+	}
+	return ssh.PublicKeys(signers...), nil
+}
+
+// loadIdentity reads the private key in file, returning nil when it can't be used. A file that isn't a private
+// key at all is only warned about when named rather than found in a directory, as known_hosts would be.
+func loadIdentity(file, passphrase string, logger Logger, named bool) ssh.Signer {
+	buffer, err := ioutil.ReadFile(file)
+	if err != nil {
+		logAt(logger, LevelWarn, "skipping identity %s: %v", file, err)
+		return nil
+	}
+	signer, err := ssh.ParsePrivateKey(buffer)
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case errors.As(err, &missing) && passphrase == "":
+		logAt(logger, LevelWarn, "skipping identity %s: it's protected by a passphrase and none is configured", file)
+		return nil
+	case errors.As(err, &missing):
+		if signer, err = ssh.ParsePrivateKeyWithPassphrase(buffer, []byte(passphrase)); err != nil {
+			logAt(logger, LevelWarn, "skipping identity %s: %v", file, err)
+			return nil
+		}
+	case err != nil:
+		if named {
+			logAt(logger, LevelWarn, "skipping identity %s: %v", file, err)
+		}
+		return nil
+	}
+	logged := identitySigner{Signer: signer, file: file, logger: logger}
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok {
+		return identityAlgorithmSigner{identitySigner: logged, algorithmSigner: algorithmSigner}
+	}
+	return logged
+}
+
+// identitySigner logs its file when asked to sign, which only happens once the server has accepted its key
+type identitySigner struct {
+	ssh.Signer
+	file   string
+	logger Logger
+}
+
+func (s identitySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	logAt(s.logger, LevelInfo, "authenticating with identity %s", s.file)
+	return s.Signer.Sign(rand, data)
+}
+
+// identityAlgorithmSigner is an identitySigner keeping the signature algorithms of its key, such as rsa-sha2-256
+// for an RSA key
+type identityAlgorithmSigner struct {
+	identitySigner
+	algorithmSigner ssh.AlgorithmSigner
+}
+
+func (s identityAlgorithmSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	logAt(s.logger, LevelInfo, "authenticating with identity %s", s.file)
+	return s.algorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
@@ -0,0 +1,38 @@
+package tunnel
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestIdentityKeys(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "known_hosts"), []byte("not a key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := testServer.WriteClientKey(filepath.Join(dir, "id_other"), "wrong"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testServer.WriteClientKey(filepath.Join(dir, "id_test"), "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := IdentityKeys([]string{filepath.Join(dir, "missing"), filepath.Join(dir, "known_hosts")}, "", nil); err == nil {
+		t.Fatal("expected an error without usable keys")
+	}
+	if _, err := IdentityKeys([]string{dir}, "", nil); err == nil {
+		t.Fatal("expected an error without the passphrase")
+	}
+	auth, err := IdentityKeys([]string{filepath.Join(dir, "missing"), dir}, "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun, err := Dial(&Spec{Host: testServer.Addr, User: testServer.User, Auth: []ssh.AuthMethod{auth}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun.Close()
+}
@@ -0,0 +1,45 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// WithIdleTimeout closes connections through the forward once nothing has been transferred either way for
+// timeout, freeing the channel and socket of connections clients abandoned; it overrides Spec.IdleTimeout
+func (f Forwarder) WithIdleTimeout(timeout time.Duration) Forwarder {
+	f.idleTimeout = timeout
+	return f
+}
+
+// activityWriter records when it was last written to in last, as unix nanoseconds; accessed atomically
+type activityWriter struct {
+	w    io.Writer
+	last *int64
+}
+
+func (a *activityWriter) Write(b []byte) (int, error) {
+	atomic.StoreInt64(a.last, time.Now().UnixNano())
+	return a.w.Write(b)
+}
+
+// closeWhenIdle calls onIdle once last hasn't moved for timeout, unless ctx is done first
+func closeWhenIdle(ctx context.Context, timeout time.Duration, last *int64, onIdle func()) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(last)))
+		if idle >= timeout {
+			onIdle()
+			return
+		}
+		timer.Reset(timeout - idle)
+	}
+}
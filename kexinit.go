@@ -0,0 +1,240 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const msgKexInit = 20
+
+// kexInit holds the algorithm name-lists of an SSH_MSG_KEXINIT (RFC 4253 7.1)
+type kexInit struct {
+	KexAlgos                []string
+	HostKeyAlgos            []string
+	CiphersClientServer     []string
+	CiphersServerClient     []string
+	MACsClientServer        []string
+	MACsServerClient        []string
+	CompressionClientServer []string
+	CompressionServerClient []string
+}
+
+// kexObservingConn watches the cleartext start of an ssh connection and records the version strings and
+// initial KEXINIT of both sides, which crypto/ssh does not expose
+type kexObservingConn struct {
+	net.Conn
+	mu     sync.Mutex
+	client kexStream
+	server kexStream
+	// 1 once the direction's stream is done, so that the rest of the connection skips the lock; accessed atomically
+	clientDone int32
+	serverDone int32
+}
+
+func observeKex(conn net.Conn) *kexObservingConn {
+	return &kexObservingConn{Conn: conn}
+}
+
+func (c *kexObservingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if atomic.LoadInt32(&c.serverDone) == 0 {
+		c.observe(&c.server, &c.serverDone, b[:n])
+	}
+	return n, err
+}
+
+func (c *kexObservingConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.clientDone) == 0 {
+		c.observe(&c.client, &c.clientDone, b)
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *kexObservingConn) observe(s *kexStream, done *int32, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.observe(b)
+	if s.done {
+		atomic.StoreInt32(done, 1)
+	}
+}
+
+// observed returns what has been seen of each side so far; the kexInits are nil if not (yet) seen
+func (c *kexObservingConn) observed() (clientVersion, serverVersion string, client, server *kexInit) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client.version, c.server.version, c.client.kexInit, c.server.kexInit
+}
+
+// kexStream accumulates one direction of the connection until its version line and first packet are parsed
+type kexStream struct {
+	buf     []byte
+	version string
+	kexInit *kexInit
+	done    bool
+}
+
+// largest packet we're willing to buffer while waiting for the KEXINIT
+const maxKexInitPacket = 35000
+
+func (s *kexStream) observe(b []byte) {
+	if s.done || len(b) == 0 {
+		return
+	}
+	s.buf = append(s.buf, b...)
+	for s.version == "" {
+		// servers may send other lines before the version line (RFC 4253 4.2)
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 {
+			if len(s.buf) > maxKexInitPacket {
+				s.stop()
+			}
+			return
+		}
+		line := strings.TrimRight(string(s.buf[:i]), "\r")
+		s.buf = s.buf[i+1:]
+		if strings.HasPrefix(line, "SSH-") {
+			s.version = line
+		}
+	}
+	if len(s.buf) < 5 {
+		return
+	}
+	length := int(binary.BigEndian.Uint32(s.buf))
+	if length > maxKexInitPacket {
+		s.stop()
+		return
+	}
+	if len(s.buf) < 4+length {
+		return
+	}
+	padding := int(s.buf[4])
+	if padding+1 <= length {
+		if k, err := parseKexInit(s.buf[5 : 4+length-padding]); err == nil {
+			s.kexInit = k
+		}
+	}
+	s.stop()
+}
+
+func (s *kexStream) stop() {
+	s.done = true
+	s.buf = nil
+}
+
+func parseKexInit(payload []byte) (*kexInit, error) {
+	if len(payload) < 17 || payload[0] != msgKexInit {
+		return nil, errors.New("not a kexinit message")
+	}
+	rest := payload[17:]
+	lists := make([][]string, 8)
+	for i := range lists {
+		if len(rest) < 4 {
+			return nil, errors.New("kexinit message is truncated")
+		}
+		l := int(binary.BigEndian.Uint32(rest))
+		rest = rest[4:]
+		if l > len(rest) {
+			return nil, errors.New("kexinit message is truncated")
+		}
+		if l > 0 {
+			lists[i] = strings.Split(string(rest[:l]), ",")
+		}
+		rest = rest[l:]
+	}
+	return &kexInit{
+		KexAlgos:                lists[0],
+		HostKeyAlgos:            lists[1],
+		CiphersClientServer:     lists[2],
+		CiphersServerClient:     lists[3],
+		MACsClientServer:        lists[4],
+		MACsServerClient:        lists[5],
+		CompressionClientServer: lists[6],
+		CompressionServerClient: lists[7],
+	}, nil
+}
+
+// negotiate picks the first client algorithm the server supports (RFC 4253 7.1), ignoring the pseudo
+// algorithms used to signal extensions
+func negotiate(client, server []string) string {
+	for _, c := range client {
+		if strings.HasPrefix(c, "ext-info-") || strings.HasPrefix(c, "kex-strict-") {
+			continue
+		}
+		for _, s := range server {
+			if c == s {
+				return c
+			}
+		}
+	}
+	return ""
+}
+
+// aeadCiphers carry their own integrity protection, so no MAC is negotiated for them
+var aeadCiphers = map[string]bool{
+	"chacha20-poly1305@openssh.com": true,
+	"aes128-gcm@openssh.com":        true,
+	"aes256-gcm@openssh.com":        true,
+}
+
+func negotiateMAC(cipher string, client, server []string) string {
+	if aeadCiphers[cipher] {
+		return "implicit (" + cipher + ")"
+	}
+	return negotiate(client, server)
+}
+
+// AlgorithmMismatch is a part of the handshake for which client and server have no algorithm in common
+type AlgorithmMismatch struct {
+	// Kind names what is negotiated, e.g. "host key"
+	Kind   string
+	Client []string
+	Server []string
+}
+
+// AlgorithmMismatchError is returned when the handshake fails because client and server have no algorithm in
+// common for one or more parts of it
+type AlgorithmMismatchError struct {
+	Host       string
+	Mismatches []AlgorithmMismatch
+}
+
+func (e *AlgorithmMismatchError) Error() string {
+	parts := make([]string, 0, len(e.Mismatches))
+	for _, m := range e.Mismatches {
+		parts = append(parts, fmt.Sprintf("no common %s algorithm: client offered [%s], server offered [%s]",
+			m.Kind, strings.Join(m.Client, ", "), strings.Join(m.Server, ", ")))
+	}
+	return fmt.Sprintf("unable to negotiate with %s: %s", e.Host, strings.Join(parts, "; "))
+}
+
+// algorithmMismatches lists the parts of the handshake the two KEXINITs can't agree on
+func algorithmMismatches(client, server *kexInit) []AlgorithmMismatch {
+	var mismatches []AlgorithmMismatch
+	check := func(kind string, c, s []string) string {
+		agreed := negotiate(c, s)
+		if agreed == "" {
+			mismatches = append(mismatches, AlgorithmMismatch{Kind: kind, Client: c, Server: s})
+		}
+		return agreed
+	}
+	check("key exchange", client.KexAlgos, server.KexAlgos)
+	check("host key", client.HostKeyAlgos, server.HostKeyAlgos)
+	cipherClientServer := check("cipher (client to server)", client.CiphersClientServer, server.CiphersClientServer)
+	cipherServerClient := check("cipher (server to client)", client.CiphersServerClient, server.CiphersServerClient)
+	if cipherClientServer != "" && !aeadCiphers[cipherClientServer] {
+		check("mac (client to server)", client.MACsClientServer, server.MACsClientServer)
+	}
+	if cipherServerClient != "" && !aeadCiphers[cipherServerClient] {
+		check("mac (server to client)", client.MACsServerClient, server.MACsServerClient)
+	}
+	check("compression (client to server)", client.CompressionClientServer, server.CompressionClientServer)
+	check("compression (server to client)", client.CompressionServerClient, server.CompressionServerClient)
+	return mismatches
+}
@@ -0,0 +1,23 @@
+package tunnel
+
+import "golang.org/x/crypto/ssh"
+
+// KeyboardInteractivePrompt answers one of the server's questions, such as the one time code a 2FA bastion asks
+// for. name and instruction are the challenge's, and may be empty; echo reports whether the answer may be shown
+// as it's typed.
+type KeyboardInteractivePrompt func(name, instruction, question string, echo bool) (string, error)
+
+// KeyboardInteractive returns an AuthMethod answering the server's keyboard-interactive challenges with prompt
+func KeyboardInteractive(prompt KeyboardInteractivePrompt) ssh.AuthMethod {
+	return ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			answer, err := prompt(name, instruction, question, echos[i])
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+		return answers, nil
+	})
+}
@@ -0,0 +1,124 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyType is the kind of key GenerateKeyPair makes
+type KeyType int
+
+const (
+	// Ed25519Key is what ssh-keygen makes by default
+	Ed25519Key KeyType = iota
+	// ECDSAKey is a NIST P-256 key, for servers that don't accept ed25519
+	ECDSAKey
+)
+
+// ParseKeyType parses "ed25519" or "ecdsa"
+func ParseKeyType(s string) (KeyType, error) {
+	switch s {
+	case "ed25519":
+		return Ed25519Key, nil
+	case "ecdsa":
+		return ECDSAKey, nil
+	default:
+		return 0, fmt.Errorf("unknown key type %q: expected ed25519 or ecdsa", s)
+	}
+}
+
+// KeyPair is a newly generated key
+type KeyPair struct {
+	// PrivateKey is in the OpenSSH format without a passphrase, as written by ssh-keygen -N ""
+	PrivateKey []byte
+	// PublicKey is a line for authorized_keys, ending with the comment when there is one
+	PublicKey []byte
+	Signer    ssh.Signer
+}
+
+// GenerateKeyPair makes a new key of keyType, such as to enroll with a bastion, with comment recorded in both of
+// its halves
+func GenerateKeyPair(keyType KeyType, comment string) (*KeyPair, error) {
+	var key interface{}
+	var fields interface{}
+	switch keyType {
+	case Ed25519Key:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key = priv
+		fields = struct {
+			Pub     []byte
+			Priv    []byte
+			Comment string
+		}{pub, priv, comment}
+	case ECDSAKey:
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key = priv
+		fields = struct {
+			Curve   string
+			Pub     []byte
+			D       *big.Int
+			Comment string
+		}{"nistp256", elliptic.Marshal(priv.Curve, priv.X, priv.Y), priv.D, comment}
+	default:
+		return nil, fmt.Errorf("unknown key type %d", keyType)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	private, err := marshalOpenSSHPrivateKey(signer.PublicKey(), ssh.Marshal(fields))
+	if err != nil {
+		return nil, err
+	}
+	public := bytes.TrimSuffix(ssh.MarshalAuthorizedKey(signer.PublicKey()), []byte("\n"))
+	if comment != "" {
+		public = append(append(public, ' '), comment...)
+	}
+	return &KeyPair{PrivateKey: private, PublicKey: append(public, '\n'), Signer: signer}, nil
+}
+
+// marshalOpenSSHPrivateKey writes an unencrypted key in the format of PROTOCOL.key in openssh-portable, which
+// this version of x/crypto reads but can't write. fields are the key type's own, comment included.
+func marshalOpenSSHPrivateKey(pub ssh.PublicKey, fields []byte) ([]byte, error) {
+	var check uint32
+	if err := binary.Read(rand.Reader, binary.BigEndian, &check); err != nil {
+		return nil, err
+	}
+	block := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Rest    []byte `ssh:"rest"`
+	}{check, check, pub.Type(), fields})
+	// padded to the block size of the "none" cipher
+	for i := 1; len(block)%8 != 0; i++ {
+		block = append(block, byte(i))
+	}
+	body := ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{"none", "none", "", 1, pub.Marshal(), block})
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte("openssh-key-v1\x00"), body...),
+	}), nil
+}
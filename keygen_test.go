@@ -0,0 +1,43 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateKeyPair(t *testing.T) {
+	for _, keyType := range []KeyType{Ed25519Key, ECDSAKey} {
+		pair, err := GenerateKeyPair(keyType, "deploy@laptop")
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.ParsePrivateKey(pair.PrivateKey)
+		if err != nil {
+			t.Fatalf("%d: %v", keyType, err)
+		}
+		public, comment, _, _, err := ssh.ParseAuthorizedKey(pair.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if comment != "deploy@laptop" || !strings.HasSuffix(string(pair.PublicKey), "\n") {
+			t.Fatalf("unexpected public key line %q", pair.PublicKey)
+		}
+		if !bytes.Equal(public.Marshal(), signer.PublicKey().Marshal()) || !bytes.Equal(public.Marshal(), pair.Signer.PublicKey().Marshal()) {
+			t.Fatalf("%d: public key doesn't match the private key", keyType)
+		}
+		sig, err := signer.Sign(rand.Reader, []byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := public.Verify([]byte("data"), sig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ParseKeyType("dsa"); err == nil {
+		t.Fatal("expected dsa to be refused")
+	}
+}
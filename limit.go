@@ -0,0 +1,9 @@
+package tunnel
+
+// WithMaxConnections limits the forward to n concurrent connections. Above it, new connections are closed straight
+// away when reject is set and otherwise left waiting to be accepted until a connection ends.
+func (f Forwarder) WithMaxConnections(n int, reject bool) Forwarder {
+	f.maxConnections = n
+	f.rejectOverLimit = reject
+	return f
+}
@@ -0,0 +1,107 @@
+package tunnel
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is the severity of a log message
+type Level int
+
+const (
+	// LevelDebug is for per-connection traces
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelError; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return LevelDebug, fmt.Errorf("unknown log level %s", s)
+}
+
+// LeveledLogger is a Logger that is also told the level of every message. Loggers that only implement Logger
+// receive messages of every level through Log.
+type LeveledLogger interface {
+	Logger
+	LogLevel(level Level, format string, v ...interface{})
+}
+
+func logAt(logger Logger, level Level, format string, v ...interface{}) {
+	if l, ok := logger.(LeveledLogger); ok {
+		l.LogLevel(level, format, v...)
+		return
+	}
+	logger.Log(format, v...)
+}
+
+// levelFilter drops messages below min; messages logged without a level are taken to be info
+type levelFilter struct {
+	logger Logger
+	min    Level
+}
+
+func (f *levelFilter) Log(format string, v ...interface{}) {
+	f.LogLevel(LevelInfo, format, v...)
+}
+
+func (f *levelFilter) LogLevel(level Level, format string, v ...interface{}) {
+	if level >= f.min {
+		logAt(f.logger, level, format, v...)
+	}
+}
+
+// SugaredLogger is the printf style half of a structured logger, such as zap's *zap.SugaredLogger
+type SugaredLogger interface {
+	Debugf(template string, args ...interface{})
+	Infof(template string, args ...interface{})
+	Warnf(template string, args ...interface{})
+	Errorf(template string, args ...interface{})
+}
+
+// FromSugaredLogger returns a Logger writing to l at the level of every message
+func FromSugaredLogger(l SugaredLogger) LeveledLogger {
+	return &sugaredLogger{l: l}
+}
+
+type sugaredLogger struct {
+	l SugaredLogger
+}
+
+func (s *sugaredLogger) Log(format string, v ...interface{}) {
+	s.LogLevel(LevelInfo, format, v...)
+}
+
+func (s *sugaredLogger) LogLevel(level Level, format string, v ...interface{}) {
+	format = strings.TrimSpace(format)
+	switch {
+	case level >= LevelError:
+		s.l.Errorf(format, v...)
+	case level == LevelWarn:
+		s.l.Warnf(format, v...)
+	case level == LevelInfo:
+		s.l.Infof(format, v...)
+	default:
+		s.l.Debugf(format, v...)
+	}
+}
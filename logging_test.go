@@ -0,0 +1,67 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (r *recordingLogger) Log(format string, v ...interface{}) {
+	r.messages = append(r.messages, fmt.Sprintf(format, v...))
+}
+
+type recordingSugaredLogger struct {
+	bytes.Buffer
+}
+
+func (r *recordingSugaredLogger) Debugf(template string, args ...interface{}) {
+	fmt.Fprintf(r, "D "+template+"\n", args...)
+}
+func (r *recordingSugaredLogger) Infof(template string, args ...interface{}) {
+	fmt.Fprintf(r, "I "+template+"\n", args...)
+}
+func (r *recordingSugaredLogger) Warnf(template string, args ...interface{}) {
+	fmt.Fprintf(r, "W "+template+"\n", args...)
+}
+func (r *recordingSugaredLogger) Errorf(template string, args ...interface{}) {
+	fmt.Fprintf(r, "E "+template+"\n", args...)
+}
+
+func TestLogLevel(t *testing.T) {
+	t.Run("unleveled loggers get everything", func(t *testing.T) {
+		logger := &recordingLogger{}
+		logAt(logger, LevelDebug, "trace %d", 1)
+		logAt(logger, LevelError, "failure")
+		if len(logger.messages) != 2 {
+			t.Fatalf("unexpected messages %v", logger.messages)
+		}
+	})
+	t.Run("spec level filters", func(t *testing.T) {
+		logger := &recordingLogger{}
+		spec := &Spec{Logger: logger, LogLevel: LevelWarn}
+		applyDefaults(spec)
+		applyDefaults(spec)
+		logAt(spec.Logger, LevelDebug, "trace")
+		logAt(spec.Logger, LevelInfo, "info")
+		logAt(spec.Logger, LevelWarn, "warning")
+		logAt(spec.Logger, LevelError, "failure")
+		if strings.Join(logger.messages, ",") != "warning,failure" {
+			t.Fatalf("unexpected messages %v", logger.messages)
+		}
+	})
+	t.Run("sugared logger", func(t *testing.T) {
+		sugared := &recordingSugaredLogger{}
+		logger := FromSugaredLogger(sugared)
+		logAt(logger, LevelDebug, "\ttrace %d\n", 1)
+		logAt(logger, LevelWarn, "warning")
+		logger.Log("plain")
+		if sugared.String() != "D trace 1\nW warning\nI plain\n" {
+			t.Fatalf("unexpected output %q", sugared.String())
+		}
+	})
+}
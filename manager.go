@@ -0,0 +1,217 @@
+package tunnel
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Manager shares ssh connections between the tunnels it starts: tunnels to the same host as the same user, through
+// the same jump hosts, run over one connection, which is closed once the last of them is. The first tunnel's
+// auth and host key checking are what the connection is made with.
+//
+// Tunnels with reverse forwards or SuspendAfter get a connection of their own: the server's forwarded
+// connections can't be told apart on a shared connection, and suspending would close it for everyone.
+type Manager struct {
+	mu      sync.Mutex
+	conns   map[string]*sharedConn
+	dialing map[string]*pendingConn
+}
+
+// NewManager returns a Manager without any connections
+func NewManager() *Manager {
+	return &Manager{conns: map[string]*sharedConn{}, dialing: map[string]*pendingConn{}}
+}
+
+// Execute is Execute with the connection shared
+//
+// Deprecated: use ExecuteContext
+func (m *Manager) Execute(spec *Spec) (*Tunnel, error) {
+	return m.ExecuteContext(context.Background(), spec)
+}
+
+// ExecuteContext is ExecuteContext with the connection shared
+func (m *Manager) ExecuteContext(ctx context.Context, spec *Spec) (*Tunnel, error) {
+	m.manage(spec)
+	return ExecuteContext(ctx, spec)
+}
+
+// ExecuteAndBlock is ExecuteAndBlock with the connection shared
+func (m *Manager) ExecuteAndBlock(ctx context.Context, spec *Spec, ok chan<- struct{}) error {
+	m.manage(spec)
+	return ExecuteAndBlock(ctx, spec, ok)
+}
+
+// ExecuteWithRetry is ExecuteWithRetry with the connection shared
+func (m *Manager) ExecuteWithRetry(ctx context.Context, spec *Spec, policy RetryPolicy) *RetryingTunnel {
+	m.manage(spec)
+	return ExecuteWithRetry(ctx, spec, policy)
+}
+
+// Dial is Dial with the connection shared
+func (m *Manager) Dial(spec *Spec) (*Tunnel, error) {
+	m.manage(spec)
+	return Dial(spec)
+}
+
+// Connections is the number of connections currently open
+func (m *Manager) Connections() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.conns)
+}
+
+func (m *Manager) manage(spec *Spec) {
+	if len(spec.Reverse) == 0 && spec.SuspendAfter <= 0 {
+		spec.manager = m
+	}
+}
+
+// connectionKey identifies the connections that can be shared
+func connectionKey(spec *Spec) string {
+	hops := []string{}
+	for s := spec; s != nil; s = s.Via {
+		hops = append(hops, s.User+"@"+s.Host)
+	}
+	return strings.Join(hops, " via ")
+}
+
+// connect returns a client over the shared connection for spec, dialing it if there's none yet. Tunnels started
+// together wait for the first one's dial rather than all dialing; the lock isn't held while dialing so that an
+// unreachable host doesn't hold up connections to others.
+func (m *Manager) connect(ctx context.Context, spec *Spec, config *ssh.ClientConfig) (*ssh.Client, error) {
+	key := connectionKey(spec)
+	m.mu.Lock()
+	for {
+		if shared, ok := m.conns[key]; ok {
+			defer m.mu.Unlock()
+			return shared.client(), nil
+		}
+		pending, ok := m.dialing[key]
+		if !ok {
+			break
+		}
+		m.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-pending.done:
+		}
+		// a dial given up on by its own tunnel is tried again
+		if pending.err != nil && !pending.abandoned {
+			return nil, pending.err
+		}
+		m.mu.Lock()
+	}
+	pending := &pendingConn{done: make(chan struct{})}
+	m.dialing[key] = pending
+	m.mu.Unlock()
+
+	owner, err := dialServer(ctx, spec, config)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.dialing, key)
+	pending.err, pending.abandoned = err, ctx.Err() != nil
+	close(pending.done)
+	if err != nil {
+		return nil, err
+	}
+	shared := &sharedConn{manager: m, key: key, owner: owner, clients: map[*sharedClientConn]bool{}}
+	m.conns[key] = shared
+	go func() {
+		owner.Wait()
+		shared.closeAll()
+	}()
+	return shared.client(), nil
+}
+
+// pendingConn is a connection being dialed; err and abandoned are set before done is closed
+type pendingConn struct {
+	done      chan struct{}
+	err       error
+	abandoned bool
+}
+
+// sharedConn is a connection handed out as a client to every tunnel using it. The owner client, which is never
+// handed out, takes what the server opens or requests. Guarded by the manager's lock.
+type sharedConn struct {
+	manager *Manager
+	key     string
+	owner   *ssh.Client
+	clients map[*sharedClientConn]bool
+}
+
+// client hands out a new client over the connection; called with the manager's lock held
+func (s *sharedConn) client() *ssh.Client {
+	c := &sharedClientConn{
+		Conn:   s.owner.Conn,
+		shared: s,
+		chans:  make(chan ssh.NewChannel),
+		reqs:   make(chan *ssh.Request),
+		closed: make(chan struct{}),
+	}
+	s.clients[c] = true
+	return ssh.NewClient(c, c.chans, c.reqs)
+}
+
+// release forgets c, closing the connection once no client is left
+func (s *sharedConn) release(c *sharedClientConn) {
+	m := s.manager
+	m.mu.Lock()
+	delete(s.clients, c)
+	last := len(s.clients) == 0
+	if last && m.conns[s.key] == s {
+		delete(m.conns, s.key)
+	}
+	m.mu.Unlock()
+	if last {
+		s.owner.Close()
+	}
+}
+
+// closeAll lets go of the clients once the connection is gone
+func (s *sharedConn) closeAll() {
+	m := s.manager
+	m.mu.Lock()
+	if m.conns[s.key] == s {
+		delete(m.conns, s.key)
+	}
+	clients := make([]*sharedClientConn, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	m.mu.Unlock()
+	for _, c := range clients {
+		c.Close()
+	}
+}
+
+// sharedClientConn is a tunnel's handle on a shared connection; closing it releases the connection rather than
+// closing it
+type sharedClientConn struct {
+	ssh.Conn
+	shared *sharedConn
+	chans  chan ssh.NewChannel
+	reqs   chan *ssh.Request
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *sharedClientConn) Close() error {
+	c.once.Do(func() {
+		close(c.chans)
+		close(c.reqs)
+		close(c.closed)
+		c.shared.release(c)
+	})
+	return nil
+}
+
+// Wait returns once the handle is closed or the connection is gone, which closes it
+func (c *sharedClientConn) Wait() error {
+	<-c.closed
+	return nil
+}
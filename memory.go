@@ -0,0 +1,129 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryPortBase is the first port handed out to listeners on port 0 of a MemoryNetwork
+const memoryPortBase = 40000
+
+// MemoryNetwork is a NetworkingDevice that connects its own dialers to its own listeners through net.Pipe, so
+// that forwards can be exercised without sockets
+type MemoryNetwork struct {
+	mu        sync.Mutex
+	listeners map[string]*memoryListener
+	nextPort  int
+}
+
+// NewMemoryNetwork returns a MemoryNetwork with nothing listening
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{listeners: map[string]*memoryListener{}, nextPort: memoryPortBase}
+}
+
+// Listen listens on address, which is matched as is by Dial. Port 0 is given a port of its own.
+func (m *MemoryNetwork) Listen(network, address string) (net.Listener, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if host, port, err := net.SplitHostPort(address); err == nil && port == "0" {
+		address = net.JoinHostPort(host, strconv.Itoa(m.nextPort))
+		m.nextPort++
+	}
+	key := network + " " + address
+	if _, taken := m.listeners[key]; taken {
+		return nil, fmt.Errorf("listen %s %s: address already in use", network, address)
+	}
+	l := &memoryListener{
+		addr:    memoryAddr{network: network, address: address},
+		conns:   make(chan net.Conn),
+		closed:  make(chan struct{}),
+		network: m,
+		key:     key,
+	}
+	m.listeners[key] = l
+	return l, nil
+}
+
+// Dial connects to a listener on exactly address, waiting for it to accept the connection
+func (m *MemoryNetwork) Dial(network, address string) (net.Conn, error) {
+	m.mu.Lock()
+	l := m.listeners[network+" "+address]
+	m.mu.Unlock()
+	if l == nil {
+		return nil, fmt.Errorf("dial %s %s: connection refused", network, address)
+	}
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		client.Close()
+		server.Close()
+		return nil, fmt.Errorf("dial %s %s: connection refused", network, address)
+	}
+}
+
+type memoryListener struct {
+	addr    memoryAddr
+	conns   chan net.Conn
+	closed  chan struct{}
+	once    sync.Once
+	network *MemoryNetwork
+	key     string
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		l.network.mu.Lock()
+		delete(l.network.listeners, l.key)
+		l.network.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+type memoryAddr struct {
+	network string
+	address string
+}
+
+func (a memoryAddr) Network() string {
+	return a.network
+}
+
+func (a memoryAddr) String() string {
+	return a.address
+}
+
+// ServeForward tunnels the connections accepted on listener to f's destination, dialed through destination,
+// until ctx is done and they're all closed, such as to run a forward over a transport other than ssh. listener
+// is closed when it returns.
+func ServeForward(ctx context.Context, listener net.Listener, destination NetworkingDevice, f Forwarder, logger Logger) {
+	if logger == nil {
+		logger = EmptyLogger()
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	wg := sync.WaitGroup{}
+	acceptNewConnectionAndTunnel(ctx, listener, destination, f, time.Second*5, logger, &wg)
+	wg.Wait()
+}
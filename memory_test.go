@@ -0,0 +1,66 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestServeForwardOverMemoryNetwork(t *testing.T) {
+	n := NewMemoryNetwork()
+	echo, err := n.Listen("tcp", "echo:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	listener, err := n.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		ServeForward(ctx, listener, n, Forward(0, "echo:7"), nil)
+		close(served)
+	}()
+
+	conn, err := n.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Fatalf("expected ping back, got %q: %v", reply, err)
+	}
+
+	cancel()
+	select {
+	case <-served:
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected the forward to stop once cancelled")
+	}
+	if _, err := conn.Read(reply); err != io.EOF {
+		t.Fatalf("expected the tunneled connection to be closed, got %v", err)
+	}
+	if _, err := n.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Fatal("expected the listener to be closed")
+	}
+}
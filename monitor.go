@@ -0,0 +1,82 @@
+package tunnel
+
+import (
+	"context"
+	"time"
+)
+
+// defaultMonitorTimeout bounds each check when ForwardTimeout isn't set
+const defaultMonitorTimeout = time.Second * 5
+
+// DestinationStatus is the reachability of a forward's destination through the ssh connection
+type DestinationStatus struct {
+	// Name is the forward's name, its destination unless set with WithName
+	Name        string
+	Destination string
+	Reachable   bool
+	// Err is why the destination couldn't be dialed when not Reachable
+	Err error
+	// Since is when the destination was first seen in this state
+	Since time.Time
+}
+
+// monitorDestinations dials every forward's destination through dialer each MonitorInterval until ctx is done,
+// reporting when one stops being reachable and when it's reachable again. Destinations start out presumed
+// reachable, so one that's up throughout is never reported.
+func monitorDestinations(ctx context.Context, dialer Dialer, spec *Spec) {
+	timeout := spec.ForwardTimeout
+	if timeout <= 0 {
+		timeout = defaultMonitorTimeout
+	}
+	statuses := make([]DestinationStatus, len(spec.Forward))
+	for i, f := range spec.Forward {
+		statuses[i] = DestinationStatus{Name: f.displayName(), Destination: f.destination, Reachable: true, Since: time.Now()}
+	}
+	ticker := time.NewTicker(spec.MonitorInterval)
+	defer ticker.Stop()
+	for {
+		for i := range statuses {
+			if ctx.Err() != nil {
+				return
+			}
+			// a dynamic forward has no destination of its own
+			if spec.Forward[i].socks != nil {
+				continue
+			}
+			s := &statuses[i]
+			err := checkDestination(dialer, s.Destination, timeout)
+			if (err == nil) == s.Reachable {
+				continue
+			}
+			s.Reachable, s.Err, s.Since = err == nil, err, time.Now()
+			// the connection itself going away isn't the destination's doing
+			if ctx.Err() != nil {
+				return
+			}
+			if s.Reachable {
+				logAt(spec.Logger, LevelInfo, "%s is reachable again", s.Destination)
+				if spec.OnDestinationUp != nil {
+					spec.OnDestinationUp(*s)
+				}
+			} else {
+				logAt(spec.Logger, LevelWarn, "%s is unreachable: %v", s.Destination, err)
+				if spec.OnDestinationDown != nil {
+					spec.OnDestinationDown(*s)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkDestination(dialer Dialer, destination string, timeout time.Duration) error {
+	conn, err := DialWithTimeout(dialer, "tcp", destination, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
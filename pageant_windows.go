@@ -0,0 +1,119 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Pageant takes agent requests as WM_COPYDATA messages naming a shared memory mapping that holds the request, and
+// writes its response back over it
+const (
+	pageantMaxMessage = 8192
+	pageantCopyDataID = 0x804e50ba
+	wmCopyData        = 0x004a
+)
+
+var (
+	user32            = windows.NewLazySystemDLL("user32.dll")
+	procFindWindow    = user32.NewProc("FindWindowW")
+	procSendMessage   = user32.NewProc("SendMessageW")
+	kernel32          = windows.NewLazySystemDLL("kernel32.dll")
+	procRtlMoveMemory = kernel32.NewProc("RtlMoveMemory")
+)
+
+type copyData struct {
+	data   uintptr
+	length uint32
+	ptr    uintptr
+}
+
+func pageantWindow() uintptr {
+	name, _ := windows.UTF16PtrFromString("Pageant")
+	hwnd, _, _ := procFindWindow.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(name)))
+	return hwnd
+}
+
+func pageantRunning() bool {
+	return pageantWindow() != 0
+}
+
+// pageantQuery sends one length prefixed agent request to Pageant and returns its length prefixed response
+func pageantQuery(request []byte) ([]byte, error) {
+	if len(request) > pageantMaxMessage {
+		return nil, errors.New("agent request too large for pageant")
+	}
+	hwnd := pageantWindow()
+	if hwnd == 0 {
+		return nil, errors.New("pageant is not running")
+	}
+	mapName := fmt.Sprintf("PageantRequest%08x", windows.GetCurrentThreadId())
+	mapName16, err := windows.UTF16PtrFromString(mapName)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := windows.CreateFileMapping(windows.InvalidHandle, nil, windows.PAGE_READWRITE, 0, pageantMaxMessage, mapName16)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create memory mapping for pageant: %v", err)
+	}
+	defer windows.CloseHandle(mapping)
+	view, err := windows.MapViewOfFile(mapping, windows.FILE_MAP_WRITE, 0, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to map memory for pageant: %v", err)
+	}
+	defer windows.UnmapViewOfFile(view)
+
+	// the view is only ever copied to and from to not hold a Go pointer to memory Go doesn't manage
+	procRtlMoveMemory.Call(view, uintptr(unsafe.Pointer(&request[0])), uintptr(len(request)))
+	name := append([]byte(mapName), 0)
+	cds := copyData{data: pageantCopyDataID, length: uint32(len(name)), ptr: uintptr(unsafe.Pointer(&name[0]))}
+	ret, _, _ := procSendMessage.Call(hwnd, wmCopyData, 0, uintptr(unsafe.Pointer(&cds)))
+	runtime.KeepAlive(name)
+	if ret == 0 {
+		return nil, errors.New("pageant refused the request")
+	}
+	header := make([]byte, 4)
+	procRtlMoveMemory.Call(uintptr(unsafe.Pointer(&header[0])), view, 4)
+	length := binary.BigEndian.Uint32(header)
+	if length > pageantMaxMessage-4 {
+		return nil, errors.New("invalid response from pageant")
+	}
+	response := make([]byte, 4+length)
+	procRtlMoveMemory.Call(uintptr(unsafe.Pointer(&response[0])), view, uintptr(len(response)))
+	return response, nil
+}
+
+// pageantConn carries the agent protocol to Pageant: each complete request written is sent as a query and the
+// response is left to be read
+type pageantConn struct {
+	request  bytes.Buffer
+	response bytes.Buffer
+}
+
+func (c *pageantConn) Write(p []byte) (int, error) {
+	c.request.Write(p)
+	for c.request.Len() >= 4 {
+		length := int(binary.BigEndian.Uint32(c.request.Bytes()))
+		if c.request.Len() < 4+length {
+			break
+		}
+		response, err := pageantQuery(c.request.Next(4 + length))
+		if err != nil {
+			return 0, err
+		}
+		c.response.Write(response)
+	}
+	return len(p), nil
+}
+
+func (c *pageantConn) Read(p []byte) (int, error) {
+	if c.response.Len() == 0 {
+		return 0, errors.New("no pending response from pageant")
+	}
+	return c.response.Read(p)
+}
@@ -0,0 +1,28 @@
+package tunnel
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func peerCredentials(conn *net.UnixConn) (uid, gid int, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var cred *unix.Xucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	}); err != nil {
+		return 0, 0, err
+	}
+	if credErr != nil {
+		return 0, 0, credErr
+	}
+	if cred.Ngroups == 0 {
+		return int(cred.Uid), -1, nil
+	}
+	return int(cred.Uid), int(cred.Groups[0]), nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package tunnel
+
+import (
+	"errors"
+	"net"
+)
+
+func peerCredentials(conn *net.UnixConn) (uid, gid int, err error) {
+	return 0, 0, errors.New("peer credentials are not available on this platform")
+}
@@ -0,0 +1,126 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// pre-dialed connections older than this are discarded as destinations tend to drop idle connections
+const prewarmedMaxAge = time.Second * 30
+
+// WithPrewarm keeps up to n connections to the destination dialed ahead of time so new local connections
+// don't pay the dial latency through the bastion. Prewarmed connections count as activity, so they keep the
+// connection from being suspended.
+func (f Forwarder) WithPrewarm(n int) Forwarder {
+	f.prewarm = n
+	return f
+}
+
+type prewarmedConn struct {
+	conn   net.Conn
+	dialed time.Time
+}
+
+// dialPool keeps a small number of connections to destination ready for use
+type dialPool struct {
+	dial   func() (net.Conn, error)
+	conns  chan prewarmedConn
+	refill chan struct{}
+	logger Logger
+	retry  time.Duration
+}
+
+func newDialPool(size int, dial func() (net.Conn, error), retry time.Duration, logger Logger) *dialPool {
+	return &dialPool{
+		dial:   dial,
+		conns:  make(chan prewarmedConn, size),
+		refill: make(chan struct{}, 1),
+		logger: logger,
+		retry:  retry,
+	}
+}
+
+// get returns a prewarmed connection if one is ready, otherwise dials a fresh one
+func (p *dialPool) get() (net.Conn, error) {
+	defer p.requestRefill()
+	for {
+		select {
+		case c := <-p.conns:
+			if time.Since(c.dialed) < prewarmedMaxAge {
+				return c.conn, nil
+			}
+			c.conn.Close()
+		default:
+			return p.dial()
+		}
+	}
+}
+
+func (p *dialPool) requestRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// run keeps the pool filled until ctx is done, then closes the connections left in it
+func (p *dialPool) run(ctx context.Context) {
+	defer p.drain()
+	expire := time.NewTicker(prewarmedMaxAge / 2)
+	defer expire.Stop()
+	for {
+		for len(p.conns) < cap(p.conns) {
+			conn, err := p.dial()
+			if err != nil {
+				logAt(p.logger, LevelWarn, "unable to prewarm connection: %v", err)
+				break
+			}
+			select {
+			case p.conns <- prewarmedConn{conn: conn, dialed: time.Now()}:
+			default:
+				conn.Close()
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		retry := time.NewTimer(p.retry)
+		select {
+		case <-ctx.Done():
+			retry.Stop()
+			return
+		case <-p.refill:
+		case <-retry.C:
+		case <-expire.C:
+			p.expire()
+		}
+		retry.Stop()
+	}
+}
+
+func (p *dialPool) expire() {
+	for i := len(p.conns); i > 0; i-- {
+		select {
+		case c := <-p.conns:
+			if time.Since(c.dialed) < prewarmedMaxAge {
+				p.conns <- c
+				continue
+			}
+			c.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (p *dialPool) drain() {
+	for {
+		select {
+		case c := <-p.conns:
+			c.conn.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDialPool(t *testing.T) {
+	var dials int32
+	dial := func() (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		c, _ := net.Pipe()
+		return c, nil
+	}
+	pool := newDialPool(2, dial, time.Second, EmptyLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pool.run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for len(pool.conns) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("pool was not filled")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if _, err := pool.get(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Fatalf("expected the connection to come from the pool, got %d dials", n)
+	}
+	deadline = time.Now().Add(time.Second)
+	for atomic.LoadInt32(&dials) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("pool was not refilled")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	cancel()
+	<-done
+	if len(pool.conns) != 0 {
+		t.Fatal("pool was not drained")
+	}
+}
@@ -0,0 +1,55 @@
+package tunnel
+
+import (
+	"net"
+	"strconv"
+)
+
+// portMapping maps a local port to a destination port of a multi-port forward
+type portMapping struct {
+	local       int
+	destination int
+}
+
+// ForwardRange returns a Forwarder listening on every local port from first to last, each forwarded to the port
+// at the same offset from destinationPort on host
+func ForwardRange(first, last int, host string, destinationPort int) Forwarder {
+	f := Forwarder{destination: host}
+	for p := first; p <= last; p++ {
+		f.ports = append(f.ports, portMapping{local: p, destination: destinationPort + p - first})
+	}
+	return f
+}
+
+// ForwardPorts returns a Forwarder listening on each of ports, forwarded to the same port on host
+func ForwardPorts(ports []int, host string) Forwarder {
+	f := Forwarder{destination: host}
+	for _, p := range ports {
+		f.ports = append(f.ports, portMapping{local: p, destination: p})
+	}
+	return f
+}
+
+// expand returns a single port Forwarder for every port of a multi-port forward, each with its own quotas
+func (f Forwarder) expand() []Forwarder {
+	if len(f.ports) == 0 {
+		return []Forwarder{f}
+	}
+	result := make([]Forwarder, 0, len(f.ports))
+	for _, m := range f.ports {
+		single := f
+		single.ports = nil
+		single.port = m.local
+		single.destination = net.JoinHostPort(f.destination, strconv.Itoa(m.destination))
+		result = append(result, single)
+	}
+	return result
+}
+
+func expandForwarders(forwarders []Forwarder) []Forwarder {
+	result := make([]Forwarder, 0, len(forwarders))
+	for _, f := range forwarders {
+		result = append(result, f.expand()...)
+	}
+	return result
+}
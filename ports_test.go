@@ -0,0 +1,36 @@
+package tunnel
+
+import (
+	"testing"
+)
+
+func TestExpandForwarders(t *testing.T) {
+	forwarders := expandForwarders([]Forwarder{
+		Forward(8080, "web:80"),
+		ForwardRange(9000, 9002, "node", 19000).WithConnectionQuota(100),
+		ForwardPorts([]int{7000, 7002}, "node"),
+	})
+	expected := []struct {
+		port        int
+		destination string
+	}{
+		{8080, "web:80"},
+		{9000, "node:19000"},
+		{9001, "node:19001"},
+		{9002, "node:19002"},
+		{7000, "node:7000"},
+		{7002, "node:7002"},
+	}
+	if len(forwarders) != len(expected) {
+		t.Fatalf("expected %d forwarders, got %d", len(expected), len(forwarders))
+	}
+	for i, e := range expected {
+		f := forwarders[i]
+		if f.port != e.port || f.destination != e.destination || len(f.ports) != 0 {
+			t.Fatalf("forwarder %d: expected %d to %s, got %+v", i, e.port, e.destination, f)
+		}
+	}
+	if forwarders[2].maxConnectionBytes != 100 {
+		t.Fatal("options not carried over to expanded forwarders")
+	}
+}
@@ -0,0 +1,316 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyDialer returns the dialer reaching the bastion through spec's DialContext, ProxyCommand or ProxyURL, or nil
+// when it has none
+func proxyDialer(ctx context.Context, spec *Spec, timeout time.Duration) (Dialer, error) {
+	if spec.DialContext != nil {
+		return contextDialer{ctx: ctx, dial: spec.DialContext, timeout: timeout}, nil
+	}
+	if spec.ProxyCommand != "" {
+		return commandDialer(spec.ProxyCommand), nil
+	}
+	if spec.ProxyURL == "" {
+		return nil, nil
+	}
+	proxy, err := url.Parse(spec.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %v", err)
+	}
+	switch proxy.Scheme {
+	case "http", "https":
+		return &httpConnectDialer{proxy: proxy, dialer: bastionDialer(timeout)}, nil
+	case "socks5", "socks5h":
+		return &socks5Dialer{proxy: proxy, dialer: bastionDialer(timeout)}, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %s", proxy.Scheme)
+}
+
+// proxyAddress is the proxy's host:port, using the scheme's default port when it has none
+func proxyAddress(proxy *url.URL, defaultPort string) string {
+	if proxy.Port() != "" {
+		return proxy.Host
+	}
+	return net.JoinHostPort(proxy.Hostname(), defaultPort)
+}
+
+// httpConnectDialer tunnels connections through an HTTP proxy with CONNECT
+type httpConnectDialer struct {
+	proxy  *url.URL
+	dialer *net.Dialer
+}
+
+func (d *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	defaultPort := "80"
+	if d.proxy.Scheme == "https" {
+		defaultPort = "443"
+	}
+	conn, err := d.dialer.Dial("tcp", proxyAddress(d.proxy, defaultPort))
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach proxy %s: %v", d.proxy.Host, err)
+	}
+	if d.proxy.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: d.proxy.Hostname()})
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if user := d.proxy.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to send CONNECT to proxy %s: %v", d.proxy.Host, err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("invalid response to CONNECT from proxy %s: %v", d.proxy.Host, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused CONNECT to %s: %s", d.proxy.Host, address, resp.Status)
+	}
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn reads what was buffered while reading the proxy's response before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// socks5Dialer tunnels connections through a SOCKS5 proxy (RFC 1928), authenticating with the url's user and
+// password when it has them (RFC 1929). Host names are resolved by the proxy.
+type socks5Dialer struct {
+	proxy  *url.URL
+	dialer *net.Dialer
+}
+
+const (
+	socks5Version          = 5
+	socks5NoAuth           = 0
+	socks5UserPassword     = 2
+	socks5NoAcceptableAuth = 0xff
+	socks5Connect          = 1
+	socks5IPv4             = 1
+	socks5DomainName       = 3
+	socks5IPv6             = 4
+)
+
+func (d *socks5Dialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.dialer.Dial("tcp", proxyAddress(d.proxy, "1080"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach proxy %s: %v", d.proxy.Host, err)
+	}
+	if err := d.connect(conn, address); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks5 proxy %s: %v", d.proxy.Host, err)
+	}
+	return conn, nil
+}
+
+func (d *socks5Dialer) connect(conn net.Conn, address string) error {
+	methods := []byte{socks5NoAuth}
+	if d.proxy.User != nil {
+		methods = []byte{socks5UserPassword}
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected protocol version %d", reply[0])
+	}
+	switch reply[1] {
+	case socks5NoAuth:
+	case socks5UserPassword:
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	case socks5NoAcceptableAuth:
+		return errors.New("no acceptable authentication method")
+	default:
+		return fmt.Errorf("unsupported authentication method %d", reply[1])
+	}
+
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return fmt.Errorf("invalid port %s", portString)
+	}
+	req := []byte{socks5Version, socks5Connect, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, socks5IPv4), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, socks5IPv6), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %s too long", host)
+		}
+		req = append(append(req, socks5DomainName, byte(len(host))), host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// version, reply, reserved and the type of the bound address that follows
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		return fmt.Errorf("unable to connect to %s: reply %d", address, header[1])
+	}
+	var boundLength int
+	switch header[3] {
+	case socks5IPv4:
+		boundLength = net.IPv4len
+	case socks5IPv6:
+		boundLength = net.IPv6len
+	case socks5DomainName:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		boundLength = int(length[0])
+	default:
+		return fmt.Errorf("unknown bound address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, boundLength+2))
+	return err
+}
+
+func (d *socks5Dialer) authenticate(conn net.Conn) error {
+	user := d.proxy.User.Username()
+	password, _ := d.proxy.User.Password()
+	if len(user) > 255 || len(password) > 255 {
+		return errors.New("user name or password too long")
+	}
+	req := []byte{1, byte(len(user))}
+	req = append(append(req, user...), byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("authentication failed")
+	}
+	return nil
+}
+
+// commandDialer connects by running a command, such as ssh's ProxyCommand, whose stdin and stdout are the
+// connection. %h and %p in the command are replaced by the host and port being connected to.
+type commandDialer string
+
+func (c commandDialer) Dial(network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	command := strings.NewReplacer("%h", host, "%p", port, "%%", "%").Replace(string(c))
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	// os pipes rather than the command's own so that deadlines are supported
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		stdinReader.Close()
+		stdinWriter.Close()
+		return nil, err
+	}
+	cmd.Stdin = stdinReader
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	// the command has its own copies of its ends
+	stdinReader.Close()
+	stdoutWriter.Close()
+	if err != nil {
+		stdinWriter.Close()
+		stdoutReader.Close()
+		return nil, fmt.Errorf("unable to run proxy command: %v", err)
+	}
+	return &commandConn{cmd: cmd, stdin: stdinWriter, stdout: stdoutReader, address: address}, nil
+}
+
+type commandConn struct {
+	cmd     *exec.Cmd
+	stdin   *os.File
+	stdout  *os.File
+	address string
+}
+
+func (c *commandConn) Read(b []byte) (int, error)  { return c.stdout.Read(b) }
+func (c *commandConn) Write(b []byte) (int, error) { return c.stdin.Write(b) }
+
+func (c *commandConn) Close() error {
+	c.stdin.Close()
+	c.stdout.Close()
+	c.cmd.Process.Kill()
+	return c.cmd.Wait()
+}
+
+func (c *commandConn) LocalAddr() net.Addr  { return commandAddr("proxy command") }
+func (c *commandConn) RemoteAddr() net.Addr { return commandAddr(c.address) }
+
+func (c *commandConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return c.stdout.SetReadDeadline(t) }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return c.stdin.SetWriteDeadline(t) }
+
+type commandAddr string
+
+func (a commandAddr) Network() string { return "proxy command" }
+func (a commandAddr) String() string  { return string(a) }
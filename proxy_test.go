@@ -0,0 +1,206 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	t.Run("http connect", func(t *testing.T) {
+		proxy, seen := httpConnectProxy(t)
+		assertForwardsThrough(t, &Spec{ProxyURL: "http://someone:secret@" + proxy}, 1245)
+		if got := <-seen; got != "Basic c29tZW9uZTpzZWNyZXQ=" {
+			t.Fatalf("expected the proxy credentials to be sent, got %q", got)
+		}
+	})
+	t.Run("socks5", func(t *testing.T) {
+		assertForwardsThrough(t, &Spec{ProxyURL: "socks5://" + socks5Proxy(t, "", "")}, 1246)
+	})
+	t.Run("socks5 with credentials", func(t *testing.T) {
+		proxy := socks5Proxy(t, "someone", "secret")
+		assertForwardsThrough(t, &Spec{ProxyURL: "socks5://someone:secret@" + proxy}, 1247)
+
+		_, err := Execute(&Spec{
+			Host:     testServer.Addr,
+			User:     testServer.User,
+			Auth:     testServer.Auth(),
+			ProxyURL: "socks5://someone:wrong@" + proxy,
+		})
+		if err == nil {
+			t.Fatal("expected wrong proxy credentials to fail")
+		}
+	})
+	t.Run("command", func(t *testing.T) {
+		if _, err := exec.LookPath("nc"); err != nil {
+			t.Skip("nc not available")
+		}
+		assertForwardsThrough(t, &Spec{ProxyCommand: "nc %h %p"}, 1248)
+	})
+	t.Run("dial context", func(t *testing.T) {
+		var dialed string
+		assertForwardsThrough(t, &Spec{
+			// ignored in favour of DialContext
+			ProxyURL: "ftp://proxy:21",
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				dialed = address
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			},
+		}, 1256)
+		if dialed != testServer.Addr {
+			t.Fatalf("expected the bastion to be dialed through DialContext, got %q", dialed)
+		}
+	})
+	t.Run("unsupported scheme", func(t *testing.T) {
+		_, err := Execute(&Spec{
+			Host:     testServer.Addr,
+			User:     testServer.User,
+			Auth:     testServer.Auth(),
+			ProxyURL: "ftp://proxy:21",
+		})
+		if err == nil {
+			t.Fatal("expected an unsupported proxy scheme to fail")
+		}
+	})
+}
+
+func assertForwardsThrough(t *testing.T, spec *Spec, port int) {
+	t.Helper()
+	spec.Host = testServer.Addr
+	spec.User = testServer.User
+	spec.Auth = testServer.Auth()
+	spec.Forward = []Forwarder{Forward(port, echoServer(t))}
+	tun, err := Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	assertEchoes(t, "localhost:"+strconv.Itoa(port))
+}
+
+// httpConnectProxy serves CONNECT requests, sending the Proxy-Authorization header of each on seen
+func httpConnectProxy(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	seen := make(chan string, 10)
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Get("Proxy-Authorization")
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, buffered, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		pipe(conn, buffered.Reader, target)
+	}))
+	return listener.Addr().String(), seen
+}
+
+// socks5Proxy serves socks5 connect requests, requiring user and password unless user is empty
+func socks5Proxy(t *testing.T, user, password string) string {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSocks5(conn, user, password)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func serveSocks5(conn net.Conn, user, password string) {
+	r := bufio.NewReader(conn)
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		conn.Close()
+		return
+	}
+	if _, err := io.ReadFull(r, make([]byte, header[1])); err != nil {
+		conn.Close()
+		return
+	}
+	if user == "" {
+		conn.Write([]byte{5, 0})
+	} else {
+		conn.Write([]byte{5, 2})
+		if readString(r, 1) != user || readString(r, 0) != password {
+			conn.Write([]byte{1, 1})
+			conn.Close()
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(r, req); err != nil {
+		conn.Close()
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case 3:
+		host = readString(r, 0)
+	default:
+		conn.Close()
+		return
+	}
+	port := make([]byte, 2)
+	io.ReadFull(r, port)
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	pipe(conn, r, target)
+}
+
+// readString reads a length prefixed string after skipping skip bytes
+func readString(r *bufio.Reader, skip int) string {
+	io.ReadFull(r, make([]byte, skip))
+	length, err := r.ReadByte()
+	if err != nil {
+		return ""
+	}
+	b := make([]byte, length)
+	io.ReadFull(r, b)
+	return string(b)
+}
+
+func pipe(conn net.Conn, r io.Reader, target net.Conn) {
+	go func() {
+		io.Copy(target, r)
+		target.Close()
+	}()
+	io.Copy(conn, target)
+	conn.Close()
+}
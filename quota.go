@@ -0,0 +1,97 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+var errQuotaExceeded = errors.New("byte quota exceeded")
+
+// WithConnectionQuota limits every connection through the forward to maxBytes, counting both directions;
+// connections reaching it are terminated
+func (f Forwarder) WithConnectionQuota(maxBytes int64) Forwarder {
+	f.maxConnectionBytes = maxBytes
+	return f
+}
+
+// WithForwardQuota limits the bytes transferred across all connections through the forward to maxBytes, counting
+// both directions; once reached connections are terminated and, if disable is set, no new ones are accepted
+func (f Forwarder) WithForwardQuota(maxBytes int64, disable bool) Forwarder {
+	f.maxForwardBytes = maxBytes
+	f.disableOnQuota = disable
+	return f
+}
+
+func (f Forwarder) hasQuota() bool {
+	return f.maxConnectionBytes > 0 || f.maxForwardBytes > 0
+}
+
+// forwardState is the runtime state of a forward shared by all of its connections
+type forwardState struct {
+	// bytes transferred across all connections; accessed atomically
+	transferred   int64
+	quotaOnce     sync.Once
+	quotaExceeded chan struct{}
+	// dial connects to the forward's destination
+	dial func() (net.Conn, error)
+	// dialTo connects to addr, as asked for by the clients of a dynamic forward
+	dialTo func(addr string) (net.Conn, error)
+	// 1 while the server refuses to open channels; accessed atomically
+	throttled int32
+	// limiter, when set, limits the throughput of all connections
+	limiter *rateLimiter
+	// capture, when set, writes the traffic of all connections
+	capture *capturer
+}
+
+func newForwardState() *forwardState {
+	return &forwardState{
+		quotaExceeded: make(chan struct{}),
+	}
+}
+
+// quotaWriter enforces the quotas of forwarder on writes; counter is shared by both directions of a connection
+type quotaWriter struct {
+	w         io.Writer
+	forwarder Forwarder
+	state     *forwardState
+	counter   *int64
+}
+
+func (q *quotaWriter) Write(b []byte) (int, error) {
+	allowed := int64(len(b))
+	if q.forwarder.maxConnectionBytes > 0 {
+		allowed = reserve(q.counter, allowed, q.forwarder.maxConnectionBytes)
+	}
+	if q.forwarder.maxForwardBytes > 0 {
+		forwardAllowed := reserve(&q.state.transferred, allowed, q.forwarder.maxForwardBytes)
+		if forwardAllowed < allowed {
+			allowed = forwardAllowed
+			q.state.quotaOnce.Do(func() { close(q.state.quotaExceeded) })
+		}
+	}
+	n, err := q.w.Write(b[:allowed])
+	if err != nil {
+		return n, err
+	}
+	if allowed < int64(len(b)) {
+		return n, errQuotaExceeded
+	}
+	return n, nil
+}
+
+// reserve adds n to the counter and returns how much of n fits within limit
+func reserve(counter *int64, n, limit int64) int64 {
+	total := atomic.AddInt64(counter, n)
+	if total <= limit {
+		return n
+	}
+	over := total - limit
+	if over > n {
+		return 0
+	}
+	return n - over
+}
@@ -0,0 +1,51 @@
+package tunnel
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConnectionQuota(t *testing.T) {
+	f := Forward(0, "").WithConnectionQuota(10)
+	var transferred int64
+	out := &bytes.Buffer{}
+	w := &quotaWriter{w: out, forwarder: f, state: newForwardState(), counter: &transferred}
+
+	if n, err := w.Write([]byte("123456")); n != 6 || err != nil {
+		t.Fatalf("write within quota failed: %d %v", n, err)
+	}
+	if n, err := w.Write([]byte("789012")); n != 4 || err != errQuotaExceeded {
+		t.Fatalf("expected write to be cut at the quota, got %d %v", n, err)
+	}
+	if out.String() != "1234567890" {
+		t.Fatalf("unexpected bytes written: %s", out)
+	}
+}
+
+func TestForwardQuota(t *testing.T) {
+	f := Forward(0, "").WithForwardQuota(8, true)
+	state := newForwardState()
+	var first, second int64
+	a := &quotaWriter{w: &bytes.Buffer{}, forwarder: f, state: state, counter: &first}
+	b := &quotaWriter{w: &bytes.Buffer{}, forwarder: f, state: state, counter: &second}
+
+	if _, err := a.Write([]byte("12345")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-state.quotaExceeded:
+		t.Fatal("quota reported exceeded too early")
+	default:
+	}
+	if n, err := b.Write([]byte("12345")); n != 3 || err != errQuotaExceeded {
+		t.Fatalf("expected second connection to be cut at the forward quota, got %d %v", n, err)
+	}
+	select {
+	case <-state.quotaExceeded:
+	default:
+		t.Fatal("quota not reported exceeded")
+	}
+	if n, err := a.Write([]byte("1")); n != 0 || err != errQuotaExceeded {
+		t.Fatalf("expected no more writes once the forward quota is used up, got %d %v", n, err)
+	}
+}
@@ -0,0 +1,87 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// WithRateLimit limits the throughput of the forward to bytesPerSecond, counting both directions across all of its
+// connections, so that a bulk transfer can't starve the others sharing the connection to the server. burst is how
+// much may be sent at once after a lull; it defaults to a second's worth.
+func (f Forwarder) WithRateLimit(bytesPerSecond, burst int64) Forwarder {
+	f.rateLimit = bytesPerSecond
+	f.rateBurst = burst
+	return f
+}
+
+// rateLimiter is a token bucket refilled at rate bytes a second up to burst
+type rateLimiter struct {
+	rate  float64
+	burst int64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate, burst int64) *rateLimiter {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &rateLimiter{rate: float64(rate), burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes n tokens, which may be no more than burst, waiting until they've been refilled if need be
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+	// taken up front, going into debt, so that waiters are served in turn
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rateLimitedWriter writes no faster than its limiter allows
+type rateLimitedWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func (r *rateLimitedWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if int64(len(chunk)) > r.limiter.burst {
+			chunk = chunk[:r.limiter.burst]
+		}
+		if err := r.limiter.wait(r.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := r.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,37 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedWriter(t *testing.T) {
+	limiter := newRateLimiter(100*1024, 10*1024)
+	out := &bytes.Buffer{}
+	a := &rateLimitedWriter{ctx: context.Background(), w: out, limiter: limiter}
+	b := &rateLimitedWriter{ctx: context.Background(), w: out, limiter: limiter}
+
+	start := time.Now()
+	// the burst goes straight away, the rest of the 30KB shared by both writers at 100KB/s
+	if _, err := a.Write(make([]byte, 20*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.Write(make([]byte, 10*1024)); n != 10*1024 || err != nil {
+		t.Fatalf("unexpected write: %d %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*180 || elapsed > time.Second {
+		t.Fatalf("expected about 200ms to write 30KB, took %v", elapsed)
+	}
+	if out.Len() != 30*1024 {
+		t.Fatalf("expected everything written, got %d bytes", out.Len())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := &rateLimitedWriter{ctx: ctx, w: out, limiter: limiter}
+	if _, err := c.Write(make([]byte, 20*1024)); err != context.Canceled {
+		t.Fatalf("expected the wait to end with the connection, got %v", err)
+	}
+}
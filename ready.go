@@ -0,0 +1,45 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Readiness is the state of every forward once the connection is up
+type Readiness struct {
+	Forward []ForwardStatus
+	Reverse []ReverseStatus
+}
+
+// Err describes the forwards that aren't live, or is nil when they all are
+func (r Readiness) Err() error {
+	var failed []string
+	for _, s := range r.Forward {
+		if !s.Bound {
+			failed = append(failed, fmt.Sprintf("local %s: %v", s.Local, s.Err))
+		}
+	}
+	for _, s := range r.Reverse {
+		if !s.Bound {
+			failed = append(failed, fmt.Sprintf("remote %s: %v", s.Remote, s.Err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d forwards not listening: %s", len(failed), strings.Join(failed, "; "))
+}
+
+// ExecuteAndReport is ExecuteAndBlock sending the state of every forward on ready, instead of signalling that
+// they're up, each time the connection is established. Forwarding waits for ready to be received from, or ctx to
+// be done.
+func ExecuteAndReport(ctx context.Context, spec *Spec, ready chan<- Readiness) error {
+	spec.ready = func(r Readiness) {
+		select {
+		case ready <- r:
+		case <-ctx.Done():
+		}
+	}
+	return executeAndBlock(ctx, spec, nil, nil)
+}
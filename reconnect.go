@@ -0,0 +1,69 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultReconnectBackoff    = time.Second
+	defaultMaxReconnectBackoff = time.Minute
+)
+
+// Reconnect is the policy for re-establishing a connection the server dropped
+type Reconnect struct {
+	// MaxRetries is how many consecutive attempts are made before giving up; 0 retries until the context is done
+	MaxRetries int
+	// InitialBackoff is the wait before the first attempt, doubled after every failed one; defaults to a second
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts; defaults to a minute
+	MaxBackoff time.Duration
+}
+
+// reconnect re-dials spec.Host following its Reconnect policy, counting attempts on t when set. It returns a nil
+// client without error when ctx is done first.
+func reconnect(ctx context.Context, spec *Spec, config *ssh.ClientConfig, t *Tunnel) (*ssh.Client, error) {
+	backoff := spec.Reconnect.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultReconnectBackoff
+	}
+	maxBackoff := spec.Reconnect.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxReconnectBackoff
+	}
+	var lastErr error
+	for attempt := 1; spec.Reconnect.MaxRetries == 0 || attempt <= spec.Reconnect.MaxRetries; attempt++ {
+		logAt(spec.Logger, LevelInfo, "reconnecting to %s in %v (attempt %d)", spec.Host, backoff, attempt)
+		retry := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			retry.Stop()
+			return nil, nil
+		case <-retry.C:
+		}
+		if t != nil {
+			atomic.AddInt64(&t.reconnectAttempts, 1)
+		}
+		client, err := makeServerConnection(ctx, spec, config)
+		if err == nil {
+			logAt(spec.Logger, LevelInfo, "reconnected to %s", spec.Host)
+			return client, nil
+		}
+		var changed *HostKeyChangedError
+		if errors.As(err, &changed) {
+			return nil, err
+		}
+		logAt(spec.Logger, LevelWarn, "unable to reconnect to %s: %v", spec.Host, err)
+		lastErr = err
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	return nil, fmt.Errorf("unable to reconnect to %s after %d attempts: %v", spec.Host, spec.Reconnect.MaxRetries, lastErr)
+}
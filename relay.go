@@ -0,0 +1,87 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// relayBufferSize is the size of the buffers connections are relayed through, larger than io.Copy's so that
+// busy tunnels make fewer reads and writes
+const relayBufferSize = 128 * 1024
+
+// spliceChunk is how much is spliced between counting the bytes relayed, so that stats keep up with
+// long-lived connections
+const spliceChunk = 4 * 1024 * 1024
+
+var relayBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, relayBufferSize)
+		return &b
+	},
+}
+
+// relay copies src to dst until src ends. When both are sockets, and dst only counts what's written to it, the
+// copy is left to the socket, which on linux splices it within the kernel; otherwise it goes through a buffer
+// shared between connections.
+func relay(dst io.Writer, src io.Reader) (int64, error) {
+	if splicing(dst, src) {
+		return dst.(io.ReaderFrom).ReadFrom(src)
+	}
+	buf := relayBuffers.Get().(*[]byte)
+	defer relayBuffers.Put(buf)
+	// hide ReadFrom and WriteTo so that the pooled buffer is used rather than one allocated by the socket
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
+
+// splicing is whether dst can take src straight from the socket
+func splicing(dst io.Writer, src io.Reader) bool {
+	switch src.(type) {
+	case *net.TCPConn, *net.UnixConn:
+	default:
+		return false
+	}
+	for {
+		switch w := dst.(type) {
+		case *net.TCPConn:
+			return true
+		case *countingWriter:
+			dst = w.w
+		default:
+			return false
+		}
+	}
+}
+
+// ReadFrom lets relay splice through a countingWriter, counting after every chunk. A limited src, as passed on
+// by an outer countingWriter, is unwrapped since sockets only splice from one that limits a socket directly.
+func (c *countingWriter) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := c.w.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{c}, src)
+	}
+	limited, isLimited := src.(*io.LimitedReader)
+	if isLimited {
+		src = limited.R
+	}
+	var total int64
+	for {
+		chunk := int64(spliceChunk)
+		if isLimited && limited.N < chunk {
+			chunk = limited.N
+		}
+		if chunk <= 0 {
+			return total, nil
+		}
+		n, err := rf.ReadFrom(&io.LimitedReader{R: src, N: chunk})
+		atomic.AddInt64(c.counter, n)
+		total += n
+		if isLimited {
+			limited.N -= n
+		}
+		if err != nil || n < chunk {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package tunnel
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// tcpPair returns both ends of a tcp connection over loopback
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	dialed, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dialed.(*net.TCPConn), (<-accepted).(*net.TCPConn)
+}
+
+func TestRelay(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 1<<18)
+	t.Run("spliced between sockets", func(t *testing.T) {
+		client, in := tcpPair(t)
+		out, server := tcpPair(t)
+		defer client.Close()
+		defer server.Close()
+		var total, connection int64
+		dst := &countingWriter{w: &countingWriter{w: out, counter: &total}, counter: &connection}
+		if !splicing(dst, in) {
+			t.Fatal("expected counted sockets to splice")
+		}
+		go func() {
+			client.Write(payload)
+			client.Close()
+		}()
+		received := make(chan []byte)
+		go func() {
+			b, _ := ioutil.ReadAll(server)
+			received <- b
+		}()
+		n, err := relay(dst, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in.Close()
+		out.Close()
+		if !bytes.Equal(<-received, payload) {
+			t.Fatal("expected the payload to be relayed intact")
+		}
+		if n != int64(len(payload)) || total != n || connection != n {
+			t.Fatalf("expected %d bytes relayed and counted, got %d, %d and %d", len(payload), n, total, connection)
+		}
+	})
+	t.Run("through a pooled buffer", func(t *testing.T) {
+		var b bytes.Buffer
+		var counted int64
+		dst := &countingWriter{w: &b, counter: &counted}
+		src := strings.NewReader(string(payload))
+		if splicing(dst, src) {
+			t.Fatal("expected a reader that isn't a socket to go through a buffer")
+		}
+		n, err := relay(dst, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(payload)) || counted != n || !bytes.Equal(b.Bytes(), payload) {
+			t.Fatalf("expected %d bytes relayed, got %d with %d counted", len(payload), n, counted)
+		}
+	})
+	t.Run("writers that see every chunk aren't spliced", func(t *testing.T) {
+		client, in := tcpPair(t)
+		defer client.Close()
+		defer in.Close()
+		var last int64
+		if splicing(&activityWriter{w: ioutil.Discard, last: &last}, in) {
+			t.Fatal("expected an activity writer to go through a buffer")
+		}
+	})
+}
+
+func BenchmarkRelay(b *testing.B) {
+	chunk := make([]byte, relayBufferSize)
+	b.SetBytes(int64(len(chunk)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		relay(ioutil.Discard, io.LimitReader(bytes.NewReader(chunk), int64(len(chunk))))
+	}
+}
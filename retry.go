@@ -0,0 +1,201 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = time.Minute
+)
+
+// RetryPolicy is how ExecuteWithRetry retries a tunnel that can't be established
+type RetryPolicy struct {
+	// MaxRetries is how many attempts are made after the first before giving up; 0 retries until the context is
+	// done
+	MaxRetries int
+	// InitialBackoff is the wait after the first failed attempt, doubled after every other one and jittered by up
+	// to half; defaults to a second
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts; defaults to a minute
+	MaxBackoff time.Duration
+	// FailureThreshold is how many consecutive failures open the circuit; defaults to 5
+	FailureThreshold int
+	// OpenTimeout is how long an open circuit waits before half opening to try again; defaults to a minute
+	OpenTimeout time.Duration
+}
+
+// CircuitState is the state of the circuit breaker of ExecuteWithRetry
+type CircuitState int
+
+const (
+	// CircuitClosed is while attempts are made with backoff
+	CircuitClosed CircuitState = iota
+	// CircuitOpen is after FailureThreshold consecutive failures, while no attempts are made for OpenTimeout
+	CircuitOpen
+	// CircuitHalfOpen is during the trial attempt after OpenTimeout; a failure opens the circuit again
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// RetryingTunnel is a tunnel being established by ExecuteWithRetry
+type RetryingTunnel struct {
+	done chan struct{}
+
+	mu       sync.Mutex
+	state    CircuitState
+	attempts int
+	lastErr  error
+	tunnel   *Tunnel
+	err      error
+}
+
+// Done is closed once the tunnel is established, or given up on
+func (r *RetryingTunnel) Done() <-chan struct{} {
+	return r.done
+}
+
+// Tunnel returns the tunnel once established, nil until then or when given up on
+func (r *RetryingTunnel) Tunnel() *Tunnel {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tunnel
+}
+
+// Err returns why the tunnel was given up on: the last attempt's error, or the context's
+func (r *RetryingTunnel) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Circuit returns the state of the circuit breaker
+func (r *RetryingTunnel) Circuit() CircuitState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// Attempts returns how many attempts have been made, and the error of the latest failed one
+func (r *RetryingTunnel) Attempts() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.attempts, r.lastErr
+}
+
+func (r *RetryingTunnel) setState(state CircuitState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = state
+}
+
+// ExecuteWithRetry establishes the tunnel in the background as ExecuteContext does, retrying with jittered backoff when
+// it can't be, as while DNS is failing or a bastion is rebooting. After FailureThreshold consecutive failures the
+// circuit opens and attempts pause for OpenTimeout. A changed host key isn't retried. The tunnel is closed once
+// ctx is done; use spec.Reconnect for it to survive losing the connection once established.
+func ExecuteWithRetry(ctx context.Context, spec *Spec, policy RetryPolicy) *RetryingTunnel {
+	return executeWithRetry(ctx, spec, policy, ExecuteContext)
+}
+
+func executeWithRetry(ctx context.Context, spec *Spec, policy RetryPolicy, execute func(context.Context, *Spec) (*Tunnel, error)) *RetryingTunnel {
+	r := &RetryingTunnel{done: make(chan struct{})}
+	go func() {
+		// the tunnel is torn down by execute once ctx is done
+		t, err := r.run(ctx, spec, policy, execute)
+		r.mu.Lock()
+		r.tunnel, r.err = t, err
+		r.mu.Unlock()
+		close(r.done)
+	}()
+	return r
+}
+
+func (r *RetryingTunnel) run(ctx context.Context, spec *Spec, policy RetryPolicy, execute func(context.Context, *Spec) (*Tunnel, error)) (*Tunnel, error) {
+	applyDefaults(spec)
+	backoff := policy.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultReconnectBackoff
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxReconnectBackoff
+	}
+	threshold := policy.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+	openTimeout := policy.OpenTimeout
+	if openTimeout <= 0 {
+		openTimeout = defaultOpenTimeout
+	}
+	failures := 0
+	for attempt := 1; ; attempt++ {
+		t, err := execute(ctx, spec)
+		r.mu.Lock()
+		r.attempts = attempt
+		if err != nil {
+			r.lastErr = err
+		}
+		r.mu.Unlock()
+		if err == nil {
+			r.setState(CircuitClosed)
+			if ctx.Err() != nil {
+				t.Close()
+				return nil, ctx.Err()
+			}
+			return t, nil
+		}
+		var changed *HostKeyChangedError
+		if errors.As(err, &changed) {
+			return nil, err
+		}
+		if policy.MaxRetries > 0 && attempt > policy.MaxRetries {
+			return nil, fmt.Errorf("unable to connect to %s after %d attempts: %w", spec.Host, attempt, err)
+		}
+		failures++
+		wait := jitter(backoff)
+		if failures >= threshold {
+			r.setState(CircuitOpen)
+			wait = openTimeout
+			logAt(spec.Logger, LevelWarn, "unable to connect to %s %d times in a row, pausing for %v: %v", spec.Host, failures, wait, err)
+		} else {
+			logAt(spec.Logger, LevelWarn, "unable to connect to %s, retrying in %v (attempt %d): %v", spec.Host, wait, attempt, err)
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		retry := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			retry.Stop()
+			return nil, ctx.Err()
+		case <-retry.C:
+		}
+		if failures >= threshold {
+			r.setState(CircuitHalfOpen)
+		}
+	}
+}
+
+// jitter picks a wait between half of d and d so that clients failing together don't retry together
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecuteWithRetry(t *testing.T) {
+	spec := &Spec{
+		Host:    testServer.Addr,
+		User:    testServer.User,
+		Auth:    testServer.Auth(),
+		Forward: []Forwarder{Forward(0, echoServer(t))},
+	}
+	var calls int32
+	flaky := func(ctx context.Context, spec *Spec) (*Tunnel, error) {
+		if atomic.AddInt32(&calls, 1) <= 3 {
+			return nil, errors.New("bastion is rebooting")
+		}
+		return ExecuteContext(ctx, spec)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	policy := RetryPolicy{InitialBackoff: time.Millisecond * 10, FailureThreshold: 2, OpenTimeout: time.Millisecond * 300}
+	r := executeWithRetry(ctx, spec, policy, flaky)
+
+	deadline := time.Now().Add(time.Second * 2)
+	for r.Circuit() != CircuitOpen && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if r.Circuit() != CircuitOpen {
+		t.Fatal("expected the circuit to open after 2 failures")
+	}
+	if attempts, err := r.Attempts(); attempts != 2 || err == nil {
+		t.Fatalf("expected 2 failed attempts, got %d (%v)", attempts, err)
+	}
+	select {
+	case <-r.Done():
+		t.Fatal("expected no attempts while the circuit is open")
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	select {
+	case <-r.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the tunnel")
+	}
+	tun := r.Tunnel()
+	if tun == nil || r.Err() != nil {
+		t.Fatalf("expected the tunnel to be established, got %v", r.Err())
+	}
+	if attempts, _ := r.Attempts(); attempts != 4 || r.Circuit() != CircuitClosed {
+		t.Fatalf("expected the circuit closed after the 4th attempt, got %v after %d", r.Circuit(), attempts)
+	}
+	cancel()
+	select {
+	case <-tun.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected the tunnel to be closed with the context")
+	}
+
+	failing := func(context.Context, *Spec) (*Tunnel, error) { return nil, errors.New("no route to host") }
+	r = executeWithRetry(context.Background(), &Spec{Host: "bastion"}, RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond}, failing)
+	<-r.Done()
+	if attempts, _ := r.Attempts(); attempts != 3 || r.Err() == nil || r.Tunnel() != nil {
+		t.Fatalf("expected to give up after 3 attempts, got %d (%v)", attempts, r.Err())
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	r = executeWithRetry(ctx, &Spec{Host: "bastion"}, RetryPolicy{}, failing)
+	cancel()
+	<-r.Done()
+	if r.Err() != context.Canceled {
+		t.Fatalf("expected retrying to stop with the context, got %v", r.Err())
+	}
+}
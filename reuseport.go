@@ -0,0 +1,10 @@
+package tunnel
+
+import "net"
+
+// ReusingPortListenConfig is a listen config setting SO_REUSEPORT on its sockets, so that another process, such as
+// an upgraded binary, can listen on the same address while this one still does; the kernel then spreads new
+// connections between the two. Where the option isn't supported it listens as usual.
+func ReusingPortListenConfig() net.ListenConfig {
+	return net.ListenConfig{Control: reusePort}
+}
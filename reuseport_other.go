@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package tunnel
+
+import "syscall"
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
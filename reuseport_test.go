@@ -0,0 +1,47 @@
+package tunnel
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("SO_REUSEPORT is only set on linux and darwin")
+	}
+	first, err := localNetwork{reusePort: true}.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if _, err := (localNetwork{}).Listen("tcp", first.Addr().String()); err == nil {
+		t.Fatal("expected the port to be taken for a listener not reusing it")
+	}
+	second, err := localNetwork{reusePort: true}.Listen("tcp", first.Addr().String())
+	if err != nil {
+		t.Fatalf("expected the port to be shared: %v", err)
+	}
+	second.Close()
+}
+
+func TestUnixSocketTakenOver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "forward.sock")
+	old, err := listenUnixSocket(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	successor, err := listenUnixSocket(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatalf("expected the successor's socket to be left in place: %v", err)
+	}
+	successor.Close()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket to be removed with its listener: %v", err)
+	}
+}
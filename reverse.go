@@ -0,0 +1,57 @@
+package tunnel
+
+import "net"
+
+// ReverseStatus is the state of a reverse forward's listener on the server
+type ReverseStatus struct {
+	// Name is the forward's name, when it has one
+	Name string
+	// Remote is where the forward listens on the server, such as port 8080
+	Remote string
+	// Addr is where the server listens when Bound, with the port it picked for a forward on port 0
+	Addr        net.Addr
+	Destination string
+	Bound       bool
+	// Err is why the server refused to listen when not Bound
+	Err error
+}
+
+func reverseStatuses(forwarders []Forwarder, listeners []net.Listener, bindErrs []error) []ReverseStatus {
+	statuses := make([]ReverseStatus, len(forwarders))
+	for i, f := range forwarders {
+		statuses[i] = ReverseStatus{
+			Name:        f.name,
+			Remote:      f.local(),
+			Destination: f.destination,
+			Bound:       bindErrs[i] == nil,
+			Err:         bindErrs[i],
+		}
+		if listeners[i] != nil {
+			statuses[i].Addr = listeners[i].Addr()
+		}
+	}
+	return statuses
+}
+
+func reportReverse(spec *Spec, t *Tunnel, statuses []ReverseStatus) {
+	if t != nil {
+		t.setReverseStatus(statuses)
+	}
+	if spec.OnReverse != nil {
+		spec.OnReverse(statuses)
+	}
+}
+
+// ReverseStatus returns the status of the reverse forwards of a tunnel started with Execute as of the latest
+// connection
+func (t *Tunnel) ReverseStatus() []ReverseStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ReverseStatus(nil), t.reverse...)
+}
+
+func (t *Tunnel) setReverseStatus(statuses []ReverseStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reverse = statuses
+}
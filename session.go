@@ -0,0 +1,58 @@
+package tunnel
+
+import (
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// NewSession opens a new session on the ssh connection for full control over the remote process
+func (t *Tunnel) NewSession() (*ssh.Session, error) {
+	return t.Client().NewSession()
+}
+
+// Run runs cmd on the remote host, returning once it completes; its output is discarded
+func (t *Tunnel) Run(cmd string) error {
+	session, err := t.Client().NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return session.Run(cmd)
+}
+
+// Output runs cmd on the remote host and returns its standard output
+func (t *Tunnel) Output(cmd string) ([]byte, error) {
+	session, err := t.Client().NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	return session.Output(cmd)
+}
+
+// CombinedOutput runs cmd on the remote host and returns its combined standard output and standard error
+func (t *Tunnel) CombinedOutput(cmd string) ([]byte, error) {
+	session, err := t.Client().NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	return session.CombinedOutput(cmd)
+}
+
+// Start starts cmd on the remote host without waiting for it to complete, sending its output to stdout and stderr
+// (either may be nil to discard it). The caller must Wait on and Close the returned session.
+func (t *Tunnel) Start(cmd string, stdout, stderr io.Writer) (*ssh.Session, error) {
+	session, err := t.Client().NewSession()
+	if err != nil {
+		return nil, err
+	}
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err := session.Start(cmd); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/sftp"
+)
+
+// SFTP returns an sftp client bound to the tunnel's ssh connection; the caller must Close it
+func (t *Tunnel) SFTP() (*sftp.Client, error) {
+	client, err := sftp.NewClient(t.Client())
+	if err != nil {
+		return nil, fmt.Errorf("unable to start sftp on %s: %v", t.spec.Host, err)
+	}
+	return client, nil
+}
+
+// Upload copies the local file at localPath to remotePath on the remote host, preserving its permissions
+func (t *Tunnel) Upload(localPath, remotePath string) error {
+	client, err := t.SFTP()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := client.Create(remotePath)
+	if err != nil {
+		return fmt.Errorf("unable to create %s on %s: %v", remotePath, t.spec.Host, err)
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return fmt.Errorf("unable to upload %s to %s:%s: %v", localPath, t.spec.Host, remotePath, err)
+	}
+	return dst.Chmod(info.Mode().Perm())
+}
+
+// Download copies remotePath on the remote host to the local file at localPath, preserving its permissions
+func (t *Tunnel) Download(remotePath, localPath string) error {
+	client, err := t.SFTP()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	src, err := client.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("unable to open %s on %s: %v", remotePath, t.spec.Host, err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("unable to download %s:%s to %s: %v", t.spec.Host, remotePath, localPath, err)
+	}
+	return dst.Close()
+}
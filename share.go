@@ -0,0 +1,268 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShareSpec defines publishing a local service through the ssh host, ngrok style
+type ShareSpec struct {
+	// LocalAddr is the local service being shared, e.g. localhost:3000
+	LocalAddr string
+	// RemotePort is the port opened on the remote host; 0 lets the remote host choose
+	RemotePort int
+	// PublicHost is the name under which the remote host is reachable; defaults to the host of Spec.Host
+	PublicHost string
+	// VHost, when set together with Front, registers the share with a ShareFront under this host name
+	VHost string
+	// Front is the registration address of a ShareFront as reachable from the remote host, e.g. localhost:8022
+	Front string
+	// Scheme of the shareable URL; defaults to http
+	Scheme string
+}
+
+// ShareAndBlock reverse-forwards share.LocalAddr to the remote host described by spec and publishes the
+// shareable URL on url once it's live. It blocks until ctx is done or the connection is terminated.
+func ShareAndBlock(ctx context.Context, spec *Spec, share *ShareSpec, url chan<- string) error {
+	if share.LocalAddr == "" {
+		return errors.New("nothing to share: local address is empty")
+	}
+	if share.VHost != "" && share.Front == "" {
+		return errors.New("sharing under a vhost requires the address of a share front")
+	}
+	t, err := Dial(spec)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	serverConnection := t.Client()
+
+	// behind a front only the front needs to reach us; otherwise the port is published directly (subject to GatewayPorts)
+	bindAddress := "0.0.0.0"
+	if share.Front != "" {
+		bindAddress = "localhost"
+	}
+	remoteListener, err := serverConnection.Listen("tcp", net.JoinHostPort(bindAddress, strconv.Itoa(share.RemotePort)))
+	if err != nil {
+		return fmt.Errorf("unable to bind to remote port %d: %v", share.RemotePort, err)
+	}
+	defer remoteListener.Close()
+	remotePort := remoteListener.Addr().(*net.TCPAddr).Port
+
+	sharedURL := ""
+	if share.VHost != "" {
+		front := shareFrontClient(serverConnection, share.Front, spec.ForwardTimeout)
+		if err := front.register(share.VHost, remotePort); err != nil {
+			return err
+		}
+		defer front.deregister(share.VHost)
+		sharedURL = shareScheme(share) + "://" + share.VHost
+	} else {
+		sharedURL = shareScheme(share) + "://" + net.JoinHostPort(sharePublicHost(spec, share), strconv.Itoa(remotePort))
+	}
+
+	localCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wg := sync.WaitGroup{}
+	go acceptNewConnectionAndTunnel(localCtx, remoteListener, localNetwork{}, Forward(remotePort, share.LocalAddr), spec.ForwardTimeout, spec.Logger, &wg)
+	logAt(spec.Logger, LevelInfo, "sharing %s as %s", share.LocalAddr, sharedURL)
+	if url != nil {
+		url <- sharedURL
+	}
+
+	serverConnectionDone := make(chan struct{})
+	go func() {
+		serverConnection.Wait()
+		close(serverConnectionDone)
+	}()
+	select {
+	case <-ctx.Done():
+		logAt(spec.Logger, LevelInfo, "sharing of %s terminating due to context cancellation", share.LocalAddr)
+	case <-serverConnectionDone:
+		logAt(spec.Logger, LevelWarn, "%s terminated our connection", spec.Host)
+	}
+	cancel()
+	wg.Wait()
+	return nil
+}
+
+func shareScheme(share *ShareSpec) string {
+	if share.Scheme == "" {
+		return "http"
+	}
+	return share.Scheme
+}
+
+func sharePublicHost(spec *Spec, share *ShareSpec) string {
+	if share.PublicHost != "" {
+		return share.PublicHost
+	}
+	host, _, err := net.SplitHostPort(spec.Host)
+	if err != nil {
+		return spec.Host
+	}
+	return host
+}
+
+type shareRegistration struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+type frontClient struct {
+	client *http.Client
+	base   string
+}
+
+// shareFrontClient talks to the front's registration endpoint through the ssh connection
+func shareFrontClient(dialer NetworkingDevice, front string, timeout time.Duration) *frontClient {
+	return &frontClient{
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Dial: dialer.Dial,
+			},
+		},
+		base: "http://" + front + "/shares",
+	}
+}
+
+func (c *frontClient) register(vhost string, port int) error {
+	body, err := json.Marshal(shareRegistration{Host: vhost, Port: port})
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(c.base, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to register %s with share front: %v", vhost, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("share front refused to register %s: %s", vhost, resp.Status)
+	}
+	return nil
+}
+
+func (c *frontClient) deregister(vhost string) {
+	req, err := http.NewRequest(http.MethodDelete, c.base+"/"+vhost, nil)
+	if err != nil {
+		return
+	}
+	if resp, err := c.client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// ShareFront is the HTTP front bundled for running on the remote host: it routes requests by their
+// Host header to the shares registered with it
+type ShareFront struct {
+	mu     sync.RWMutex
+	shares map[string]int
+	logger Logger
+}
+
+// NewShareFront returns an empty ShareFront
+func NewShareFront(logger Logger) *ShareFront {
+	if logger == nil {
+		logger = EmptyLogger()
+	}
+	return &ShareFront{
+		shares: make(map[string]int),
+		logger: logger,
+	}
+}
+
+// ServeAndBlock serves public traffic on publicAddr and the registration endpoint on adminAddr
+// (which should only be reachable locally) until ctx is done
+func (f *ShareFront) ServeAndBlock(ctx context.Context, publicAddr, adminAddr string) error {
+	public := &http.Server{Addr: publicAddr, Handler: f.proxy()}
+	admin := &http.Server{Addr: adminAddr, Handler: f.admin()}
+	errCh := make(chan error, 2)
+	for _, s := range []*http.Server{public, admin} {
+		go func(s *http.Server) {
+			if err := s.ListenAndServe(); err != http.ErrServerClosed {
+				errCh <- err
+			}
+		}(s)
+	}
+	logAt(f.logger, LevelInfo, "share front serving on %s, registrations on %s", publicAddr, adminAddr)
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+	}
+	public.Close()
+	admin.Close()
+	return err
+}
+
+func (f *ShareFront) proxy() http.Handler {
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = ""
+			if port, ok := f.portFor(req.Host); ok {
+				req.URL.Host = net.JoinHostPort("localhost", strconv.Itoa(port))
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			if req.URL.Host == "" {
+				http.Error(w, "no share registered for "+req.Host, http.StatusNotFound)
+				return
+			}
+			logAt(f.logger, LevelWarn, "unable to reach share for %s: %v", req.Host, err)
+			http.Error(w, "share unavailable", http.StatusBadGateway)
+		},
+	}
+}
+
+func (f *ShareFront) admin() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shares", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		reg := shareRegistration{}
+		if err := json.NewDecoder(req.Body).Decode(&reg); err != nil || reg.Host == "" || reg.Port == 0 {
+			http.Error(w, "invalid registration", http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.shares[strings.ToLower(reg.Host)] = reg.Port
+		f.mu.Unlock()
+		logAt(f.logger, LevelInfo, "registered share %s on port %d", reg.Host, reg.Port)
+	})
+	mux.HandleFunc("/shares/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		host := strings.ToLower(strings.TrimPrefix(req.URL.Path, "/shares/"))
+		f.mu.Lock()
+		delete(f.shares, host)
+		f.mu.Unlock()
+		logAt(f.logger, LevelInfo, "deregistered share %s", host)
+	})
+	return mux
+}
+
+func (f *ShareFront) portFor(host string) (int, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	port, ok := f.shares[strings.ToLower(host)]
+	return port, ok
+}
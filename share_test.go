@@ -0,0 +1,55 @@
+package tunnel
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestShareFront(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("shared"))
+	}))
+	defer backend.Close()
+	backendPort := backend.Listener.Addr().(*net.TCPAddr).Port
+
+	front := NewShareFront(nil)
+	admin := front.admin()
+	proxy := front.proxy()
+
+	get := func(host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("demo.share.example.com"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unregistered share, got %d", rec.Code)
+	}
+
+	body := []byte(`{"host":"demo.share.example.com","port":` + strconv.Itoa(backendPort) + `}`)
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/shares", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("registration failed: %d", rec.Code)
+	}
+
+	rec = get("Demo.share.example.com:80")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a registered share, got %d", rec.Code)
+	}
+	if b, _ := ioutil.ReadAll(rec.Body); string(b) != "shared" {
+		t.Fatalf("unexpected body: %s", b)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/shares/demo.share.example.com", nil))
+	if rec := get("demo.share.example.com"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after deregistration, got %d", rec.Code)
+	}
+}
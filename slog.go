@@ -0,0 +1,40 @@
+//go:build go1.21
+// +build go1.21
+
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// SlogLogger returns a Logger writing to l at the level of every message
+func SlogLogger(l *slog.Logger) LeveledLogger {
+	return &slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s *slogLogger) Log(format string, v ...interface{}) {
+	s.LogLevel(LevelInfo, format, v...)
+}
+
+func (s *slogLogger) LogLevel(level Level, format string, v ...interface{}) {
+	s.l.Log(context.Background(), slogLevel(level), strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
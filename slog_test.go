@@ -0,0 +1,24 @@
+//go:build go1.21
+// +build go1.21
+
+package tunnel
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := SlogLogger(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	logAt(logger, LevelDebug, "trace")
+	logAt(logger, LevelError, "unable to connect to %s\n", "host")
+	if strings.Contains(out.String(), "trace") {
+		t.Fatalf("debug message not filtered: %s", out)
+	}
+	if !strings.Contains(out.String(), `level=ERROR msg="unable to connect to host"`) {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
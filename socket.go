@@ -0,0 +1,113 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// ForwardUnix returns a Forwarder listening on a unix socket at socketPath instead of a local port. Only the
+// user running the tunnel may use it unless other local users are allowed with WithAllowedPeers. replicas are
+// as with Forward.
+func ForwardUnix(socketPath string, destination string, replicas ...string) Forwarder {
+	return Forwarder{
+		socket:       socketPath,
+		destination:  destination,
+		destinations: withReplicas(destination, replicas),
+	}
+}
+
+// WithAllowedPeers additionally allows the local users with the given uids, and processes running with one of
+// the given primary gids, to use a unix socket forward. Peers are identified by the kernel supplied credentials
+// of the connecting process; where those aren't available every connection is refused.
+func (f Forwarder) WithAllowedPeers(uids, gids []int) Forwarder {
+	f.allowedUIDs = uids
+	f.allowedGIDs = gids
+	return f
+}
+
+// local describes where the forward listens
+func (f Forwarder) local() string {
+	if f.socket != "" {
+		return "socket " + f.socket
+	}
+	if f.bindAddress != "" {
+		return "address " + net.JoinHostPort(f.bindAddress, strconv.Itoa(f.port))
+	}
+	return "port " + strconv.Itoa(f.port)
+}
+
+// listenUnixSocket listens on path, replacing a stale socket left behind by a previous run. The socket is only
+// accessible to its owner unless other peers are allowed, in which case access is left to allowsPeer.
+func listenUnixSocket(path string, shared bool) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0600)
+	if shared {
+		mode = 0666
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	unixListener := listener.(*net.UnixListener)
+	unixListener.SetUnlinkOnClose(false)
+	return &unixSocketListener{UnixListener: unixListener, path: path, info: info}, nil
+}
+
+// unixSocketListener removes its socket when closed only if it's still the one at its path, and not one that a
+// process taking over from this one listens on since
+type unixSocketListener struct {
+	*net.UnixListener
+	path string
+	info os.FileInfo
+}
+
+func (l *unixSocketListener) Close() error {
+	err := l.UnixListener.Close()
+	if current, statErr := os.Lstat(l.path); statErr == nil && os.SameFile(current, l.info) {
+		os.Remove(l.path)
+	}
+	return err
+}
+
+// allowsPeer checks the credentials of the process on the other end of a local unix socket connection
+func (f Forwarder) allowsPeer(conn net.Conn) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	uid, gid, err := peerCredentials(unixConn)
+	if err != nil {
+		return fmt.Errorf("unable to identify peer: %v", err)
+	}
+	if uid == os.Getuid() {
+		return nil
+	}
+	for _, allowed := range f.allowedUIDs {
+		if uid == allowed {
+			return nil
+		}
+	}
+	for _, allowed := range f.allowedGIDs {
+		if gid == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("peer with uid %d and gid %d is not allowed", uid, gid)
+}
@@ -0,0 +1,259 @@
+package tunnel
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// socksHandshakeTimeout is how long a SOCKS client has to authenticate and ask for a destination
+const socksHandshakeTimeout = time.Second * 10
+
+// replies to a SOCKS request (RFC 1928)
+const (
+	socksSucceeded       = 0
+	socksNotAllowed      = 2
+	socksHostUnreachable = 4
+	socksNotSupported    = 7
+)
+
+var lookupIP = net.LookupIP
+
+// socksServer is what a dynamic forward asks of its SOCKS clients
+type socksServer struct {
+	username string
+	password string
+	allowed  []string
+	denied   []string
+}
+
+// ForwardDynamic returns a Forwarder serving SOCKS5 on port, as with ssh -D: every connection is forwarded to the
+// destination its client asks for, dialed through the connection. Replicas and prewarming don't apply.
+func ForwardDynamic(port int) Forwarder {
+	return Forwarder{port: port, destination: "dynamic", socks: &socksServer{}}
+}
+
+// WithSOCKSAuth has the clients of a dynamic forward authenticate with username and password (RFC 1929), so that
+// others able to reach where it listens can't use the connection
+func (f Forwarder) WithSOCKSAuth(username, password string) Forwarder {
+	if f.socks != nil {
+		socks := *f.socks
+		socks.username, socks.password = username, password
+		f.socks = &socks
+	}
+	return f
+}
+
+// WithAllowedDestinations limits the clients of a dynamic forward to destinations matching one of patterns: an
+// address, a CIDR such as 10.0.0.0/8, a host name, or *.example.com for its subdomains. Host names are resolved by
+// the server, so they never match CIDRs.
+func (f Forwarder) WithAllowedDestinations(patterns ...string) Forwarder {
+	if f.socks != nil {
+		socks := *f.socks
+		socks.allowed = patterns
+		f.socks = &socks
+	}
+	return f
+}
+
+// WithDeniedDestinations refuses the clients of a dynamic forward destinations matching one of patterns, as for
+// WithAllowedDestinations, even when they're allowed. As the server resolves host names itself, a host name asked
+// for is resolved locally too and refused when any of its addresses is denied, or when it doesn't resolve while
+// addresses or CIDRs are denied.
+func (f Forwarder) WithDeniedDestinations(patterns ...string) Forwarder {
+	if f.socks != nil {
+		socks := *f.socks
+		socks.denied = patterns
+		f.socks = &socks
+	}
+	return f
+}
+
+// accept authenticates a SOCKS client and reads the destination it asks to connect to, which it's told it can't
+// have when that fails; the client is then owed a reply once the destination is dialed
+func (s *socksServer) accept(conn net.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := s.authenticate(conn); err != nil {
+		return "", err
+	}
+	// version, command, reserved and the type of the address that follows
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unexpected protocol version %d", header[0])
+	}
+	host, err := readSOCKSAddress(conn, header[3])
+	if err != nil {
+		socksReply(conn, socksNotSupported)
+		return "", err
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	destination := net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+	if header[1] != socks5Connect {
+		socksReply(conn, socksNotSupported)
+		return "", fmt.Errorf("unsupported command %d for %s", header[1], destination)
+	}
+	if !s.allows(host) {
+		socksReply(conn, socksNotAllowed)
+		return "", fmt.Errorf("%s is not an allowed destination", destination)
+	}
+	return destination, nil
+}
+
+// authenticate negotiates the method the client authenticates with: none, unless a username is required
+func (s *socksServer) authenticate(conn net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != socks5Version {
+		return fmt.Errorf("unexpected protocol version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+	method := byte(socks5NoAuth)
+	if s.username != "" {
+		method = socks5UserPassword
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == method
+	}
+	if !offered {
+		conn.Write([]byte{socks5Version, socks5NoAcceptableAuth})
+		return errors.New("no acceptable authentication method offered")
+	}
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return err
+	}
+	if method == socks5NoAuth {
+		return nil
+	}
+	// version of the subnegotiation, then the length prefixed username and password
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return err
+	}
+	username, err := readSOCKSString(conn)
+	if err != nil {
+		return err
+	}
+	password, err := readSOCKSString(conn)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(username), []byte(s.username)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) != 1 {
+		conn.Write([]byte{1, 1})
+		return fmt.Errorf("wrong credentials for %q", username)
+	}
+	_, err = conn.Write([]byte{1, 0})
+	return err
+}
+
+// allows is whether host is a destination the client may connect to
+func (s *socksServer) allows(host string) bool {
+	for _, pattern := range s.denied {
+		if matchesDestination(pattern, host) {
+			return false
+		}
+	}
+	if net.ParseIP(host) == nil && hasAddressPatterns(s.denied) {
+		ips, err := lookupIP(host)
+		if err != nil || len(ips) == 0 {
+			return false
+		}
+		for _, ip := range ips {
+			for _, pattern := range s.denied {
+				if matchesDestination(pattern, ip.String()) {
+					return false
+				}
+			}
+		}
+	}
+	if len(s.allowed) == 0 {
+		return true
+	}
+	for _, pattern := range s.allowed {
+		if matchesDestination(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAddressPatterns is whether any of patterns is an address or a CIDR, which host names never match
+func hasAddressPatterns(patterns []string) bool {
+	for _, pattern := range patterns {
+		if _, _, err := net.ParseCIDR(pattern); err == nil || net.ParseIP(pattern) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesDestination is whether host, an address or a host name, matches pattern as for WithAllowedDestinations
+func matchesDestination(pattern, host string) bool {
+	ip := net.ParseIP(host)
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		return ip != nil && network.Contains(ip)
+	}
+	if patternIP := net.ParseIP(pattern); patternIP != nil {
+		return ip != nil && patternIP.Equal(ip)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	if strings.HasPrefix(pattern, "*.") {
+		return ip == nil && strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+func readSOCKSAddress(r io.Reader, addressType byte) (string, error) {
+	switch addressType {
+	case socks5IPv4, socks5IPv6:
+		ip := make(net.IP, net.IPv4len)
+		if addressType == socks5IPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		return ip.String(), nil
+	case socks5DomainName:
+		return readSOCKSString(r)
+	}
+	return "", fmt.Errorf("unsupported address type %d", addressType)
+}
+
+// readSOCKSString reads a string prefixed by its length in a byte
+func readSOCKSString(r io.Reader) (string, error) {
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return "", err
+	}
+	b := make([]byte, length[0])
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// socksReply answers the client's request; the address it's bound to isn't known through ssh so none is given
+func socksReply(conn net.Conn, reply byte) error {
+	_, err := conn.Write([]byte{socks5Version, reply, 0, socks5IPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
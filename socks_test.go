@@ -0,0 +1,110 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestDynamicForward(t *testing.T) {
+	echo := echoServer(t)
+	spec := &Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: testServer.Auth(),
+		Forward: []Forwarder{
+			ForwardDynamic(0).WithName("socks").
+				WithSOCKSAuth("someone", "secret").
+				WithAllowedDestinations("127.0.0.0/8", "localhost").
+				WithDeniedDestinations("127.0.0.2"),
+		},
+	}
+	tun, err := Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	addr, _ := tun.LocalAddr("socks")
+	socks := func(credentials string) *socks5Dialer {
+		proxy, err := url.Parse("socks5://" + credentials + "@" + addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &socks5Dialer{proxy: proxy, dialer: &net.Dialer{}}
+	}
+
+	conn, err := socks("someone:secret").Dial("tcp", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("ping"))
+	reply := make([]byte, 4)
+	if _, err := conn.Read(reply); err != nil || string(reply) != "ping" {
+		t.Fatalf("expected ping back, got %q: %v", reply, err)
+	}
+	conn.Close()
+
+	if _, err := socks("someone:wrong").Dial("tcp", echo); err == nil {
+		t.Fatal("expected wrong credentials to be refused")
+	}
+	_, port, _ := net.SplitHostPort(echo)
+	for _, denied := range []string{"127.0.0.2:" + port, "example.com:80"} {
+		if _, err := socks("someone:secret").Dial("tcp", denied); err == nil {
+			t.Fatalf("expected %s to be refused", denied)
+		}
+	}
+	if _, err := socks("someone:secret").Dial("tcp", "localhost:1"); err == nil {
+		t.Fatal("expected an unreachable destination to be reported")
+	}
+}
+
+func TestMatchesDestination(t *testing.T) {
+	for _, c := range []struct {
+		pattern, host string
+		matches       bool
+	}{
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "11.1.2.3", false},
+		{"10.0.0.0/8", "db.internal", false},
+		{"::1", "0:0::1", true},
+		{"db.internal", "DB.internal.", true},
+		{"*.internal", "db.internal", true},
+		{"*.internal", "internal", false},
+		{"*.internal", "10.0.0.1", false},
+	} {
+		if matchesDestination(c.pattern, c.host) != c.matches {
+			t.Errorf("expected %s matching %s to be %v", c.pattern, c.host, c.matches)
+		}
+	}
+}
+
+func TestDeniedDestinationsResolveNames(t *testing.T) {
+	defer func(l func(string) ([]net.IP, error)) { lookupIP = l }(lookupIP)
+	lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "metadata.example.com":
+			return []net.IP{net.ParseIP("10.0.0.5"), net.ParseIP("169.254.169.254")}, nil
+		case "db.example.com":
+			return []net.IP{net.ParseIP("10.0.0.5")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	s := &socksServer{denied: []string{"169.254.0.0/16"}}
+	for host, allowed := range map[string]bool{
+		"db.example.com":       true,
+		"metadata.example.com": false,
+		"unknown.example.com":  false,
+		"169.254.169.254":      false,
+		"10.0.0.5":             true,
+	} {
+		if s.allows(host) != allowed {
+			t.Errorf("expected %s to be allowed %v", host, allowed)
+		}
+	}
+	// names are only looked up to check them against addresses
+	s = &socksServer{denied: []string{"*.blocked.example.com"}}
+	if !s.allows("unknown.example.com") {
+		t.Error("expected a name that doesn't resolve to be allowed when only names are denied")
+	}
+}
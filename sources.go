@@ -0,0 +1,38 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+)
+
+// WithAllowedSources refuses connections to the forward from addresses outside networks, such as when it listens
+// on 0.0.0.0 for a few hosts. For a reverse forward it's the address the server reports each connection came from.
+func (f Forwarder) WithAllowedSources(networks ...*net.IPNet) Forwarder {
+	f.allowedSources = networks
+	return f
+}
+
+// allowsSource is whether conn comes from one of the forward's allowed sources, when it has some
+func (f Forwarder) allowsSource(conn net.Conn) error {
+	if len(f.allowedSources) == 0 {
+		return nil
+	}
+	var ip net.IP
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	default:
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			ip = net.ParseIP(host)
+		}
+	}
+	if ip == nil {
+		return fmt.Errorf("source %s is not an address", conn.RemoteAddr())
+	}
+	for _, network := range f.allowedSources {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("source %s is not allowed", ip)
+}
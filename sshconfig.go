@@ -0,0 +1,454 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHConfig is an OpenSSH client config such as ~/.ssh/config, to look connection details of host aliases up in.
+// Host blocks, Include and the HostName, User, Port, IdentityFile, ProxyJump, LocalForward and RemoteForward options
+// are understood; Match blocks never match and other options are ignored.
+type SSHConfig struct {
+	blocks []sshConfigBlock
+}
+
+type sshConfigBlock struct {
+	// patterns is nil for options given before the first Host, which apply to every host
+	patterns []string
+	match    bool
+	options  []sshConfigOption
+}
+
+type sshConfigOption struct {
+	keyword string
+	args    []string
+}
+
+// SSHHost is what an SSHConfig says about connecting to a host alias
+type SSHHost struct {
+	// HostName is the host to connect to, the alias itself unless the config says otherwise
+	HostName string
+	// Port defaults to 22
+	Port string
+	// User is empty unless the config sets it
+	User          string
+	IdentityFiles []string
+	// ProxyJump are the jump hosts, as [user@]host[:port], to go through in order
+	ProxyJump []string
+	// LocalForwards and RemoteForwards are the forwards ssh would set up, as [bind_address:]port:host:hostport
+	LocalForwards  []string
+	RemoteForwards []string
+}
+
+// Addr is the host:port to connect to
+func (h SSHHost) Addr() string {
+	return net.JoinHostPort(h.HostName, h.Port)
+}
+
+// includes nested deeper than this are refused, as ssh does, to stop recursive includes
+const maxSSHConfigDepth = 16
+
+// LoadSSHConfig reads an OpenSSH client config file, ~/.ssh/config when file is empty. A missing
+// ~/.ssh/config is an empty config.
+func LoadSSHConfig(file string) (*SSHConfig, error) {
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return &SSHConfig{}, nil
+		}
+		file = filepath.Join(home, ".ssh", "config")
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			return &SSHConfig{}, nil
+		}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open ssh config: %v", err)
+	}
+	defer f.Close()
+	return ParseSSHConfig(f)
+}
+
+// ParseSSHConfig parses an OpenSSH client config; relative Include paths are taken to be in ~/.ssh
+func ParseSSHConfig(r io.Reader) (*SSHConfig, error) {
+	c := &SSHConfig{blocks: []sshConfigBlock{{match: true}}}
+	if err := c.parse(r, "config", 0); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *SSHConfig) parse(r io.Reader, name string, depth int) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		keyword, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s line %d: %v", name, line, err)
+		}
+		switch keyword {
+		case "":
+		case "host":
+			c.blocks = append(c.blocks, sshConfigBlock{patterns: args, match: true})
+		case "match":
+			c.blocks = append(c.blocks, sshConfigBlock{patterns: args})
+		case "include":
+			if err := c.include(args, depth); err != nil {
+				return fmt.Errorf("%s line %d: %v", name, line, err)
+			}
+		default:
+			if len(args) == 0 {
+				return fmt.Errorf("%s line %d: %s without a value", name, line, keyword)
+			}
+			current := &c.blocks[len(c.blocks)-1]
+			current.options = append(current.options, sshConfigOption{keyword: keyword, args: args})
+		}
+	}
+	return scanner.Err()
+}
+
+// include parses the files matching patterns in place; as with ssh, their options stay in the current block
+// until they start one of their own
+func (c *SSHConfig) include(patterns []string, depth int) error {
+	if depth >= maxSSHConfigDepth {
+		return fmt.Errorf("includes nested too deep")
+	}
+	for _, pattern := range patterns {
+		pattern = expandHome(pattern)
+		if !filepath.IsAbs(pattern) {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			pattern = filepath.Join(home, ".ssh", pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid include %s: %v", pattern, err)
+		}
+		for _, file := range files {
+			f, err := os.Open(file)
+			if err != nil {
+				return fmt.Errorf("unable to open include %s: %v", file, err)
+			}
+			err = c.parse(f, file, depth+1)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// splitSSHConfigLine splits a line into its lowercased keyword and arguments, which may be quoted; the keyword
+// may be separated by = instead of whitespace
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+	var args []string
+	for rest != "" {
+		var arg string
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return "", nil, fmt.Errorf("unterminated quote")
+			}
+			arg, rest = rest[1:closing+1], rest[closing+2:]
+		} else {
+			end := strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			arg, rest = rest[:end], rest[end:]
+		}
+		if strings.HasPrefix(arg, "#") {
+			break
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return keyword, args, nil
+}
+
+// matches reports whether alias matches one of the block's patterns and none of its negated ones
+func (b sshConfigBlock) matches(alias string) bool {
+	if !b.match {
+		return false
+	}
+	if b.patterns == nil {
+		return true
+	}
+	matched := false
+	for _, pattern := range b.patterns {
+		if strings.HasPrefix(pattern, "!") {
+			if wildcardMatch(pattern[1:], alias) {
+				return false
+			}
+			continue
+		}
+		if wildcardMatch(pattern, alias) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// wildcardMatch matches s against pattern, where * matches any run of characters and ? any single one
+func wildcardMatch(pattern, s string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || !strings.EqualFold(pattern[:1], s[:1]) {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+// Hosts returns the aliases named by Host lines, leaving out patterns
+func (c *SSHConfig) Hosts() []string {
+	seen := map[string]bool{}
+	hosts := []string{}
+	for _, b := range c.blocks {
+		if !b.match {
+			continue
+		}
+		for _, pattern := range b.patterns {
+			if strings.ContainsAny(pattern, "*?!") || seen[pattern] {
+				continue
+			}
+			seen[pattern] = true
+			hosts = append(hosts, pattern)
+		}
+	}
+	return hosts
+}
+
+// Lookup returns the connection details of alias. As with ssh, the first value found for an option is the one
+// used, except for IdentityFile, LocalForward and RemoteForward where all of them are.
+func (c *SSHConfig) Lookup(alias string) SSHHost {
+	host := SSHHost{}
+	var hostName string
+	if c != nil {
+		for _, b := range c.blocks {
+			if !b.matches(alias) {
+				continue
+			}
+			for _, o := range b.options {
+				switch o.keyword {
+				case "hostname":
+					if hostName == "" {
+						hostName = o.args[0]
+					}
+				case "user":
+					if host.User == "" {
+						host.User = o.args[0]
+					}
+				case "port":
+					if host.Port == "" {
+						host.Port = o.args[0]
+					}
+				case "identityfile":
+					host.IdentityFiles = append(host.IdentityFiles, o.args[0])
+				case "localforward":
+					host.LocalForwards = append(host.LocalForwards, strings.Join(o.args, ":"))
+				case "remoteforward":
+					host.RemoteForwards = append(host.RemoteForwards, strings.Join(o.args, ":"))
+				case "proxyjump":
+					if host.ProxyJump == nil {
+						host.ProxyJump = []string{}
+						if !strings.EqualFold(o.args[0], "none") {
+							host.ProxyJump = strings.Split(o.args[0], ",")
+						}
+					}
+				}
+			}
+		}
+	}
+	host.HostName = alias
+	if hostName != "" {
+		host.HostName = strings.NewReplacer("%h", alias, "%%", "%").Replace(hostName)
+	}
+	if host.Port == "" {
+		host.Port = "22"
+	}
+	if len(host.ProxyJump) == 0 {
+		host.ProxyJump = nil
+	}
+	for i, file := range host.IdentityFiles {
+		host.IdentityFiles[i] = expandIdentityFile(file, host)
+	}
+	return host
+}
+
+// expandIdentityFile expands ~ and the %d, %u, %h, %r and %% tokens ssh understands in identity file names
+func expandIdentityFile(file string, host SSHHost) string {
+	home, _ := os.UserHomeDir()
+	local := ""
+	if u, err := user.Current(); err == nil {
+		local = u.Username
+	}
+	return strings.NewReplacer("%d", home, "%u", local, "%h", host.HostName, "%r", host.User, "%%", "%").
+		Replace(expandHome(file))
+}
+
+func expandHome(file string) string {
+	if file != "~" && !strings.HasPrefix(file, "~/") {
+		return file
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return file
+	}
+	return filepath.Join(home, file[1:])
+}
+
+// Spec returns a Spec connecting to alias as ssh would: to its HostName and Port, as its User or the current
+// user, with its identity files or else ssh's default ones, through its ProxyJump hosts. The identity files must
+// not be passphrase protected. Add forwards and a HostKeyCallback before executing it.
+func (c *SSHConfig) Spec(alias string) (*Spec, error) {
+	host := c.Lookup(alias)
+	auth, err := IdentityFiles(host.IdentityFiles)
+	if err != nil {
+		return nil, err
+	}
+	spec := &Spec{Host: host.Addr(), User: host.User, Auth: auth}
+	if spec.User == "" {
+		spec.User = currentUser()
+	}
+	if spec.Via, err = c.Via(host.ProxyJump, auth); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// Via returns the jump host chain for ProxyJump hosts, each given as [user@]host[:port] and looked up in the
+// config, for use as Spec.Via. Hops without identity files of their own authenticate with auth.
+func (c *SSHConfig) Via(jumps []string, auth []ssh.AuthMethod) (*Spec, error) {
+	return c.via(jumps, auth, 0)
+}
+
+func (c *SSHConfig) via(jumps []string, auth []ssh.AuthMethod, depth int) (*Spec, error) {
+	if depth >= maxSSHConfigDepth {
+		return nil, fmt.Errorf("jump hosts nested too deep")
+	}
+	var via *Spec
+	for _, jump := range jumps {
+		jumpUser, alias, port := splitJump(jump)
+		host := c.Lookup(alias)
+		if jumpUser != "" {
+			host.User = jumpUser
+		}
+		if port != "" {
+			host.Port = port
+		}
+		hop := &Spec{Host: host.Addr(), User: host.User, Auth: auth, Via: via}
+		if hop.User == "" {
+			hop.User = currentUser()
+		}
+		if len(host.IdentityFiles) > 0 {
+			hopAuth, err := IdentityFiles(host.IdentityFiles)
+			if err != nil {
+				return nil, err
+			}
+			hop.Auth = hopAuth
+		}
+		// the first hop is connected to directly, so its own jump hosts apply
+		if via == nil && len(host.ProxyJump) > 0 {
+			first, err := c.via(host.ProxyJump, hop.Auth, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			hop.Via = first
+		}
+		via = hop
+	}
+	return via, nil
+}
+
+// splitJump splits [ssh://][user@]host[:port]
+func splitJump(jump string) (string, string, string) {
+	jump = strings.TrimPrefix(strings.TrimSpace(jump), "ssh://")
+	jumpUser := ""
+	if i := strings.LastIndex(jump, "@"); i >= 0 {
+		jumpUser, jump = jump[:i], jump[i+1:]
+	}
+	if host, port, err := net.SplitHostPort(jump); err == nil {
+		return jumpUser, host, port
+	}
+	return jumpUser, jump, ""
+}
+
+// defaultIdentityFiles are the identities ssh tries when none are configured
+var defaultIdentityFiles = []string{"~/.ssh/id_rsa", "~/.ssh/id_ecdsa", "~/.ssh/id_ed25519"}
+
+// IdentityFiles returns an AuthMethod offering the keys in files, skipping the ones that don't exist as ssh
+// does; with no files ssh's default identities are used, skipping passphrase protected ones
+func IdentityFiles(files []string) ([]ssh.AuthMethod, error) {
+	defaults := len(files) == 0
+	if defaults {
+		files = defaultIdentityFiles
+	}
+	var signers []ssh.Signer
+	for _, file := range files {
+		buffer, err := ioutil.ReadFile(expandHome(file))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read identity file %s: %v", file, err)
+		}
+		signer, err := ssh.ParsePrivateKey(buffer)
+		if err != nil && defaults {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse identity file %s: %v", file, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, nil
+	}
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, nil
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package tunnel
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSSHConfigLookup(t *testing.T) {
+	config, err := ParseSSHConfig(strings.NewReader(`
+# options before any host apply to all of them
+IdentityFile ~/.ssh/common
+
+Host bastion bastion-dr
+    HostName %h.example.com
+    User ops
+    Port 2222
+    IdentityFile "/keys/bastion key"
+    LocalForward 8080 db:5432
+    LocalForward 127.0.0.1:8081 web:80
+    RemoteForward 9000 localhost:80
+
+Host *.internal !skip.internal
+    ProxyJump ops@bastion,jump2:2200
+    User=app
+
+Match host anything
+    User never
+
+Host *
+    User fallback
+    ProxyJump none
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	home, _ := os.UserHomeDir()
+	common := filepath.Join(home, ".ssh", "common")
+
+	tests := []struct {
+		alias string
+		want  SSHHost
+	}{
+		{"bastion", SSHHost{
+			HostName:       "bastion.example.com",
+			Port:           "2222",
+			User:           "ops",
+			IdentityFiles:  []string{common, "/keys/bastion key"},
+			LocalForwards:  []string{"8080:db:5432", "127.0.0.1:8081:web:80"},
+			RemoteForwards: []string{"9000:localhost:80"},
+		}},
+		{"db.internal", SSHHost{
+			HostName:      "db.internal",
+			Port:          "22",
+			User:          "app",
+			IdentityFiles: []string{common},
+			ProxyJump:     []string{"ops@bastion", "jump2:2200"},
+		}},
+		{"skip.internal", SSHHost{
+			HostName:      "skip.internal",
+			Port:          "22",
+			User:          "fallback",
+			IdentityFiles: []string{common},
+		}},
+	}
+	for _, test := range tests {
+		if got := config.Lookup(test.alias); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %+v, got %+v", test.alias, test.want, got)
+		}
+	}
+
+	if hosts := config.Hosts(); !reflect.DeepEqual(hosts, []string{"bastion", "bastion-dr"}) {
+		t.Fatalf("expected the aliases without patterns, got %v", hosts)
+	}
+
+	via, err := config.Via(config.Lookup("db.internal").ProxyJump, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if via.Host != "jump2:2200" || via.User != "fallback" || via.Via == nil {
+		t.Fatalf("unexpected last jump: %+v", via)
+	}
+	if via.Via.Host != "bastion.example.com:2222" || via.Via.User != "ops" || via.Via.Via != nil {
+		t.Fatalf("unexpected first jump: %+v", via.Via)
+	}
+}
+
+func TestSSHConfigInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	included := filepath.Join(dir, "included")
+	if err := ioutil.WriteFile(included, []byte("Host box\n  HostName box.example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseSSHConfig(strings.NewReader("Include " + filepath.Join(dir, "incl*") + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Lookup("box").HostName; got != "box.example.com" {
+		t.Fatalf("expected the included host, got %s", got)
+	}
+
+	if err := ioutil.WriteFile(included, []byte("Include "+included+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSSHConfig(included); err == nil {
+		t.Fatal("expected recursive includes to be refused")
+	}
+}
+
+func TestSSHConfigSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := filepath.Join(dir, "id")
+	if err := testServer.WriteClientKey(key, ""); err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(testServer.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseSSHConfig(strings.NewReader(`
+Host target
+    HostName ` + host + `
+    Port ` + port + `
+    User ` + testServer.User + `
+    IdentityFile ` + key + `
+    ProxyJump jump
+
+Host jump
+    HostName ` + host + `
+    Port ` + port + `
+    User ` + testServer.User + `
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := config.Spec("target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.Forward = []Forwarder{Forward(1249, echoServer(t))}
+	tun, err := Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	assertEchoes(t, "localhost:1249")
+}
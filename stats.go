@@ -0,0 +1,137 @@
+package tunnel
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ForwardStats are the counters of a forward since the tunnel was started
+type ForwardStats struct {
+	// Name is the forward's name, or its destination when unnamed
+	Name        string
+	Local       string
+	Destination string
+	// ActiveConnections are currently being tunneled; TotalConnections includes them
+	ActiveConnections int64
+	TotalConnections  int64
+	// BytesIn were received from the destination, BytesOut sent to it
+	BytesIn  int64
+	BytesOut int64
+	// DialErrors counts connections that couldn't be tunneled as the destination couldn't be reached
+	DialErrors int64
+	// ConnectionTime is the time spent by connections that have finished
+	ConnectionTime time.Duration
+	// Disabled is set while the forward refuses new connections, see Tunnel.SetForwardEnabled
+	Disabled bool
+	// BindError is why the forward isn't listening, when it couldn't with BestEffort
+	BindError error
+}
+
+// Stats is a snapshot of the state of a tunnel and the counters of its forwards
+type Stats struct {
+	// Connected is false once the connection is lost, including while reconnecting; ConnectedSince is when it
+	// was last established
+	Connected         bool
+	ConnectedSince    time.Time
+	ReconnectAttempts int64
+	Forward           []ForwardStats
+	Reverse           []ForwardStats
+}
+
+// Total sums the counters of all forwards
+func (s Stats) Total() ForwardStats {
+	total := ForwardStats{}
+	for _, f := range append(append([]ForwardStats{}, s.Forward...), s.Reverse...) {
+		total.ActiveConnections += f.ActiveConnections
+		total.TotalConnections += f.TotalConnections
+		total.BytesIn += f.BytesIn
+		total.BytesOut += f.BytesOut
+		total.DialErrors += f.DialErrors
+		total.ConnectionTime += f.ConnectionTime
+	}
+	return total
+}
+
+// Stats returns the counters of the forwards of a tunnel started with Execute
+func (t *Tunnel) Stats() Stats {
+	t.mu.Lock()
+	connected, since, statuses := t.connected, t.since, t.forward
+	t.mu.Unlock()
+	forward := forwardStats(t.spec.Forward)
+	for i, s := range statuses {
+		if i < len(forward) && !s.Bound {
+			forward[i].BindError = s.Err
+		}
+	}
+	return Stats{
+		Connected:         connected,
+		ConnectedSince:    since,
+		ReconnectAttempts: atomic.LoadInt64(&t.reconnectAttempts),
+		Forward:           forward,
+		Reverse:           forwardStats(t.spec.Reverse),
+	}
+}
+
+func forwardStats(forwarders []Forwarder) []ForwardStats {
+	stats := make([]ForwardStats, 0, len(forwarders))
+	for _, f := range forwarders {
+		s := ForwardStats{Name: f.displayName(), Local: f.local(), Destination: f.destination}
+		if c := f.counters; c != nil {
+			s.ActiveConnections = atomic.LoadInt64(&c.active)
+			s.TotalConnections = atomic.LoadInt64(&c.total)
+			s.BytesIn = atomic.LoadInt64(&c.bytesIn)
+			s.BytesOut = atomic.LoadInt64(&c.bytesOut)
+			s.DialErrors = atomic.LoadInt64(&c.dialErrors)
+			s.ConnectionTime = time.Duration(atomic.LoadInt64(&c.connectionTime))
+			s.Disabled = c.isDisabled()
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// forwardCounters are shared by every copy of a forwarder so they outlive reconnects; all fields are accessed
+// atomically. A nil *forwardCounters counts nothing.
+type forwardCounters struct {
+	active         int64
+	total          int64
+	bytesIn        int64
+	bytesOut       int64
+	dialErrors     int64
+	connectionTime int64
+	// disabled is 1 while the forward refuses new connections
+	disabled int32
+}
+
+func (c *forwardCounters) dialFailed() {
+	if c != nil {
+		atomic.AddInt64(&c.dialErrors, 1)
+	}
+}
+
+// connected records a new connection, returning the func to call once it's finished
+func (c *forwardCounters) connected() func() {
+	if c == nil {
+		return func() {}
+	}
+	atomic.AddInt64(&c.active, 1)
+	atomic.AddInt64(&c.total, 1)
+	start := time.Now()
+	return func() {
+		atomic.AddInt64(&c.active, -1)
+		atomic.AddInt64(&c.connectionTime, int64(time.Since(start)))
+	}
+}
+
+// countingWriter adds the bytes written to counter
+type countingWriter struct {
+	w       io.Writer
+	counter *int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	atomic.AddInt64(c.counter, int64(n))
+	return n, err
+}
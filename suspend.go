@@ -0,0 +1,210 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// suspendingClient is a NetworkingDevice for local forwards that closes the ssh connection once no tunneled
+// connection has been active for spec.SuspendAfter, and transparently re-establishes it on the next dial
+type suspendingClient struct {
+	spec   *Spec
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+	active int
+	idle   *time.Timer
+	closed bool
+	// resuming, while set, is closed once the dial resuming the connection is done
+	resuming chan struct{}
+}
+
+func canSuspend(spec *Spec) bool {
+	if spec.SuspendAfter <= 0 {
+		return false
+	}
+	if len(spec.Reverse) > 0 || spec.VPN != nil {
+		logAt(spec.Logger, LevelWarn, "not suspending idle connection to %s: reverse forwards and vpn need a permanent connection", spec.Host)
+		return false
+	}
+	return true
+}
+
+func newSuspendingClient(spec *Spec, config *ssh.ClientConfig, client *ssh.Client) *suspendingClient {
+	s := &suspendingClient{
+		spec:   spec,
+		config: config,
+		client: client,
+	}
+	s.idle = time.AfterFunc(spec.SuspendAfter, s.suspend)
+	return s
+}
+
+func (s *suspendingClient) Listen(network, address string) (net.Listener, error) {
+	return nil, errors.New("listening is not supported on a suspendable connection")
+}
+
+func (s *suspendingClient) Dial(n, addr string) (net.Conn, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, s.closedError()
+	}
+	s.idle.Stop()
+	// counted while dialing so that the connection isn't suspended underneath it
+	s.active++
+	s.mu.Unlock()
+	conn, err := s.dial(n, addr)
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	return &releasingConn{Conn: conn, release: s.release}, nil
+}
+
+// dial dials addr without holding mu, so that a slow destination or resume doesn't hold up other connections
+func (s *suspendingClient) dial(n, addr string) (net.Conn, error) {
+	client, err := s.connected()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := DialWithTimeout(client, n, addr, s.spec.ForwardTimeout)
+	if err == nil || !isConnectionGone(client) {
+		return conn, err
+	}
+	// the server dropped us while suspended in all but name; reconnect once
+	s.mu.Lock()
+	if s.client == client {
+		s.client = nil
+	}
+	s.mu.Unlock()
+	client.Close()
+	if client, err = s.connected(); err != nil {
+		return nil, err
+	}
+	return DialWithTimeout(client, n, addr, s.spec.ForwardTimeout)
+}
+
+// connected returns the ssh connection, resuming it if suspended. Only one caller resumes at a time, the others
+// wait for it.
+func (s *suspendingClient) connected() (*ssh.Client, error) {
+	s.mu.Lock()
+	for {
+		if s.closed {
+			s.mu.Unlock()
+			return nil, s.closedError()
+		}
+		if s.client != nil {
+			client := s.client
+			s.mu.Unlock()
+			return client, nil
+		}
+		if s.resuming == nil {
+			break
+		}
+		resuming := s.resuming
+		s.mu.Unlock()
+		<-resuming
+		s.mu.Lock()
+	}
+	resuming := make(chan struct{})
+	s.resuming = resuming
+	s.mu.Unlock()
+
+	client, err := s.resume()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resuming = nil
+	close(resuming)
+	if err != nil {
+		return nil, err
+	}
+	if s.closed {
+		onDisconnect(s.spec, client)
+		client.Close()
+		return nil, s.closedError()
+	}
+	s.client = client
+	return client, nil
+}
+
+func (s *suspendingClient) resume() (*ssh.Client, error) {
+	client, err := makeServerConnection(context.Background(), s.spec, s.config)
+	if err != nil {
+		return nil, err
+	}
+	if err := onConnect(s.spec, client); err != nil {
+		client.Close()
+		return nil, err
+	}
+	logAt(s.spec.Logger, LevelInfo, "resumed connection to %s", s.spec.Host)
+	return client, nil
+}
+
+func (s *suspendingClient) closedError() error {
+	return errors.New("connection to " + s.spec.Host + " is closed")
+}
+
+func (s *suspendingClient) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	s.startIdleTimerIfIdle()
+}
+
+func (s *suspendingClient) startIdleTimerIfIdle() {
+	if s.active == 0 && !s.closed {
+		s.idle.Reset(s.spec.SuspendAfter)
+	}
+}
+
+func (s *suspendingClient) suspend() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active > 0 || s.client == nil {
+		return
+	}
+	onDisconnect(s.spec, s.client)
+	s.client.Close()
+	s.client = nil
+	logAt(s.spec.Logger, LevelInfo, "suspended idle connection to %s", s.spec.Host)
+}
+
+// Close closes the connection for good
+func (s *suspendingClient) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.idle.Stop()
+	if s.client != nil {
+		onDisconnect(s.spec, s.client)
+		s.client.Close()
+		s.client = nil
+	}
+}
+
+// isConnectionGone reports whether the ssh connection has terminated
+func isConnectionGone(client *ssh.Client) bool {
+	_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+	return err != nil
+}
+
+// releasingConn calls release exactly once when closed
+type releasingConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *releasingConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -1,56 +1,47 @@
 package main
 
 import (
-	"io"
-	"io/ioutil"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
 
-	"github.com/gliderlabs/ssh"
-	cryptossh "golang.org/x/crypto/ssh"
+	"github.com/arunsworld/go-tunnel/tunneltest"
+	"golang.org/x/crypto/ssh"
 )
 
-func main() {
-
-	pwdHandler := func(ctx ssh.Context, password string) bool {
-		if ctx.User() != "testuser" {
-			return false
-		}
-		if password != "the right password" {
-			return false
-		}
-		return true
-	}
-
-	lc := ssh.LocalPortForwardingCallback(func(ctx ssh.Context, dhost string, dport uint32) bool {
-		log.Println("Accepted forward", dhost, dport)
-		return true
-	})
+// the client key is encrypted so that tests exercise passphrase handling
+const clientKeyPassphrase = "passphrase"
 
-	buffer, err := ioutil.ReadFile("id_rsa.pub")
-	if err != nil {
-		log.Fatal("Couldn't read public key:", err)
-	}
-	acceptableKey, _, _, _, err := cryptossh.ParseAuthorizedKey(buffer)
+func main() {
+	keyDir := flag.String("keydir", filepath.Join(os.TempDir(), "go-tunnel-test-server"), "directory to write the acceptable client key to")
+	var conditions tunneltest.Conditions
+	flag.DurationVar(&conditions.Latency, "latency", 0, "delay added to forwarded data")
+	flag.DurationVar(&conditions.Jitter, "jitter", 0, "maximum random delay added on top of latency")
+	flag.IntVar(&conditions.BytesPerSecond, "bandwidth", 0, "bytes per second cap on each direction of a forwarded connection")
+	flag.Float64Var(&conditions.DisconnectProbability, "disconnect", 0, "probability per chunk of data of dropping a forwarded connection")
+	flag.Int64Var(&conditions.Seed, "seed", 1, "seed for jitter and disconnects")
+	flag.Parse()
+
+	server, err := tunneltest.Listen(":2229")
 	if err != nil {
-		log.Fatal("Couldn't parse public key:", err)
+		log.Fatal("Couldn't start test server:", err)
 	}
+	defer server.Close()
+	server.SetConditions(conditions)
 
-	pkeyHandler := func(ctx ssh.Context, key ssh.PublicKey) bool {
-		if ctx.User() != "testuser" {
-			return false
-		}
-		return ssh.KeysEqual(key, acceptableKey)
+	if err := os.MkdirAll(*keyDir, 0700); err != nil {
+		log.Fatal("Couldn't create key directory:", err)
 	}
-
-	server := ssh.Server{
-		Addr: ":2229",
-		Handler: ssh.Handler(func(s ssh.Session) {
-			io.WriteString(s, "hello, world\n")
-		}),
-		PasswordHandler:             pwdHandler,
-		LocalPortForwardingCallback: lc,
-		PublicKeyHandler:            pkeyHandler,
+	keyFile := filepath.Join(*keyDir, "id_ecdsa")
+	if err := server.WriteClientKey(keyFile, clientKeyPassphrase); err != nil {
+		log.Fatal("Couldn't write client key:", err)
 	}
+	log.Println("Client key written to", keyFile)
+	log.Println("Listening on", server.Addr, "as", server.User, "with host key", ssh.FingerprintSHA256(server.HostKey))
 
-	log.Fatal(server.ListenAndServe())
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	<-interrupted
 }
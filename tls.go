@@ -0,0 +1,92 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// WithListenerTLS serves the forward's listener over TLS with config, terminating it there so that clients
+// speaking TLS reach a destination that doesn't, such as https tools reaching a plain http backend
+func (f Forwarder) WithListenerTLS(config *tls.Config) Forwarder {
+	f.listenerTLS = config
+	return f
+}
+
+// WithDestinationTLS speaks TLS with config to the forward's destination, so that plain clients reach a
+// destination that only accepts TLS. The server name is the destination's host unless config sets one.
+func (f Forwarder) WithDestinationTLS(config *tls.Config) Forwarder {
+	f.destinationTLS = config
+	return f
+}
+
+// SelfSignedTLSConfig returns a TLS config serving a certificate for hosts, names or IP addresses, signed by
+// itself and valid for a year; for WithListenerTLS when clients can be told to trust it or not to verify
+func SelfSignedTLSConfig(hosts ...string) (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"go-tunnel"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, nil
+}
+
+// dialingTLS dials with dial and completes a TLS handshake with config over the connection within timeout
+func dialingTLS(ctx context.Context, dial func(string) (net.Conn, error), config *tls.Config, timeout time.Duration) func(string) (net.Conn, error) {
+	return func(addr string) (net.Conn, error) {
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		config := config.Clone()
+		if config.ServerName == "" {
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				config.ServerName = host
+			}
+		}
+		handshakeCtx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			handshakeCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("tls handshake with %s failed: %v", addr, err)
+		}
+		return tlsConn, nil
+	}
+}
@@ -0,0 +1,72 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestForwardTLS(t *testing.T) {
+	serverConfig, err := SelfSignedTLSConfig("localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := x509.NewCertPool()
+	cert, err := x509.ParseCertificate(serverConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted.AddCert(cert)
+
+	// an https-only destination, reached by plain clients
+	tlsEcho, err := tls.Listen("tcp", "localhost:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsEcho.Close()
+	go func() {
+		for {
+			conn, err := tlsEcho.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	tun, err := Execute(&Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: testServer.Auth(),
+		Forward: []Forwarder{
+			Forward(1246, echoServer(t)).WithListenerTLS(serverConfig),
+			Forward(1247, tlsEcho.Addr().String()).WithDestinationTLS(&tls.Config{RootCAs: trusted}),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", "localhost:1246", &tls.Config{RootCAs: trusted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Fatalf("expected ping back over tls, got %q: %v", reply, err)
+	}
+
+	assertEchoes(t, "localhost:1247")
+}
@@ -0,0 +1,30 @@
+package tunnel
+
+import "sync/atomic"
+
+// SetForwardEnabled enables or disables the local or reverse forward with the given name, or if unnamed with the
+// given destination, of a tunnel started with Execute. A disabled forward keeps listening but closes new
+// connections straight away; connections already tunneled are left alone. It reports whether such a forward
+// exists. Disabling survives reconnects.
+func (t *Tunnel) SetForwardEnabled(name string, enabled bool) bool {
+	found := false
+	for _, f := range append(append([]Forwarder{}, t.spec.Forward...), t.spec.Reverse...) {
+		if f.displayName() == name && f.counters != nil {
+			f.counters.setDisabled(!enabled)
+			found = true
+		}
+	}
+	return found
+}
+
+func (c *forwardCounters) setDisabled(disabled bool) {
+	var v int32
+	if disabled {
+		v = 1
+	}
+	atomic.StoreInt32(&c.disabled, v)
+}
+
+func (c *forwardCounters) isDisabled() bool {
+	return c != nil && atomic.LoadInt32(&c.disabled) == 1
+}
@@ -0,0 +1,36 @@
+package tunnel
+
+import "context"
+
+// Tracer starts the spans of a tunnel's lifecycle, such as with OpenTelemetry through tunnelotel.Tracer from the
+// github.com/arunsworld/go-tunnel/tunnelotel module, so that the latency of connecting and forwarding can be seen
+// alongside application traces. Spans are children of the span in the context the tunnel was started with, if any:
+//
+//	tunnel.connect  connecting to Host, with tunnel.host, tunnel.user and once connected tunnel.server
+//	tunnel.forward  a tunneled connection, with tunnel.forward, tunnel.local, tunnel.peer, tunnel.destination,
+//	                and once it's closed tunnel.bytes_in and tunnel.bytes_out
+//	tunnel.dial     dialing a forward's destination through the connection, within tunnel.forward
+type Tracer interface {
+	Start(ctx context.Context, name string, attributes map[string]interface{}) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer; attribute values are strings or int64s
+type Span interface {
+	SetAttributes(attributes map[string]interface{})
+	// End ends the span, which failed when err is set
+	End(err error)
+}
+
+// startSpan starts a span with t, or one recording nothing when t is nil
+func startSpan(ctx context.Context, t Tracer, name string, attributes map[string]interface{}) (context.Context, Span) {
+	if t == nil {
+		return ctx, noSpan{}
+	}
+	return t.Start(ctx, name, attributes)
+}
+
+type noSpan struct{}
+
+func (noSpan) SetAttributes(map[string]interface{}) {}
+
+func (noSpan) End(error) {}
@@ -0,0 +1,110 @@
+package tunnel
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedSpan struct {
+	name       string
+	parent     string
+	attributes map[string]interface{}
+	ended      bool
+	err        error
+}
+
+// recordingTracer keeps every span it starts, parented by the name of the span in the context
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type spanKey struct{}
+
+func (r *recordingTracer) Start(ctx context.Context, name string, attributes map[string]interface{}) (context.Context, Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	span := &recordedSpan{name: name, attributes: map[string]interface{}{}}
+	span.parent, _ = ctx.Value(spanKey{}).(string)
+	for k, v := range attributes {
+		span.attributes[k] = v
+	}
+	r.spans = append(r.spans, span)
+	return context.WithValue(ctx, spanKey{}, name), &recordingSpan{tracer: r, span: span}
+}
+
+func (r *recordingTracer) ended(name string) *recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.spans {
+		if s.name == name && s.ended {
+			return s
+		}
+	}
+	return nil
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	span   *recordedSpan
+}
+
+func (s *recordingSpan) SetAttributes(attributes map[string]interface{}) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	for k, v := range attributes {
+		s.span.attributes[k] = v
+	}
+}
+
+func (s *recordingSpan) End(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.span.ended, s.span.err = true, err
+}
+
+func TestTracer(t *testing.T) {
+	// connections that are drained outlive the context, but their spans are still within its
+	for _, drain := range []time.Duration{0, time.Second} {
+		tracer := &recordingTracer{}
+		echo := echoServer(t)
+		spec := &Spec{
+			Host:         testServer.Addr,
+			User:         testServer.User,
+			Auth:         testServer.Auth(),
+			Forward:      []Forwarder{Forward(0, echo).WithName("echo")},
+			Tracer:       tracer,
+			DrainTimeout: drain,
+		}
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), spanKey{}, "app"))
+		defer cancel()
+		tun, err := ExecuteContext(ctx, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tun.Close()
+		addr, _ := tun.LocalAddr("echo")
+		assertEchoes(t, addr.String())
+
+		connect := tracer.ended("tunnel.connect")
+		if connect == nil || connect.err != nil || connect.parent != "app" || connect.attributes["tunnel.server"] == nil {
+			t.Fatalf("expected a span of connecting within the application's, got %+v", connect)
+		}
+		var forward *recordedSpan
+		for deadline := time.Now().Add(time.Second * 5); forward == nil && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond * 10)
+			forward = tracer.ended("tunnel.forward")
+		}
+		if forward == nil || forward.attributes["tunnel.destination"] != echo || forward.attributes["tunnel.bytes_in"] != int64(4) || forward.attributes["tunnel.bytes_out"] != int64(4) {
+			t.Fatalf("expected a span of the tunneled connection with the bytes it transferred, got %+v", forward)
+		}
+		if forward.parent != "app" {
+			t.Fatalf("drain %v: expected the tunneled connection's span within the application's, got %q", drain, forward.parent)
+		}
+		if dial := tracer.ended("tunnel.dial"); dial == nil || dial.parent != "tunnel.forward" {
+			t.Fatalf("expected a span of dialing the destination within the connection's, got %+v", dial)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	tunSetIff = 0x400454ca
+	iffTun    = 0x0001
+	iffNoPi   = 0x1000
+)
+
+type ifReq struct {
+	Name  [syscall.IFNAMSIZ]byte
+	Flags uint16
+	_     [22]byte
+}
+
+type linuxTUN struct {
+	*os.File
+	name string
+}
+
+func (t *linuxTUN) Name() string {
+	return t.name
+}
+
+func openTUN(name string) (TUNDevice, error) {
+	f, err := os.OpenFile("/dev/net/tun", os.O_RDWR|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	req := ifReq{Flags: iffTun | iffNoPi}
+	copy(req.Name[:], name)
+	rawConn, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var errno syscall.Errno
+	err = rawConn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, tunSetIff, uintptr(unsafe.Pointer(&req)))
+	})
+	if err == nil && errno != 0 {
+		err = errno
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &linuxTUN{
+		File: f,
+		name: string(bytes.TrimRight(req.Name[:], "\x00")),
+	}, nil
+}
+
+func configureTUN(name, address string, mtu int, routes []string) error {
+	commands := [][]string{
+		{"link", "set", "dev", name, "mtu", strconv.Itoa(mtu)},
+	}
+	if address != "" {
+		commands = append(commands, []string{"addr", "add", address, "dev", name})
+	}
+	commands = append(commands, []string{"link", "set", "dev", name, "up"})
+	for _, r := range routes {
+		commands = append(commands, []string{"route", "add", r, "dev", name})
+	}
+	for _, args := range commands {
+		if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("ip %v: %v: %s", args, err, bytes.TrimSpace(out))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package tunnel
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func openTUN(name string) (TUNDevice, error) {
+	return nil, fmt.Errorf("tun interfaces are not supported on %s", runtime.GOOS)
+}
+
+func configureTUN(name, address string, mtu int, routes []string) error {
+	return fmt.Errorf("tun interfaces are not supported on %s", runtime.GOOS)
+}
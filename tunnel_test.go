@@ -1,82 +1,154 @@
 package tunnel
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/arunsworld/go-tunnel/tunneltest"
 	"golang.org/x/crypto/ssh"
 )
 
-func port2229Open() bool {
-	conn, err := net.DialTimeout("tcp", net.JoinHostPort("", "2229"), time.Millisecond*200)
+// testServer is the in-process ssh server the tests connect to
+var testServer *tunneltest.Server
+
+func TestMain(m *testing.M) {
+	server, err := tunneltest.NewServer()
 	if err != nil {
-		return false
+		log.Fatal(err)
 	}
-	conn.Close()
-	return true
+	testServer = server
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
 }
 
-func TestSSHConnectionWithPassword(t *testing.T) {
-	if !port2229Open() {
-		t.Fatal("Port 2229 not open. Please run test_server.")
+// closedPort returns a localhost port nothing is listening on
+func closedPort(t *testing.T) string {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	return l.Addr().String()
+}
+
+// echoServer relays everything it receives back to the sender until the test ends
+func echoServer(t *testing.T) string {
+	echo, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return echo.Addr().String()
+}
+
+// assertEchoes sends a message to addr and expects it back
+func assertEchoes(t *testing.T, addr string) {
+	t.Helper()
+	assertEchoesOn(t, "tcp", addr)
+}
+
+func assertEchoesOn(t *testing.T, network, addr string) {
+	t.Helper()
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
 	}
+	if string(reply) != "ping" {
+		t.Fatalf("expected ping back, got %q", reply)
+	}
+}
 
+func TestSSHConnectionWithPassword(t *testing.T) {
 	spec := &Spec{
-		Host: "localhost:2229",
-		User: "testuser",
+		Host: testServer.Addr,
+		User: testServer.User,
 		Auth: []ssh.AuthMethod{
-			ssh.Password("the right password"),
+			ssh.Password(testServer.Password),
 		},
 	}
 
-	if err := Execute(spec); err != nil {
+	if _, err := Execute(spec); err != nil {
 		t.Fatal(err)
 	}
 }
 
 func TestSSHConnectionWithKey(t *testing.T) {
-	if !port2229Open() {
-		t.Fatal("Port 2229 not open. Please run test_server.")
+	dir, err := ioutil.TempDir("", "key")
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	key, err := PrivateKeyFile("test_server/id_rsa", "passphrase")
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "id_ecdsa")
+	if err := testServer.WriteClientKey(keyFile, "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	key, err := PrivateKeyFile(keyFile, "passphrase")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	spec := &Spec{
-		Host: "localhost:2229",
-		User: "testuser",
+		Host: testServer.Addr,
+		User: testServer.User,
 		Auth: []ssh.AuthMethod{
 			key,
 		},
 	}
 
-	if err := Execute(spec); err != nil {
+	if _, err := Execute(spec); err != nil {
 		t.Fatal(err)
 	}
 }
 
 func TestPortForward(t *testing.T) {
-	if !port2229Open() {
-		t.Fatal("Port 2229 not open. Please run test_server.")
-	}
-
 	spec := &Spec{
-		Host: "localhost:2229",
-		User: "testuser",
+		Host: testServer.Addr,
+		User: testServer.User,
 		Auth: []ssh.AuthMethod{
-			ssh.Password("the right password"),
+			ssh.Password(testServer.Password),
 		},
 		Forward: []Forwarder{
-			Forward(1234, "localhost:2229"),
+			Forward(1234, testServer.Addr),
 		},
 	}
 
-	if err := Execute(spec); err != nil {
+	if _, err := Execute(spec); err != nil {
 		t.Fatal(err)
 	}
 
@@ -90,25 +162,21 @@ func TestPortForward(t *testing.T) {
 	// Now connect to it via ssh and test it works
 	cspec := &Spec{
 		Host: "localhost:1234",
-		User: "testuser",
+		User: testServer.User,
 		Auth: []ssh.AuthMethod{
-			ssh.Password("the right password"),
+			ssh.Password(testServer.Password),
 		},
 	}
 
-	if err := Execute(cspec); err != nil {
+	if _, err := Execute(cspec); err != nil {
 		t.Fatal(err)
 	}
 }
 
 func TestErrorCases(t *testing.T) {
-	if !port2229Open() {
-		t.Fatal("Port 2229 not open. Please run test_server.")
-	}
-
 	t.Run("Bad Spec", func(t *testing.T) {
 		spec := &Spec{}
-		err := Execute(spec)
+		_, err := Execute(spec)
 		if err == nil {
 			t.Fatal("Expected an error but didn't get it!")
 		}
@@ -116,17 +184,17 @@ func TestErrorCases(t *testing.T) {
 
 	t.Run("Bad Local Port during Forward", func(t *testing.T) {
 		spec := &Spec{
-			Host: "localhost:2229",
-			User: "testuser",
+			Host: testServer.Addr,
+			User: testServer.User,
 			Auth: []ssh.AuthMethod{
-				ssh.Password("the right password"),
+				ssh.Password(testServer.Password),
 			},
 			Forward: []Forwarder{
 				Forward(222922, "localhost:2223"),
 			},
 		}
 
-		err := Execute(spec)
+		_, err := Execute(spec)
 		if err == nil {
 			t.Fatal("Expected an error but didn't get it!")
 		}
@@ -134,24 +202,29 @@ func TestErrorCases(t *testing.T) {
 
 	t.Run("Bad Destination during Forward", func(t *testing.T) {
 		spec := &Spec{
-			Host: "localhost:2229",
-			User: "testuser",
+			Host: testServer.Addr,
+			User: testServer.User,
 			Auth: []ssh.AuthMethod{
-				ssh.Password("the right password"),
+				ssh.Password(testServer.Password),
 			},
 			Forward: []Forwarder{
-				Forward(1235, "localhost:8989"),
+				Forward(1235, closedPort(t)),
 			},
 		}
 
-		err := Execute(spec)
-		if err == nil {
-			t.Fatal("Expected an error but didn't get it!")
+		// destinations are only dialed once a connection arrives, so the forward is set up
+		if _, err := Execute(spec); err != nil {
+			t.Fatal(err)
 		}
 
-		_, err = net.DialTimeout("tcp", net.JoinHostPort("", "1235"), time.Millisecond*200)
-		if err == nil {
-			t.Fatal("Expected not to be able to connect to 1235 port but did!")
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort("", "1235"), time.Millisecond*200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("expected the connection to be closed as the destination is unreachable, got %v", err)
 		}
 	})
 
@@ -178,17 +251,17 @@ func TestErrorCases(t *testing.T) {
 
 		// Now portforward to port 6767
 		spec := &Spec{
-			Host: "localhost:2229",
-			User: "testuser",
+			Host: testServer.Addr,
+			User: testServer.User,
 			Auth: []ssh.AuthMethod{
-				ssh.Password("the right password"),
+				ssh.Password(testServer.Password),
 			},
 			Forward: []Forwarder{
 				Forward(6768, "localhost:6767"),
 			},
 		}
 
-		if err := Execute(spec); err != nil {
+		if _, err := Execute(spec); err != nil {
 			t.Fatal(err)
 		}
 
@@ -222,3 +295,1450 @@ func TestBadPrivateKey(t *testing.T) {
 	})
 
 }
+
+func TestRemoteCommand(t *testing.T) {
+	tun, err := Dial(&Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(testServer.Password),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+
+	out, err := tun.Output("systemctl status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hello, world\n" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+
+	if err := tun.Run("systemctl status"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	tun, err := Dial(&Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(testServer.Password),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+
+	dir, err := ioutil.TempDir("", "sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	remote := filepath.Join(dir, "uploaded")
+	if err := tun.Upload("tunnel_test.go", remote); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(dir, "downloaded")
+	if err := tun.Download(remote, local); err != nil {
+		t.Fatal(err)
+	}
+
+	original, _ := ioutil.ReadFile("tunnel_test.go")
+	roundTripped, err := ioutil.ReadFile(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(original, roundTripped) {
+		t.Fatal("file contents changed during upload and download")
+	}
+}
+
+type recordingAuditSink struct {
+	records []AuditRecord
+}
+
+func (s *recordingAuditSink) Record(r AuditRecord) {
+	s.records = append(s.records, r)
+}
+
+func TestAudit(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordingAuditSink{}
+	tun, err := Dial(&Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		// the server refuses the key and accepts the password
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(unknown),
+			ssh.Password(testServer.Password),
+		},
+		Audit: sink,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+
+	if len(sink.records) != 1 {
+		t.Fatalf("expected one audit record, got %d", len(sink.records))
+	}
+	r := sink.records[0]
+	if r.User != testServer.User || r.HostKeyFingerprint == "" || r.SessionID == "" {
+		t.Fatalf("incomplete audit record: %+v", r)
+	}
+	if r.ServerVersion == "" || r.ClientVersion == "" {
+		t.Fatalf("versions not recorded: %+v", r)
+	}
+	if r.KeyExchange == "" || r.CipherClientServer == "" || r.MACClientServer == "" {
+		t.Fatalf("negotiated algorithms not recorded: %+v", r)
+	}
+	if r.AuthMethod != "password" {
+		t.Fatalf("expected the password to be recorded as the method used, got %q", r.AuthMethod)
+	}
+}
+
+func TestSuspendIdleConnection(t *testing.T) {
+	spec := &Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(testServer.Password),
+		},
+		SuspendAfter: time.Millisecond * 50,
+	}
+	tun, err := Dial(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSuspendingClient(spec, getSSHConfig(spec), tun.Client())
+	defer s.Close()
+
+	time.Sleep(time.Millisecond * 200)
+	s.mu.Lock()
+	suspended := s.client == nil
+	s.mu.Unlock()
+	if !suspended {
+		t.Fatal("expected the idle connection to be suspended")
+	}
+
+	// the dial itself may be refused by the server but the connection must be resumed for it
+	if conn, err := s.Dial("tcp", testServer.Addr); err == nil {
+		conn.Close()
+	}
+	s.mu.Lock()
+	resumed := s.client != nil
+	s.mu.Unlock()
+	if !resumed {
+		t.Fatal("expected the connection to be resumed on dial")
+	}
+
+	// a slow resume doesn't hold up the rest of the client
+	time.Sleep(time.Millisecond * 200)
+	testServer.SetAuthDelay(time.Millisecond * 500)
+	defer testServer.SetAuthDelay(0)
+	dialed := make(chan error, 1)
+	go func() {
+		conn, err := s.Dial("tcp", testServer.Addr)
+		if err == nil {
+			conn.Close()
+		}
+		dialed <- err
+	}()
+	time.Sleep(time.Millisecond * 100)
+	start := time.Now()
+	s.Close()
+	if elapsed := time.Since(start); elapsed > time.Millisecond*200 {
+		t.Fatalf("closing waited %v for the dial resuming the connection", elapsed)
+	}
+	if err := <-dialed; err == nil {
+		t.Fatal("expected the dial to fail once the client was closed")
+	}
+}
+
+func TestListenForAll(t *testing.T) {
+	taken, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	takenPort := taken.Addr().(*net.TCPAddr).Port
+
+	forwarders := []Forwarder{Forward(1241, "a:1"), Forward(takenPort, "b:1")}
+	if _, err := listenForAll(localNetwork{}, forwarders, EmptyLogger()); err == nil || !strings.Contains(err.Error(), strconv.Itoa(takenPort)) {
+		t.Fatalf("expected an error naming port %d, got %v", takenPort, err)
+	}
+	// the port that could be bound must have been released again
+	listeners, err := listenForAll(localNetwork{}, forwarders[:1], EmptyLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	listeners[0].Close()
+}
+
+func TestPortConflict(t *testing.T) {
+	taken, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	takenPort := taken.Addr().(*net.TCPAddr).Port
+
+	forwarders := []Forwarder{Forward(takenPort, "a:1").WithPortConflict(NextFreePort)}
+	listeners, err := listenForAll(localNetwork{}, forwarders, EmptyLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listeners[0].Close()
+	if port := listeners[0].Addr().(*net.TCPAddr).Port; port <= takenPort {
+		t.Fatalf("expected a port after %d, got %d", takenPort, port)
+	}
+	pinBoundPorts(forwarders, listeners)
+	if forwarders[0].local() != "port "+strconv.Itoa(listeners[0].Addr().(*net.TCPAddr).Port) {
+		t.Fatalf("expected the bound port to be reported, got %s", forwarders[0].local())
+	}
+
+	// this test is what's listening, not a previous instance, so it's left alone
+	stale := []Forwarder{Forward(takenPort, "a:1").WithPortConflict(KillStale)}
+	if _, err := listenForAll(localNetwork{}, stale, EmptyLogger()); err == nil {
+		t.Fatal("expected the port to stay in use")
+	}
+	if runtime.GOOS == "linux" {
+		if pid, _, err := listeningProcess(takenPort); err != nil || pid != os.Getpid() {
+			t.Fatalf("expected port %d to be found listened on by this process, got %d: %v", takenPort, pid, err)
+		}
+	}
+}
+
+func TestSRVBastionFailover(t *testing.T) {
+	port, _ := strconv.Atoi(testServer.Addr[strings.LastIndex(testServer.Addr, ":")+1:])
+	defer func(l func(string, string, string) (string, []*net.SRV, error)) { lookupSRV = l }(lookupSRV)
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return name, []*net.SRV{
+			{Target: "localhost.", Port: 1, Priority: 1},
+			{Target: "localhost.", Port: uint16(port), Priority: 2},
+		}, nil
+	}
+
+	tun, err := Dial(&Spec{
+		Host: "_ssh._tcp.bastions.example.com",
+		User: testServer.User,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(testServer.Password),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun.Close()
+}
+
+func TestFallbackHosts(t *testing.T) {
+	tun, err := Dial(&Spec{
+		Host:          "localhost:1",
+		FallbackHosts: []string{testServer.Addr},
+		User:          testServer.User,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(testServer.Password),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun.Close()
+}
+
+func TestReverseForward(t *testing.T) {
+	spec := &Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(testServer.Password),
+		},
+		Reverse: []Forwarder{
+			Forward(1236, echoServer(t)),
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ok := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- ExecuteAndBlock(ctx, spec, ok)
+	}()
+	select {
+	case <-ok:
+	case err := <-done:
+		t.Fatal(err)
+	}
+
+	// the test server listens on the remote port on our behalf and relays connections back to the echo server
+	assertEchoes(t, "localhost:1236")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReverseForwardWithExecute(t *testing.T) {
+	taken, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	takenPort := taken.Addr().(*net.TCPAddr).Port
+	reported := make(chan []ReverseStatus, 1)
+	spec := &Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: testServer.Auth(),
+		Reverse: []Forwarder{
+			Forward(1242, echoServer(t)),
+			Forward(takenPort, echoServer(t)),
+		},
+		OnReverse: func(statuses []ReverseStatus) {
+			reported <- statuses
+		},
+	}
+	tun, err := Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	assertEchoes(t, "localhost:1242")
+
+	statuses := tun.ReverseStatus()
+	if len(statuses) != 2 {
+		t.Fatalf("expected a status per reverse forward, got %+v", statuses)
+	}
+	if !statuses[0].Bound || statuses[0].Err != nil {
+		t.Fatalf("expected the first reverse forward to be bound, got %+v", statuses[0])
+	}
+	if statuses[1].Bound || statuses[1].Err == nil {
+		t.Fatalf("expected the second reverse forward to fail binding, got %+v", statuses[1])
+	}
+	if len(<-reported) != 2 {
+		t.Fatal("expected OnReverse to be called with every status")
+	}
+}
+
+func TestReverseForwardOnPickedPort(t *testing.T) {
+	spec := &Spec{
+		Host:    testServer.Addr,
+		User:    testServer.User,
+		Auth:    testServer.Auth(),
+		Reverse: []Forwarder{Forward(0, echoServer(t)).WithBindAddress("127.0.0.1").WithName("echo")},
+	}
+	tun, err := Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	addr, ok := tun.RemoteAddr("echo")
+	if !ok || addr.(*net.TCPAddr).Port == 0 {
+		t.Fatalf("expected the port picked by the server, got %v", addr)
+	}
+	assertEchoes(t, addr.String())
+	statuses := tun.ReverseStatus()
+	if statuses[0].Addr == nil || statuses[0].Addr.String() != addr.String() {
+		t.Fatalf("expected the picked port to be reported, got %+v", statuses[0])
+	}
+	if statuses[0].Remote != "address "+addr.String() {
+		t.Fatalf("expected the picked port to be kept for reconnecting, got %q", statuses[0].Remote)
+	}
+}
+
+func TestAllowedSources(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	spec := &Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: testServer.Auth(),
+		Forward: []Forwarder{
+			Forward(1259, echoServer(t)).WithAllowedSources(private, loopback),
+			Forward(1260, echoServer(t)).WithAllowedSources(private),
+		},
+	}
+	tun, err := Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	assertEchoes(t, "localhost:1259")
+
+	conn, err := net.Dial("tcp", "localhost:1260")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected a connection from outside the allowed sources to be closed, got %v", err)
+	}
+}
+
+func TestBestEffortForwards(t *testing.T) {
+	taken, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	takenPort := taken.Addr().(*net.TCPAddr).Port
+	reported := make(chan []ForwardStatus, 1)
+	spec := &Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: testServer.Auth(),
+		Forward: []Forwarder{
+			Forward(1245, echoServer(t)),
+			Forward(takenPort, echoServer(t)),
+		},
+		FailurePolicy: BestEffort,
+		OnForward: func(statuses []ForwardStatus) {
+			reported <- statuses
+		},
+	}
+	tun, err := Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	assertEchoes(t, "localhost:1245")
+
+	statuses := tun.ForwardStatus()
+	if len(statuses) != 2 || !statuses[0].Bound || statuses[1].Bound || statuses[1].Err == nil {
+		t.Fatalf("expected only the second forward to fail listening, got %+v", statuses)
+	}
+	if len(<-reported) != 2 {
+		t.Fatal("expected OnForward to be called with every status")
+	}
+	stats := tun.Stats()
+	if stats.Forward[0].BindError != nil || stats.Forward[1].BindError == nil {
+		t.Fatalf("expected the bind error in the stats of the second forward, got %+v", stats.Forward)
+	}
+
+	// by default the whole spec is given up on
+	all := *spec
+	all.FailurePolicy, all.OnForward = AllOrNothing, nil
+	all.Forward = []Forwarder{Forward(takenPort, echoServer(t))}
+	if _, err := Execute(&all); err == nil {
+		t.Fatal("expected the connection to be given up on")
+	}
+}
+
+func TestExecuteAndReport(t *testing.T) {
+	taken, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	takenPort := taken.Addr().(*net.TCPAddr).Port
+	spec := &Spec{
+		Host:          testServer.Addr,
+		User:          testServer.User,
+		Auth:          testServer.Auth(),
+		Forward:       []Forwarder{Forward(1257, echoServer(t)).WithName("echo"), Forward(takenPort, echoServer(t))},
+		Reverse:       []Forwarder{Forward(1258, echoServer(t)).WithName("reverse echo"), Forward(takenPort, echoServer(t))},
+		FailurePolicy: BestEffort,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan Readiness)
+	done := make(chan error)
+	go func() {
+		done <- ExecuteAndReport(ctx, spec, ready)
+	}()
+	r := <-ready
+	if len(r.Forward) != 2 || !r.Forward[0].Bound || r.Forward[0].Name != "echo" || r.Forward[1].Bound {
+		t.Fatalf("expected only the first local forward to be live, got %+v", r.Forward)
+	}
+	if len(r.Reverse) != 2 || !r.Reverse[0].Bound || r.Reverse[0].Name != "reverse echo" || r.Reverse[1].Bound {
+		t.Fatalf("expected only the first reverse forward to be live, got %+v", r.Reverse)
+	}
+	if r.Err() == nil {
+		t.Fatal("expected the forwards that aren't listening to be reported")
+	}
+	assertEchoes(t, "localhost:1257")
+	assertEchoes(t, "localhost:1258")
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExecuteAndBlock(t *testing.T) {
+	t.Run("cancellation tears down forwards", func(t *testing.T) {
+		spec := &Spec{
+			Host: testServer.Addr,
+			User: testServer.User,
+			Auth: []ssh.AuthMethod{
+				ssh.Password(testServer.Password),
+			},
+			Forward: []Forwarder{
+				Forward(1237, echoServer(t)),
+			},
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		ok := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- ExecuteAndBlock(ctx, spec, ok)
+		}()
+		select {
+		case <-ok:
+		case err := <-done:
+			t.Fatal(err)
+		}
+		assertEchoes(t, "localhost:1237")
+
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("ExecuteAndBlock didn't return after cancellation")
+		}
+		if conn, err := net.DialTimeout("tcp", "localhost:1237", time.Millisecond*200); err == nil {
+			conn.Close()
+			t.Fatal("expected the local port to be closed after cancellation")
+		}
+	})
+
+	t.Run("returns when the server goes away", func(t *testing.T) {
+		server, err := tunneltest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec := &Spec{
+			Host: server.Addr,
+			User: server.User,
+			Auth: server.Auth(),
+			Forward: []Forwarder{
+				Forward(1238, echoServer(t)),
+			},
+		}
+		ok := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- ExecuteAndBlock(context.Background(), spec, ok)
+		}()
+		select {
+		case <-ok:
+		case err := <-done:
+			t.Fatal(err)
+		}
+
+		server.Close()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("ExecuteAndBlock didn't return after the server went away")
+		}
+		if conn, err := net.DialTimeout("tcp", "localhost:1238", time.Millisecond*200); err == nil {
+			conn.Close()
+			t.Fatal("expected the local port to be closed once the server went away")
+		}
+	})
+
+	t.Run("reconnects when the server comes back", func(t *testing.T) {
+		server, err := tunneltest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec := &Spec{
+			Host: server.Addr,
+			User: server.User,
+			Auth: []ssh.AuthMethod{
+				ssh.Password(server.Password),
+			},
+			Forward: []Forwarder{
+				Forward(1239, echoServer(t)),
+			},
+			Reconnect: &Reconnect{InitialBackoff: time.Millisecond * 100},
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		ok := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- ExecuteAndBlock(ctx, spec, ok)
+		}()
+		select {
+		case <-ok:
+		case err := <-done:
+			t.Fatal(err)
+		}
+		assertEchoes(t, "localhost:1239")
+
+		server.Close()
+		time.Sleep(time.Millisecond * 300)
+		restarted, err := tunneltest.Listen(server.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer restarted.Close()
+		deadline := time.Now().Add(time.Second * 5)
+		for {
+			conn, err := net.DialTimeout("tcp", "localhost:1239", time.Millisecond*200)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("forward not re-established after reconnecting")
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		assertEchoes(t, "localhost:1239")
+
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("ExecuteAndBlock didn't return after cancellation")
+		}
+	})
+
+	t.Run("gives up after max retries", func(t *testing.T) {
+		server, err := tunneltest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec := &Spec{
+			Host:      server.Addr,
+			User:      server.User,
+			Auth:      server.Auth(),
+			Reconnect: &Reconnect{MaxRetries: 2, InitialBackoff: time.Millisecond * 10},
+		}
+		ok := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- ExecuteAndBlock(context.Background(), spec, ok)
+		}()
+		select {
+		case <-ok:
+		case err := <-done:
+			t.Fatal(err)
+		}
+		server.Close()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Fatal("expected an error once retries were exhausted")
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("ExecuteAndBlock didn't give up reconnecting")
+		}
+	})
+}
+
+func TestUnixSocketForward(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("peer credentials are not available on " + runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "forward.sock")
+
+	spec := &Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(testServer.Password),
+		},
+		Forward: []Forwarder{
+			ForwardUnix(socket, echoServer(t)),
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ok := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- ExecuteAndBlock(ctx, spec, ok)
+	}()
+	select {
+	case <-ok:
+	case err := <-done:
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the socket to be private to its owner, got %v", info.Mode().Perm())
+	}
+	// connections from the owning user are always allowed
+	assertEchoesOn(t, "unix", socket)
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	uid, gid, err := peerCredentials(conn.(*net.UnixConn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != os.Getuid() || gid != os.Getgid() {
+		t.Fatalf("expected peer credentials %d/%d, got %d/%d", os.Getuid(), os.Getgid(), uid, gid)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAlgorithmMismatch(t *testing.T) {
+	spec := &Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(testServer.Password),
+		},
+	}
+	applyDefaults(spec)
+	config := getSSHConfig(spec)
+	// the test server only has an ed25519 host key and doesn't offer arcfour
+	config.HostKeyAlgorithms = []string{ssh.KeyAlgoRSA}
+	config.Ciphers = []string{"arcfour256"}
+
+	_, err := dialAddress(context.Background(), spec, config, bastionDialer(config.Timeout), testServer.Addr)
+	mismatch, ok := err.(*AlgorithmMismatchError)
+	if !ok {
+		t.Fatalf("expected an *AlgorithmMismatchError, got %T: %v", err, err)
+	}
+	kinds := map[string]bool{}
+	for _, m := range mismatch.Mismatches {
+		kinds[m.Kind] = true
+		if len(m.Client) == 0 || len(m.Server) == 0 {
+			t.Fatalf("offers not captured: %+v", m)
+		}
+	}
+	if !kinds["host key"] || !kinds["cipher (client to server)"] || !kinds["cipher (server to client)"] {
+		t.Fatalf("unexpected mismatches: %v", err)
+	}
+}
+
+func TestConfiguredAlgorithms(t *testing.T) {
+	sink := &recordingAuditSink{}
+	tun, err := Dial(&Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(testServer.Password),
+		},
+		Ciphers:      []string{"aes256-ctr"},
+		MACs:         []string{"hmac-sha1"},
+		KeyExchanges: []string{"ecdh-sha2-nistp384"},
+		Audit:        sink,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+
+	r := sink.records[0]
+	if r.CipherClientServer != "aes256-ctr" || r.MACClientServer != "hmac-sha1" || r.KeyExchange != "ecdh-sha2-nistp384" {
+		t.Fatalf("expected the configured algorithms to be negotiated, got %+v", r)
+	}
+}
+
+func TestAuthTimeout(t *testing.T) {
+	testServer.SetAuthDelay(time.Second * 5)
+	defer testServer.SetAuthDelay(0)
+
+	start := time.Now()
+	_, err := Dial(&Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(testServer.Password),
+		},
+		AuthTimeout: time.Millisecond * 200,
+	})
+	if _, ok := err.(*AuthTimeoutError); !ok {
+		t.Fatalf("expected an *AuthTimeoutError, got %T: %v", err, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Fatalf("took %v to time out", elapsed)
+	}
+
+	testServer.SetAuthDelay(time.Millisecond * 100)
+	tun, err := Dial(&Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(testServer.Password),
+		},
+		AuthTimeout: time.Second * 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the deadline must not outlive the handshake
+	time.Sleep(time.Millisecond * 2500)
+	if _, err := tun.Output("anything"); err != nil {
+		t.Fatal(err)
+	}
+	tun.Close()
+}
+
+func TestVia(t *testing.T) {
+	jump, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jump.Close()
+	target, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	outer, err := tunneltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outer.Close()
+
+	spec := &Spec{
+		Host: target.Addr,
+		User: target.User,
+		Auth: target.Auth(),
+		Via: &Spec{
+			Host: jump.Addr,
+			User: jump.User,
+			Auth: jump.Auth(),
+			Via: &Spec{
+				Host: outer.Addr,
+				User: outer.User,
+				Auth: outer.Auth(),
+			},
+		},
+		Forward: []Forwarder{
+			Forward(1240, echoServer(t)),
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ok := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- ExecuteAndBlock(ctx, spec, ok)
+	}()
+	select {
+	case <-ok:
+	case err := <-done:
+		t.Fatal(err)
+	}
+	assertEchoes(t, "localhost:1240")
+
+	t.Run("unreachable jump host", func(t *testing.T) {
+		_, err := Dial(&Spec{
+			Host: target.Addr,
+			User: target.User,
+			Auth: target.Auth(),
+			Via:  &Spec{Host: closedPort(t), User: "nobody"},
+		})
+		if err == nil || !strings.Contains(err.Error(), "jump host") {
+			t.Fatalf("expected a jump host error, got %v", err)
+		}
+	})
+}
+
+func TestExecuteClose(t *testing.T) {
+	t.Run("close releases ports", func(t *testing.T) {
+		spec := &Spec{
+			Host:    testServer.Addr,
+			User:    testServer.User,
+			Auth:    testServer.Auth(),
+			Forward: []Forwarder{Forward(1241, echoServer(t))},
+		}
+		tun, err := Execute(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEchoes(t, "localhost:1241")
+		select {
+		case <-tun.Done():
+			t.Fatal("tunnel done while running")
+		default:
+		}
+
+		tun.Close()
+		select {
+		case <-tun.Done():
+		default:
+			t.Fatal("expected the tunnel to be done once closed")
+		}
+		if tun.Err() != nil {
+			t.Fatalf("expected no error after close, got %v", tun.Err())
+		}
+		if conn, err := net.DialTimeout("tcp", "localhost:1241", time.Millisecond*200); err == nil {
+			conn.Close()
+			t.Fatal("expected the local port to be released after close")
+		}
+	})
+
+	t.Run("lost connection is reported", func(t *testing.T) {
+		server, err := tunneltest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tun, err := Execute(&Spec{
+			Host: server.Addr,
+			User: server.User,
+			Auth: server.Auth(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+		select {
+		case <-tun.Done():
+		case <-time.After(time.Second * 5):
+			t.Fatal("tunnel not done after losing its connection")
+		}
+		if tun.Err() == nil {
+			t.Fatal("expected an error for the lost connection")
+		}
+	})
+}
+
+func TestBindAddress(t *testing.T) {
+	spec := &Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: testServer.Auth(),
+		Forward: []Forwarder{
+			Forward(1243, echoServer(t)),
+			Forward(1244, echoServer(t)).WithBindAddress("127.0.0.1"),
+		},
+		BindAddress: "0.0.0.0",
+	}
+	tun, err := Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	assertEchoes(t, "127.0.0.1:1243")
+	assertEchoes(t, "127.0.0.1:1244")
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range addrs {
+		ip, ok := a.(*net.IPNet)
+		if !ok || ip.IP.IsLoopback() || ip.IP.To4() == nil {
+			continue
+		}
+		assertEchoes(t, net.JoinHostPort(ip.IP.String(), "1243"))
+		if conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.IP.String(), "1244"), time.Millisecond*200); err == nil {
+			conn.Close()
+			t.Fatal("expected the forward bound to 127.0.0.1 not to be reachable on other interfaces")
+		}
+		break
+	}
+}
+
+func TestEphemeralPorts(t *testing.T) {
+	unnamed := echoServer(t)
+	spec := &Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: testServer.Auth(),
+		Forward: []Forwarder{
+			Forward(0, echoServer(t)).WithName("echo"),
+			Forward(0, unnamed),
+		},
+	}
+	tun, err := Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	for _, name := range []string{"echo", unnamed} {
+		addr, ok := tun.LocalAddr(name)
+		if !ok {
+			t.Fatalf("no local address for %s", name)
+		}
+		if addr.(*net.TCPAddr).Port == 0 {
+			t.Fatalf("expected a port to be picked for %s", name)
+		}
+		assertEchoes(t, addr.String())
+	}
+	if _, ok := tun.LocalAddr("missing"); ok {
+		t.Fatal("expected no local address for an unknown forward")
+	}
+}
+
+func TestStats(t *testing.T) {
+	spec := &Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: testServer.Auth(),
+		Forward: []Forwarder{
+			Forward(0, echoServer(t)).WithName("echo"),
+			Forward(0, closedPort(t)).WithName("closed"),
+		},
+	}
+	tun, err := Execute(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	echo, _ := tun.LocalAddr("echo")
+	assertEchoes(t, echo.String())
+	closed, _ := tun.LocalAddr("closed")
+	conn, err := net.Dial("tcp", closed.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	conn.Read(make([]byte, 1))
+	conn.Close()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		stats := tun.Stats()
+		e, c := stats.Forward[0], stats.Forward[1]
+		if !stats.Connected {
+			t.Fatal("expected the tunnel to be connected")
+		}
+		if e.Name == "echo" && e.TotalConnections == 1 && e.ActiveConnections == 0 && e.BytesIn > 0 && e.BytesIn == e.BytesOut && c.DialErrors == 1 {
+			if total := stats.Total(); total.TotalConnections != 1 || total.DialErrors != 1 {
+				t.Fatalf("unexpected totals %+v", total)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected stats %+v", stats)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func TestMonitorDestinations(t *testing.T) {
+	backend, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	destination := backend.Addr().String()
+	down := make(chan DestinationStatus, 1)
+	up := make(chan DestinationStatus, 1)
+	tun, err := Execute(&Spec{
+		Host:              testServer.Addr,
+		User:              testServer.User,
+		Auth:              testServer.Auth(),
+		Forward:           []Forwarder{Forward(0, destination).WithName("backend")},
+		MonitorInterval:   time.Millisecond * 50,
+		OnDestinationDown: func(s DestinationStatus) { down <- s },
+		OnDestinationUp:   func(s DestinationStatus) { up <- s },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+
+	select {
+	case s := <-down:
+		t.Fatalf("unexpected report of a reachable destination: %+v", s)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	backend.Close()
+	select {
+	case s := <-down:
+		if s.Name != "backend" || s.Destination != destination || s.Reachable || s.Err == nil {
+			t.Fatalf("unexpected down status %+v", s)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected the destination to be reported down")
+	}
+
+	backend, err = net.Listen("tcp", destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	select {
+	case s := <-up:
+		if !s.Reachable || s.Err != nil {
+			t.Fatalf("unexpected up status %+v", s)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected the destination to be reported up again")
+	}
+}
+
+func TestSetForwardEnabled(t *testing.T) {
+	tun, err := Execute(&Spec{
+		Host:    testServer.Addr,
+		User:    testServer.User,
+		Auth:    testServer.Auth(),
+		Forward: []Forwarder{Forward(0, echoServer(t)).WithName("echo")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	echo, _ := tun.LocalAddr("echo")
+	assertEchoes(t, echo.String())
+
+	if tun.SetForwardEnabled("unknown", false) {
+		t.Fatal("expected an unknown forward not to be found")
+	}
+	if !tun.SetForwardEnabled("echo", false) {
+		t.Fatal("expected the forward to be found")
+	}
+	if !tun.Stats().Forward[0].Disabled {
+		t.Fatal("expected the forward to be reported disabled")
+	}
+	conn, err := net.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected a disabled forward to close connections, got %v", err)
+	}
+	conn.Close()
+
+	tun.SetForwardEnabled("echo", true)
+	assertEchoes(t, echo.String())
+}
+
+func TestManagerSharesConnections(t *testing.T) {
+	m := NewManager()
+	sink := &recordingAuditSink{}
+	execute := func() *Tunnel {
+		tun, err := m.Execute(&Spec{
+			Host:    testServer.Addr,
+			User:    testServer.User,
+			Auth:    testServer.Auth(),
+			Forward: []Forwarder{Forward(0, echoServer(t)).WithName("echo")},
+			Audit:   sink,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tun
+	}
+	first, second := execute(), execute()
+	defer second.Close()
+	if m.Connections() != 1 || len(sink.records) != 1 {
+		t.Fatalf("expected one shared connection, got %d with %d handshakes", m.Connections(), len(sink.records))
+	}
+	for _, tun := range []*Tunnel{first, second} {
+		echo, _ := tun.LocalAddr("echo")
+		assertEchoes(t, echo.String())
+	}
+
+	first.Close()
+	if m.Connections() != 1 {
+		t.Fatal("expected the connection to stay open for the second tunnel")
+	}
+	echo, _ := second.LocalAddr("echo")
+	assertEchoes(t, echo.String())
+
+	second.Close()
+	if m.Connections() != 0 {
+		t.Fatal("expected the connection to be closed with the last tunnel")
+	}
+}
+
+func TestManagerConnectsToHostsIndependently(t *testing.T) {
+	// accepts connections but never answers the ssh handshake
+	silent, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	m := NewManager()
+	hung := make(chan error, 1)
+	go func() {
+		_, err := m.Dial(&Spec{Host: silent.Addr().String(), User: testServer.User, Auth: testServer.Auth(), AuthTimeout: time.Second * 2})
+		hung <- err
+	}()
+	time.Sleep(time.Millisecond * 100)
+	start := time.Now()
+	tun, err := m.Dial(&Spec{Host: testServer.Addr, User: testServer.User, Auth: testServer.Auth()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("connecting waited %v for the unresponsive host", elapsed)
+	}
+	if err := <-hung; err == nil {
+		t.Fatal("expected the unresponsive host to time out")
+	}
+}
+
+func TestKeyboardInteractive(t *testing.T) {
+	dial := func(code string) (*Tunnel, []string, error) {
+		questions := []string{}
+		tun, err := Dial(&Spec{
+			Host: testServer.Addr,
+			User: testServer.User,
+			Auth: []ssh.AuthMethod{KeyboardInteractive(func(name, instruction, question string, echo bool) (string, error) {
+				if echo {
+					t.Errorf("expected the code not to be echoed")
+				}
+				questions = append(questions, instruction+" "+question)
+				return code, nil
+			})},
+		})
+		return tun, questions, err
+	}
+	tun, questions, err := dial(testServer.OTP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun.Close()
+	if len(questions) != 1 || !strings.Contains(questions[0], "Verification code") {
+		t.Fatalf("unexpected questions: %v", questions)
+	}
+	if _, _, err := dial("000000"); err == nil {
+		t.Fatal("expected a wrong code to be refused")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	tun, err := Execute(&Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: testServer.Auth(),
+		Forward: []Forwarder{
+			Forward(0, echoServer(t)).WithName("spec"),
+			Forward(0, echoServer(t)).WithName("own").WithIdleTimeout(time.Hour),
+		},
+		IdleTimeout: time.Millisecond * 200,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	dial := func(name string) net.Conn {
+		addr, _ := tun.LocalAddr(name)
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	echo := func(conn net.Conn) error {
+		conn.SetDeadline(time.Now().Add(time.Second * 2))
+		if _, err := conn.Write([]byte("x")); err != nil {
+			return err
+		}
+		_, err := conn.Read(make([]byte, 1))
+		return err
+	}
+
+	active, idle, own := dial("spec"), dial("spec"), dial("own")
+	defer active.Close()
+	defer idle.Close()
+	defer own.Close()
+	for i := 0; i < 6; i++ {
+		if err := echo(active); err != nil {
+			t.Fatalf("expected a connection in use to stay open, got %v", err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if err := echo(idle); err == nil {
+		t.Fatal("expected the idle connection to be closed")
+	}
+	if err := echo(own); err != nil {
+		t.Fatalf("expected the forward's own idle timeout to apply, got %v", err)
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	tun, err := Execute(&Spec{
+		Host: testServer.Addr,
+		User: testServer.User,
+		Auth: testServer.Auth(),
+		Forward: []Forwarder{
+			Forward(0, echoServer(t)).WithName("reject").WithMaxConnections(1, true),
+			Forward(0, echoServer(t)).WithName("queue").WithMaxConnections(1, false),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	dial := func(name string) net.Conn {
+		addr, _ := tun.LocalAddr(name)
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	echo := func(conn net.Conn, timeout time.Duration) error {
+		conn.SetDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write([]byte("x")); err != nil {
+			return err
+		}
+		_, err := conn.Read(make([]byte, 1))
+		return err
+	}
+
+	first, second := dial("reject"), dial("reject")
+	if err := echo(first, time.Second*2); err != nil {
+		t.Fatal(err)
+	}
+	if err := echo(second, time.Second*2); err == nil {
+		t.Fatal("expected the connection over the limit to be refused")
+	}
+	second.Close()
+	first.Close()
+
+	first, second = dial("queue"), dial("queue")
+	defer second.Close()
+	if err := echo(first, time.Second*2); err != nil {
+		t.Fatal(err)
+	}
+	if err := echo(second, time.Millisecond*200); err == nil {
+		t.Fatal("expected the connection over the limit to wait")
+	}
+	first.Close()
+	second.SetDeadline(time.Now().Add(time.Second * 2))
+	if _, err := second.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("expected the waiting connection to go through once the first ended, got %v", err)
+	}
+}
+
+func TestCaptureWithQuota(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "capture.txt")
+	echo := echoServer(t)
+	tun, err := Execute(&Spec{
+		Host:    testServer.Addr,
+		User:    testServer.User,
+		Auth:    testServer.Auth(),
+		Forward: []Forwarder{Forward(0, echo).WithName("echo").WithCapture(Capture{File: file}).WithConnectionQuota(6)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := tun.LocalAddr("echo")
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	reply, _ := io.ReadAll(conn)
+	if string(reply) != "pi" {
+		t.Fatalf("expected the reply to be cut at the quota, got %q", reply)
+	}
+	tun.Close()
+
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump := string(contents)
+	if !strings.Contains(dump, " -> "+echo+" 4 bytes") || !strings.Contains(dump, " "+echo+" -> ") || !strings.Contains(dump, " 2 bytes") {
+		t.Fatalf("expected both directions to be captured within the quota:\n%s", dump)
+	}
+}
+
+func TestExecuteContext(t *testing.T) {
+	t.Run("torn down with the context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		tun, err := ExecuteContext(ctx, &Spec{
+			Host:    testServer.Addr,
+			User:    testServer.User,
+			Auth:    testServer.Auth(),
+			Forward: []Forwarder{Forward(0, echoServer(t)).WithName("echo")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, _ := tun.LocalAddr("echo")
+		assertEchoes(t, addr.String())
+		cancel()
+		select {
+		case <-tun.Done():
+		case <-time.After(time.Second * 5):
+			t.Fatal("expected the tunnel to stop with its context")
+		}
+		if err := tun.Err(); err != nil {
+			t.Fatalf("expected no error once cancelled, got %v", err)
+		}
+		l, err := net.Listen("tcp", addr.String())
+		if err != nil {
+			t.Fatalf("expected the forward's port to be released: %v", err)
+		}
+		l.Close()
+	})
+	t.Run("gives up connecting", func(t *testing.T) {
+		// accepts but never speaks ssh
+		silent, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer silent.Close()
+		go func() {
+			for {
+				conn, err := silent.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		tun, err := ExecuteContext(ctx, &Spec{
+			Host: silent.Addr().String(),
+			User: testServer.User,
+			Auth: testServer.Auth(),
+		})
+		if err == nil || tun != nil {
+			t.Fatal("expected to give up when the context is done before connecting")
+		}
+	})
+}
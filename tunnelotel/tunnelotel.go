@@ -0,0 +1,67 @@
+// Package tunnelotel records the spans of a tunnel with OpenTelemetry. It's a module of its own so that the tunnel
+// module doesn't depend on OpenTelemetry.
+package tunnelotel
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer returns a tunnel.Tracer starting its spans with t, such as otel.Tracer("tunnel"), for Spec.Tracer
+func Tracer(t trace.Tracer) tunnel.Tracer {
+	return tracer{t: t}
+}
+
+type tracer struct {
+	t trace.Tracer
+}
+
+func (t tracer) Start(ctx context.Context, name string, attributes map[string]interface{}) (context.Context, tunnel.Span) {
+	ctx, s := t.t.Start(ctx, name, trace.WithAttributes(keyValues(attributes)...))
+	return ctx, span{s: s}
+}
+
+type span struct {
+	s trace.Span
+}
+
+func (s span) SetAttributes(attributes map[string]interface{}) {
+	s.s.SetAttributes(keyValues(attributes)...)
+}
+
+func (s span) End(err error) {
+	if err != nil {
+		s.s.RecordError(err)
+		s.s.SetStatus(codes.Error, err.Error())
+	}
+	s.s.End()
+}
+
+// keyValues converts attributes, ordered by key; values other than strings and integers are formatted as strings
+func keyValues(attributes map[string]interface{}) []attribute.KeyValue {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]attribute.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		switch v := attributes[k].(type) {
+		case string:
+			kvs = append(kvs, attribute.String(k, v))
+		case int64:
+			kvs = append(kvs, attribute.Int64(k, v))
+		case int:
+			kvs = append(kvs, attribute.Int(k, v))
+		default:
+			kvs = append(kvs, attribute.String(k, fmt.Sprint(v)))
+		}
+	}
+	return kvs
+}
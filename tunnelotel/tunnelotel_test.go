@@ -0,0 +1,80 @@
+package tunnelotel
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/arunsworld/go-tunnel/tunneltest"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	server := tunneltest.StartServer(t)
+	server.HandleDestination("echo.internal:7", tunneltest.Echo)
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+
+	ctx, app := provider.Tracer("test").Start(context.Background(), "app")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tun, err := tunnel.ExecuteContext(ctx, &tunnel.Spec{
+		Host:    server.Addr,
+		User:    server.User,
+		Auth:    server.Auth(),
+		Forward: []tunnel.Forwarder{tunnel.Forward(0, "echo.internal:7").WithName("echo")},
+		Tracer:  Tracer(provider.Tracer("tunnel")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	addr, _ := tun.LocalAddr("echo")
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	io.WriteString(conn, "ping")
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Fatalf("expected ping back, got %q: %v", reply, err)
+	}
+	conn.Close()
+
+	var forward *tracetest.SpanStub
+	for deadline := time.Now().Add(time.Second * 5); forward == nil && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond * 10)
+		for _, s := range exporter.GetSpans() {
+			if s.Name == "tunnel.forward" {
+				s := s
+				forward = &s
+			}
+		}
+	}
+	if forward == nil {
+		t.Fatal("expected a span of the tunneled connection")
+	}
+	if forward.Parent.SpanID() != app.SpanContext().SpanID() {
+		t.Fatal("expected the tunneled connection's span within the application's")
+	}
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range forward.Attributes {
+		attributes[kv.Key] = kv.Value
+	}
+	if attributes["tunnel.destination"].AsString() != "echo.internal:7" || attributes["tunnel.bytes_in"].AsInt64() != 4 {
+		t.Fatalf("expected the destination and bytes transferred, got %v", forward.Attributes)
+	}
+	for _, s := range exporter.GetSpans() {
+		if s.Name == "tunnel.connect" && s.Parent.SpanID() == app.SpanContext().SpanID() {
+			return
+		}
+	}
+	t.Fatal("expected a span of connecting within the application's")
+}
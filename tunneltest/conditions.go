@@ -0,0 +1,141 @@
+package tunneltest
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// Conditions degrade forwarded connections to simulate a poor network; the zero value leaves them untouched
+type Conditions struct {
+	// Latency delays every chunk of data in either direction
+	Latency time.Duration
+	// Jitter adds a random delay of up to this much on top of Latency
+	Jitter time.Duration
+	// BytesPerSecond caps the throughput of each direction of a connection
+	BytesPerSecond int
+	// DisconnectProbability is the chance, per chunk of data, that the connection is dropped
+	DisconnectProbability float64
+	// Seed makes the random jitter and disconnects repeatable
+	Seed int64
+}
+
+var errSimulatedDisconnect = errors.New("simulated disconnect")
+
+// conditioner applies Conditions; its random source is shared by all connections
+type conditioner struct {
+	Conditions
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+func newConditioner(c Conditions) *conditioner {
+	return &conditioner{Conditions: c, random: rand.New(rand.NewSource(c.Seed))}
+}
+
+func (c *conditioner) float64() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.random.Float64()
+}
+
+func (c *conditioner) delay() time.Duration {
+	d := c.Latency
+	if c.Jitter > 0 {
+		d += time.Duration(c.float64() * float64(c.Jitter))
+	}
+	return d
+}
+
+func (c *conditioner) disconnect() bool {
+	return c.DisconnectProbability > 0 && c.float64() < c.DisconnectProbability
+}
+
+// conditionedWriter applies the conditions to everything written to w
+type conditionedWriter struct {
+	w io.Writer
+	c *conditioner
+}
+
+func (cw *conditionedWriter) Write(b []byte) (int, error) {
+	if d := cw.c.delay(); d > 0 {
+		time.Sleep(d)
+	}
+	if cw.c.disconnect() {
+		return 0, errSimulatedDisconnect
+	}
+	n, err := cw.w.Write(b)
+	if cw.c.BytesPerSecond > 0 {
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(cw.c.BytesPerSecond))
+	}
+	return n, err
+}
+
+// directTCPIPRequest is the payload of a direct-tcpip channel open (RFC 4254 7.2)
+type directTCPIPRequest struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// ConditionedDirectTCPIPHandler handles local forwarding like gliderlabs' DirectTCPIPHandler, subjecting the
+// forwarded data to the conditions returned by conditions at the time each connection is opened
+func ConditionedDirectTCPIPHandler(conditions func() Conditions) gliderssh.ChannelHandler {
+	return conditionedDirectTCPIPHandler(conditions, func(addr string) (net.Conn, error) {
+		return net.Dial("tcp", addr)
+	})
+}
+
+// conditionedDirectTCPIPHandler is ConditionedDirectTCPIPHandler connecting to destinations with dial
+func conditionedDirectTCPIPHandler(conditions func() Conditions, dial func(addr string) (net.Conn, error)) gliderssh.ChannelHandler {
+	var mu sync.Mutex
+	var current *conditioner
+	conditionerFor := func(c Conditions) *conditioner {
+		mu.Lock()
+		defer mu.Unlock()
+		if current == nil || current.Conditions != c {
+			current = newConditioner(c)
+		}
+		return current
+	}
+	return func(srv *gliderssh.Server, conn *ssh.ServerConn, newChan ssh.NewChannel, ctx gliderssh.Context) {
+		var req directTCPIPRequest
+		if err := ssh.Unmarshal(newChan.ExtraData(), &req); err != nil {
+			newChan.Reject(ssh.ConnectionFailed, "error parsing forward data: "+err.Error())
+			return
+		}
+		if srv.LocalPortForwardingCallback == nil || !srv.LocalPortForwardingCallback(ctx, req.DestAddr, req.DestPort) {
+			newChan.Reject(ssh.Prohibited, "port forwarding is disabled")
+			return
+		}
+		dest := net.JoinHostPort(req.DestAddr, strconv.Itoa(int(req.DestPort)))
+		dconn, err := dial(dest)
+		if err != nil {
+			newChan.Reject(ssh.ConnectionFailed, err.Error())
+			return
+		}
+		ch, reqs, err := newChan.Accept()
+		if err != nil {
+			dconn.Close()
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+
+		c := conditionerFor(conditions())
+		relay := func(w io.Writer, r io.Reader) {
+			io.Copy(&conditionedWriter{w: w, c: c}, r)
+			ch.Close()
+			dconn.Close()
+		}
+		go relay(ch, dconn)
+		go relay(dconn, ch)
+	}
+}
@@ -0,0 +1,56 @@
+package tunneltest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestConditionedWriter(t *testing.T) {
+	t.Run("latency", func(t *testing.T) {
+		w := &conditionedWriter{w: ioutil.Discard, c: newConditioner(Conditions{Latency: time.Millisecond * 50})}
+		start := time.Now()
+		w.Write([]byte("x"))
+		if elapsed := time.Since(start); elapsed < time.Millisecond*50 {
+			t.Fatalf("expected the write to be delayed, took %v", elapsed)
+		}
+	})
+	t.Run("bandwidth", func(t *testing.T) {
+		w := &conditionedWriter{w: ioutil.Discard, c: newConditioner(Conditions{BytesPerSecond: 1000})}
+		start := time.Now()
+		w.Write(make([]byte, 100))
+		if elapsed := time.Since(start); elapsed < time.Millisecond*100 {
+			t.Fatalf("expected 100 bytes at 1000/s to take 100ms, took %v", elapsed)
+		}
+	})
+	t.Run("disconnects are repeatable", func(t *testing.T) {
+		written := func() int {
+			out := &bytes.Buffer{}
+			w := &conditionedWriter{w: out, c: newConditioner(Conditions{DisconnectProbability: 0.2, Seed: 42})}
+			_, err := io.Copy(w, &chunkedReader{chunks: 100})
+			if err != errSimulatedDisconnect {
+				t.Fatalf("expected a simulated disconnect, got %v", err)
+			}
+			return out.Len()
+		}
+		if first, second := written(), written(); first != second {
+			t.Fatalf("same seed disconnected after %d and %d bytes", first, second)
+		}
+	})
+}
+
+// chunkedReader returns single bytes so that every read is a separate chunk
+type chunkedReader struct {
+	chunks int
+}
+
+func (r *chunkedReader) Read(b []byte) (int, error) {
+	if r.chunks == 0 {
+		return 0, io.EOF
+	}
+	r.chunks--
+	b[0] = 'x'
+	return 1, nil
+}
@@ -0,0 +1,245 @@
+// Package tunneltest runs an in-process ssh server to test tunnels against, without an external server
+package tunneltest
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Server is an ssh server accepting User with either Password, ClientKey or OTP as the answer to a
+// keyboard-interactive challenge. It allows local and remote forwarding, serves sftp and answers sessions with "hello, world\n".
+type Server struct {
+	// Addr is the host:port the server listens on
+	Addr     string
+	User     string
+	Password string
+	// OTP is the one time code the server asks for with keyboard-interactive
+	OTP string
+	// ClientKey is a key the server accepts for User
+	ClientKey ssh.Signer
+	// HostKey is the key the server identifies itself with
+	HostKey ssh.PublicKey
+
+	server    *gliderssh.Server
+	listener  net.Listener
+	clientKey *ecdsa.PrivateKey
+
+	mu           sync.Mutex
+	conditions   Conditions
+	authDelay    time.Duration
+	destinations map[string]func(net.Conn)
+}
+
+// NewServer starts a server on an ephemeral localhost port; Close it when done
+func NewServer() (*Server, error) {
+	return Listen("localhost:0")
+}
+
+// StartServer starts a server on an ephemeral localhost port that's closed when the test ends
+func StartServer(t testing.TB) *Server {
+	t.Helper()
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Listen starts a server on addr; Close it when done
+func Listen(addr string) (*Server, error) {
+	hostSigner, err := newSigner()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate host key: %v", err)
+	}
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate client key: %v", err)
+	}
+	clientSigner, err := ssh.NewSignerFromKey(clientKey)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:      listener.Addr().String(),
+		User:      "testuser",
+		Password:  "the right password",
+		OTP:       "123456",
+		ClientKey: clientSigner,
+		HostKey:   hostSigner.PublicKey(),
+		listener:  listener,
+		clientKey: clientKey,
+	}
+	forwardHandler := &gliderssh.ForwardedTCPHandler{}
+	s.server = &gliderssh.Server{
+		Handler: func(session gliderssh.Session) {
+			// cat echoes, as for measuring throughput
+			if session.RawCommand() == "cat" {
+				io.Copy(session, session)
+				return
+			}
+			io.WriteString(session, "hello, world\n")
+		},
+		HostSigners: []gliderssh.Signer{hostSigner},
+		PasswordHandler: func(ctx gliderssh.Context, password string) bool {
+			s.delayAuth()
+			return ctx.User() == s.User && password == s.Password
+		},
+		PublicKeyHandler: func(ctx gliderssh.Context, key gliderssh.PublicKey) bool {
+			return ctx.User() == s.User && gliderssh.KeysEqual(key, clientSigner.PublicKey())
+		},
+		KeyboardInteractiveHandler: func(ctx gliderssh.Context, challenge ssh.KeyboardInteractiveChallenge) bool {
+			answers, err := challenge("", "Enter the code from your authenticator", []string{"Verification code: "}, []bool{false})
+			return err == nil && ctx.User() == s.User && len(answers) == 1 && answers[0] == s.OTP
+		},
+		LocalPortForwardingCallback: func(ctx gliderssh.Context, host string, port uint32) bool {
+			return true
+		},
+		ReversePortForwardingCallback: func(ctx gliderssh.Context, host string, port uint32) bool {
+			return true
+		},
+		ChannelHandlers: map[string]gliderssh.ChannelHandler{
+			"session":      gliderssh.DefaultSessionHandler,
+			"direct-tcpip": conditionedDirectTCPIPHandler(s.currentConditions, s.dial),
+		},
+		RequestHandlers: map[string]gliderssh.RequestHandler{
+			"tcpip-forward":        forwardHandler.HandleSSHRequest,
+			"cancel-tcpip-forward": forwardHandler.HandleSSHRequest,
+		},
+		SubsystemHandlers: map[string]gliderssh.SubsystemHandler{
+			"sftp": serveSFTP,
+		},
+	}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// Auth returns auth methods for both of the accepted credentials
+func (s *Server) Auth() []ssh.AuthMethod {
+	return []ssh.AuthMethod{ssh.PublicKeys(s.ClientKey), ssh.Password(s.Password)}
+}
+
+// WriteClientKey writes ClientKey to file in PEM format, encrypted with passphrase unless it's empty
+func (s *Server) WriteClientKey(file, passphrase string) error {
+	der, err := x509.MarshalECPrivateKey(s.clientKey)
+	if err != nil {
+		return err
+	}
+	block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	if passphrase != "" {
+		// legacy PEM encryption is what ssh.ParsePrivateKeyWithPassphrase understands besides the OpenSSH
+		// format, which this version of x/crypto can't write
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, der, []byte(passphrase), x509.PEMCipherAES128) //nolint:staticcheck
+		if err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600)
+}
+
+// HostKeyCallback accepts only the server's host key
+func (s *Server) HostKeyCallback() ssh.HostKeyCallback {
+	return ssh.FixedHostKey(s.HostKey)
+}
+
+// SetConditions degrades the forwarded connections opened from now on
+func (s *Server) SetConditions(c Conditions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conditions = c
+}
+
+// SetAuthDelay makes the server take d to answer password auth attempts, like a bastion waiting on a slow
+// one time password gateway
+func (s *Server) SetAuthDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authDelay = d
+}
+
+func (s *Server) delayAuth() {
+	s.mu.Lock()
+	d := s.authDelay
+	s.mu.Unlock()
+	time.Sleep(d)
+}
+
+// HandleDestination has connections forwarded to addr, a host:port as the client asks for it, served in memory by
+// handler rather than dialed, so that tests don't need a real destination. handler owns the connection.
+func (s *Server) HandleDestination(addr string, handler func(net.Conn)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.destinations == nil {
+		s.destinations = map[string]func(net.Conn){}
+	}
+	s.destinations[addr] = handler
+}
+
+// Echo is a handler for HandleDestination sending back everything it receives
+func Echo(conn net.Conn) {
+	io.Copy(conn, conn)
+	conn.Close()
+}
+
+// dial connects to a forwarded destination, in memory if it has a handler
+func (s *Server) dial(addr string) (net.Conn, error) {
+	s.mu.Lock()
+	handler := s.destinations[addr]
+	s.mu.Unlock()
+	if handler == nil {
+		return net.Dial("tcp", addr)
+	}
+	client, server := net.Pipe()
+	go handler(server)
+	return client, nil
+}
+
+func (s *Server) currentConditions() Conditions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conditions
+}
+
+// Close stops the server and terminates its connections
+func (s *Server) Close() error {
+	// the listener may not be tracked by the server yet if Serve hasn't got going
+	s.listener.Close()
+	return s.server.Close()
+}
+
+func newSigner() (ssh.Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+func serveSFTP(session gliderssh.Session) {
+	server, err := sftp.NewServer(session)
+	if err != nil {
+		log.Println("sftp server init error:", err)
+		return
+	}
+	server.Serve()
+}
@@ -0,0 +1,78 @@
+package tunneltest_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	tunnel "github.com/arunsworld/go-tunnel"
+	"github.com/arunsworld/go-tunnel/tunneltest"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestServer(t *testing.T) {
+	server := tunneltest.StartServer(t)
+
+	for name, auth := range map[string]ssh.AuthMethod{
+		"password": ssh.Password(server.Password),
+		"key":      ssh.PublicKeys(server.ClientKey),
+		"otp": tunnel.KeyboardInteractive(func(name, instruction, question string, echo bool) (string, error) {
+			return server.OTP, nil
+		}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			tun, err := tunnel.Dial(&tunnel.Spec{
+				Host:            server.Addr,
+				User:            server.User,
+				Auth:            []ssh.AuthMethod{auth},
+				HostKeyCallback: server.HostKeyCallback(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tun.Close()
+			out, err := tun.Output("anything")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != "hello, world\n" {
+				t.Fatalf("unexpected output: %q", out)
+			}
+		})
+	}
+}
+
+func TestHandleDestination(t *testing.T) {
+	server := tunneltest.StartServer(t)
+	server.HandleDestination("db.internal:5432", tunneltest.Echo)
+
+	tun, err := tunnel.Execute(&tunnel.Spec{
+		Host:            server.Addr,
+		User:            server.User,
+		Auth:            server.Auth(),
+		HostKeyCallback: server.HostKeyCallback(),
+		Forward:         []tunnel.Forwarder{tunnel.Forward(0, "db.internal:5432").WithName("db")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	addr, _ := tun.LocalAddr("db")
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "ping" {
+		t.Fatalf("expected ping back, got %q", reply)
+	}
+}
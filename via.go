@@ -0,0 +1,30 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// dialVia connects to the jump host spec.Host is reached through
+func dialVia(ctx context.Context, spec *Spec) (*ssh.Client, error) {
+	via := spec.Via
+	if via.Logger == nil {
+		via.Logger = spec.Logger
+	}
+	applyDefaults(via)
+	client, err := makeServerConnection(ctx, via, getSSHConfig(via))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to jump host %s: %w", via.Host, err)
+	}
+	return client, nil
+}
+
+// closeWith closes the jump host connection once client, whose connection runs over it, is done
+func closeWith(client *ssh.Client, via *ssh.Client) {
+	go func() {
+		client.Wait()
+		via.Close()
+	}()
+}
@@ -0,0 +1,155 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/arunsworld/nursery"
+	"golang.org/x/crypto/ssh"
+)
+
+// VPN defines a layer-3 tunnel: a local TUN interface whose IP packets are relayed
+// through the ssh connection to a peer helper started on the remote side
+type VPN struct {
+	// Interface is the name of the local TUN interface; empty lets the kernel choose
+	Interface string
+	// Address is the CIDR assigned to the local interface, e.g. 10.77.0.1/30
+	Address string
+	// Routes are the subnets routed via the local interface, e.g. 10.20.0.0/16
+	Routes []string
+	// MTU of the local interface; defaults to 1400
+	MTU int
+	// RemoteCommand starts the peer helper on the remote side, e.g. "sudo tunnel vpn-helper --address 10.77.0.2/30".
+	// The helper must relay packets over its stdin/stdout using ServeVPNPeer.
+	RemoteCommand string
+}
+
+const defaultVPNMTU = 1400
+
+// TUNDevice is a layer-3 virtual network interface
+type TUNDevice interface {
+	io.ReadWriteCloser
+	Name() string
+}
+
+// ServeVPNPeer is the peer helper side of a VPN: it opens a TUN interface as described by v
+// and relays packets between it and the framed stream on r/w (typically stdin/stdout) until ctx is done
+func ServeVPNPeer(ctx context.Context, v *VPN, r io.Reader, w io.Writer) error {
+	dev, err := openConfiguredTUN(v)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+	return relayPackets(ctx, dev, r, w, vpnMTU(v))
+}
+
+func serveVPN(ctx context.Context, serverConnection *ssh.Client, v *VPN, logger Logger) error {
+	if v.RemoteCommand == "" {
+		return errors.New("vpn requires a remote command to start the peer helper")
+	}
+	session, err := serverConnection.NewSession()
+	if err != nil {
+		return fmt.Errorf("unable to open session for vpn peer: %v", err)
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("unable to open vpn peer stdin: %v", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("unable to open vpn peer stdout: %v", err)
+	}
+	dev, err := openConfiguredTUN(v)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+	if err := session.Start(v.RemoteCommand); err != nil {
+		return fmt.Errorf("unable to start vpn peer helper: %v", err)
+	}
+	logAt(logger, LevelInfo, "vpn established on %s (%s) via %s", dev.Name(), v.Address, v.RemoteCommand)
+	err = relayPackets(ctx, dev, stdout, stdin, vpnMTU(v))
+	logAt(logger, LevelInfo, "vpn on %s terminated", dev.Name())
+	return err
+}
+
+func openConfiguredTUN(v *VPN) (TUNDevice, error) {
+	dev, err := openTUN(v.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open tun interface: %v", err)
+	}
+	if err := configureTUN(dev.Name(), v.Address, vpnMTU(v), v.Routes); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("unable to configure tun interface %s: %v", dev.Name(), err)
+	}
+	return dev, nil
+}
+
+func vpnMTU(v *VPN) int {
+	if v.MTU <= 0 {
+		return defaultVPNMTU
+	}
+	return v.MTU
+}
+
+// relayPackets copies packets from dev to w and from r to dev until either side fails or ctx is done;
+// on the stream every packet is prefixed with its length as a big endian uint16
+func relayPackets(ctx context.Context, dev TUNDevice, r io.Reader, w io.Writer, mtu int) error {
+	return nursery.RunUntilFirstCompletionWithContext(ctx,
+		func(ctx context.Context, errCh chan error) {
+			buf := make([]byte, mtu+2)
+			for {
+				n, err := dev.Read(buf[2:])
+				if err != nil {
+					if !nursery.IsContextDone(ctx) {
+						errCh <- fmt.Errorf("error reading from %s: %v", dev.Name(), err)
+					}
+					return
+				}
+				binary.BigEndian.PutUint16(buf, uint16(n))
+				if _, err := w.Write(buf[:n+2]); err != nil {
+					if !nursery.IsContextDone(ctx) {
+						errCh <- fmt.Errorf("error writing to vpn peer: %v", err)
+					}
+					return
+				}
+			}
+		},
+		func(ctx context.Context, errCh chan error) {
+			buf := make([]byte, 65535)
+			header := make([]byte, 2)
+			for {
+				if _, err := io.ReadFull(r, header); err != nil {
+					if err != io.EOF && !nursery.IsContextDone(ctx) {
+						errCh <- fmt.Errorf("error reading from vpn peer: %v", err)
+					}
+					return
+				}
+				n := int(binary.BigEndian.Uint16(header))
+				if _, err := io.ReadFull(r, buf[:n]); err != nil {
+					if !nursery.IsContextDone(ctx) {
+						errCh <- fmt.Errorf("error reading from vpn peer: %v", err)
+					}
+					return
+				}
+				if _, err := dev.Write(buf[:n]); err != nil {
+					if !nursery.IsContextDone(ctx) {
+						errCh <- fmt.Errorf("error writing to %s: %v", dev.Name(), err)
+					}
+					return
+				}
+			}
+		},
+		func(ctx context.Context, errCh chan error) {
+			<-ctx.Done()
+			dev.Close()
+			if c, ok := w.(io.Closer); ok {
+				c.Close()
+			}
+		},
+	)
+}
@@ -0,0 +1,58 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type pipeTUN struct {
+	net.Conn
+}
+
+func (pipeTUN) Name() string {
+	return "pipe0"
+}
+
+func TestRelayPackets(t *testing.T) {
+	devSide, kernelSide := net.Pipe()
+	peerSide, streamSide := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- relayPackets(ctx, pipeTUN{devSide}, streamSide, streamSide, defaultVPNMTU)
+	}()
+
+	// a packet read from the interface is framed onto the stream
+	go kernelSide.Write([]byte("packet one"))
+	framed := make([]byte, 12)
+	if _, err := io.ReadFull(peerSide, framed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(framed, append([]byte{0, 10}, "packet one"...)) {
+		t.Fatalf("unexpected framing: %v", framed)
+	}
+
+	// a framed packet from the stream is written to the interface
+	go peerSide.Write(append([]byte{0, 3}, "two"...))
+	packet := make([]byte, 3)
+	if _, err := io.ReadFull(kernelSide, packet); err != nil {
+		t.Fatal(err)
+	}
+	if string(packet) != "two" {
+		t.Fatalf("unexpected packet: %s", packet)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("relay did not terminate on cancellation")
+	}
+}